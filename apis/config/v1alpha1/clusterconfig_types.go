@@ -19,6 +19,7 @@ import (
 	"github.com/liqotech/liqo/pkg/crdClient"
 	"github.com/liqotech/liqo/pkg/labelPolicy"
 	"github.com/liqotech/liqo/pkg/liqonet"
+	rbacv1 "k8s.io/api/rbac/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/client-go/kubernetes/scheme"
@@ -31,6 +32,10 @@ type ClusterConfigSpec struct {
 	DiscoveryConfig     DiscoveryConfig     `json:"discoveryConfig"`
 	LiqonetConfig       LiqonetConfig       `json:"liqonetConfig"`
 	DispatcherConfig    DispatcherConfig    `json:"dispatcherConfig,omitempty"`
+	//PeerPermissionsConfig defines the permissions granted in the local cluster to the identities of the peering clusters
+	PeerPermissionsConfig PeerPermissionsConfig `json:"peerPermissionsConfig,omitempty"`
+	//CredentialsConfig defines the lifecycle of the credentials issued to the peering clusters
+	CredentialsConfig CredentialsConfig `json:"credentialsConfig,omitempty"`
 	//AgentConfig defines the configuration for Liqo Agent.
 	AgentConfig AgentConfig `json:"agentConfig"`
 }
@@ -121,8 +126,134 @@ type DiscoveryConfig struct {
 
 	AutoJoin          bool `json:"autojoin"`
 	AutoJoinUntrusted bool `json:"autojoinUntrusted"`
+	// PinnedKeys maps the ClusterIDs of the foreign clusters to the fingerprints of the keys signing their
+	// announcements, as pinned by the administrator. The clusters announced in the LAN are joined with
+	// AutoJoinUntrusted only if their announcements are signed with the key pinned here, since the key pinned at the
+	// first announcement is only asserted by the cluster itself. The announcements signed with other keys are refused.
+	// +optional
+	PinnedKeys map[string]string `json:"pinnedKeys,omitempty"`
+
+	// Interfaces, if set, restricts the network interfaces and the addresses used to announce the cluster and to
+	// discover the other ones with mDNS
+	Interfaces *InterfacesConfig `json:"interfaces,omitempty"`
+
+	// --- DNS-SD ---
+
+	// DNSPublishing, if set, keeps the DNS-SD records of this cluster up to date in a DNS zone, to make it discoverable
+	// by the clusters which have a SearchDomain for that zone
+	DNSPublishing *DNSPublishingConfig `json:"dnsPublishing,omitempty"`
+
+	// --- Registry ---
+
+	// Registry, if set, enables the discovery through the ClusterAnnouncements stored in a registry cluster shared by
+	// the partner clusters. The local cluster is announced if EnableAdvertisement is set, and the other clusters are
+	// discovered if EnableDiscovery is set
+	Registry *RegistryConfig `json:"registry,omitempty"`
+
+	// --- ForeignClusters lifecycle ---
+
+	// ForeignClusterGC defines when the ForeignClusters which are not seen anymore by their discovery source are deleted
+	ForeignClusterGC *ForeignClusterGCConfig `json:"foreignClusterGC,omitempty"`
+	// UnpeeringDrain defines how the virtual node of a foreign cluster is drained when the outgoing peering is disabled,
+	// before tearing it down
+	UnpeeringDrain *UnpeeringDrainConfig `json:"unpeeringDrain,omitempty"`
+}
+
+// InterfacesConfig defines the network interfaces and the addresses used by mDNS. The interface names can be glob
+// patterns, e.g. "eth*"
+type InterfacesConfig struct {
+	// Include lists the interfaces which can be used, all the eligible ones are used if it is empty
+	// +optional
+	Include []string `json:"include,omitempty"`
+	// Exclude lists the interfaces which are never used
+	// +optional
+	Exclude []string `json:"exclude,omitempty"`
+	// AllowedCIDRs, if not empty, restricts the addresses: an interface is used only if it has an IPv4 address in
+	// them, only the addresses in them are advertised, and the addresses of the discovered clusters outside them are
+	// ignored
+	// +optional
+	AllowedCIDRs []string `json:"allowedCIDRs,omitempty"`
+	// ExcludedCIDRs lists the addresses which are never used, neither to select the interfaces, nor to be advertised,
+	// nor to reach the discovered clusters
+	// +optional
+	ExcludedCIDRs []string `json:"excludedCIDRs,omitempty"`
+}
+
+// ExpiredPeeredPolicy defines how the expired ForeignClusters which are peered are handled
+type ExpiredPeeredPolicy string
+
+const (
+	// ExpiredPeeredWarn keeps the ForeignCluster, setting its Expired condition
+	ExpiredPeeredWarn ExpiredPeeredPolicy = "Warn"
+	// ExpiredPeeredUnpeer disables the outgoing and the incoming peering, the ForeignCluster is deleted when they are over.
+	// The settings of the peerings are restored if the cluster is seen again before
+	ExpiredPeeredUnpeer ExpiredPeeredPolicy = "Unpeer"
+	// ExpiredPeeredKeep keeps the ForeignCluster
+	ExpiredPeeredKeep ExpiredPeeredPolicy = "Keep"
+)
+
+type ForeignClusterGCConfig struct {
+	// GracePeriods are the seconds, per discovery type (LAN, WAN, Registry, Manual, IncomingPeering), after which a
+	// ForeignCluster which is not seen anymore is expired, in addition to the TTL announced with it. A negative value
+	// disables the collection for that type. The missing types use the defaults: 0 for LAN, 300 for WAN and Registry,
+	// disabled for Manual and IncomingPeering
+	// +optional
+	GracePeriods map[string]int64 `json:"gracePeriods,omitempty"`
+	// PeeredPolicy defines how the expired ForeignClusters which are peered are handled, instead of deleting them
+	// +kubebuilder:validation:Enum="Warn";"Unpeer";"Keep"
+	// +kubebuilder:default="Warn"
+	// +optional
+	PeeredPolicy ExpiredPeeredPolicy `json:"peeredPolicy,omitempty"`
+}
+
+// UnpeeringDrainConfig defines the drain of the virtual nodes performed before tearing down the outgoing peerings
+type UnpeeringDrainConfig struct {
+	// Disabled tears down the outgoing peerings without evicting the pods offloaded to the virtual nodes
+	// +optional
+	Disabled bool `json:"disabled,omitempty"`
+	// TimeoutSeconds is the maximum time waited for the offloaded pods to be evicted, respecting their
+	// PodDisruptionBudgets, after which the peering is torn down anyway. It defaults to 300
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	TimeoutSeconds int64 `json:"timeoutSeconds,omitempty"`
+}
+
+// RegistryConfig defines how to access the registry cluster
+type RegistryConfig struct {
+	// KubeconfigSecretName is the name of the Secret in the Liqo namespace which stores in its "kubeconfig" key the
+	// kubeconfig to access the registry cluster
+	KubeconfigSecretName string `json:"kubeconfigSecretName"`
 }
 
+// DNSPublishingConfig defines where the PTR, SRV and TXT records of the cluster are published through RFC 2136
+// dynamic updates signed with TSIG
+type DNSPublishingConfig struct {
+	// Server receiving the updates, as host:port
+	Server string `json:"server"`
+	// Zone to update, e.g. example.com.
+	Zone string `json:"zone"`
+	// Domain in which the cluster is registered, it has to be a name in the Zone. It defaults to the Zone
+	Domain string `json:"domain,omitempty"`
+	// TsigKeyName is the name of the TSIG key accepted by the server for the updates of the Zone
+	TsigKeyName string `json:"tsigKeyName"`
+	// +kubebuilder:validation:Enum="hmac-sha1";"hmac-sha256";"hmac-sha512"
+	// +kubebuilder:default="hmac-sha256"
+	TsigAlgorithm string `json:"tsigAlgorithm,omitempty"`
+	// TsigSecretName is the name of the Secret in the Liqo namespace which stores the base64 encoded TSIG secret in
+	// its "secret" key
+	TsigSecretName string `json:"tsigSecretName"`
+}
+
+// NetworkMode defines which subnet of the local cluster is reachable from the peering clusters
+type NetworkMode string
+
+const (
+	// PodCIDRNetworkMode means that the pods' subnets are routed between the peering clusters
+	PodCIDRNetworkMode NetworkMode = "PodCIDR"
+	// ServiceCIDRNetworkMode means that only the services' subnets are routed between the peering clusters
+	ServiceCIDRNetworkMode NetworkMode = "ServiceCIDR"
+)
+
 type LiqonetConfig struct {
 	//This field is used by the IPAM embedded in the tunnelEndpointCreator.
 	//Subnets listed in this field are excluded from the list of possible subnets used for natting POD CIDR.
@@ -132,6 +263,14 @@ type LiqonetConfig struct {
 	PodCIDR string `json:"podCIDR"`
 	//the subnet used by the cluster for the services, in CIDR notation
 	ServiceCIDR string `json:"serviceCIDR"`
+	//NetworkMode defines which traffic crosses the tunnel towards the peering clusters.
+	//In PodCIDR mode the whole pods' subnet is routed and, if needed, NATed. In ServiceCIDR mode only the traffic
+	//destined to the services is routed: the ServiceCIDR is remapped for each peering cluster and the
+	//reflected services are reached through their remapped ClusterIPs. A ServiceCIDR wider than a /16 can be remapped
+	//only if it does not conflict, otherwise the pods' subnet is routed with that peering cluster.
+	// +kubebuilder:validation:Enum="PodCIDR";"ServiceCIDR"
+	// +kubebuilder:default="PodCIDR"
+	NetworkMode NetworkMode `json:"networkMode,omitempty"`
 	//the configuration for the VXLAN overlay network which handles the traffic in the local cluster destined to remote peering clusters
 	VxlanNetConfig liqonet.VxlanNetConfig `json:"vxlanNetConfig,omitempty"`
 }
@@ -146,6 +285,39 @@ type DispatcherConfig struct {
 	ResourcesToReplicate []Resource `json:"resourcesToReplicate,omitempty"`
 }
 
+//PeerPermissionsConfig contains a template of the permissions granted to each component of the peering clusters.
+//When a template is not set, the default permissions of the component are granted.
+type PeerPermissionsConfig struct {
+	//PeeringRequest is granted by the auth-service to the peering clusters to create their PeeringRequests
+	PeeringRequest *PermissionsTemplate `json:"peeringRequest,omitempty"`
+	//AdvertisementBroadcaster is granted to the broadcasters of the peering clusters to create their Advertisements
+	//and the Secrets for the virtual kubelets
+	AdvertisementBroadcaster *PermissionsTemplate `json:"advertisementBroadcaster,omitempty"`
+	//CRDReplicator is granted to the CRD replicators of the peering clusters. By default, it grants the access to the
+	//resources listed in DispatcherConfig
+	CRDReplicator *PermissionsTemplate `json:"crdReplicator,omitempty"`
+	//VirtualKubelet is granted to the virtual kubelets of the peering clusters to offload pods and reflect resources.
+	//It is shared by all the peering clusters, so its rules cannot refer to the ClusterID
+	VirtualKubelet *PermissionsTemplate `json:"virtualKubelet,omitempty"`
+}
+
+//PermissionsTemplate contains the rules granted to an identity of a peering cluster. The string $(CLUSTER_ID) in the
+//resourceNames of the rules is replaced with the ClusterID of the peering cluster.
+type PermissionsTemplate struct {
+	//ClusterRules are granted in the whole cluster
+	ClusterRules []rbacv1.PolicyRule `json:"clusterRules,omitempty"`
+	//NamespacedRules are granted in the Liqo namespace
+	NamespacedRules []rbacv1.PolicyRule `json:"namespacedRules,omitempty"`
+}
+
+//CredentialsConfig contains the configuration of the rotation of the credentials issued to the peering clusters
+type CredentialsConfig struct {
+	//RotationPeriod is the age after which a new credential is issued to a peering cluster, replacing the previous
+	//one. When not set, the credentials are rotated every 30 days
+	// +kubebuilder:default="720h"
+	RotationPeriod metav1.Duration `json:"rotationPeriod,omitempty"`
+}
+
 type DashboardConfig struct {
 	// Namespace defines the namespace LiqoDash resources belongs to.
 	Namespace string `json:"namespace"`
@@ -20,6 +20,7 @@ limitations under the License.
 package v1alpha1
 
 import (
+	"k8s.io/api/rbac/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 )
 
@@ -154,9 +155,11 @@ func (in *ClusterConfigList) DeepCopyObject() runtime.Object {
 func (in *ClusterConfigSpec) DeepCopyInto(out *ClusterConfigSpec) {
 	*out = *in
 	in.AdvertisementConfig.DeepCopyInto(&out.AdvertisementConfig)
-	out.DiscoveryConfig = in.DiscoveryConfig
+	in.DiscoveryConfig.DeepCopyInto(&out.DiscoveryConfig)
 	in.LiqonetConfig.DeepCopyInto(&out.LiqonetConfig)
 	in.DispatcherConfig.DeepCopyInto(&out.DispatcherConfig)
+	in.PeerPermissionsConfig.DeepCopyInto(&out.PeerPermissionsConfig)
+	out.CredentialsConfig = in.CredentialsConfig
 	out.AgentConfig = in.AgentConfig
 }
 
@@ -185,6 +188,37 @@ func (in *ClusterConfigStatus) DeepCopy() *ClusterConfigStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CredentialsConfig) DeepCopyInto(out *CredentialsConfig) {
+	*out = *in
+	out.RotationPeriod = in.RotationPeriod
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CredentialsConfig.
+func (in *CredentialsConfig) DeepCopy() *CredentialsConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(CredentialsConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DNSPublishingConfig) DeepCopyInto(out *DNSPublishingConfig) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DNSPublishingConfig.
+func (in *DNSPublishingConfig) DeepCopy() *DNSPublishingConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(DNSPublishingConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *DashboardConfig) DeepCopyInto(out *DashboardConfig) {
 	*out = *in
@@ -203,6 +237,38 @@ func (in *DashboardConfig) DeepCopy() *DashboardConfig {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *DiscoveryConfig) DeepCopyInto(out *DiscoveryConfig) {
 	*out = *in
+	if in.PinnedKeys != nil {
+		in, out := &in.PinnedKeys, &out.PinnedKeys
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.Interfaces != nil {
+		in, out := &in.Interfaces, &out.Interfaces
+		*out = new(InterfacesConfig)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.DNSPublishing != nil {
+		in, out := &in.DNSPublishing, &out.DNSPublishing
+		*out = new(DNSPublishingConfig)
+		**out = **in
+	}
+	if in.Registry != nil {
+		in, out := &in.Registry, &out.Registry
+		*out = new(RegistryConfig)
+		**out = **in
+	}
+	if in.ForeignClusterGC != nil {
+		in, out := &in.ForeignClusterGC, &out.ForeignClusterGC
+		*out = new(ForeignClusterGCConfig)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.UnpeeringDrain != nil {
+		in, out := &in.UnpeeringDrain, &out.UnpeeringDrain
+		*out = new(UnpeeringDrainConfig)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DiscoveryConfig.
@@ -235,6 +301,63 @@ func (in *DispatcherConfig) DeepCopy() *DispatcherConfig {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ForeignClusterGCConfig) DeepCopyInto(out *ForeignClusterGCConfig) {
+	*out = *in
+	if in.GracePeriods != nil {
+		in, out := &in.GracePeriods, &out.GracePeriods
+		*out = make(map[string]int64, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ForeignClusterGCConfig.
+func (in *ForeignClusterGCConfig) DeepCopy() *ForeignClusterGCConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(ForeignClusterGCConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *InterfacesConfig) DeepCopyInto(out *InterfacesConfig) {
+	*out = *in
+	if in.Include != nil {
+		in, out := &in.Include, &out.Include
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Exclude != nil {
+		in, out := &in.Exclude, &out.Exclude
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.AllowedCIDRs != nil {
+		in, out := &in.AllowedCIDRs, &out.AllowedCIDRs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.ExcludedCIDRs != nil {
+		in, out := &in.ExcludedCIDRs, &out.ExcludedCIDRs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new InterfacesConfig.
+func (in *InterfacesConfig) DeepCopy() *InterfacesConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(InterfacesConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *LabelPolicy) DeepCopyInto(out *LabelPolicy) {
 	*out = *in
@@ -271,6 +394,85 @@ func (in *LiqonetConfig) DeepCopy() *LiqonetConfig {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PeerPermissionsConfig) DeepCopyInto(out *PeerPermissionsConfig) {
+	*out = *in
+	if in.PeeringRequest != nil {
+		in, out := &in.PeeringRequest, &out.PeeringRequest
+		*out = new(PermissionsTemplate)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.AdvertisementBroadcaster != nil {
+		in, out := &in.AdvertisementBroadcaster, &out.AdvertisementBroadcaster
+		*out = new(PermissionsTemplate)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.CRDReplicator != nil {
+		in, out := &in.CRDReplicator, &out.CRDReplicator
+		*out = new(PermissionsTemplate)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.VirtualKubelet != nil {
+		in, out := &in.VirtualKubelet, &out.VirtualKubelet
+		*out = new(PermissionsTemplate)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PeerPermissionsConfig.
+func (in *PeerPermissionsConfig) DeepCopy() *PeerPermissionsConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(PeerPermissionsConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PermissionsTemplate) DeepCopyInto(out *PermissionsTemplate) {
+	*out = *in
+	if in.ClusterRules != nil {
+		in, out := &in.ClusterRules, &out.ClusterRules
+		*out = make([]v1.PolicyRule, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.NamespacedRules != nil {
+		in, out := &in.NamespacedRules, &out.NamespacedRules
+		*out = make([]v1.PolicyRule, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PermissionsTemplate.
+func (in *PermissionsTemplate) DeepCopy() *PermissionsTemplate {
+	if in == nil {
+		return nil
+	}
+	out := new(PermissionsTemplate)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RegistryConfig) DeepCopyInto(out *RegistryConfig) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RegistryConfig.
+func (in *RegistryConfig) DeepCopy() *RegistryConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(RegistryConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Resource) DeepCopyInto(out *Resource) {
 	*out = *in
@@ -285,3 +487,18 @@ func (in *Resource) DeepCopy() *Resource {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *UnpeeringDrainConfig) DeepCopyInto(out *UnpeeringDrainConfig) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new UnpeeringDrainConfig.
+func (in *UnpeeringDrainConfig) DeepCopy() *UnpeeringDrainConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(UnpeeringDrainConfig)
+	in.DeepCopyInto(out)
+	return out
+}
@@ -0,0 +1,105 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"errors"
+	"github.com/liqotech/liqo/pkg/crdClient"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/scheme"
+	"time"
+)
+
+// ClusterAnnouncementSpec defines the data needed to discover a cluster registered in a registry cluster
+type ClusterAnnouncementSpec struct {
+	// Identity of the announced cluster
+	ClusterIdentity ClusterIdentity `json:"clusterIdentity"`
+	// Namespace where Liqo is deployed
+	Namespace string `json:"namespace"`
+	// URL where to contact the API server of the announced cluster
+	ApiUrl string `json:"apiUrl"`
+	// Seconds after the last heartbeat after which the announcement is considered expired
+	// +kubebuilder:validation:Minimum=30
+	Ttl uint32 `json:"ttl"`
+	// Fingerprint of the CA of the announced cluster, set if the announcement is signed
+	CAFingerprint string `json:"caFingerprint,omitempty"`
+	// PKIX DER encoding of the public key of the announced cluster, which signs the announcement
+	PublicKey []byte `json:"publicKey,omitempty"`
+	// Signature of the announcement, made with the key of the cluster identity as for the TXT records
+	Signature []byte `json:"signature,omitempty"`
+}
+
+// ClusterAnnouncementStatus defines the observed state of ClusterAnnouncement
+type ClusterAnnouncementStatus struct {
+	// Last time the announced cluster refreshed the announcement
+	LastHeartbeatTime metav1.Time `json:"lastHeartbeatTime,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster
+// +kubebuilder:printcolumn:name="Cluster ID",type=string,JSONPath=`.spec.clusterIdentity.clusterID`
+// +kubebuilder:printcolumn:name="API URL",type=string,JSONPath=`.spec.apiUrl`
+// +kubebuilder:printcolumn:name="Last Heartbeat",type=date,JSONPath=`.status.lastHeartbeatTime`
+
+// ClusterAnnouncement is the Schema for the ClusterAnnouncements API, it is created in a registry cluster by each
+// cluster which wants to be discovered by the other ones sharing the same registry
+type ClusterAnnouncement struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ClusterAnnouncementSpec   `json:"spec,omitempty"`
+	Status ClusterAnnouncementStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// ClusterAnnouncementList contains a list of ClusterAnnouncement
+type ClusterAnnouncementList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ClusterAnnouncement `json:"items"`
+}
+
+// IsExpired returns true if the announced cluster has not refreshed the announcement in the last TTL
+func (ca *ClusterAnnouncement) IsExpired() bool {
+	if ca.Status.LastHeartbeatTime.IsZero() {
+		return true
+	}
+	return time.Since(ca.Status.LastHeartbeatTime.Time) > time.Duration(ca.Spec.Ttl)*time.Second
+}
+
+func clusterAnnouncementKeyer(obj runtime.Object) (string, error) {
+	ca, ok := obj.(*ClusterAnnouncement)
+	if !ok {
+		return "", errors.New("cannot cast received object to ClusterAnnouncement")
+	}
+	return ca.Name, nil
+}
+
+func init() {
+	SchemeBuilder.Register(&ClusterAnnouncement{}, &ClusterAnnouncementList{})
+
+	if err := AddToScheme(scheme.Scheme); err != nil {
+		panic(err)
+	}
+	crdClient.AddToRegistry("clusterannouncements", &ClusterAnnouncement{}, &ClusterAnnouncementList{}, clusterAnnouncementKeyer, schema.GroupResource{
+		Group:    GroupVersion.Group,
+		Resource: "clusterannouncements",
+	})
+}
@@ -0,0 +1,86 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type ConditionType string
+
+// Condition describes an aspect of the state of a resource, with the same fields of metav1.Condition
+type Condition struct {
+	// Type of the condition
+	Type ConditionType `json:"type"`
+	// Status of the condition, one of True, False, Unknown
+	Status v1.ConditionStatus `json:"status"`
+	// The metadata.generation of the resource the condition has been set upon
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// Last time the condition transitioned from one status to another
+	LastTransitionTime metav1.Time `json:"lastTransitionTime,omitempty"`
+	// Machine readable reason for the last transition
+	Reason string `json:"reason,omitempty"`
+	// Human readable details about the condition
+	Message string `json:"message,omitempty"`
+}
+
+// SetCondition adds or updates the condition in the list, changing its transition time only if the status is
+// different. The observed generation is recorded only when the condition changes: the ForeignClusters have no status
+// subresource, so their generation changes at each update, and setting the same condition must not require another
+// one. It returns true if the list has been modified.
+func SetCondition(conditions *[]Condition, condition Condition) bool {
+	existing := FindCondition(*conditions, condition.Type)
+	if existing == nil {
+		condition.LastTransitionTime = metav1.Now()
+		*conditions = append(*conditions, condition)
+		return true
+	}
+	if existing.Status == condition.Status && existing.Reason == condition.Reason && existing.Message == condition.Message {
+		return false
+	}
+	if existing.Status != condition.Status {
+		existing.LastTransitionTime = metav1.Now()
+	}
+	existing.Status = condition.Status
+	existing.Reason = condition.Reason
+	existing.Message = condition.Message
+	existing.ObservedGeneration = condition.ObservedGeneration
+	return true
+}
+
+// FindCondition returns the condition of the given type in the list, nil if it is not set
+func FindCondition(conditions []Condition, conditionType ConditionType) *Condition {
+	for i := range conditions {
+		if conditions[i].Type == conditionType {
+			return &conditions[i]
+		}
+	}
+	return nil
+}
+
+// RemoveCondition removes the condition of the given type from the list, it returns true if it was present
+func RemoveCondition(conditions *[]Condition, conditionType ConditionType) bool {
+	for i := range *conditions {
+		if (*conditions)[i].Type == conditionType {
+			*conditions = append((*conditions)[:i], (*conditions)[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
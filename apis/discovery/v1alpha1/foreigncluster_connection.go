@@ -0,0 +1,197 @@
+package v1alpha1
+
+import (
+	"fmt"
+	"github.com/liqotech/liqo/pkg/crdClient"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilnet "k8s.io/apimachinery/pkg/util/net"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/transport"
+	"k8s.io/klog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// maximum time waited for an API URL to answer when choosing among the alternative ones
+	apiUrlProbeTimeout = 5 * time.Second
+	// how often the API URLs of a ForeignCluster are probed again to select the one used by its clients
+	ApiUrlProbeInterval = 5 * time.Minute
+)
+
+// ApplyConnectionConfig configures a client of the foreign cluster to contact its API server as stated in the
+// connection settings of the ForeignCluster: through the proxy, verifying it with the CA bundle and the server name,
+// and at the API URL selected among the one of the config and the alternative ones. The URLs are probed only if the
+// selection has not been recorded yet by RefreshApiUrl
+func (fc *ForeignCluster) ApplyConnectionConfig(cnf *rest.Config) error {
+	connection := fc.Spec.Connection
+	if connection == nil {
+		return nil
+	}
+	if err := fc.applyTransportConfig(cnf); err != nil {
+		return err
+	}
+	if len(connection.AlternativeApiUrls) == 0 {
+		return nil
+	}
+	apiUrls := append([]string{cnf.Host}, connection.AlternativeApiUrls...)
+	if status := fc.Status.Connection; status != nil && containsApiUrl(apiUrls, status.ApiUrl) {
+		cnf.Host = status.ApiUrl
+		return nil
+	}
+	if apiUrl := selectApiUrl(cnf, apiUrls); apiUrl != "" {
+		cnf.Host = apiUrl
+	}
+	return nil
+}
+
+// RefreshApiUrl probes the API URLs of the ForeignCluster if the selected one has not been probed within
+// ApiUrlProbeInterval or it is not among them anymore, and records the first one answering in the status. It returns
+// true if the status has been changed
+func (fc *ForeignCluster) RefreshApiUrl() (bool, error) {
+	connection := fc.Spec.Connection
+	if connection == nil || len(connection.AlternativeApiUrls) == 0 {
+		if fc.Status.Connection == nil {
+			return false, nil
+		}
+		fc.Status.Connection = nil
+		return true, nil
+	}
+	apiUrls := append([]string{fc.Spec.ApiUrl}, connection.AlternativeApiUrls...)
+	if status := fc.Status.Connection; status != nil && containsApiUrl(apiUrls, status.ApiUrl) &&
+		time.Since(status.LastProbeTime.Time) < ApiUrlProbeInterval {
+		return false, nil
+	}
+
+	// the probe checks only that the API server answers, the clients verify it with the configured CAs
+	cnf := &rest.Config{
+		Host: fc.Spec.ApiUrl,
+		TLSClientConfig: rest.TLSClientConfig{
+			Insecure: true,
+		},
+	}
+	if err := fc.applyTransportConfig(cnf); err != nil {
+		return false, err
+	}
+	apiUrl := selectApiUrl(cnf, apiUrls)
+	if apiUrl == "" {
+		apiUrl = fc.Spec.ApiUrl
+	}
+	fc.Status.Connection = &ConnectionStatus{
+		ApiUrl:        apiUrl,
+		LastProbeTime: metav1.Now(),
+	}
+	return true, nil
+}
+
+// applyTransportConfig configures a client of the foreign cluster to use the proxy, the CA bundle and the server name
+// of the connection settings
+func (fc *ForeignCluster) applyTransportConfig(cnf *rest.Config) error {
+	connection := fc.Spec.Connection
+	if connection.ProxyURL != "" {
+		proxyURL, err := url.Parse(connection.ProxyURL)
+		if err != nil {
+			return fmt.Errorf("invalid proxy URL for the ForeignCluster %s: %v", fc.Name, err)
+		}
+		// the proxy has to be set on the underlying transport, before it is wrapped by the other round trippers
+		cnf.WrapTransport = transport.Wrappers(proxyWrapper(proxyURL), cnf.WrapTransport)
+	}
+	if len(connection.CABundle) > 0 && !cnf.Insecure {
+		caData := append([]byte{}, cnf.CAData...)
+		if len(caData) > 0 && !strings.HasSuffix(string(caData), "\n") {
+			caData = append(caData, '\n')
+		}
+		cnf.CAData = append(caData, connection.CABundle...)
+	}
+	if connection.ServerName != "" {
+		cnf.ServerName = connection.ServerName
+	}
+	return nil
+}
+
+// ApplyForeignClusterConnection applies the connection settings of the ForeignCluster with the given cluster ID to a
+// client of that cluster, nothing is applied if there is no ForeignCluster. It has to be called on every client of a
+// foreign cluster, since it can be reachable only through a proxy or at a private endpoint
+func ApplyForeignClusterConnection(discoveryClient *crdClient.CRDClient, clusterID string, cnf *rest.Config) error {
+	tmp, err := discoveryClient.Resource("foreignclusters").List(metav1.ListOptions{
+		LabelSelector: "cluster-id=" + clusterID,
+	})
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	fcList, ok := tmp.(*ForeignClusterList)
+	if !ok {
+		return fmt.Errorf("retrieved object is not a ForeignClusterList")
+	}
+	if len(fcList.Items) == 0 {
+		return nil
+	}
+	return fcList.Items[0].ApplyConnectionConfig(cnf)
+}
+
+// proxyWrapper makes the requests go through the proxy. The transport is cloned, since it can be shared with the
+// clients of other clusters
+func proxyWrapper(proxyURL *url.URL) transport.WrapperFunc {
+	return func(rt http.RoundTripper) http.RoundTripper {
+		t, ok := rt.(*http.Transport)
+		if !ok {
+			klog.Warningf("unable to use the proxy %s with a custom transport", proxyURL.Host)
+			return rt
+		}
+		t = t.Clone()
+		// HTTP/2 is configured again, binding it to the cloned transport
+		t.TLSNextProto = nil
+		t.Proxy = http.ProxyURL(proxyURL)
+		return utilnet.SetTransportDefaults(t)
+	}
+}
+
+// selectApiUrl returns the first URL answering, or an empty string if none of them does
+func selectApiUrl(cnf *rest.Config, apiUrls []string) string {
+	for _, apiUrl := range apiUrls {
+		if apiUrl == "" {
+			continue
+		}
+		probe := rest.CopyConfig(cnf)
+		probe.Host = apiUrl
+		if err := probeApiUrl(probe); err != nil {
+			klog.V(4).Infof("API server not reachable at %s: %v", apiUrl, err)
+			continue
+		}
+		if apiUrl != cnf.Host {
+			klog.Infof("API server not reachable at %s, using %s", cnf.Host, apiUrl)
+		}
+		return apiUrl
+	}
+	klog.Warningf("API server not reachable at any of the URLs %v", apiUrls)
+	return ""
+}
+
+func containsApiUrl(apiUrls []string, apiUrl string) bool {
+	for _, u := range apiUrls {
+		if u != "" && u == apiUrl {
+			return true
+		}
+	}
+	return false
+}
+
+// probeApiUrl returns nil if the API server answers at the host of the config, even if the request is not authorized
+func probeApiUrl(cnf *rest.Config) error {
+	rt, err := rest.TransportFor(cnf)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Transport: rt, Timeout: apiUrlProbeTimeout}
+	resp, err := client.Get(strings.TrimSuffix(cnf.Host, "/") + "/version")
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
@@ -3,8 +3,11 @@ package v1alpha1
 import (
 	"context"
 	"crypto/x509"
+	"encoding/json"
 	goerrors "errors"
+	"fmt"
 	advtypes "github.com/liqotech/liqo/apis/sharing/v1alpha1"
+	"github.com/liqotech/liqo/pkg/auth"
 	"github.com/liqotech/liqo/pkg/crdClient"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
@@ -12,6 +15,7 @@ import (
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/util/retry"
 	"k8s.io/klog"
 	"k8s.io/utils/pointer"
 	"strconv"
@@ -42,6 +46,9 @@ func (fc *ForeignCluster) GetConfig(client kubernetes.Interface) (*rest.Config,
 	cnf.APIPath = "/apis"
 	cnf.NegotiatedSerializer = scheme.Codecs.WithoutConversion()
 	cnf.UserAgent = rest.DefaultKubernetesUserAgent()
+	if err := fc.ApplyConnectionConfig(&cnf); err != nil {
+		return nil, err
+	}
 	return &cnf, nil
 }
 
@@ -52,6 +59,9 @@ func (fc *ForeignCluster) CheckTrusted() (bool, error) {
 			Insecure: false,
 		},
 	}
+	if err := fc.ApplyConnectionConfig(cnf); err != nil {
+		return false, err
+	}
 	client, err := kubernetes.NewForConfig(cnf)
 	if err != nil {
 		return false, err
@@ -71,19 +81,25 @@ func (fc *ForeignCluster) CheckTrusted() (bool, error) {
 	return false, nil
 }
 
-func (fc *ForeignCluster) getInsecureConfig() *rest.Config {
+func (fc *ForeignCluster) getInsecureConfig() (*rest.Config, error) {
 	cnf := rest.Config{
 		Host: fc.Spec.ApiUrl,
 		TLSClientConfig: rest.TLSClientConfig{
 			Insecure: true,
 		},
 	}
-	return &cnf
+	if err := fc.ApplyConnectionConfig(&cnf); err != nil {
+		return nil, err
+	}
+	return &cnf, nil
 }
 
 func (fc *ForeignCluster) LoadForeignCA(localClient kubernetes.Interface, localNamespace string, config *rest.Config) error {
+	var err error
 	if config == nil {
-		config = fc.getInsecureConfig()
+		if config, err = fc.getInsecureConfig(); err != nil {
+			return err
+		}
 	}
 	client, err := kubernetes.NewForConfig(config)
 	if err != nil {
@@ -93,6 +109,9 @@ func (fc *ForeignCluster) LoadForeignCA(localClient kubernetes.Interface, localN
 	if err != nil {
 		return err
 	}
+	if err = fc.CheckPinnedCA(auth.CAFingerprint(secret.Data["ca.crt"])); err != nil {
+		return err
+	}
 	localSecret := &v1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
 			Name: fc.Name + "-ca-data",
@@ -114,8 +133,13 @@ func (fc *ForeignCluster) LoadForeignCA(localClient kubernetes.Interface, localN
 		if !errors.IsAlreadyExists(err) {
 			return err
 		}
-		// already exists
-		localSecret, err = localClient.CoreV1().Secrets(localNamespace).Get(context.TODO(), fc.Name+"-ca-data", metav1.GetOptions{})
+		// already exists, it may contain a previous CA
+		existing, err := localClient.CoreV1().Secrets(localNamespace).Get(context.TODO(), fc.Name+"-ca-data", metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		existing.Data = localSecret.Data
+		localSecret, err = localClient.CoreV1().Secrets(localNamespace).Update(context.TODO(), existing, metav1.UpdateOptions{})
 		if err != nil {
 			return err
 		}
@@ -130,6 +154,83 @@ func (fc *ForeignCluster) LoadForeignCA(localClient kubernetes.Interface, localN
 	return nil
 }
 
+// CAMismatchError is returned when the foreign cluster presents a CA different from the pinned one
+type CAMismatchError struct {
+	Pinned    string
+	Presented string
+}
+
+func (e *CAMismatchError) Error() string {
+	return fmt.Sprintf("the foreign cluster presents the CA %s instead of the pinned %s", e.Presented, e.Pinned)
+}
+
+// CheckPinnedCA checks that the fingerprint of the CA presented by the foreign cluster matches the pinned one, if any
+func (fc *ForeignCluster) CheckPinnedCA(fingerprint string) error {
+	if fc.Status.PinnedCA == nil || fc.Status.PinnedCA.Fingerprint == fingerprint {
+		return nil
+	}
+	return &CAMismatchError{Pinned: fc.Status.PinnedCA.Fingerprint, Presented: fingerprint}
+}
+
+// PinCA pins the CA presented by the foreign cluster if none is pinned yet, otherwise it checks that it matches the
+// pinned one. It returns true if the CA has been pinned.
+func (fc *ForeignCluster) PinCA(fingerprint string) (bool, error) {
+	if fingerprint == "" {
+		return false, goerrors.New("the fingerprint of the CA is empty")
+	}
+	if fc.Status.PinnedCA != nil {
+		return false, fc.CheckPinnedCA(fingerprint)
+	}
+	fc.Status.PinnedCA = &PinnedCA{
+		Fingerprint: fingerprint,
+		PinnedTime:  metav1.Now(),
+	}
+	return true, nil
+}
+
+// SetAnnouncement records the signature of an announcement of the foreign cluster, the key fingerprint is empty if it is
+// unsigned. The key is pinned at the first signed announcement, then the unsigned announcements and the ones signed
+// with other keys are refused. It returns true if the status has been changed.
+func (fc *ForeignCluster) SetAnnouncement(keyFingerprint string, caFingerprint string) (bool, error) {
+	current := fc.Status.Announcement
+	if current != nil && current.KeyFingerprint != "" && current.KeyFingerprint != keyFingerprint {
+		if keyFingerprint == "" {
+			return false, fmt.Errorf("the announcement is not signed, the announcements of the foreign cluster are signed with the key %s", current.KeyFingerprint)
+		}
+		return false, fmt.Errorf("the announcement is signed with the key %s instead of the pinned %s", keyFingerprint, current.KeyFingerprint)
+	}
+	announcement := &AnnouncementStatus{
+		Signed:         keyFingerprint != "",
+		KeyFingerprint: keyFingerprint,
+		CAFingerprint:  caFingerprint,
+	}
+	if current != nil && *current == *announcement {
+		return false, nil
+	}
+	fc.Status.Announcement = announcement
+	return true, nil
+}
+
+// SetIdentity records the identity presented by the auth-service of the foreign cluster. The key signing it is pinned
+// at the first verification, then the identities signed with other keys, or not signed at all, are refused.
+func (fc *ForeignCluster) SetIdentity(identity *IdentityStatus) error {
+	current := fc.Status.Identity
+	if current != nil && current.KeyFingerprint != "" && current.KeyFingerprint != identity.KeyFingerprint {
+		if identity.KeyFingerprint == "" {
+			return fmt.Errorf("the identity is not signed, the identity of the foreign cluster is signed with the key %s", current.KeyFingerprint)
+		}
+		return fmt.Errorf("the identity is signed with the key %s instead of the pinned %s", identity.KeyFingerprint, current.KeyFingerprint)
+	}
+	fc.Status.Identity = identity
+	return nil
+}
+
+// IsAnnouncementSigned returns true if the foreign cluster has been discovered with announcements signed by its pinned
+// key
+func (fc *ForeignCluster) IsAnnouncementSigned() bool {
+	return fc.Status.Announcement != nil && fc.Status.Announcement.Signed
+}
+
 func (fc *ForeignCluster) SetAdvertisement(adv *advtypes.Advertisement, discoveryClient *crdClient.CRDClient) error {
 	if fc.Status.Outgoing.Advertisement == nil {
 		// Advertisement has not been set in ForeignCluster yet
@@ -166,31 +267,227 @@ func (fc *ForeignCluster) HasHigherPriority(discoveryType DiscoveryType) bool {
 	return fc.Spec.DiscoveryType == IncomingPeeringDiscovery && discoveryType != IncomingPeeringDiscovery
 }
 
-// sets lastUpdate annotation to current time
+// IsIncomingAllowed returns true if the foreign cluster is allowed to join the local one, which is the default
+func (fc *ForeignCluster) IsIncomingAllowed() bool {
+	return fc.Spec.AllowIncoming == nil || *fc.Spec.AllowIncoming
+}
+
+// peeringSettings are the settings of the peerings with an expired foreign cluster before it has been unpeered
+// +kubebuilder:object:generate=false
+type peeringSettings struct {
+	Join          bool  `json:"join"`
+	AllowIncoming *bool `json:"allowIncoming,omitempty"`
+}
+
+// DisablePeerings disables the outgoing and the incoming peering, recording their settings to restore them with
+// RestorePeerings. It returns true if the ForeignCluster has been changed.
+func (fc *ForeignCluster) DisablePeerings() (bool, error) {
+	if !fc.Spec.Join && !fc.IsIncomingAllowed() {
+		return false, nil
+	}
+	if _, recorded := fc.Annotations[ExpiredPeeringAnnotation]; !recorded {
+		settings, err := json.Marshal(&peeringSettings{Join: fc.Spec.Join, AllowIncoming: fc.Spec.AllowIncoming})
+		if err != nil {
+			return false, err
+		}
+		if fc.Annotations == nil {
+			fc.Annotations = map[string]string{}
+		}
+		fc.Annotations[ExpiredPeeringAnnotation] = string(settings)
+	}
+	fc.Spec.Join = false
+	fc.Spec.AllowIncoming = pointer.BoolPtr(false)
+	return true, nil
+}
+
+// RestorePeerings restores the settings of the peerings recorded by DisablePeerings, it returns true if the
+// ForeignCluster has been changed
+func (fc *ForeignCluster) RestorePeerings() (bool, error) {
+	recorded, ok := fc.Annotations[ExpiredPeeringAnnotation]
+	if !ok {
+		return false, nil
+	}
+	settings := &peeringSettings{}
+	if err := json.Unmarshal([]byte(recorded), settings); err != nil {
+		return false, fmt.Errorf("invalid annotation %s: %v", ExpiredPeeringAnnotation, err)
+	}
+	fc.Spec.Join = settings.Join
+	fc.Spec.AllowIncoming = settings.AllowIncoming
+	delete(fc.Annotations, ExpiredPeeringAnnotation)
+	return true, nil
+}
+
+// LastUpdateNow records that the foreign cluster has been seen now by its discovery source
 func (fc *ForeignCluster) LastUpdateNow() {
 	ann := fc.GetAnnotations()
 	if ann == nil {
 		ann = map[string]string{}
 	}
-	ann[LastUpdateAnnotation] = strconv.Itoa(int(time.Now().Unix()))
+	now := time.Now()
+	ann[LastUpdateAnnotation] = strconv.Itoa(int(now.Unix()))
 	fc.SetAnnotations(ann)
+	fc.Status.LastSeen = metav1.NewTime(now)
+}
+
+// GetLastSeen returns the last time the foreign cluster has been seen, falling back to the LastUpdate annotation
+// set by the previous versions and to the creation time. It returns false if it is not known.
+func (fc *ForeignCluster) GetLastSeen() (time.Time, bool) {
+	if !fc.Status.LastSeen.IsZero() {
+		return fc.Status.LastSeen.Time, true
+	}
+	if lastUpdate, ok := fc.GetAnnotations()[LastUpdateAnnotation]; ok {
+		lu, err := strconv.Atoi(lastUpdate)
+		if err != nil {
+			klog.Error(err)
+			// considered as expired
+			return time.Unix(0, 0), true
+		}
+		return time.Unix(int64(lu), 0), true
+	}
+	if !fc.CreationTimestamp.IsZero() {
+		return fc.CreationTimestamp.Time, true
+	}
+	return time.Time{}, false
 }
 
+// IsExpired returns true if the foreign cluster has not been seen within its TTL
 func (fc *ForeignCluster) IsExpired() bool {
-	ann := fc.GetAnnotations()
-	if ann == nil {
+	return fc.IsExpiredAfter(0)
+}
+
+// IsExpiredAfter returns true if the foreign cluster has not been seen within its TTL and the grace period
+func (fc *ForeignCluster) IsExpiredAfter(gracePeriod time.Duration) bool {
+	lastSeen, ok := fc.GetLastSeen()
+	if !ok {
 		return false
 	}
+	return time.Since(lastSeen) > time.Duration(fc.Status.Ttl)*time.Second+gracePeriod
+}
 
-	lastUpdate, ok := ann[LastUpdateAnnotation]
-	if !ok {
+// IsPeered returns true if the outgoing or the incoming peering with the foreign cluster is established
+func (fc *ForeignCluster) IsPeered() bool {
+	return fc.Status.Outgoing.Joined || fc.Status.Incoming.Joined
+}
+
+// SetCondition adds or updates the condition of the ForeignCluster, changing its transition time only if the status is
+// different. It returns true if the conditions have been modified.
+func (fc *ForeignCluster) SetCondition(condition Condition) bool {
+	condition.ObservedGeneration = fc.Generation
+	return SetCondition(&fc.Status.Conditions, condition)
+}
+
+// GetCondition returns the condition of the given type, nil if it is not set
+func (fc *ForeignCluster) GetCondition(conditionType ConditionType) *Condition {
+	return FindCondition(fc.Status.Conditions, conditionType)
+}
+
+// RemoveCondition removes the condition of the given type, it returns true if it was present.
+func (fc *ForeignCluster) RemoveCondition(conditionType ConditionType) bool {
+	return RemoveCondition(&fc.Status.Conditions, conditionType)
+}
+
+// SetNetworkCondition adds or updates the condition of the network of the ForeignCluster, it returns true if the
+// conditions have been modified.
+func (fc *ForeignCluster) SetNetworkCondition(condition Condition) bool {
+	condition.ObservedGeneration = fc.Generation
+	return SetCondition(&fc.Status.Network.Conditions, condition)
+}
+
+// RemoveNetworkCondition removes the condition of the network of the given type, it returns true if it was present.
+func (fc *ForeignCluster) RemoveNetworkCondition(conditionType ConditionType) bool {
+	return RemoveCondition(&fc.Status.Network.Conditions, conditionType)
+}
+
+// peeringSteps are the conditions of the outgoing peering, in the order they are satisfied, with the phase of the
+// foreign cluster while each one is not
+var peeringSteps = []struct {
+	condition ConditionType
+	phase     ForeignClusterPhase
+}{
+	{AuthenticationSucceededCondition, AuthenticatingPhase},
+	{PeeringRequestAcceptedCondition, PeeringPhase},
+	{AdvertisementAcceptedCondition, PeeringPhase},
+	{NetworkConfigExchangedCondition, NetworkingPhase},
+	{TunnelEstablishedCondition, NetworkingPhase},
+	{VirtualNodeReadyCondition, PeeringPhase},
+}
+
+// GetPhase computes the stage of the peering with the foreign cluster from its conditions
+func (fc *ForeignCluster) GetPhase() ForeignClusterPhase {
+	if condition := fc.GetCondition(CAMismatchCondition); condition != nil && condition.Status == v1.ConditionTrue {
+		return FailedPhase
+	}
+	if !fc.Spec.Join || !fc.DeletionTimestamp.IsZero() {
+		drained := fc.GetCondition(WorkloadDrainedCondition)
+		switch {
+		case fc.Status.Outgoing.Joined && drained != nil && drained.Status != v1.ConditionTrue:
+			return DrainingPhase
+		case fc.Status.Outgoing.Joined:
+			return UnpeeringPhase
+		case fc.Status.Incoming.Joined:
+			return PeeredPhase
+		default:
+			return DiscoveredPhase
+		}
+	}
+	// the refusals of the foreign cluster are not retried
+	for _, conditionType := range []ConditionType{PeeringRequestAcceptedCondition, AdvertisementAcceptedCondition} {
+		if condition := fc.GetCondition(conditionType); condition != nil && condition.Status == v1.ConditionFalse {
+			return FailedPhase
+		}
+	}
+	for _, step := range peeringSteps {
+		if condition := fc.GetCondition(step.condition); condition == nil || condition.Status != v1.ConditionTrue {
+			return step.phase
+		}
+	}
+	return PeeredPhase
+}
+
+// UpdatePhase sets the phase computed from the conditions, it returns true if it has changed
+func (fc *ForeignCluster) UpdatePhase() bool {
+	phase := fc.GetPhase()
+	if fc.Status.Phase == phase {
 		return false
 	}
-	lu, err := strconv.Atoi(lastUpdate)
-	if err != nil {
-		klog.Error(err)
-		return true
+	fc.Status.Phase = phase
+	return true
+}
+
+// RemovePeeringConditions removes the conditions of the outgoing peering which do not hold once it has been torn down,
+// it returns true if any of them was present
+func (fc *ForeignCluster) RemovePeeringConditions() bool {
+	removed := false
+	for _, conditionType := range []ConditionType{PeeringRequestAcceptedCondition, AdvertisementAcceptedCondition, VirtualNodeReadyCondition} {
+		removed = fc.RemoveCondition(conditionType) || removed
 	}
-	now := time.Now().Unix()
-	return int64(lu+int(fc.Status.Ttl)) < now
+	return removed
+}
+
+// SetForeignClusterCondition sets the condition of the ForeignCluster of the cluster with the given ID, and its phase,
+// updating it only if they have changed. It is used by the components taking part in the peering to report its
+// progress.
+func SetForeignClusterCondition(discoveryClient *crdClient.CRDClient, clusterID string, condition Condition) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		tmp, err := discoveryClient.Resource("foreignclusters").List(metav1.ListOptions{
+			LabelSelector: "cluster-id=" + clusterID,
+		})
+		if err != nil {
+			return err
+		}
+		fcList, ok := tmp.(*ForeignClusterList)
+		if !ok {
+			return goerrors.New("retrieved object is not a ForeignClusterList")
+		}
+		if len(fcList.Items) == 0 {
+			return fmt.Errorf("ForeignCluster not found for cluster id %s", clusterID)
+		}
+		fc := &fcList.Items[0]
+		updated := fc.SetCondition(condition)
+		if !fc.UpdatePhase() && !updated {
+			return nil
+		}
+		_, err = discoveryClient.Resource("foreignclusters").Update(fc.Name, fc, metav1.UpdateOptions{})
+		return err
+	})
 }
@@ -17,9 +17,11 @@ limitations under the License.
 package v1alpha1
 
 import (
+	netv1alpha1 "github.com/liqotech/liqo/apis/net/v1alpha1"
 	advtypes "github.com/liqotech/liqo/apis/sharing/v1alpha1"
 	"github.com/liqotech/liqo/pkg/crdClient"
 	v1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/client-go/kubernetes/scheme"
@@ -35,6 +37,8 @@ const (
 	WanDiscovery             DiscoveryType = "WAN"
 	ManualDiscovery          DiscoveryType = "Manual"
 	IncomingPeeringDiscovery DiscoveryType = "IncomingPeering"
+	// the cluster has been announced in a shared registry cluster
+	RegistryDiscovery DiscoveryType = "Registry"
 )
 
 type TrustMode string
@@ -47,6 +51,12 @@ const (
 
 const (
 	LastUpdateAnnotation string = "LastUpdate"
+	// Set to "true" to accept the CA currently presented by the foreign cluster, replacing the pinned one, and the key
+	// signing its next announcement
+	RepinCAAnnotation string = "discovery.liqo.io/repin-ca"
+	// Records the peering settings of an expired ForeignCluster unpeered by the garbage collector, they are restored
+	// when the cluster is seen again
+	ExpiredPeeringAnnotation string = "discovery.liqo.io/expired-peering"
 )
 
 // ForeignClusterSpec defines the desired state of ForeignCluster
@@ -58,12 +68,47 @@ type ForeignClusterSpec struct {
 	ClusterIdentity ClusterIdentity `json:"clusterIdentity"`
 	// Namespace where Liqo is deployed
 	Namespace string `json:"namespace"`
-	// Enable join process to foreign cluster
+	// Enable the outgoing peering: join the foreign cluster to offload to it
 	Join bool `json:"join"`
+	// Allow the incoming peering: the foreign cluster can join this one to offload to it. If false, its
+	// PeeringRequests are refused and the existing one is deleted
+	// +kubebuilder:default=true
+	// +optional
+	AllowIncoming *bool `json:"allowIncoming,omitempty"`
 	// URL where to contact foreign API server
 	ApiUrl string `json:"apiUrl"`
+	// How to reach the foreign API server when it is not directly reachable at its URL, applied to every client of the
+	// foreign cluster
+	// +optional
+	Connection *ConnectionConfig `json:"connection,omitempty"`
 	// How this ForeignCluster has been discovered
 	DiscoveryType DiscoveryType `json:"discoveryType"`
+	// MTU of the tunnel towards this cluster, it overrides the one computed from the interface of the gateway
+	// +kubebuilder:validation:Minimum=576
+	// +optional
+	MTU int `json:"mtu,omitempty"`
+	// Traffic that this cluster is allowed to send to the local pods, if not set all the traffic is allowed
+	// +optional
+	IngressPolicy *netv1alpha1.IngressPolicy `json:"ingressPolicy,omitempty"`
+}
+
+// ConnectionConfig defines how the API server of the foreign cluster is contacted, e.g. through a proxy or at a private
+// endpoint
+type ConnectionConfig struct {
+	// URL of the proxy the API server is contacted through, the supported schemes are http, https and socks5
+	// +kubebuilder:validation:Pattern=`^(http|https|socks5)://`
+	// +optional
+	ProxyURL string `json:"proxyURL,omitempty"`
+	// PEM encoded CA certificates the API server is verified with, in addition to the CA retrieved from the foreign
+	// cluster. When set, the CAs of the system are not used
+	// +optional
+	CABundle []byte `json:"caBundle,omitempty"`
+	// Name sent with SNI and checked in the certificate of the API server, instead of the host of its URL
+	// +optional
+	ServerName string `json:"serverName,omitempty"`
+	// URLs of the API server tried in order when it does not answer at its URL
+	// +optional
+	AlternativeApiUrls []string `json:"alternativeApiUrls,omitempty"`
 }
 
 type ClusterIdentity struct {
@@ -80,14 +125,140 @@ type ForeignClusterStatus struct {
 
 	Outgoing Outgoing `json:"outgoing,omitempty"`
 	Incoming Incoming `json:"incoming,omitempty"`
-	// If discoveryType is LAN and this counter reach 0 value, this FC will be removed
+	// Seconds the foreign cluster is valid for after it has been seen, as announced by the discovery source. After
+	// them, and the grace period of its discovery type, this FC will be removed
 	Ttl uint32 `json:"ttl,omitempty"`
+	// Last time the foreign cluster has been seen by its discovery source
+	LastSeen metav1.Time `json:"lastSeen,omitempty"`
 	// +kubebuilder:validation:Enum="Unknown";"Trusted";"Untrusted"
 	// +kubebuilder:default="Unknown"
 	// Indicates if this remote cluster is trusted or not
 	TrustMode TrustMode `json:"trustMode,omitempty"`
 	// It stores most important network statuses
 	Network Network `json:"network,omitempty"`
+	// Permissions granted in the local cluster to the identities of the foreign cluster
+	GrantedPermissions []GrantedPermissions `json:"grantedPermissions,omitempty"`
+	// CA of the foreign cluster pinned at the first join, a different CA is refused until it is pinned again
+	PinnedCA *PinnedCA `json:"pinnedCA,omitempty"`
+	// Signature of the announcements of the foreign cluster in LAN and WAN discovery
+	Announcement *AnnouncementStatus `json:"announcement,omitempty"`
+	// Identity presented by the auth-service of the foreign cluster when it has been discovered
+	Identity *IdentityStatus `json:"identity,omitempty"`
+	// API URL selected among the alternative ones of the connection settings, used by the clients of the foreign cluster
+	Connection *ConnectionStatus `json:"connection,omitempty"`
+	// Stage of the peering with the foreign cluster, computed from the conditions
+	Phase ForeignClusterPhase `json:"phase,omitempty"`
+	// Conditions about the foreign cluster
+	Conditions []Condition `json:"conditions,omitempty"`
+}
+
+type ForeignClusterPhase string
+
+const (
+	// No peering with the foreign cluster has been requested
+	DiscoveredPhase ForeignClusterPhase = "Discovered"
+	// The local cluster is retrieving the CA of the foreign cluster and authenticating to its API server
+	AuthenticatingPhase ForeignClusterPhase = "Authenticating"
+	// Waiting for the PeeringRequest and the Advertisement to be accepted, and for the virtual node to be ready
+	PeeringPhase ForeignClusterPhase = "Peering"
+	// Waiting for the NetworkConfigs to be exchanged and for the tunnel to be established
+	NetworkingPhase ForeignClusterPhase = "Networking"
+	// Every step of the outgoing peering has succeeded, or the foreign cluster has joined the local one
+	PeeredPhase ForeignClusterPhase = "Peered"
+	// The pods offloaded to the virtual node are being evicted before tearing down the outgoing peering
+	DrainingPhase ForeignClusterPhase = "Draining"
+	// The outgoing peering is being torn down
+	UnpeeringPhase ForeignClusterPhase = "Unpeering"
+	// The peering has been refused, the conditions report why
+	FailedPhase ForeignClusterPhase = "Failed"
+)
+
+type PinnedCA struct {
+	// Hex encoded SHA-256 of the DER encoding of the CA certificate
+	Fingerprint string `json:"fingerprint"`
+	// Time the CA has been pinned
+	PinnedTime metav1.Time `json:"pinnedTime,omitempty"`
+}
+
+type ConnectionStatus struct {
+	// First URL answering among the API URL and the alternative ones, or the API URL if none of them answers
+	ApiUrl string `json:"apiUrl"`
+	// Last time the URLs have been probed, they are probed again after ApiUrlProbeInterval
+	LastProbeTime metav1.Time `json:"lastProbeTime,omitempty"`
+}
+
+type AnnouncementStatus struct {
+	// True if the last announcement has been signed with the pinned key
+	Signed bool `json:"signed"`
+	// Hex encoded SHA-256 of the DER encoding of the public key signing the announcements, pinned at the first signed
+	// announcement. Once it is pinned the unsigned announcements and the ones signed with other keys are refused until
+	// it is pinned again by setting the RepinCAAnnotation
+	KeyFingerprint string `json:"keyFingerprint,omitempty"`
+	// Fingerprint of the CA of the foreign cluster stated in the signed announcements, in the format of the pinned CA
+	CAFingerprint string `json:"caFingerprint,omitempty"`
+}
+
+type IdentityVerification string
+
+const (
+	// The auth-service of the foreign cluster presented a signed identity matching the discovered data
+	IdentityVerified IdentityVerification = "Verified"
+	// The auth-service of the foreign cluster does not serve a signed identity, e.g. it runs an older version
+	IdentityUnverified IdentityVerification = "Unverified"
+)
+
+type IdentityStatus struct {
+	// +kubebuilder:validation:Enum="Verified";"Unverified"
+	// Result of the verification of the identity of the foreign cluster
+	Verification IdentityVerification `json:"verification"`
+	// Hex encoded SHA-256 of the DER encoding of the public key of the cluster identity signing the identity, pinned at
+	// the first verification. The identities signed with other keys are refused until it is pinned again by setting the
+	// RepinCAAnnotation
+	KeyFingerprint string `json:"keyFingerprint,omitempty"`
+}
+
+// the conditions of the ForeignCluster
+const (
+	// The foreign cluster presents a CA different from the pinned one, the peering is refused until the CA is pinned
+	// again by setting the RepinCAAnnotation
+	CAMismatchCondition ConditionType = "CAMismatch"
+	// The foreign cluster has not been seen by its discovery source within its TTL and grace period, but it is kept
+	// because it is peered
+	ExpiredCondition ConditionType = "Expired"
+
+	// The conditions of the outgoing peering, in the order they are satisfied
+
+	// The CA of the foreign cluster has been loaded and its API server accepted the local cluster
+	AuthenticationSucceededCondition ConditionType = "AuthenticationSucceeded"
+	// The PeeringRequest has been admitted by the foreign cluster, it is False if its peering policy denied it
+	PeeringRequestAcceptedCondition ConditionType = "PeeringRequestAccepted"
+	// The Advertisement of the foreign cluster has been accepted by the advertisement operator, it is False if it has
+	// been refused
+	AdvertisementAcceptedCondition ConditionType = "AdvertisementAccepted"
+	// Both the local and the remote NetworkConfigs are available
+	NetworkConfigExchangedCondition ConditionType = "NetworkConfigExchanged"
+	// The tunnel has been installed by liqonet and the remote gateway answers the probes sent through it
+	TunnelEstablishedCondition ConditionType = "TunnelEstablished"
+	// The virtual node of the foreign cluster is ready, as reported by the virtual kubelet
+	VirtualNodeReadyCondition ConditionType = "VirtualNodeReady"
+
+	// The virtual node has been cordoned and the pods offloaded to it have been evicted, before tearing down the
+	// outgoing peering. It is False while they are evicted, and if they have not been evicted within the timeout
+	WorkloadDrainedCondition ConditionType = "WorkloadDrained"
+)
+
+// GrantedPermissions contains the rules granted to an identity of the foreign cluster by a binding
+type GrantedPermissions struct {
+	// Identity the rules are granted to
+	Subject rbacv1.Subject `json:"subject"`
+	// Name of the binding granting the rules
+	Binding string `json:"binding"`
+	// Namespace the rules are granted in, empty if they are granted in the whole cluster
+	Namespace string `json:"namespace,omitempty"`
+	// Role containing the rules
+	RoleRef rbacv1.RoleRef `json:"roleRef"`
+	// Rules granted
+	Rules []rbacv1.PolicyRule `json:"rules,omitempty"`
 }
 
 type ResourceLink struct {
@@ -104,8 +275,16 @@ type Network struct {
 	RemoteNetworkConfig ResourceLink `json:"remoteNetworkConfig"`
 	// TunnelEndpoint link
 	TunnelEndpoint ResourceLink `json:"tunnelEndpoint"`
+	// Conditions about the data plane towards the foreign cluster
+	Conditions []Condition `json:"conditions,omitempty"`
 }
 
+// the conditions of the Network
+const (
+	// The tunnel towards the foreign cluster is up and the probes sent through it are answered
+	TunnelConnectedCondition ConditionType = "TunnelConnected"
+)
+
 type Outgoing struct {
 	// Indicates if peering request has been created and this remote cluster is sharing its resources to us
 	Joined bool `json:"joined"`
@@ -138,6 +317,11 @@ type Incoming struct {
 
 // +kubebuilder:object:root=true
 // +kubebuilder:resource:scope=Cluster
+// +kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+// +kubebuilder:printcolumn:name="Trust",type=string,JSONPath=`.status.trustMode`
+// +kubebuilder:printcolumn:name="Outgoing",type=boolean,JSONPath=`.status.outgoing.joined`
+// +kubebuilder:printcolumn:name="Incoming",type=boolean,JSONPath=`.status.incoming.joined`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 
 // ForeignCluster is the Schema for the foreignclusters API
 type ForeignCluster struct {
@@ -36,8 +36,29 @@ type SearchDomainSpec struct {
 	Domain string `json:"domain"`
 	// Enable join process for retrieved clusters
 	AutoJoin bool `json:"autojoin"`
+	// DNS servers queried in order until one answers, as host:port, or tls://host:port for DNS-over-TLS.
+	// If empty, the servers in /etc/resolv.conf are used
+	// +optional
+	DNSServers []string `json:"dnsServers,omitempty"`
+	// DNSSEC validation of the answers: the DNS server has to validate them, and the authenticated flag of its answers
+	// is checked. The flag is trusted only from the servers contacted with DNS-over-TLS or running on the local host.
+	// With Optional the answers which are not authenticated are accepted, but the retrieved clusters are never
+	// considered as trusted, with Enforced they are refused, and only the servers contacted on a secure channel can
+	// be used
+	// +kubebuilder:validation:Enum="Disabled";"Optional";"Enforced"
+	// +kubebuilder:default="Disabled"
+	// +optional
+	DNSSEC DNSSECMode `json:"dnssec,omitempty"`
 }
 
+type DNSSECMode string
+
+const (
+	DNSSECDisabled DNSSECMode = "Disabled"
+	DNSSECOptional DNSSECMode = "Optional"
+	DNSSECEnforced DNSSECMode = "Enforced"
+)
+
 // SearchDomainStatus defines the observed state of SearchDomain
 type SearchDomainStatus struct {
 	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
@@ -45,6 +66,10 @@ type SearchDomainStatus struct {
 
 	// ForeignCluster created basing on this SearchDomain
 	ForeignClusters []v1.ObjectReference `json:"foreignClusters"`
+	// Indicates if all the answers of the last resolution have been authenticated by DNSSEC
+	DNSSECValidated bool `json:"dnssecValidated,omitempty"`
+	// DNS server which answered the last resolution
+	DNSServer string `json:"dnsServer,omitempty"`
 }
 
 // +kubebuilder:object:root=true
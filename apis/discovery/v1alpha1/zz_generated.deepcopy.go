@@ -20,11 +20,144 @@ limitations under the License.
 package v1alpha1
 
 import (
+	netv1alpha1 "github.com/liqotech/liqo/apis/net/v1alpha1"
 	"github.com/liqotech/liqo/pkg/object-references"
-	"k8s.io/api/core/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/api/rbac/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AnnouncementStatus) DeepCopyInto(out *AnnouncementStatus) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AnnouncementStatus.
+func (in *AnnouncementStatus) DeepCopy() *AnnouncementStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(AnnouncementStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CAMismatchError) DeepCopyInto(out *CAMismatchError) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CAMismatchError.
+func (in *CAMismatchError) DeepCopy() *CAMismatchError {
+	if in == nil {
+		return nil
+	}
+	out := new(CAMismatchError)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ClusterAnnouncement) DeepCopyInto(out *ClusterAnnouncement) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ClusterAnnouncement.
+func (in *ClusterAnnouncement) DeepCopy() *ClusterAnnouncement {
+	if in == nil {
+		return nil
+	}
+	out := new(ClusterAnnouncement)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ClusterAnnouncement) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ClusterAnnouncementList) DeepCopyInto(out *ClusterAnnouncementList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]ClusterAnnouncement, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ClusterAnnouncementList.
+func (in *ClusterAnnouncementList) DeepCopy() *ClusterAnnouncementList {
+	if in == nil {
+		return nil
+	}
+	out := new(ClusterAnnouncementList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ClusterAnnouncementList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ClusterAnnouncementSpec) DeepCopyInto(out *ClusterAnnouncementSpec) {
+	*out = *in
+	out.ClusterIdentity = in.ClusterIdentity
+	if in.PublicKey != nil {
+		in, out := &in.PublicKey, &out.PublicKey
+		*out = make([]byte, len(*in))
+		copy(*out, *in)
+	}
+	if in.Signature != nil {
+		in, out := &in.Signature, &out.Signature
+		*out = make([]byte, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ClusterAnnouncementSpec.
+func (in *ClusterAnnouncementSpec) DeepCopy() *ClusterAnnouncementSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ClusterAnnouncementSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ClusterAnnouncementStatus) DeepCopyInto(out *ClusterAnnouncementStatus) {
+	*out = *in
+	in.LastHeartbeatTime.DeepCopyInto(&out.LastHeartbeatTime)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ClusterAnnouncementStatus.
+func (in *ClusterAnnouncementStatus) DeepCopy() *ClusterAnnouncementStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ClusterAnnouncementStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ClusterIdentity) DeepCopyInto(out *ClusterIdentity) {
 	*out = *in
@@ -40,12 +173,69 @@ func (in *ClusterIdentity) DeepCopy() *ClusterIdentity {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Condition) DeepCopyInto(out *Condition) {
+	*out = *in
+	in.LastTransitionTime.DeepCopyInto(&out.LastTransitionTime)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Condition.
+func (in *Condition) DeepCopy() *Condition {
+	if in == nil {
+		return nil
+	}
+	out := new(Condition)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ConnectionConfig) DeepCopyInto(out *ConnectionConfig) {
+	*out = *in
+	if in.CABundle != nil {
+		in, out := &in.CABundle, &out.CABundle
+		*out = make([]byte, len(*in))
+		copy(*out, *in)
+	}
+	if in.AlternativeApiUrls != nil {
+		in, out := &in.AlternativeApiUrls, &out.AlternativeApiUrls
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ConnectionConfig.
+func (in *ConnectionConfig) DeepCopy() *ConnectionConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(ConnectionConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ConnectionStatus) DeepCopyInto(out *ConnectionStatus) {
+	*out = *in
+	in.LastProbeTime.DeepCopyInto(&out.LastProbeTime)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ConnectionStatus.
+func (in *ConnectionStatus) DeepCopy() *ConnectionStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ConnectionStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ForeignCluster) DeepCopyInto(out *ForeignCluster) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
-	out.Spec = in.Spec
+	in.Spec.DeepCopyInto(&out.Spec)
 	in.Status.DeepCopyInto(&out.Status)
 }
 
@@ -103,6 +293,21 @@ func (in *ForeignClusterList) DeepCopyObject() runtime.Object {
 func (in *ForeignClusterSpec) DeepCopyInto(out *ForeignClusterSpec) {
 	*out = *in
 	out.ClusterIdentity = in.ClusterIdentity
+	if in.AllowIncoming != nil {
+		in, out := &in.AllowIncoming, &out.AllowIncoming
+		*out = new(bool)
+		**out = **in
+	}
+	if in.Connection != nil {
+		in, out := &in.Connection, &out.Connection
+		*out = new(ConnectionConfig)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.IngressPolicy != nil {
+		in, out := &in.IngressPolicy, &out.IngressPolicy
+		*out = new(netv1alpha1.IngressPolicy)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ForeignClusterSpec.
@@ -120,7 +325,42 @@ func (in *ForeignClusterStatus) DeepCopyInto(out *ForeignClusterStatus) {
 	*out = *in
 	in.Outgoing.DeepCopyInto(&out.Outgoing)
 	in.Incoming.DeepCopyInto(&out.Incoming)
+	in.LastSeen.DeepCopyInto(&out.LastSeen)
 	in.Network.DeepCopyInto(&out.Network)
+	if in.GrantedPermissions != nil {
+		in, out := &in.GrantedPermissions, &out.GrantedPermissions
+		*out = make([]GrantedPermissions, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.PinnedCA != nil {
+		in, out := &in.PinnedCA, &out.PinnedCA
+		*out = new(PinnedCA)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Announcement != nil {
+		in, out := &in.Announcement, &out.Announcement
+		*out = new(AnnouncementStatus)
+		**out = **in
+	}
+	if in.Identity != nil {
+		in, out := &in.Identity, &out.Identity
+		*out = new(IdentityStatus)
+		**out = **in
+	}
+	if in.Connection != nil {
+		in, out := &in.Connection, &out.Connection
+		*out = new(ConnectionStatus)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ForeignClusterStatus.
@@ -133,17 +373,56 @@ func (in *ForeignClusterStatus) DeepCopy() *ForeignClusterStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GrantedPermissions) DeepCopyInto(out *GrantedPermissions) {
+	*out = *in
+	out.Subject = in.Subject
+	out.RoleRef = in.RoleRef
+	if in.Rules != nil {
+		in, out := &in.Rules, &out.Rules
+		*out = make([]v1.PolicyRule, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GrantedPermissions.
+func (in *GrantedPermissions) DeepCopy() *GrantedPermissions {
+	if in == nil {
+		return nil
+	}
+	out := new(GrantedPermissions)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IdentityStatus) DeepCopyInto(out *IdentityStatus) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IdentityStatus.
+func (in *IdentityStatus) DeepCopy() *IdentityStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(IdentityStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Incoming) DeepCopyInto(out *Incoming) {
 	*out = *in
 	if in.PeeringRequest != nil {
 		in, out := &in.PeeringRequest, &out.PeeringRequest
-		*out = new(v1.ObjectReference)
+		*out = new(corev1.ObjectReference)
 		**out = **in
 	}
 	if in.IdentityRef != nil {
 		in, out := &in.IdentityRef, &out.IdentityRef
-		*out = new(v1.ObjectReference)
+		*out = new(corev1.ObjectReference)
 		**out = **in
 	}
 }
@@ -179,6 +458,13 @@ func (in *Network) DeepCopyInto(out *Network) {
 	in.LocalNetworkConfig.DeepCopyInto(&out.LocalNetworkConfig)
 	in.RemoteNetworkConfig.DeepCopyInto(&out.RemoteNetworkConfig)
 	in.TunnelEndpoint.DeepCopyInto(&out.TunnelEndpoint)
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Network.
@@ -196,17 +482,17 @@ func (in *Outgoing) DeepCopyInto(out *Outgoing) {
 	*out = *in
 	if in.CaDataRef != nil {
 		in, out := &in.CaDataRef, &out.CaDataRef
-		*out = new(v1.ObjectReference)
+		*out = new(corev1.ObjectReference)
 		**out = **in
 	}
 	if in.Advertisement != nil {
 		in, out := &in.Advertisement, &out.Advertisement
-		*out = new(v1.ObjectReference)
+		*out = new(corev1.ObjectReference)
 		**out = **in
 	}
 	if in.IdentityRef != nil {
 		in, out := &in.IdentityRef, &out.IdentityRef
-		*out = new(v1.ObjectReference)
+		*out = new(corev1.ObjectReference)
 		**out = **in
 	}
 }
@@ -286,7 +572,7 @@ func (in *PeeringRequestSpec) DeepCopyInto(out *PeeringRequestSpec) {
 	out.ClusterIdentity = in.ClusterIdentity
 	if in.KubeConfigRef != nil {
 		in, out := &in.KubeConfigRef, &out.KubeConfigRef
-		*out = new(v1.ObjectReference)
+		*out = new(corev1.ObjectReference)
 		**out = **in
 	}
 }
@@ -321,12 +607,28 @@ func (in *PeeringRequestStatus) DeepCopy() *PeeringRequestStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PinnedCA) DeepCopyInto(out *PinnedCA) {
+	*out = *in
+	in.PinnedTime.DeepCopyInto(&out.PinnedTime)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PinnedCA.
+func (in *PinnedCA) DeepCopy() *PinnedCA {
+	if in == nil {
+		return nil
+	}
+	out := new(PinnedCA)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ResourceLink) DeepCopyInto(out *ResourceLink) {
 	*out = *in
 	if in.Reference != nil {
 		in, out := &in.Reference, &out.Reference
-		*out = new(v1.ObjectReference)
+		*out = new(corev1.ObjectReference)
 		**out = **in
 	}
 }
@@ -346,7 +648,7 @@ func (in *SearchDomain) DeepCopyInto(out *SearchDomain) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
-	out.Spec = in.Spec
+	in.Spec.DeepCopyInto(&out.Spec)
 	in.Status.DeepCopyInto(&out.Status)
 }
 
@@ -403,6 +705,11 @@ func (in *SearchDomainList) DeepCopyObject() runtime.Object {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *SearchDomainSpec) DeepCopyInto(out *SearchDomainSpec) {
 	*out = *in
+	if in.DNSServers != nil {
+		in, out := &in.DNSServers, &out.DNSServers
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SearchDomainSpec.
@@ -420,7 +727,7 @@ func (in *SearchDomainStatus) DeepCopyInto(out *SearchDomainStatus) {
 	*out = *in
 	if in.ForeignClusters != nil {
 		in, out := &in.ForeignClusters, &out.ForeignClusters
-		*out = make([]v1.ObjectReference, len(*in))
+		*out = make([]corev1.ObjectReference, len(*in))
 		copy(*out, *in)
 	}
 }
@@ -34,6 +34,12 @@ type NetworkConfigSpec struct {
 	PodCIDR string `json:"podCIDR"`
 	//public IP of the node where the VPN tunnel is created
 	TunnelPublicIP string `json:"tunnelPublicIP"`
+	//network subnet used in the local cluster for the services, set only when the cluster
+	//is configured to reach the remote services without routing the pods' subnet
+	ServiceCIDR string `json:"serviceCIDR,omitempty"`
+	//MTU supported by the tunnel of the local cluster, both ends of the tunnel use the minimum between
+	//the local and the remote one
+	MTU int `json:"mtu,omitempty"`
 }
 
 // NetworkConfigStatus defines the observed state of NetworkConfig
@@ -44,6 +50,8 @@ type NetworkConfigStatus struct {
 	NATEnabled string `json:"natEnabled,omitempty"`
 	//the new subnet used to NAT the pods' subnet of the remote cluster
 	PodCIDRNAT string `json:"podCIDRNAT,omitempty"`
+	//the new subnet used to NAT the services' subnet of the remote cluster
+	ServiceCIDRNAT string `json:"serviceCIDRNAT,omitempty"`
 }
 
 // +kubebuilder:object:root=true
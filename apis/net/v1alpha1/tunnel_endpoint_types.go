@@ -16,6 +16,7 @@ limitations under the License.
 package v1alpha1
 
 import (
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -29,6 +30,39 @@ type TunnelEndpointSpec struct {
 	ClusterID      string `json:"clusterID"`
 	PodCIDR        string `json:"podCIDR"`
 	TunnelPublicIP string `json:"tunnelPublicIP"`
+	ServiceCIDR    string `json:"serviceCIDR,omitempty"`
+	//MTU agreed by the clusters for the tunnel, if not set it is computed from the interface of the gateway
+	MTU int `json:"mtu,omitempty"`
+	//traffic allowed from the remote cluster towards the local pods, if not set all the traffic is allowed
+	IngressPolicy *IngressPolicy `json:"ingressPolicy,omitempty"`
+}
+
+// IngressPolicy restricts the traffic coming from a peered cluster: a connection is accepted if it matches at least one rule
+type IngressPolicy struct {
+	// +optional
+	Rules []IngressRule `json:"rules,omitempty"`
+}
+
+// IngressRule allows the traffic towards the given destinations and ports. An empty field matches everything.
+type IngressRule struct {
+	//namespaces whose pods can be reached
+	// +optional
+	Namespaces []string `json:"namespaces,omitempty"`
+	//local subnets which can be reached
+	// +optional
+	CIDRs []string `json:"cidrs,omitempty"`
+	// +optional
+	Ports []IngressPort `json:"ports,omitempty"`
+}
+
+type IngressPort struct {
+	// +kubebuilder:validation:Enum="TCP";"UDP";"SCTP"
+	// +kubebuilder:default="TCP"
+	// +optional
+	Protocol corev1.Protocol `json:"protocol,omitempty"`
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	Port int32 `json:"port"`
 }
 
 // TunnelEndpointStatus defines the observed state of TunnelEndpoint
@@ -38,11 +72,40 @@ type TunnelEndpointStatus struct {
 	Phase                 string `json:"phase,omitempty"` //two phases: New, Processed
 	LocalRemappedPodCIDR  string `json:"localRemappedPodCIDR,omitempty"`
 	RemoteRemappedPodCIDR string `json:"remoteRemappedPodCIDR,omitempty"`
-	NATEnabled            bool   `json:"NAT,omitempty"`
-	RemoteTunnelPublicIP  string `json:"remoteTunnelPublicIP,omitempty"`
-	LocalTunnelPublicIP   string `json:"localTunnelPublicIP,omitempty"`
-	TunnelIFaceIndex      int    `json:"tunnelIFaceIndex,omitempty"`
-	TunnelIFaceName       string `json:"tunnelIFaceName,omitempty"`
+	//the subnets used to remap the services' subnets when only the service traffic crosses the tunnel
+	LocalRemappedServiceCIDR  string `json:"localRemappedServiceCIDR,omitempty"`
+	RemoteRemappedServiceCIDR string `json:"remoteRemappedServiceCIDR,omitempty"`
+	NATEnabled                bool   `json:"NAT,omitempty"`
+	RemoteTunnelPublicIP      string `json:"remoteTunnelPublicIP,omitempty"`
+	LocalTunnelPublicIP       string `json:"localTunnelPublicIP,omitempty"`
+	TunnelIFaceIndex          int    `json:"tunnelIFaceIndex,omitempty"`
+	TunnelIFaceName           string `json:"tunnelIFaceName,omitempty"`
+	//health of the data plane, measured periodically probing the remote end of the tunnel
+	Connection TunnelConnection `json:"connection,omitempty"`
+}
+
+type ConnectionStatus string
+
+const (
+	//at least one probe of the last round has been answered by the remote cluster
+	Connected ConnectionStatus = "Connected"
+	//all the probes of the last round have been lost
+	ConnectionDown ConnectionStatus = "Down"
+)
+
+// TunnelConnection reports the results of the probes sent through the tunnel
+type TunnelConnection struct {
+	// +kubebuilder:validation:Enum="Connected";"Down"
+	Status ConnectionStatus `json:"status,omitempty"`
+	//average round trip time of the probes answered in the last round written in the status
+	RTT metav1.Duration `json:"rtt,omitempty"`
+	//percentage of the probes lost in the last round written in the status
+	PacketLoss int `json:"packetLoss,omitempty"`
+	//the status is written when the state or the packet loss change, and at least every minute to refresh the RTT and
+	//the last success time: this is the time of the probe round last written
+	LastProbeTime *metav1.Time `json:"lastProbeTime,omitempty"`
+	//time of the last probe round answered by the remote end of the tunnel, known when the status is written
+	LastSuccessTime *metav1.Time `json:"lastSuccessTime,omitempty"`
 }
 
 // +kubebuilder:object:root=true
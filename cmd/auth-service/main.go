@@ -18,6 +18,8 @@ func main() {
 	var listeningPort string
 	var certFile string
 	var keyFile string
+	var limits auth_service.RateLimitConfig
+	var auditEvents bool
 
 	flag.StringVar(&namespace, "namespace", "default", "Namespace where your configs are stored.")
 	flag.StringVar(&kubeconfigPath, "kubeconfigPath", filepath.Join(os.Getenv("HOME"), ".kube", "config"), "For debug purpose, set path to local kubeconfig")
@@ -25,11 +27,19 @@ func main() {
 	flag.StringVar(&listeningPort, "listeningPort", "5000", "Sets the port where the service will listen")
 	flag.StringVar(&certFile, "certFile", "/certs/cert.pem", "Path to cert file")
 	flag.StringVar(&keyFile, "keyFile", "/certs/key.pem", "Path to key file")
+	var requestsPerSecond float64
+	flag.Float64Var(&requestsPerSecond, "requestsPerSecond", 1, "Sustained rate of requests accepted from each source address, 0 to disable the limit")
+	flag.IntVar(&limits.Burst, "requestsBurst", 5, "Maximum number of requests accepted in a burst from each source address")
+	flag.IntVar(&limits.MaxFailedAttempts, "maxFailedAttempts", 5, "Consecutive failed authentications after which a ClusterID is locked out from its source address, 0 to disable the lockout")
+	flag.IntVar(&limits.MaxSourceFailedAttempts, "maxSourceFailedAttempts", 20, "Failed authentications with any ClusterID within 10 minutes after which a source address is locked out, 0 to disable the lockout")
+	flag.DurationVar(&limits.LockoutDuration, "lockoutDuration", 15*time.Minute, "Time for which the requests of a locked out ClusterID or source address are refused")
+	flag.BoolVar(&auditEvents, "auditEvents", true, "Export the audit log of the identity requests as Kubernetes Events")
 	flag.Parse()
+	limits.RequestsPerSecond = float32(requestsPerSecond)
 
 	klog.Info("Namespace: ", namespace)
 
-	authService, err := auth_service.NewAuthServiceCtrl(namespace, kubeconfigPath, time.Duration(resyncSeconds)*time.Second)
+	authService, err := auth_service.NewAuthServiceCtrl(namespace, kubeconfigPath, time.Duration(resyncSeconds)*time.Second, limits, auditEvents)
 	if err != nil {
 		klog.Error(err)
 		os.Exit(1)
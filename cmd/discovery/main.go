@@ -38,12 +38,14 @@ func main() {
 	var kubeconfigPath string
 	var resolveContextRefreshTime int // minutes
 	var dialTcpTimeout int64          // milliseconds
+	var requestedClusterID string
 
 	flag.StringVar(&namespace, "namespace", "default", "Namespace where your configs are stored.")
 	flag.Int64Var(&requeueAfter, "requeueAfter", 30, "Period after that PeeringRequests status is rechecked (seconds)")
 	flag.StringVar(&kubeconfigPath, "kubeconfigPath", filepath.Join(os.Getenv("HOME"), ".kube", "config"), "For debug purpose, set path to local kubeconfig")
 	flag.IntVar(&resolveContextRefreshTime, "resolveContextRefreshTime", 10, "Period after that mDNS resolve context is refreshed (minutes)")
 	flag.Int64Var(&dialTcpTimeout, "dialTcpTimeout", 500, "Time to wait for a TCP connection to a remote cluster before to consider it as not reachable (milliseconds)")
+	flag.StringVar(&requestedClusterID, "clusterID", "", "ID of the cluster set at the first start, a new one is generated if it is empty. It cannot be changed later")
 	flag.Parse()
 
 	klog.Info("Namespace: ", namespace)
@@ -54,7 +56,7 @@ func main() {
 		klog.Error(err, err.Error())
 		os.Exit(1)
 	}
-	err = clusterId.SetupClusterID(namespace)
+	err = clusterId.SetupClusterID(namespace, requestedClusterID)
 	if err != nil {
 		klog.Error(err, err.Error())
 		os.Exit(1)
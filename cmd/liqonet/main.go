@@ -16,12 +16,12 @@ limitations under the License.
 package main
 
 import (
-	"context"
 	"flag"
-	"github.com/coreos/go-iptables/iptables"
 	clusterConfig "github.com/liqotech/liqo/apis/config/v1alpha1"
+	discoveryv1alpha1 "github.com/liqotech/liqo/apis/discovery/v1alpha1"
 	netv1alpha1 "github.com/liqotech/liqo/apis/net/v1alpha1"
 	"github.com/liqotech/liqo/internal/liqonet"
+	"github.com/liqotech/liqo/pkg/crdClient"
 	"github.com/liqotech/liqo/pkg/liqonet"
 	"github.com/vishvananda/netlink"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -36,6 +36,7 @@ import (
 	"net"
 	"os"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
 	"strconv"
 	"strings"
 	"time"
@@ -64,6 +65,7 @@ func main() {
 	var enableLeaderElection bool
 	var runAsRouteOperator bool
 	var runAs string
+	var iptablesBackend string
 
 	flag.StringVar(&metricsAddr, "metrics-addr", ":0", "The address the metric endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "enable-leader-election", false,
@@ -71,12 +73,14 @@ func main() {
 	flag.BoolVar(&runAsRouteOperator, "run-as-route-operator", false,
 		"Runs the controller as Route-Operator, the default value is false and will run as Tunnel-Operator")
 	flag.StringVar(&runAs, "run-as", "tunnel-operator", "The accepted values are: tunnel-operator, route-operator, tunnelEndpointCreator-operator. The default value is \"tunnel-operator\"")
+	flag.StringVar(&iptablesBackend, "iptables-backend", liqonet.AutoBackend, "The backend used by the route-operator to program the packet filter. The accepted values are: auto, iptables, nftables. The default value is \"auto\"")
 	flag.Parse()
 	waitCleanUp := make(chan struct{})
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
 		Scheme:             scheme,
 		MetricsBindAddress: metricsAddr,
 		LeaderElection:     enableLeaderElection,
+		LeaderElectionID:   strings.ToLower(runAs) + ".net.liqo.io",
 		Port:               9443,
 	})
 	if err != nil {
@@ -126,9 +130,9 @@ func main() {
 			klog.Errorf("unable to build gateway vxlanIP: %s", err)
 			os.Exit(5)
 		}
-		ipt, err := iptables.New()
+		ipt, err := liqonet.NewIPTablesBackend(iptablesBackend)
 		if err != nil {
-			klog.Errorf("unable to initialize iptables, check if the binaries are present in the sysetm: %s", err)
+			klog.Errorf("unable to initialize the %s backend: %s", iptablesBackend, err)
 			os.Exit(6)
 		}
 		r := &liqonetOperators.RouteController{
@@ -184,37 +188,65 @@ func main() {
 		<-waitCleanUp
 
 	case "tunnel-operator":
+		nodeName, err := liqonet.GetNodeName()
+		if err != nil {
+			klog.Errorf("unable to get node nome: %s", err)
+			os.Exit(4)
+		}
+		discoveryConfig, err := crdClient.NewKubeconfig("", &discoveryv1alpha1.GroupVersion)
+		if err != nil {
+			klog.Errorf("unable to get the configuration of the discovery client: %s", err)
+			os.Exit(1)
+		}
+		discoveryClient, err := crdClient.NewFromConfig(discoveryConfig)
+		if err != nil {
+			klog.Errorf("unable to create the discovery client: %s", err)
+			os.Exit(1)
+		}
 		r := &liqonetOperators.TunnelController{
 			Client:                       mgr.GetClient(),
 			Scheme:                       mgr.GetScheme(),
 			Recorder:                     mgr.GetEventRecorderFor("tunnel-operator"),
 			TunnelIFacesPerRemoteCluster: make(map[string]int),
+			ClientSet:                    clientset,
+			NodeName:                     nodeName,
+			DiscoveryClient:              discoveryClient,
 		}
 		if err = r.SetupWithManager(mgr); err != nil {
 			klog.Errorf("unable to setup controller: %s", err)
 			os.Exit(1)
 		}
+		//the runnable is started only when the leadership is acquired
+		if err = mgr.Add(manager.RunnableFunc(r.ActivateGateway)); err != nil {
+			klog.Errorf("unable to setup the gateway activation: %s", err)
+			os.Exit(1)
+		}
 		klog.Info("Starting manager as Tunnel-Operator")
 		if err := mgr.Start(r.SetupSignalHandlerForTunnelOperator()); err != nil {
 			klog.Errorf("unable to start controller: %s", err)
+			//the leadership has been lost and another replica is taking over, the tunnels are removed from this node
+			r.RemoveAllTunnels()
 			os.Exit(1)
 		}
 
 	case "tunnelEndpointCreator-operator":
 
-		//get IP of gatewayNode
-		nodeList, err := clientset.CoreV1().Nodes().List(context.Background(), metav1.ListOptions{
-			LabelSelector: "net.liqo.io/gateway=true",
-		})
+		//get IP of the active gatewayNode
+		nodeList, err := liqonet.ListGatewayNodes(clientset)
 		if err != nil {
 			klog.Errorf("an error occurred while getting nodes %s", err)
 			os.Exit(-1)
 		}
-		if len(nodeList.Items) != 1 {
-			klog.Errorf("no node or multiple nodes found with label \"net.liqo.io/gateway=true\"")
+		gatewayNode, err := liqonet.GetActiveGateway(nodeList)
+		if err != nil {
+			klog.Errorf("no active gateway found among the nodes with label \"net.liqo.io/gateway=true\": %s", err)
+			os.Exit(-1)
+		}
+		gatewayIP, err := liqonet.GetInternalIPOfNode(*gatewayNode)
+		if err != nil {
+			klog.Errorf("unable to get the IP of the gateway node %s: %s", gatewayNode.Name, err)
 			os.Exit(-1)
 		}
-		gatewayIP := nodeList.Items[0].Status.Addresses[0].Address
 		//creating dynamic client
 		dynClient := dynamic.NewForConfigOrDie(mgr.GetConfig())
 		//creating dynamicSharedInformerFactory
@@ -225,6 +257,7 @@ func main() {
 			DynClient:                  dynClient,
 			DynFactory:                 dynFactory,
 			GatewayIP:                  gatewayIP,
+			GatewayMTU:                 liqonet.GetTunnelMTUAnnotation(gatewayNode),
 			ReservedSubnets:            make(map[string]*net.IPNet),
 			Configured:                 make(chan bool, 1),
 			ForeignClusterStartWatcher: make(chan bool, 1),
@@ -243,6 +276,13 @@ func main() {
 			UpdateFunc: r.ForeignClusterHandlerUpdate,
 			DeleteFunc: r.ForeignClusterHandlerDelete,
 		}, r.ForeignClusterStartWatcher, r.ForeignClusterStopWatcher)
+		//the active gateway changes when the tunnel-operator fails over to a standby replica
+		gatewayFactory := dynamicinformer.NewFilteredDynamicSharedInformerFactory(dynClient, liqonetOperators.ResyncPeriod, metav1.NamespaceAll, func(options *metav1.ListOptions) {
+			options.LabelSelector = liqonet.GatewayLabelKey + "=true"
+		})
+		gatewayInformer := gatewayFactory.ForResource(liqonetOperators.NodeGVR).Informer()
+		gatewayInformer.AddEventHandler(r.GatewayNodeHandlers())
+		go gatewayInformer.Run(r.ForeignClusterStopWatcher)
 		//starting configuration watcher
 		r.WatchConfiguration(config, &clusterConfig.GroupVersion)
 		if err = r.SetupWithManager(mgr); err != nil {
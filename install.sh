@@ -32,6 +32,8 @@ set -o pipefail  # Fail if one of the piped commands fails
 #     the Kubernetes namespace where all Liqo control plane components are created (defaults to liqo).
 #   - CLUSTER_NAME
 #     the mnemonic name assigned to this Liqo instance. Automatically generated if not specified.
+#   - CLUSTER_ID
+#     the unique identifier of this Liqo instance, kept across the reinstallations. Automatically generated if not specified.
 #   - DASHBOARD_HOSTNAME
 #     the hostname assigned to the Liqo dashboard (exposed through an Ingress resource).
 #
@@ -130,6 +132,7 @@ function help() {
 
 	  ${BOLD}LIQO_NAMESPACE${RESET}:     the Kubernetes namespace where all Liqo components are created (defaults to liqo)
 	  ${BOLD}CLUSTER_NAME${RESET}:       the mnemonic name assigned to this Liqo instance. Automatically generated if not specified.
+	  ${BOLD}CLUSTER_ID${RESET}:         the unique identifier of this Liqo instance, kept across the reinstallations. Automatically generated if not specified.
 	  ${BOLD}DASHBOARD_HOSTNAME${RESET}: the hostname assigned to the Liqo dashboard (exposed through an Ingress resource).
 
 	  ${BOLD}POD_CIDR${RESET}:           the Pod CIDR of your cluster (e.g.; 10.0.0.0/16). Automatically detected if not configured.
@@ -531,7 +534,7 @@ function install_liqo() {
 		--set global.version="${LIQO_IMAGE_VERSION}" --set global.suffix="${LIQO_SUFFIX:-}" --set clusterName="${CLUSTER_NAME}" \
 		--set podCIDR="${POD_CIDR}" --set serviceCIDR="${SERVICE_CIDR}" --set gatewayIP="${GATEWAY_IP}" \
 		--set global.dashboard_version="${LIQO_DASHBOARD_IMAGE_VERSION}" \
-		--set global.dashboard_ingress="${DASHBOARD_INGRESS:-}" >/dev/null ||
+		--set global.dashboard_ingress="${DASHBOARD_INGRESS:-}" --set discoveryOperator.clusterID="${CLUSTER_ID:-}" >/dev/null ||
 			fatal "[INSTALL]" "Something went wrong while installing Liqo"
 
 	info "[INSTALL]" "Hooray! Liqo is now installed on your cluster"
@@ -683,6 +686,9 @@ function purge_liqo() {
 
 	info "[UNINSTALL]" "Purging all remaining Liqo resources from your cluster..."
 	${KUBECTL} delete --filename="${TMPDIR}/${LIQO_CHARTS_PATH}/crds" 1>/dev/null 2>&1
+	# the cluster-id ConfigMap is protected by a finalizer, the cluster identity is lost only when Liqo is purged
+	${KUBECTL} patch configmap cluster-id --namespace "${LIQO_NAMESPACE}" --type=json \
+		--patch='[{"op": "remove", "path": "/metadata/finalizers"}]' 1>/dev/null 2>&1
 	${KUBECTL} delete namespace "${LIQO_NAMESPACE}" 1>/dev/null 2>&1
 	info "[UNINSTALL]" "All Liqo resources have been succesfully purged"
 
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"github.com/liqotech/liqo/internal/discovery/kubeconfig"
 	advpkg "github.com/liqotech/liqo/pkg/advertisement-operator"
+	"github.com/liqotech/liqo/pkg/auth"
 	"github.com/liqotech/liqo/pkg/crdClient"
 	"github.com/liqotech/liqo/pkg/labelPolicy"
 	pkg "github.com/liqotech/liqo/pkg/virtualKubelet"
@@ -31,10 +32,13 @@ type AdvertisementBroadcaster struct {
 	// remote-related variables
 	KubeconfigSecretForForeign *corev1.Secret       // secret containing the kubeconfig that will be sent to the foreign cluster
 	RemoteClient               *crdClient.CRDClient // client to create Advertisements and Secrets on the foreign cluster
+	remoteToken                *auth.RotatingToken  // token of RemoteClient, reloaded when the foreign cluster rotates it
+	localToken                 *corev1.Secret       // token of the kubeconfig sent to the foreign cluster
 	// configuration variables
 	HomeClusterId      string
 	ForeignClusterId   string
 	PeeringRequestName string
+	ServiceAccountName string
 	ClusterConfig      configv1alpha1.ClusterConfigSpec
 	mutex              sync.Mutex
 }
@@ -99,12 +103,25 @@ func StartBroadcaster(homeClusterId, localKubeconfigPath, peeringRequestName, sa
 	}
 
 	// create the Advertisement client to the remote cluster, using the retrieved Secret
+	// its token is replaced when the foreign cluster rotates the credentials
+	remoteConfig, err := crdClient.NewKubeconfigFromSecret(secretForAdvertisementCreation, &advtypes.GroupVersion)
+	if err != nil {
+		klog.Errorln(err, "Unable to get the configuration of remote cluster "+foreignClusterId)
+		return err
+	}
+	remoteToken := auth.NewRotatingToken(remoteConfig.BearerToken)
+	remoteToken.WrapConfig(remoteConfig)
+	if err = discoveryv1alpha1.ApplyForeignClusterConnection(discoveryClient, foreignClusterId, remoteConfig); err != nil {
+		klog.Errorln(err, "Unable to apply the connection settings of remote cluster "+foreignClusterId)
+		return err
+	}
+
 	var remoteClient *crdClient.CRDClient
 	var retry int
 
 	// create a CRD-client to the foreign cluster
 	for retry = 0; retry < 3; retry++ {
-		remoteClient, err = advtypes.CreateAdvertisementClient("", secretForAdvertisementCreation, true)
+		remoteClient, err = advtypes.CreateAdvertisementClientFromConfig(remoteConfig, true)
 		if err != nil {
 			klog.Errorln(err, "Unable to create client to remote cluster "+foreignClusterId+". Retry in 1 minute")
 			time.Sleep(1 * time.Minute)
@@ -123,20 +140,17 @@ func StartBroadcaster(homeClusterId, localKubeconfigPath, peeringRequestName, sa
 		LocalClient:        localClient,
 		DiscoveryClient:    discoveryClient,
 		RemoteClient:       remoteClient,
+		remoteToken:        remoteToken,
 		HomeClusterId:      homeClusterId,
 		ForeignClusterId:   pr.Name,
 		PeeringRequestName: peeringRequestName,
+		ServiceAccountName: saName,
 	}
 
 	kubeconfigSecretName := pkg.VirtualKubeletSecPrefix + homeClusterId
 
-	// create the kubeconfig to allow the foreign cluster to create resources on local cluster
-	kubeconfigForForeignCluster, err := kubeconfig.CreateKubeConfig(localClient.Client(), saName, pr.Spec.Namespace)
-	if err != nil {
-		klog.Errorln(err, "Unable to create Kubeconfig")
-		return err
-	}
-	// put the kubeconfig in a Secret, which is created on the foreign cluster
+	// put the kubeconfig to allow the foreign cluster to create resources on local cluster in a Secret, which is
+	// created on the foreign cluster
 	kubeconfigSecretForForeign := &corev1.Secret{
 		TypeMeta: metav1.TypeMeta{},
 		ObjectMeta: metav1.ObjectMeta{
@@ -144,11 +158,12 @@ func StartBroadcaster(homeClusterId, localKubeconfigPath, peeringRequestName, sa
 			Namespace: pr.Spec.Namespace,
 		},
 		Data: nil,
-		StringData: map[string]string{
-			"kubeconfig": kubeconfigForForeignCluster,
-		},
 	}
 	broadcaster.KubeconfigSecretForForeign = kubeconfigSecretForForeign
+	if err = broadcaster.rotateCredentials(); err != nil {
+		klog.Errorln(err, "Unable to create Kubeconfig")
+		return err
+	}
 	_, err = broadcaster.SendSecretToForeignCluster(kubeconfigSecretForForeign)
 	if err != nil {
 		// secret not created, without it the vk cannot be launched: just log and exit
@@ -171,13 +186,28 @@ func (b *AdvertisementBroadcaster) GenerateAdvertisement() {
 	var once sync.Once
 
 	for {
-		_, err := b.SendSecretToForeignCluster(b.KubeconfigSecretForForeign)
+		b.reloadRemoteCredentials()
+
+		err := b.rotateCredentials()
+		if err != nil {
+			klog.Errorln(err, "Error while rotating the credentials of cluster "+b.ForeignClusterId)
+			time.Sleep(1 * time.Minute)
+			continue
+		}
+
+		_, err = b.SendSecretToForeignCluster(b.KubeconfigSecretForForeign)
 		if err != nil {
 			klog.Errorln(err, "Error while sending Secret for virtual-kubelet to cluster "+b.ForeignClusterId)
 			time.Sleep(1 * time.Minute)
 			continue
 		}
 
+		// the foreign cluster is using the current credentials, the previous ones can be revoked
+		err = kubeconfig.RevokePreviousTokens(b.LocalClient.Client(), b.KubeconfigSecretForForeign.Namespace, b.ServiceAccountName, b.ForeignClusterId, false, b.localToken)
+		if err != nil {
+			klog.Errorln(err, "Error while revoking the previous credentials of cluster "+b.ForeignClusterId)
+		}
+
 		advRes, err := b.GetResourcesForAdv()
 		if err != nil {
 			klog.Errorln(err, "Error while computing resources for Advertisement")
@@ -203,6 +233,52 @@ func (b *AdvertisementBroadcaster) GenerateAdvertisement() {
 	}
 }
 
+// rotateCredentials mints a new token for the kubeconfig sent to the foreign cluster when the current one is older
+// than the rotation period, and updates the Secret to send with it
+func (b *AdvertisementBroadcaster) rotateCredentials() error {
+	secret := b.KubeconfigSecretForForeign
+	token, rotated, err := kubeconfig.RotateToken(b.LocalClient.Client(), secret.Namespace, b.ServiceAccountName, b.ForeignClusterId, false, auth.RotationPeriod(&b.ClusterConfig))
+	if err != nil {
+		return err
+	}
+	if !rotated && secret.StringData != nil {
+		return nil
+	}
+	kubeconfigForForeignCluster, err := kubeconfig.CreateKubeConfigFromToken(b.LocalClient.Client(), b.ServiceAccountName, token)
+	if err != nil {
+		return err
+	}
+	secret.StringData = map[string]string{
+		"kubeconfig": kubeconfigForForeignCluster,
+	}
+	b.localToken = token
+	return nil
+}
+
+// reloadRemoteCredentials reads the token of the remote client from the Secret currently referred by the
+// PeeringRequest, which changes when the foreign cluster rotates the credentials
+func (b *AdvertisementBroadcaster) reloadRemoteCredentials() {
+	tmp, err := b.DiscoveryClient.Resource("peeringrequests").Get(b.PeeringRequestName, metav1.GetOptions{})
+	if err != nil {
+		klog.Errorln(err, "Unable to get PeeringRequest "+b.PeeringRequestName)
+		return
+	}
+	pr, ok := tmp.(*discoveryv1alpha1.PeeringRequest)
+	if !ok || pr.Spec.KubeConfigRef == nil {
+		return
+	}
+	secret, err := b.LocalClient.Client().CoreV1().Secrets(pr.Spec.KubeConfigRef.Namespace).Get(context.TODO(), pr.Spec.KubeConfigRef.Name, metav1.GetOptions{})
+	if err != nil {
+		klog.Errorln(err, "Unable to get PeeringRequest secret")
+		return
+	}
+	if changed, err := b.remoteToken.SetFromKubeconfig(secret.Data["kubeconfig"]); err != nil {
+		klog.Errorln(err, "Unable to read the credentials of cluster "+b.ForeignClusterId)
+	} else if changed {
+		klog.Info("Credentials of remote cluster " + b.ForeignClusterId + " reloaded")
+	}
+}
+
 // create advertisement message
 func (b *AdvertisementBroadcaster) CreateAdvertisement(advRes *AdvResources) advtypes.Advertisement {
 
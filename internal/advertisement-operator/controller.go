@@ -116,6 +116,11 @@ func (r *AdvertisementReconciler) Reconcile(req ctrl.Request) (ctrl.Result, erro
 		return ctrl.Result{RequeueAfter: r.RetryTimeout}, nil
 	}
 
+	// report in the ForeignCluster whether the Advertisement has been accepted
+	if err := r.setAdvertisementCondition(&adv); err != nil {
+		klog.Error(err)
+	}
+
 	if adv.Status.AdvertisementStatus != advtypes.AdvertisementAccepted {
 		klog.Info("Advertisement " + adv.Name + " refused")
 		return ctrl.Result{RequeueAfter: r.RetryTimeout}, nil
@@ -254,6 +259,22 @@ func (r *AdvertisementReconciler) UpdateAdvertisement(adv *advtypes.Advertisemen
 	}
 }
 
+// setAdvertisementCondition sets the AdvertisementAccepted condition of the ForeignCluster which sent the Advertisement
+func (r *AdvertisementReconciler) setAdvertisementCondition(adv *advtypes.Advertisement) error {
+	condition := discoveryv1alpha1.Condition{
+		Type:    discoveryv1alpha1.AdvertisementAcceptedCondition,
+		Status:  v1.ConditionTrue,
+		Reason:  "Accepted",
+		Message: "Advertisement " + adv.Name + " accepted",
+	}
+	if adv.Status.AdvertisementStatus != advtypes.AdvertisementAccepted {
+		condition.Status = v1.ConditionFalse
+		condition.Reason = "Refused"
+		condition.Message = "Advertisement " + adv.Name + " refused, check the accept policy and the announced resources"
+	}
+	return discoveryv1alpha1.SetForeignClusterCondition(r.DiscoveryClient, adv.Spec.ClusterId, condition)
+}
+
 func (r *AdvertisementReconciler) createVirtualKubelet(ctx context.Context, adv *advtypes.Advertisement) error {
 
 	secRef := adv.Spec.KubeConfigRef
@@ -352,7 +373,16 @@ func (r *AdvertisementReconciler) checkClusterStatus(adv advtypes.Advertisement)
 		return err
 	}
 
-	remoteClient, err := advtypes.CreateAdvertisementClient("", remoteKubeconfig, true)
+	config, err := crdClient.NewKubeconfigFromSecret(remoteKubeconfig, &advtypes.GroupVersion)
+	if err != nil {
+		return err
+	}
+	if r.DiscoveryClient != nil {
+		if err = discoveryv1alpha1.ApplyForeignClusterConnection(r.DiscoveryClient, adv.Spec.ClusterId, config); err != nil {
+			return err
+		}
+	}
+	remoteClient, err := advtypes.CreateAdvertisementClientFromConfig(config, true)
 	if err != nil {
 		return err
 	}
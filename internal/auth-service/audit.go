@@ -0,0 +1,235 @@
+package auth_service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/julienschmidt/httprouter"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	auditOutcomeSuccess     = "success"
+	auditOutcomeDenied      = "denied"
+	auditOutcomeInvalid     = "invalid"
+	auditOutcomeError       = "error"
+	auditOutcomeRateLimited = "rate-limited"
+	auditOutcomeLockedOut   = "locked-out"
+
+	// name of the Service exposing the auth-service, the Events not related to a peer refer to it
+	authServiceName = "auth-service"
+)
+
+// auditRecord describes an identity request and how it has been handled
+type auditRecord struct {
+	Time      time.Time `json:"time"`
+	Path      string    `json:"path"`
+	SourceIP  string    `json:"sourceIP"`
+	ClusterID string    `json:"clusterID,omitempty"`
+	// token or certificate
+	Method     string `json:"method,omitempty"`
+	Token      string `json:"token,omitempty"`
+	Outcome    string `json:"outcome"`
+	StatusCode int    `json:"statusCode"`
+	Error      string `json:"error,omitempty"`
+	// the resources created or updated to grant the identity, as kind/name
+	Resources []string `json:"resources,omitempty"`
+}
+
+type auditRecordKey struct{}
+
+// getAuditRecord returns the audit record of the request, which the handlers complete with what they do
+func getAuditRecord(r *http.Request) *auditRecord {
+	if record, ok := r.Context().Value(auditRecordKey{}).(*auditRecord); ok {
+		return record
+	}
+	// the request has not been audited, the record is discarded
+	return &auditRecord{}
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	statusCode int
+}
+
+func (w *statusRecorder) WriteHeader(statusCode int) {
+	w.statusCode = statusCode
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+// audited wraps a handler applying the rate limits of the source of the request and recording the request in the
+// audit log
+func (authService *AuthServiceCtrl) audited(handle httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		record := &auditRecord{
+			Time:     time.Now(),
+			Path:     r.URL.Path,
+			SourceIP: getSourceIP(r),
+		}
+		defer authService.audit(record)
+
+		if reason := authService.limit(w, record.SourceIP); reason != "" {
+			record.Outcome = reason
+			record.StatusCode = http.StatusTooManyRequests
+			return
+		}
+
+		recorder := &statusRecorder{ResponseWriter: w, statusCode: http.StatusOK}
+		handle(recorder, r.WithContext(context.WithValue(r.Context(), auditRecordKey{}, record)), ps)
+		record.StatusCode = recorder.statusCode
+
+		switch {
+		case record.Outcome != "":
+			// the handler refused the request before authenticating it
+			return
+		case record.StatusCode < 300:
+			record.Outcome = auditOutcomeSuccess
+		case record.StatusCode == http.StatusUnauthorized || record.StatusCode == http.StatusForbidden:
+			record.Outcome = auditOutcomeDenied
+		case record.StatusCode < 500:
+			record.Outcome = auditOutcomeInvalid
+		default:
+			record.Outcome = auditOutcomeError
+		}
+		// only the authentication results count for the lockout, not the internal errors
+		if record.ClusterID != "" && (record.Outcome == auditOutcomeSuccess || record.Outcome == auditOutcomeDenied) {
+			authService.limiter.recordResult(record.SourceIP, record.ClusterID, record.Outcome == auditOutcomeSuccess)
+		}
+	}
+}
+
+// limited wraps a handler applying only the rate limits of the source of the request, for the endpoints which do not
+// authenticate the peers and are not audited
+func (authService *AuthServiceCtrl) limited(handle httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		if reason := authService.limit(w, getSourceIP(r)); reason != "" {
+			klog.V(4).Infof("%s request from %s refused: %s", r.URL.Path, getSourceIP(r), reason)
+			return
+		}
+		handle(w, r, ps)
+	}
+}
+
+// limit checks if a request of the source can be served, otherwise it responds with 429 and returns the reason
+func (authService *AuthServiceCtrl) limit(w http.ResponseWriter, source string) string {
+	reason, retryAfter := authService.limiter.allow(source)
+	if reason == "" {
+		return ""
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	authService.sendError(w, "too many requests", http.StatusTooManyRequests)
+	return reason
+}
+
+// lockedOut checks if the cluster is locked out from the source, and in that case it responds with 429 and returns
+// the reason
+func (authService *AuthServiceCtrl) lockedOut(w http.ResponseWriter, source string, clusterID string) string {
+	locked, retryAfter := authService.limiter.lockedOut(source, clusterID)
+	if !locked {
+		return ""
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	authService.sendError(w, "too many requests", http.StatusTooManyRequests)
+	return auditOutcomeLockedOut
+}
+
+// audit writes the record in the log and, if enabled, as an Event
+func (authService *AuthServiceCtrl) audit(record *auditRecord) {
+	bytes, err := json.Marshal(record)
+	if err != nil {
+		klog.Error(err)
+		return
+	}
+	klog.Infof("audit: %s", bytes)
+
+	if authService.eventRecorder == nil {
+		return
+	}
+	involved := &v1.ObjectReference{
+		APIVersion: "v1",
+		Kind:       "Service",
+		Namespace:  authService.namespace,
+		Name:       authServiceName,
+	}
+	if record.Outcome == auditOutcomeSuccess {
+		involved = &v1.ObjectReference{
+			APIVersion: "v1",
+			Kind:       "ServiceAccount",
+			Namespace:  authService.namespace,
+			Name:       record.ClusterID,
+		}
+	}
+	eventType, reason := getEventReason(record.Outcome)
+	authService.eventRecorder.Event(involved, eventType, reason, record.message())
+}
+
+func getEventReason(outcome string) (eventType string, reason string) {
+	switch outcome {
+	case auditOutcomeSuccess:
+		return v1.EventTypeNormal, "IdentityIssued"
+	case auditOutcomeDenied:
+		return v1.EventTypeWarning, "IdentityDenied"
+	case auditOutcomeRateLimited:
+		return v1.EventTypeWarning, "RateLimited"
+	case auditOutcomeLockedOut:
+		return v1.EventTypeWarning, "LockedOut"
+	case auditOutcomeInvalid:
+		return v1.EventTypeWarning, "InvalidRequest"
+	default:
+		return v1.EventTypeWarning, "RequestFailed"
+	}
+}
+
+// message returns a human readable description of the record
+func (record *auditRecord) message() string {
+	clusterID := record.ClusterID
+	if clusterID == "" {
+		clusterID = "unknown cluster"
+	}
+	msg := fmt.Sprintf("%s request of %s from %s: %s (%d)", record.Path, clusterID, record.SourceIP, record.Outcome, record.StatusCode)
+	if record.Method != "" {
+		msg += ", authenticated with " + record.Method
+		if record.Token != "" {
+			msg += " " + record.Token
+		}
+	}
+	if len(record.Resources) > 0 {
+		msg += ", granted " + strings.Join(record.Resources, ", ")
+	}
+	if record.Error != "" {
+		msg += ": " + record.Error
+	}
+	return msg
+}
+
+// getSourceIP returns the address the request comes from. The forwarding headers are not trusted, since they can be
+// set by the client to evade the limits.
+func getSourceIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// newEventRecorder returns a recorder writing the Events of the auth-service in its namespace
+func newEventRecorder(authService *AuthServiceCtrl) record.EventRecorder {
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: authService.clientset.CoreV1().Events(authService.namespace)})
+	return broadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: "liqo-auth-service"})
+}
+
+// resourceName returns the kind/name of a resource, as recorded in the audit log
+func resourceName(kind string, name string) string {
+	return kind + "/" + name
+}
@@ -2,30 +2,56 @@ package auth_service
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"github.com/julienschmidt/httprouter"
+	configv1alpha1 "github.com/liqotech/liqo/apis/config/v1alpha1"
 	discoveryv1alpha1 "github.com/liqotech/liqo/apis/discovery/v1alpha1"
+	"github.com/liqotech/liqo/pkg/auth"
+	"github.com/liqotech/liqo/pkg/clusterConfig"
+	"github.com/liqotech/liqo/pkg/clusterID"
 	"github.com/liqotech/liqo/pkg/crdClient"
+	"io/ioutil"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/klog"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 )
 
 type AuthServiceCtrl struct {
 	namespace      string
 	clientset      kubernetes.Interface
+	dynamicClient  dynamic.Interface
 	saInformer     cache.SharedIndexInformer
 	nodeInformer   cache.SharedIndexInformer
 	secretInformer cache.SharedIndexInformer
+	//CA of the API server, which signs the certificates of the peers
+	clusterCA []byte
+
+	configMutex sync.RWMutex
+	config      *configv1alpha1.ClusterConfigSpec
+
+	//limits of the requests of each source
+	limiter *sourceLimiter
+	//records the audit log as Events, if enabled
+	eventRecorder record.EventRecorder
+
+	//its key signs the identity of the cluster
+	clusterID *clusterID.ClusterID
 }
 
-func NewAuthServiceCtrl(namespace string, kubeconfigPath string, resyncTime time.Duration) (*AuthServiceCtrl, error) {
+// NewAuthServiceCtrl creates the auth-service, limiting the requests of each source address as configured. When
+// auditEvents is set the audit log of the identity requests is exported also as Kubernetes Events.
+func NewAuthServiceCtrl(namespace string, kubeconfigPath string, resyncTime time.Duration, limits RateLimitConfig, auditEvents bool) (*AuthServiceCtrl, error) {
 	config, err := crdClient.NewKubeconfig(kubeconfigPath, &discoveryv1alpha1.GroupVersion)
 	if err != nil {
 		return nil, err
@@ -34,6 +60,22 @@ func NewAuthServiceCtrl(namespace string, kubeconfigPath string, resyncTime time
 	if err != nil {
 		return nil, err
 	}
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	clusterCA := config.CAData
+	if len(clusterCA) == 0 && config.CAFile != "" {
+		if clusterCA, err = ioutil.ReadFile(config.CAFile); err != nil {
+			return nil, err
+		}
+	}
+
+	clusterId, err := clusterID.NewClusterID(kubeconfigPath)
+	if err != nil {
+		return nil, err
+	}
 
 	informerFactory := informers.NewSharedInformerFactoryWithOptions(clientset, resyncTime, informers.WithNamespace(namespace))
 
@@ -49,13 +91,35 @@ func NewAuthServiceCtrl(namespace string, kubeconfigPath string, resyncTime time
 	informerFactory.Start(wait.NeverStop)
 	informerFactory.WaitForCacheSync(wait.NeverStop)
 
-	return &AuthServiceCtrl{
+	authService := &AuthServiceCtrl{
 		namespace:      namespace,
 		clientset:      clientset,
+		dynamicClient:  dynamicClient,
 		saInformer:     saInformer,
 		nodeInformer:   nodeInformer,
 		secretInformer: secretInformer,
-	}, nil
+		clusterCA:      clusterCA,
+		limiter:        newSourceLimiter(limits, nil),
+		clusterID:      clusterId,
+	}
+	if auditEvents {
+		authService.eventRecorder = newEventRecorder(authService)
+	}
+	go clusterConfig.WatchConfiguration(authService.handleConfiguration, nil, kubeconfigPath)
+	return authService, nil
+}
+
+func (authService *AuthServiceCtrl) handleConfiguration(configuration *configv1alpha1.ClusterConfig) {
+	authService.configMutex.Lock()
+	defer authService.configMutex.Unlock()
+	authService.config = configuration.Spec.DeepCopy()
+}
+
+// getPeerPermissions returns the permissions granted to the given peer, as configured in the ClusterConfig
+func (authService *AuthServiceCtrl) getPeerPermissions(clusterID string) configv1alpha1.PermissionsTemplate {
+	authService.configMutex.RLock()
+	defer authService.configMutex.RUnlock()
+	return auth.PeeringRequestPermissions(authService.config, clusterID)
 }
 
 func (authService *AuthServiceCtrl) Start(listeningPort string, certFile string, keyFile string) error {
@@ -63,11 +127,32 @@ func (authService *AuthServiceCtrl) Start(listeningPort string, certFile string,
 		return err
 	}
 
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		klog.Error(err)
+		return err
+	}
+
+	go wait.Until(authService.rotatePeerCredentials, rotationCheckPeriod, wait.NeverStop)
+
 	router := httprouter.New()
 
-	router.POST("/role", authService.role)
+	router.POST("/role", authService.audited(authService.role))
+	router.GET(auth.IdentityPath, authService.limited(authService.identity))
 
-	err := http.ListenAndServeTLS(strings.Join([]string{":", listeningPort}, ""), certFile, keyFile, router)
+	server := &http.Server{
+		Addr:    strings.Join([]string{":", listeningPort}, ""),
+		Handler: router,
+		TLSConfig: &tls.Config{
+			Certificates: []tls.Certificate{cert},
+		},
+	}
+	//the peers which already own a certificate authenticate with it, the others with the token
+	if pool := x509.NewCertPool(); pool.AppendCertsFromPEM(authService.clusterCA) {
+		server.TLSConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		server.TLSConfig.ClientCAs = pool
+	}
+	err = server.ListenAndServeTLS("", "")
 	if err != nil {
 		klog.Error(err)
 		return err
@@ -81,11 +166,19 @@ func (authService *AuthServiceCtrl) configureToken() error {
 	}
 
 	authService.secretInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			secret, ok := obj.(*v1.Secret)
+			if !ok {
+				return
+			}
+			authService.handleRevokedToken(secret)
+		},
 		UpdateFunc: func(oldObj interface{}, newObj interface{}) {
 			newSecret, ok := newObj.(*v1.Secret)
 			if !ok {
 				return
 			}
+			authService.handleRevokedToken(newSecret)
 			if newSecret.Name != AuthTokenSecretName {
 				return
 			}
@@ -115,3 +208,13 @@ func (authService *AuthServiceCtrl) configureToken() error {
 	})
 	return nil
 }
+
+// handleRevokedToken deletes the identities issued with the token contained in the secret, if it has been revoked
+func (authService *AuthServiceCtrl) handleRevokedToken(secret *v1.Secret) {
+	if !isTokenSecret(secret) || secret.Annotations[TokenRevokedAnnotation] != "true" {
+		return
+	}
+	if err := authService.revokeToken(secret.Name); err != nil {
+		klog.Error(err)
+	}
+}
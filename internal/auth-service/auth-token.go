@@ -3,45 +3,292 @@ package auth_service
 import (
 	"context"
 	"crypto/rand"
+	"crypto/subtle"
 	"errors"
 	"fmt"
 	v1 "k8s.io/api/core/v1"
 	kerrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/util/retry"
 	"k8s.io/klog"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
 )
 
 const (
+	// the default token, accepted from any cluster without limits
 	AuthTokenSecretName = "auth-token"
+
+	// label of the secrets containing the tokens accepted by the auth-service, the name of the secret is the name of the token
+	TokenLabel = "auth.liqo.io/token"
+	// time, in RFC3339 format, after which the token is no more accepted
+	TokenExpirationAnnotation = "auth.liqo.io/token-expiration"
+	// number of identities which can be issued with the token
+	TokenMaxUsesAnnotation = "auth.liqo.io/token-max-uses"
+	// number of identities already issued with the token, updated by the auth-service
+	TokenUsesAnnotation = "auth.liqo.io/token-uses"
+	// regular expression matching the whole ClusterIDs allowed to use the token
+	TokenClusterIDPatternAnnotation = "auth.liqo.io/token-cluster-id-pattern"
+	// when set to true the token is no more accepted and the identities issued with it are deleted
+	TokenRevokedAnnotation = "auth.liqo.io/token-revoked"
+
+	// label of the ServiceAccounts of the peers containing the name of the token used to issue their identity
+	IssuedWithTokenLabel = "auth.liqo.io/issued-with-token"
+
+	// ConfigMap recording the ClusterIDs whose identity has been revoked, with the name of the revoked token. They
+	// cannot renew their certificates until they enroll again with a valid token.
+	RevokedPeersConfigMapName = "auth-revoked-peers"
 )
 
-func (authService *AuthServiceCtrl) getToken() (string, error) {
-	obj, exists, err := authService.secretInformer.GetStore().GetByKey(strings.Join([]string{authService.namespace, AuthTokenSecretName}, "/"))
+type authToken struct {
+	name             string
+	value            string
+	expiration       *time.Time
+	maxUses          int
+	uses             int
+	clusterIDPattern *regexp.Regexp
+	revoked          bool
+}
+
+func (authService *AuthServiceCtrl) parseToken(secret *v1.Secret) (*authToken, error) {
+	value, err := authService.getTokenFromSecret(secret)
 	if err != nil {
-		klog.Error(err)
-		return "", err
-	} else if !exists {
-		err = kerrors.NewNotFound(schema.GroupResource{
-			Group:    "v1",
-			Resource: "secrets",
-		}, AuthTokenSecretName)
-		klog.Error(err)
-		return "", err
+		return nil, err
+	}
+	token := &authToken{
+		name:    secret.Name,
+		value:   value,
+		revoked: secret.Annotations[TokenRevokedAnnotation] == "true",
+	}
+	if v, ok := secret.Annotations[TokenExpirationAnnotation]; ok {
+		expiration, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid expiration of token %s: %v", secret.Name, err)
+		}
+		token.expiration = &expiration
+	}
+	if v, ok := secret.Annotations[TokenMaxUsesAnnotation]; ok {
+		if token.maxUses, err = strconv.Atoi(v); err != nil {
+			return nil, fmt.Errorf("invalid max uses of token %s: %v", secret.Name, err)
+		}
+	}
+	if v, ok := secret.Annotations[TokenUsesAnnotation]; ok {
+		if token.uses, err = strconv.Atoi(v); err != nil {
+			return nil, fmt.Errorf("invalid uses of token %s: %v", secret.Name, err)
+		}
 	}
+	if v, ok := secret.Annotations[TokenClusterIDPatternAnnotation]; ok {
+		if token.clusterIDPattern, err = regexp.Compile("^(?:" + v + ")$"); err != nil {
+			return nil, fmt.Errorf("invalid cluster-id pattern of token %s: %v", secret.Name, err)
+		}
+	}
+	return token, nil
+}
 
-	secret, ok := obj.(*v1.Secret)
-	if !ok {
-		err = kerrors.NewNotFound(schema.GroupResource{
-			Group:    "v1",
-			Resource: "secrets",
-		}, AuthTokenSecretName)
-		klog.Error(err)
+// validate checks if the token can be used to issue the identity of the given cluster
+func (token *authToken) validate(clusterID string, now time.Time) error {
+	switch {
+	case token.revoked:
+		return forbidden(fmt.Sprintf("token %s has been revoked", token.name))
+	case token.expiration != nil && now.After(*token.expiration):
+		return forbidden(fmt.Sprintf("token %s expired at %s", token.name, token.expiration.Format(time.RFC3339)))
+	case token.maxUses > 0 && token.uses >= token.maxUses:
+		return forbidden(fmt.Sprintf("token %s has already been used %d times", token.name, token.uses))
+	case token.clusterIDPattern != nil && !token.clusterIDPattern.MatchString(clusterID):
+		return forbidden(fmt.Sprintf("token %s cannot be used by cluster %s", token.name, clusterID))
+	}
+	return nil
+}
+
+// useToken checks that the given value is a valid token for the cluster and counts its use, returning its name
+func (authService *AuthServiceCtrl) useToken(value string, clusterID string) (string, error) {
+	name := authService.findToken(value)
+	if name == "" {
+		return "", forbidden("invalid token")
+	}
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		secret, err := authService.clientset.CoreV1().Secrets(authService.namespace).Get(context.TODO(), name, metav1.GetOptions{})
+		if kerrors.IsNotFound(err) {
+			return forbidden("invalid token")
+		} else if err != nil {
+			return err
+		}
+		token, err := authService.parseToken(secret)
+		if err != nil {
+			return forbidden(err.Error())
+		}
+		if subtle.ConstantTimeCompare([]byte(token.value), []byte(value)) != 1 {
+			return forbidden("invalid token")
+		}
+		if err = token.validate(clusterID, time.Now()); err != nil {
+			return err
+		}
+		if secret.Annotations == nil {
+			secret.Annotations = map[string]string{}
+		}
+		secret.Annotations[TokenUsesAnnotation] = strconv.Itoa(token.uses + 1)
+		_, err = authService.clientset.CoreV1().Secrets(authService.namespace).Update(context.TODO(), secret, metav1.UpdateOptions{})
+		return err
+	})
+	if err != nil {
 		return "", err
 	}
+	klog.Infof("token %s used by cluster %s", name, clusterID)
+	return name, nil
+}
 
-	return authService.getTokenFromSecret(secret.DeepCopy())
+// releaseToken gives back a use of the token counted by useToken, when the identity of the cluster has not been issued
+func (authService *AuthServiceCtrl) releaseToken(name string, clusterID string) {
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		secret, err := authService.clientset.CoreV1().Secrets(authService.namespace).Get(context.TODO(), name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		uses, err := strconv.Atoi(secret.Annotations[TokenUsesAnnotation])
+		if err != nil || uses <= 0 {
+			return nil
+		}
+		secret.Annotations[TokenUsesAnnotation] = strconv.Itoa(uses - 1)
+		_, err = authService.clientset.CoreV1().Secrets(authService.namespace).Update(context.TODO(), secret, metav1.UpdateOptions{})
+		return err
+	})
+	if err != nil {
+		klog.Errorf("unable to release the use of token %s by cluster %s: %v", name, clusterID, err)
+		return
+	}
+	klog.Infof("use of token %s by cluster %s released, its identity has not been issued", name, clusterID)
+}
+
+// findToken returns the name of the secret containing the given token, an empty string if there is none
+func (authService *AuthServiceCtrl) findToken(value string) string {
+	if value == "" {
+		return ""
+	}
+	for _, obj := range authService.secretInformer.GetStore().List() {
+		secret, ok := obj.(*v1.Secret)
+		if !ok || !isTokenSecret(secret) {
+			continue
+		}
+		if v, ok := secret.Data["token"]; ok && subtle.ConstantTimeCompare(v, []byte(value)) == 1 {
+			return secret.Name
+		}
+	}
+	return ""
+}
+
+func isTokenSecret(secret *v1.Secret) bool {
+	return secret.Name == AuthTokenSecretName || secret.Labels[TokenLabel] == "true"
+}
+
+// revokeToken deletes the identities issued with a revoked token, along with the permissions granted to them
+func (authService *AuthServiceCtrl) revokeToken(name string) error {
+	sas, err := authService.clientset.CoreV1().ServiceAccounts(authService.namespace).List(context.TODO(), metav1.ListOptions{
+		LabelSelector: IssuedWithTokenLabel + "=" + name,
+	})
+	if err != nil {
+		return err
+	}
+	for i := range sas.Items {
+		clusterID := sas.Items[i].Name
+		klog.Infof("token %s has been revoked, deleting the identity of cluster %s", name, clusterID)
+		// the revocation is recorded first, the certificates issued to the peer are valid until they expire
+		if err = authService.recordRevocation(clusterID, name); err != nil {
+			return err
+		}
+		if err = authService.deletePeerPermissions(clusterID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recordRevocation records that the identity of the cluster has been revoked along with the given token, or removes
+// the record if the token name is empty
+func (authService *AuthServiceCtrl) recordRevocation(clusterID string, tokenName string) error {
+	configMaps := authService.clientset.CoreV1().ConfigMaps(authService.namespace)
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		cm, err := configMaps.Get(context.TODO(), RevokedPeersConfigMapName, metav1.GetOptions{})
+		if kerrors.IsNotFound(err) {
+			if tokenName == "" {
+				return nil
+			}
+			cm = &v1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: RevokedPeersConfigMapName},
+				Data:       map[string]string{clusterID: tokenName},
+			}
+			_, err = configMaps.Create(context.TODO(), cm, metav1.CreateOptions{})
+			if kerrors.IsAlreadyExists(err) {
+				return kerrors.NewConflict(v1.Resource("configmaps"), RevokedPeersConfigMapName, err)
+			}
+			return err
+		} else if err != nil {
+			return err
+		}
+		if cm.Data[clusterID] == tokenName {
+			return nil
+		}
+		if tokenName == "" {
+			delete(cm.Data, clusterID)
+		} else {
+			if cm.Data == nil {
+				cm.Data = map[string]string{}
+			}
+			cm.Data[clusterID] = tokenName
+		}
+		_, err = configMaps.Update(context.TODO(), cm, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+// checkNotRevoked returns an error if the identity of the cluster, issued with the given token, has been revoked
+func (authService *AuthServiceCtrl) checkNotRevoked(clusterID string, tokenName string) error {
+	cm, err := authService.clientset.CoreV1().ConfigMaps(authService.namespace).Get(context.TODO(), RevokedPeersConfigMapName, metav1.GetOptions{})
+	if err != nil && !kerrors.IsNotFound(err) {
+		return err
+	}
+	if err == nil {
+		if revokedToken, ok := cm.Data[clusterID]; ok {
+			return forbidden(fmt.Sprintf("the identity of cluster %s has been revoked with token %s", clusterID, revokedToken))
+		}
+	}
+	if tokenName == "" {
+		return nil
+	}
+	secret, err := authService.clientset.CoreV1().Secrets(authService.namespace).Get(context.TODO(), tokenName, metav1.GetOptions{})
+	if kerrors.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if secret.Annotations[TokenRevokedAnnotation] == "true" {
+		return forbidden(fmt.Sprintf("the identity of cluster %s has been issued with the revoked token %s", clusterID, tokenName))
+	}
+	return nil
+}
+
+// deletePeerPermissions deletes the ServiceAccount of a peer and the roles granted to it. The namespaced
+// resources are deleted by the garbage collector, being owned by the ServiceAccount, the cluster ones explicitly.
+func (authService *AuthServiceCtrl) deletePeerPermissions(clusterID string) error {
+	deletions := []func() error{
+		func() error {
+			return authService.clientset.RbacV1().ClusterRoleBindings().Delete(context.TODO(), clusterID, metav1.DeleteOptions{})
+		},
+		func() error {
+			return authService.clientset.RbacV1().ClusterRoles().Delete(context.TODO(), clusterID, metav1.DeleteOptions{})
+		},
+		func() error {
+			return authService.clientset.CoreV1().ServiceAccounts(authService.namespace).Delete(context.TODO(), clusterID, metav1.DeleteOptions{})
+		},
+	}
+	for _, deletion := range deletions {
+		if err := deletion(); err != nil && !kerrors.IsNotFound(err) {
+			klog.Error(err)
+			return err
+		}
+	}
+	return nil
 }
 
 func (authService *AuthServiceCtrl) createToken() error {
@@ -55,6 +302,9 @@ func (authService *AuthServiceCtrl) createToken() error {
 		secret := &v1.Secret{
 			ObjectMeta: metav1.ObjectMeta{
 				Name: AuthTokenSecretName,
+				Labels: map[string]string{
+					TokenLabel: "true",
+				},
 			},
 			StringData: map[string]string{
 				"token": token,
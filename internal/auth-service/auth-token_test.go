@@ -0,0 +1,157 @@
+package auth_service
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"github.com/liqotech/liqo/pkg/auth"
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func getTokenSecret(name, value string, annotations map[string]string) *v1.Secret {
+	return &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   "liqo",
+			Labels:      map[string]string{TokenLabel: "true"},
+			Annotations: annotations,
+		},
+		Data: map[string][]byte{"token": []byte(value)},
+	}
+}
+
+func TestValidateToken(t *testing.T) {
+	authService := &AuthServiceCtrl{}
+	now := time.Date(2020, 10, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		clusterID   string
+		valid       bool
+	}{
+		{"no limits", nil, "cluster-1", true},
+		{"not expired", map[string]string{TokenExpirationAnnotation: "2020-10-02T00:00:00Z"}, "cluster-1", true},
+		{"expired", map[string]string{TokenExpirationAnnotation: "2020-09-30T00:00:00Z"}, "cluster-1", false},
+		{"uses left", map[string]string{TokenMaxUsesAnnotation: "2", TokenUsesAnnotation: "1"}, "cluster-1", true},
+		{"no uses left", map[string]string{TokenMaxUsesAnnotation: "2", TokenUsesAnnotation: "2"}, "cluster-1", false},
+		{"matching cluster", map[string]string{TokenClusterIDPatternAnnotation: "cluster-[0-9]+"}, "cluster-1", true},
+		{"partially matching cluster", map[string]string{TokenClusterIDPatternAnnotation: "cluster-[0-9]+"}, "cluster-1a", false},
+		{"revoked", map[string]string{TokenRevokedAnnotation: "true"}, "cluster-1", false},
+	}
+	for _, test := range tests {
+		token, err := authService.parseToken(getTokenSecret("token-1", "value", test.annotations))
+		assert.Nil(t, err, test.name)
+		err = token.validate(test.clusterID, now)
+		if test.valid {
+			assert.Nil(t, err, test.name)
+		} else {
+			assert.True(t, kerrors.IsForbidden(err), test.name)
+		}
+	}
+
+	_, err := authService.parseToken(getTokenSecret("token-1", "value", map[string]string{TokenMaxUsesAnnotation: "many"}))
+	assert.NotNil(t, err)
+}
+
+func TestUseToken(t *testing.T) {
+	authService := getAuthServiceCtrl(t, getTokenSecret("token-1", "value", map[string]string{TokenMaxUsesAnnotation: "1"}))
+
+	_, err := authService.useToken("wrong", "cluster-1")
+	assertForbidden(t, err)
+
+	name, err := authService.useToken("value", "cluster-1")
+	assert.Nil(t, err)
+	assert.Equal(t, "token-1", name)
+	secret, err := authService.clientset.CoreV1().Secrets("liqo").Get(context.TODO(), "token-1", metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, "1", secret.Annotations[TokenUsesAnnotation])
+
+	_, err = authService.useToken("value", "cluster-2")
+	assertForbidden(t, err)
+
+	//the default token has no limits
+	for i := 0; i < 3; i++ {
+		name, err = authService.useToken("token", "cluster-1")
+		assert.Nil(t, err)
+		assert.Equal(t, AuthTokenSecretName, name)
+	}
+}
+
+func TestReleaseToken(t *testing.T) {
+	authService := getAuthServiceCtrl(t, getTokenSecret("token-1", "value", map[string]string{TokenMaxUsesAnnotation: "1"}),
+		&v1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "cluster-1", Namespace: "liqo"}})
+
+	//the identity of the cluster already exists, the use of the token is given back
+	w := postRole(t, authService, "10.0.0.2", auth.RoleRequest{ClusterID: "cluster-1", Token: "value"})
+	assert.Equal(t, http.StatusConflict, w.Code)
+	secret, err := authService.clientset.CoreV1().Secrets("liqo").Get(context.TODO(), "token-1", metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, "0", secret.Annotations[TokenUsesAnnotation])
+
+	name, err := authService.useToken("value", "cluster-2")
+	assert.Nil(t, err)
+	assert.Equal(t, "token-1", name)
+}
+
+func TestRevokeToken(t *testing.T) {
+	authService := getAuthServiceCtrl(t, getTokenSecret("token-1", "value", nil))
+
+	_, err := authService.peerCertificate(&http.Request{}, getRoleRequest(t, "cluster-1", "value"))
+	assert.Nil(t, err)
+	_, err = authService.peerCertificate(&http.Request{}, getRoleRequest(t, "cluster-2", "token"))
+	assert.Nil(t, err)
+
+	assert.Nil(t, authService.revokeToken("token-1"))
+	_, err = authService.clientset.CoreV1().ServiceAccounts("liqo").Get(context.TODO(), "cluster-1", metav1.GetOptions{})
+	assert.True(t, kerrors.IsNotFound(err))
+	_, err = authService.clientset.RbacV1().ClusterRoles().Get(context.TODO(), "cluster-1", metav1.GetOptions{})
+	assert.True(t, kerrors.IsNotFound(err))
+	_, err = authService.clientset.RbacV1().ClusterRoleBindings().Get(context.TODO(), "cluster-1", metav1.GetOptions{})
+	assert.True(t, kerrors.IsNotFound(err))
+
+	//the identities issued with other tokens are kept
+	_, err = authService.clientset.CoreV1().ServiceAccounts("liqo").Get(context.TODO(), "cluster-2", metav1.GetOptions{})
+	assert.Nil(t, err)
+
+	//a certificate still valid does not grant the permissions again
+	withCertificate := func(clusterID string) *http.Request {
+		return &http.Request{TLS: &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{
+			Subject:     pkix.Name{CommonName: auth.PeerUserPrefix + clusterID},
+			ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		}}}}}
+	}
+	_, err = authService.peerCertificate(withCertificate("cluster-1"), getRoleRequest(t, "cluster-1", ""))
+	assertForbidden(t, err)
+	_, err = authService.clientset.CoreV1().ServiceAccounts("liqo").Get(context.TODO(), "cluster-1", metav1.GetOptions{})
+	assert.True(t, kerrors.IsNotFound(err))
+	_, err = authService.clientset.RbacV1().ClusterRoles().Get(context.TODO(), "cluster-1", metav1.GetOptions{})
+	assert.True(t, kerrors.IsNotFound(err))
+
+	//even if the ServiceAccount is created again
+	_, err = authService.createServiceAccount("cluster-1", "token-1")
+	assert.Nil(t, err)
+	_, err = authService.peerCertificate(withCertificate("cluster-1"), getRoleRequest(t, "cluster-1", ""))
+	assertForbidden(t, err)
+	assert.Nil(t, authService.clientset.CoreV1().ServiceAccounts("liqo").Delete(context.TODO(), "cluster-1", metav1.DeleteOptions{}))
+
+	//the peer enrolls again with a valid token
+	_, err = authService.peerCertificate(&http.Request{}, getRoleRequest(t, "cluster-1", "token"))
+	assert.Nil(t, err)
+	_, err = authService.peerCertificate(withCertificate("cluster-1"), getRoleRequest(t, "cluster-1", ""))
+	assert.Nil(t, err)
+
+	//the permissions deleted by hand are not granted again with a certificate
+	assert.Nil(t, authService.clientset.RbacV1().ClusterRoles().Delete(context.TODO(), "cluster-2", metav1.DeleteOptions{}))
+	_, err = authService.peerCertificate(withCertificate("cluster-2"), getRoleRequest(t, "cluster-2", ""))
+	assertForbidden(t, err)
+	_, err = authService.clientset.RbacV1().ClusterRoles().Get(context.TODO(), "cluster-2", metav1.GetOptions{})
+	assert.True(t, kerrors.IsNotFound(err))
+}
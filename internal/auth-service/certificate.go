@@ -0,0 +1,447 @@
+package auth_service
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"github.com/liqotech/liqo/pkg/auth"
+	certificatesv1beta1 "k8s.io/api/certificates/v1beta1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog"
+	"net/http"
+	"time"
+)
+
+const (
+	// annotation of the ServiceAccount of a peer containing the fingerprint of the key bound to its identity
+	PeerKeyAnnotation = "auth.liqo.io/peer-key-fingerprint"
+	// label of the CertificateSigningRequests containing the ClusterID of the peer which requested them
+	PeerClusterIDLabel = "auth.liqo.io/cluster-id"
+
+	// CertificateTTL is the validity of the certificates issued to the peers, they renew them before they expire
+	CertificateTTL = 24 * time.Hour
+
+	certificateTimeout = 30 * time.Second
+	// the signers round the duration of the certificates and backdate them to tolerate the clock skew
+	certificateTTLTolerance = time.Hour
+	approvalReason          = "LiqoPeerAuthenticated"
+)
+
+var certificatesV1Resource = certificatesv1beta1.SchemeGroupVersion.WithResource("certificatesigningrequests").GroupResource().WithVersion("v1")
+
+// peerCertificate authenticates the peer and returns a certificate for the key of the CertificateSigningRequest,
+// signed by the CA of the cluster and bound to the identity of the peer.
+// The first time a peer enrolls, it authenticates with the token and its key is bound to its ClusterID: the token
+// is then no more enough to request certificates for that ClusterID with a different key. A peer can change its key
+// authenticating with a valid certificate issued to it, as long as its identity has not been deleted or revoked:
+// only the token grants the permissions again.
+func (authService *AuthServiceCtrl) peerCertificate(r *http.Request, roleRequest *auth.RoleRequest) (response *auth.CertificateResponse, err error) {
+	csr, err := auth.ParseCSR(roleRequest.CertificateSigningRequest, roleRequest.ClusterID)
+	if err != nil {
+		return nil, forbidden(err.Error())
+	}
+	fingerprint, err := auth.PublicKeyFingerprint(csr.PublicKey)
+	if err != nil {
+		return nil, forbidden(err.Error())
+	}
+
+	sa, err := authService.clientset.CoreV1().ServiceAccounts(authService.namespace).Get(context.TODO(), roleRequest.ClusterID, metav1.GetOptions{})
+	if err != nil && !kerrors.IsNotFound(err) {
+		return nil, err
+	} else if kerrors.IsNotFound(err) {
+		sa = nil
+	}
+
+	//the identity issued with the token is renewed with the certificate, the token is not used again
+	var tokenName string
+	if !hasPeerCertificate(r, roleRequest.ClusterID) {
+		if sa != nil && sa.Annotations[PeerKeyAnnotation] != "" && sa.Annotations[PeerKeyAnnotation] != fingerprint {
+			return nil, forbidden(fmt.Sprintf("the identity of cluster %s is bound to a different key", roleRequest.ClusterID))
+		}
+		if tokenName, err = authService.useToken(roleRequest.Token, roleRequest.ClusterID); err != nil {
+			return nil, err
+		}
+		// the use of the token is given back if the certificate is not issued
+		defer func() {
+			if err != nil {
+				authService.releaseToken(tokenName, roleRequest.ClusterID)
+			}
+		}()
+		if err = authService.recordRevocation(roleRequest.ClusterID, ""); err != nil {
+			return nil, err
+		}
+	} else {
+		if sa == nil {
+			return nil, forbidden(fmt.Sprintf("the identity of cluster %s has been deleted, it has to enroll again with a token", roleRequest.ClusterID))
+		}
+		if err = authService.checkNotRevoked(roleRequest.ClusterID, sa.Labels[IssuedWithTokenLabel]); err != nil {
+			return nil, err
+		}
+	}
+
+	var resources []string
+	if sa, resources, err = authService.ensurePeerPermissions(roleRequest.ClusterID, sa, tokenName); err != nil {
+		return nil, err
+	}
+	getAuditRecord(r).Resources = resources
+	if err = authService.recordPeerIdentity(sa, fingerprint, tokenName); err != nil {
+		return nil, err
+	}
+
+	certificate, err := authService.signCertificate(roleRequest.ClusterID, roleRequest.CertificateSigningRequest)
+	if err != nil {
+		return nil, err
+	}
+	renewAfter, err := getRenewAfter(certificate)
+	if err != nil {
+		return nil, err
+	}
+	server, err := authService.getAPIServerURL()
+	if err != nil {
+		return nil, err
+	}
+	return &auth.CertificateResponse{
+		Certificate: certificate,
+		CAData:      authService.clusterCA,
+		Server:      server,
+		RenewAfter:  renewAfter,
+	}, nil
+}
+
+// hasPeerCertificate checks if the request has been authenticated with a client certificate issued to the given cluster
+func hasPeerCertificate(r *http.Request, clusterID string) bool {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return false
+	}
+	cert := r.TLS.VerifiedChains[0][0]
+	return cert.Subject.CommonName == auth.PeerSubject(clusterID).CommonName && hasClientAuthUsage(cert)
+}
+
+func hasClientAuthUsage(cert *x509.Certificate) bool {
+	for _, usage := range cert.ExtKeyUsage {
+		if usage == x509.ExtKeyUsageClientAuth {
+			return true
+		}
+	}
+	return false
+}
+
+// ensurePeerPermissions creates the ServiceAccount of the peer, if it does not exist yet, and the roles granted to it.
+// The permissions are created only when the identity is issued with a token: when it is renewed with a certificate
+// the existing ones are just updated, to not grant again the permissions of a deleted identity.
+// It returns the resources it has created or updated, as kind/name.
+func (authService *AuthServiceCtrl) ensurePeerPermissions(clusterID string, sa *v1.ServiceAccount, tokenName string) (*v1.ServiceAccount, []string, error) {
+	var resources []string
+	var err error
+	if sa == nil {
+		if sa, err = authService.createServiceAccount(clusterID, tokenName); err != nil {
+			return nil, nil, err
+		}
+		resources = append(resources, resourceName("ServiceAccount", sa.Name))
+	}
+	if tokenName == "" {
+		updated, err := authService.updatePeerPermissions(clusterID)
+		return sa, append(resources, updated...), err
+	}
+
+	permissions := authService.getPeerPermissions(clusterID)
+
+	role, err := authService.createRole(clusterID, sa)
+	if kerrors.IsAlreadyExists(err) {
+		role, err = authService.clientset.RbacV1().Roles(authService.namespace).Get(context.TODO(), clusterID, metav1.GetOptions{})
+		//the permissions are updated if the configuration has changed since they were granted
+		if err == nil && !equality.Semantic.DeepEqual(role.Rules, permissions.NamespacedRules) {
+			role.Rules = permissions.NamespacedRules
+			role, err = authService.clientset.RbacV1().Roles(authService.namespace).Update(context.TODO(), role, metav1.UpdateOptions{})
+			resources = append(resources, resourceName("Role", clusterID))
+		}
+	} else if err == nil {
+		resources = append(resources, resourceName("Role", clusterID))
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err = authService.createRoleBinding(clusterID, sa, role); err == nil {
+		resources = append(resources, resourceName("RoleBinding", clusterID))
+	} else if !kerrors.IsAlreadyExists(err) {
+		return nil, nil, err
+	}
+
+	clusterRole, err := authService.createClusterRole(clusterID, sa)
+	if kerrors.IsAlreadyExists(err) {
+		clusterRole, err = authService.clientset.RbacV1().ClusterRoles().Get(context.TODO(), clusterID, metav1.GetOptions{})
+		if err == nil && !equality.Semantic.DeepEqual(clusterRole.Rules, permissions.ClusterRules) {
+			clusterRole.Rules = permissions.ClusterRules
+			clusterRole, err = authService.clientset.RbacV1().ClusterRoles().Update(context.TODO(), clusterRole, metav1.UpdateOptions{})
+			resources = append(resources, resourceName("ClusterRole", clusterID))
+		}
+	} else if err == nil {
+		resources = append(resources, resourceName("ClusterRole", clusterID))
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err = authService.createClusterRoleBinding(clusterID, sa, clusterRole); err == nil {
+		resources = append(resources, resourceName("ClusterRoleBinding", clusterID))
+	} else if !kerrors.IsAlreadyExists(err) {
+		return nil, nil, err
+	}
+	return sa, resources, nil
+}
+
+// updatePeerPermissions updates the roles granted to a peer if the configuration has changed since they were granted,
+// returning the updated ones
+func (authService *AuthServiceCtrl) updatePeerPermissions(clusterID string) ([]string, error) {
+	var resources []string
+	permissions := authService.getPeerPermissions(clusterID)
+	deleted := forbidden(fmt.Sprintf("the permissions of cluster %s have been deleted, it has to enroll again with a token", clusterID))
+
+	roles := authService.clientset.RbacV1().Roles(authService.namespace)
+	role, err := roles.Get(context.TODO(), clusterID, metav1.GetOptions{})
+	if kerrors.IsNotFound(err) {
+		return nil, deleted
+	} else if err != nil {
+		return nil, err
+	}
+	if !equality.Semantic.DeepEqual(role.Rules, permissions.NamespacedRules) {
+		role.Rules = permissions.NamespacedRules
+		if _, err = roles.Update(context.TODO(), role, metav1.UpdateOptions{}); err != nil {
+			return nil, err
+		}
+		resources = append(resources, resourceName("Role", clusterID))
+	}
+
+	clusterRoles := authService.clientset.RbacV1().ClusterRoles()
+	clusterRole, err := clusterRoles.Get(context.TODO(), clusterID, metav1.GetOptions{})
+	if kerrors.IsNotFound(err) {
+		return nil, deleted
+	} else if err != nil {
+		return nil, err
+	}
+	if !equality.Semantic.DeepEqual(clusterRole.Rules, permissions.ClusterRules) {
+		clusterRole.Rules = permissions.ClusterRules
+		if _, err = clusterRoles.Update(context.TODO(), clusterRole, metav1.UpdateOptions{}); err != nil {
+			return nil, err
+		}
+		resources = append(resources, resourceName("ClusterRole", clusterID))
+	}
+	return resources, nil
+}
+
+// recordPeerIdentity records in the ServiceAccount of the peer the key bound to its identity and, if it has been
+// (re)issued with a token, the name of the token
+func (authService *AuthServiceCtrl) recordPeerIdentity(sa *v1.ServiceAccount, fingerprint string, tokenName string) error {
+	if sa.Annotations[PeerKeyAnnotation] == fingerprint && (tokenName == "" || sa.Labels[IssuedWithTokenLabel] == tokenName) {
+		return nil
+	}
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		current, err := authService.clientset.CoreV1().ServiceAccounts(sa.Namespace).Get(context.TODO(), sa.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if current.Annotations == nil {
+			current.Annotations = map[string]string{}
+		}
+		current.Annotations[PeerKeyAnnotation] = fingerprint
+		if tokenName != "" {
+			if current.Labels == nil {
+				current.Labels = map[string]string{}
+			}
+			current.Labels[IssuedWithTokenLabel] = tokenName
+		}
+		_, err = authService.clientset.CoreV1().ServiceAccounts(sa.Namespace).Update(context.TODO(), current, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+// signCertificate creates a CertificateSigningRequest for the API server client signer, approves it and waits for
+// the certificate. The certificates/v1 API is used to bound the validity of the certificate to CertificateTTL, the
+// v1beta1 one is used only if the cluster does not serve it.
+func (authService *AuthServiceCtrl) signCertificate(clusterID string, csrPEM []byte) ([]byte, error) {
+	certificate, err := authService.signCertificateV1(clusterID, csrPEM)
+	if kerrors.IsNotFound(err) {
+		klog.Warningf("certificates/v1 not available, the certificate of cluster %s lasts as configured for the signer of the cluster", clusterID)
+		certificate, err = authService.signCertificateV1beta1(clusterID, csrPEM)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	cert, err := parseCertificate(certificate)
+	if err != nil {
+		return nil, err
+	}
+	// the duration is honored by the signers since Kubernetes 1.22
+	if cert.NotAfter.After(time.Now().Add(CertificateTTL + certificateTTLTolerance)) {
+		klog.Warningf("the certificate of cluster %s expires at %s, the signer of the cluster ignored the requested duration of %s",
+			clusterID, cert.NotAfter.UTC().Format(time.RFC3339), CertificateTTL)
+	}
+	return certificate, nil
+}
+
+func (authService *AuthServiceCtrl) signCertificateV1(clusterID string, csrPEM []byte) ([]byte, error) {
+	csrClient := authService.dynamicClient.Resource(certificatesV1Resource)
+	csr := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": certificatesV1Resource.GroupVersion().String(),
+		"kind":       "CertificateSigningRequest",
+		"metadata": map[string]interface{}{
+			"generateName": "liqo-peer-" + clusterID + "-",
+			"labels": map[string]interface{}{
+				PeerClusterIDLabel: clusterID,
+			},
+		},
+		"spec": map[string]interface{}{
+			"request":           base64.StdEncoding.EncodeToString(csrPEM),
+			"signerName":        certificatesv1beta1.KubeAPIServerClientSignerName,
+			"expirationSeconds": int64(CertificateTTL / time.Second),
+			"usages": []interface{}{
+				string(certificatesv1beta1.UsageDigitalSignature),
+				string(certificatesv1beta1.UsageKeyEncipherment),
+				string(certificatesv1beta1.UsageClientAuth),
+			},
+		},
+	}}
+	csr, err := csrClient.Create(context.TODO(), csr, metav1.CreateOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	conditions, _, _ := unstructured.NestedSlice(csr.Object, "status", "conditions")
+	conditions = append(conditions, map[string]interface{}{
+		"type":           string(certificatesv1beta1.CertificateApproved),
+		"status":         string(v1.ConditionTrue),
+		"reason":         approvalReason,
+		"message":        fmt.Sprintf("the identity of cluster %s has been verified by the auth-service", clusterID),
+		"lastUpdateTime": metav1.Now().UTC().Format(time.RFC3339),
+	})
+	if err = unstructured.SetNestedSlice(csr.Object, conditions, "status", "conditions"); err != nil {
+		return nil, err
+	}
+	if _, err = csrClient.Update(context.TODO(), csr, metav1.UpdateOptions{}, "approval"); err != nil {
+		return nil, err
+	}
+
+	return waitCertificate(csr.GetName(), func() (*certificatesv1beta1.CertificateSigningRequestStatus, error) {
+		current, err := csrClient.Get(context.TODO(), csr.GetName(), metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		// the status of the two versions has the same fields
+		status := &certificatesv1beta1.CertificateSigningRequestStatus{}
+		statusObj, _, _ := unstructured.NestedMap(current.Object, "status")
+		return status, runtime.DefaultUnstructuredConverter.FromUnstructured(statusObj, status)
+	})
+}
+
+func (authService *AuthServiceCtrl) signCertificateV1beta1(clusterID string, csrPEM []byte) ([]byte, error) {
+	signerName := certificatesv1beta1.KubeAPIServerClientSignerName
+	csr := &certificatesv1beta1.CertificateSigningRequest{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: "liqo-peer-" + clusterID + "-",
+			Labels: map[string]string{
+				PeerClusterIDLabel: clusterID,
+			},
+		},
+		Spec: certificatesv1beta1.CertificateSigningRequestSpec{
+			Request:    csrPEM,
+			SignerName: &signerName,
+			Usages: []certificatesv1beta1.KeyUsage{
+				certificatesv1beta1.UsageDigitalSignature,
+				certificatesv1beta1.UsageKeyEncipherment,
+				certificatesv1beta1.UsageClientAuth,
+			},
+		},
+	}
+	csrClient := authService.clientset.CertificatesV1beta1().CertificateSigningRequests()
+	csr, err := csrClient.Create(context.TODO(), csr, metav1.CreateOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	csr.Status.Conditions = append(csr.Status.Conditions, certificatesv1beta1.CertificateSigningRequestCondition{
+		Type:           certificatesv1beta1.CertificateApproved,
+		Reason:         approvalReason,
+		Message:        fmt.Sprintf("the identity of cluster %s has been verified by the auth-service", clusterID),
+		LastUpdateTime: metav1.Now(),
+	})
+	if _, err = csrClient.UpdateApproval(context.TODO(), csr, metav1.UpdateOptions{}); err != nil {
+		return nil, err
+	}
+
+	return waitCertificate(csr.Name, func() (*certificatesv1beta1.CertificateSigningRequestStatus, error) {
+		current, err := csrClient.Get(context.TODO(), csr.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		return &current.Status, nil
+	})
+}
+
+// waitCertificate waits for the signer to issue the certificate of an approved CertificateSigningRequest
+func waitCertificate(name string, getStatus func() (*certificatesv1beta1.CertificateSigningRequestStatus, error)) ([]byte, error) {
+	var certificate []byte
+	err := wait.PollImmediate(time.Second, certificateTimeout, func() (bool, error) {
+		status, err := getStatus()
+		if err != nil {
+			klog.Error(err)
+			return false, nil
+		}
+		for _, condition := range status.Conditions {
+			if condition.Type == certificatesv1beta1.CertificateDenied {
+				return false, fmt.Errorf("the certificate signing request %s has been denied: %s", name, condition.Message)
+			}
+		}
+		certificate = status.Certificate
+		return len(certificate) > 0, nil
+	})
+	if err == wait.ErrWaitTimeout {
+		err = fmt.Errorf("the certificate signing request %s has not been signed", name)
+	}
+	return certificate, err
+}
+
+// parseCertificate parses the first certificate of a PEM bundle
+func parseCertificate(certificate []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(certificate)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("the signer issued an invalid certificate")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// getRenewAfter returns the time after which the peer has to renew the certificate, when two thirds of its validity
+// have elapsed
+func getRenewAfter(certificate []byte) (time.Time, error) {
+	cert, err := parseCertificate(certificate)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return cert.NotBefore.Add(cert.NotAfter.Sub(cert.NotBefore) * 2 / 3), nil
+}
+
+func forbidden(message string) error {
+	return &kerrors.StatusError{ErrStatus: metav1.Status{
+		Status:  metav1.StatusFailure,
+		Code:    http.StatusForbidden,
+		Reason:  metav1.StatusReasonForbidden,
+		Message: message,
+	}}
+}
+
+func badRequest(message string) error {
+	return &kerrors.StatusError{ErrStatus: metav1.Status{
+		Status:  metav1.StatusFailure,
+		Code:    http.StatusBadRequest,
+		Reason:  metav1.StatusReasonBadRequest,
+		Message: message,
+	}}
+}
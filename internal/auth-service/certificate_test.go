@@ -0,0 +1,254 @@
+package auth_service
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	configv1alpha1 "github.com/liqotech/liqo/apis/config/v1alpha1"
+	"github.com/liqotech/liqo/pkg/auth"
+	"github.com/liqotech/liqo/pkg/clusterID"
+	"github.com/stretchr/testify/assert"
+	certificatesv1beta1 "k8s.io/api/certificates/v1beta1"
+	v1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"math/big"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+)
+
+// issueCertificate signs the certificate signing request with a test CA, as the signer of the cluster would do
+func issueCertificate(t *testing.T, csrPEM []byte, duration time.Duration) []byte {
+	block, _ := pem.Decode(csrPEM)
+	assert.NotNil(t, block)
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	assert.Nil(t, err)
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      csr.Subject,
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(duration),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, csr.PublicKey, caKey)
+	assert.Nil(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func getAuthServiceCtrl(t *testing.T, objects ...runtime.Object) *AuthServiceCtrl {
+	clientset := fake.NewSimpleClientset(append(objects, &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: AuthTokenSecretName, Namespace: "liqo"},
+		Data:       map[string][]byte{"token": []byte("token")},
+	})...)
+	generated := 0
+	clientset.PrependReactor("create", "certificatesigningrequests", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		csr := action.(k8stesting.CreateAction).GetObject().(*certificatesv1beta1.CertificateSigningRequest)
+		generated++
+		csr.Name = fmt.Sprintf("%s%d", csr.GenerateName, generated)
+		return false, nil, nil
+	})
+	//the signer of the cluster issues the certificates of the approved requests, with its default duration
+	clientset.PrependReactor("get", "certificatesigningrequests", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		name := action.(k8stesting.GetAction).GetName()
+		obj, err := clientset.Tracker().Get(certificatesv1beta1.SchemeGroupVersion.WithResource("certificatesigningrequests"), "", name)
+		if err != nil {
+			return true, nil, err
+		}
+		csr := obj.(*certificatesv1beta1.CertificateSigningRequest).DeepCopy()
+		csr.Status.Certificate = issueCertificate(t, csr.Spec.Request, 365*24*time.Hour)
+		return true, csr, nil
+	})
+
+	//the certificates/v1 API is reached through the dynamic client, the signer honors the requested duration
+	dynamicClient := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme())
+	requests := map[string]*unstructured.Unstructured{}
+	dynamicClient.PrependReactor("create", "certificatesigningrequests", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		csr := action.(k8stesting.CreateAction).GetObject().(*unstructured.Unstructured).DeepCopy()
+		generated++
+		csr.SetName(fmt.Sprintf("%s%d", csr.GetGenerateName(), generated))
+		requests[csr.GetName()] = csr
+		return true, csr, nil
+	})
+	dynamicClient.PrependReactor("update", "certificatesigningrequests", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		csr := action.(k8stesting.UpdateAction).GetObject().(*unstructured.Unstructured).DeepCopy()
+		assert.Equal(t, "approval", action.GetSubresource())
+		requests[csr.GetName()] = csr
+		return true, csr, nil
+	})
+	dynamicClient.PrependReactor("get", "certificatesigningrequests", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		name := action.(k8stesting.GetAction).GetName()
+		csr, ok := requests[name]
+		if !ok {
+			return true, nil, kerrors.NewNotFound(certificatesV1Resource.GroupResource(), name)
+		}
+		csr = csr.DeepCopy()
+		conditions, _, _ := unstructured.NestedSlice(csr.Object, "status", "conditions")
+		if len(conditions) == 0 {
+			return true, csr, nil
+		}
+		request, _, _ := unstructured.NestedString(csr.Object, "spec", "request")
+		csrPEM, err := base64.StdEncoding.DecodeString(request)
+		assert.Nil(t, err)
+		seconds, _, _ := unstructured.NestedInt64(csr.Object, "spec", "expirationSeconds")
+		certificate := issueCertificate(t, csrPEM, time.Duration(seconds)*time.Second)
+		assert.Nil(t, unstructured.SetNestedField(csr.Object, base64.StdEncoding.EncodeToString(certificate), "status", "certificate"))
+		return true, csr, nil
+	})
+
+	informerFactory := informers.NewSharedInformerFactoryWithOptions(clientset, 0, informers.WithNamespace("liqo"))
+	secretInformer := informerFactory.Core().V1().Secrets().Informer()
+	informerFactory.Start(wait.NeverStop)
+	informerFactory.WaitForCacheSync(wait.NeverStop)
+	assert.Nil(t, os.Setenv("APISERVER", "10.0.0.1"))
+	return &AuthServiceCtrl{
+		namespace:      "liqo",
+		clientset:      clientset,
+		dynamicClient:  dynamicClient,
+		secretInformer: secretInformer,
+		clusterCA:      []byte("ca"),
+		clusterID:      clusterID.GetNewClusterID("local-cluster-id", clientset),
+	}
+}
+
+func getRoleRequest(t *testing.T, clusterID, token string) *auth.RoleRequest {
+	csr, _, err := auth.GenerateCSR(clusterID)
+	assert.Nil(t, err)
+	return &auth.RoleRequest{ClusterID: clusterID, Token: token, CertificateSigningRequest: csr}
+}
+
+func assertForbidden(t *testing.T, err error) {
+	assert.NotNil(t, err)
+	assert.True(t, kerrors.IsForbidden(err), err)
+}
+
+func TestPeerCertificate(t *testing.T) {
+	authService := getAuthServiceCtrl(t)
+	r := &http.Request{}
+
+	_, err := authService.peerCertificate(r, getRoleRequest(t, "cluster-1", "wrong"))
+	assertForbidden(t, err)
+
+	//the subject of the request has to match the ClusterID
+	roleRequest := getRoleRequest(t, "cluster-2", "token")
+	roleRequest.ClusterID = "cluster-1"
+	_, err = authService.peerCertificate(r, roleRequest)
+	assertForbidden(t, err)
+
+	response, err := authService.peerCertificate(r, getRoleRequest(t, "cluster-1", "token"))
+	assert.Nil(t, err)
+	cert, err := parseCertificate(response.Certificate)
+	assert.Nil(t, err)
+	assert.Equal(t, auth.PeerUserPrefix+"cluster-1", cert.Subject.CommonName)
+	assert.Equal(t, []byte("ca"), response.CAData)
+	assert.Equal(t, "https://10.0.0.1:6443", response.Server)
+	sa, err := authService.clientset.CoreV1().ServiceAccounts("liqo").Get(context.TODO(), "cluster-1", metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.NotEmpty(t, sa.Annotations[PeerKeyAnnotation])
+	assert.Equal(t, AuthTokenSecretName, sa.Labels[IssuedWithTokenLabel])
+	binding, err := authService.clientset.RbacV1().ClusterRoleBindings().Get(context.TODO(), "cluster-1", metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.Contains(t, binding.Subjects, rbacv1.Subject{Kind: rbacv1.UserKind, APIGroup: rbacv1.GroupName, Name: auth.PeerUserPrefix + "cluster-1"})
+
+	//once enrolled the token is no more enough to change the key of the cluster
+	_, err = authService.peerCertificate(r, getRoleRequest(t, "cluster-1", "token"))
+	assertForbidden(t, err)
+
+	//while a certificate issued to the cluster is
+	r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{
+		Subject:     pkix.Name{CommonName: auth.PeerUserPrefix + "cluster-1"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}}}}
+	_, err = authService.peerCertificate(r, getRoleRequest(t, "cluster-1", ""))
+	assert.Nil(t, err)
+	_, err = authService.peerCertificate(r, getRoleRequest(t, "cluster-2", ""))
+	assertForbidden(t, err)
+}
+
+func TestPeerPermissionsTemplate(t *testing.T) {
+	authService := getAuthServiceCtrl(t)
+
+	_, err := authService.peerCertificate(&http.Request{}, getRoleRequest(t, "cluster-1", "token"))
+	assert.Nil(t, err)
+	clusterRole, err := authService.clientset.RbacV1().ClusterRoles().Get(context.TODO(), "cluster-1", metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.Contains(t, clusterRole.Rules, rbacv1.PolicyRule{
+		APIGroups:     []string{"discovery.liqo.io"},
+		Resources:     []string{"peeringrequests"},
+		Verbs:         []string{"get", "delete", "update"},
+		ResourceNames: []string{"cluster-1"},
+	})
+
+	//the roles granted to the enrolled peers follow the configuration
+	authService.handleConfiguration(&configv1alpha1.ClusterConfig{Spec: configv1alpha1.ClusterConfigSpec{
+		PeerPermissionsConfig: configv1alpha1.PeerPermissionsConfig{
+			PeeringRequest: &configv1alpha1.PermissionsTemplate{
+				ClusterRules: []rbacv1.PolicyRule{{
+					APIGroups:     []string{"discovery.liqo.io"},
+					Resources:     []string{"peeringrequests"},
+					Verbs:         []string{"get"},
+					ResourceNames: []string{auth.ClusterIDPlaceholder},
+				}},
+			},
+		},
+	}})
+	r := &http.Request{TLS: &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{
+		Subject:     pkix.Name{CommonName: auth.PeerUserPrefix + "cluster-1"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}}}}}
+	_, err = authService.peerCertificate(r, getRoleRequest(t, "cluster-1", ""))
+	assert.Nil(t, err)
+	clusterRole, err = authService.clientset.RbacV1().ClusterRoles().Get(context.TODO(), "cluster-1", metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, []rbacv1.PolicyRule{{
+		APIGroups:     []string{"discovery.liqo.io"},
+		Resources:     []string{"peeringrequests"},
+		Verbs:         []string{"get"},
+		ResourceNames: []string{"cluster-1"},
+	}}, clusterRole.Rules)
+	role, err := authService.clientset.RbacV1().Roles("liqo").Get(context.TODO(), "cluster-1", metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.Empty(t, role.Rules)
+}
+
+func TestSignCertificate(t *testing.T) {
+	authService := getAuthServiceCtrl(t)
+
+	//the certificates are short-lived and renewed when two thirds of their validity have elapsed
+	response, err := authService.peerCertificate(&http.Request{}, getRoleRequest(t, "cluster-1", "token"))
+	assert.Nil(t, err)
+	cert, err := parseCertificate(response.Certificate)
+	assert.Nil(t, err)
+	assert.WithinDuration(t, time.Now().Add(CertificateTTL), cert.NotAfter, time.Minute)
+	assert.WithinDuration(t, time.Now().Add(CertificateTTL*2/3), response.RenewAfter, time.Minute)
+
+	//the clusters not serving certificates/v1 sign the certificates with the v1beta1 API
+	authService.dynamicClient.(*dynamicfake.FakeDynamicClient).PrependReactor("create", "certificatesigningrequests", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, kerrors.NewNotFound(certificatesV1Resource.GroupResource(), "")
+	})
+	csr, _, err := auth.GenerateCSR("cluster-2")
+	assert.Nil(t, err)
+	certificate, err := authService.signCertificate("cluster-2", csr)
+	assert.Nil(t, err)
+	cert, err = parseCertificate(certificate)
+	assert.Nil(t, err)
+	assert.Equal(t, auth.PeerUserPrefix+"cluster-2", cert.Subject.CommonName)
+}
@@ -15,7 +15,7 @@ func (authService *AuthServiceCtrl) role(w http.ResponseWriter, r *http.Request,
 	bytes, err := ioutil.ReadAll(r.Body)
 	if err != nil {
 		klog.Error(err)
-		authService.handleError(w, err)
+		authService.handleError(w, r, err)
 		return
 	}
 
@@ -23,74 +23,96 @@ func (authService *AuthServiceCtrl) role(w http.ResponseWriter, r *http.Request,
 	err = json.Unmarshal(bytes, roleRequest)
 	if err != nil {
 		klog.Error(err)
-		authService.handleError(w, err)
+		authService.handleError(w, r, badRequest(err.Error()))
+		return
+	}
+	record := getAuditRecord(r)
+	record.ClusterID = roleRequest.ClusterID
+	if roleRequest.ClusterID == "" {
+		authService.handleError(w, r, badRequest("the clusterID is required"))
+		return
+	}
+	if reason := authService.lockedOut(w, record.SourceIP, roleRequest.ClusterID); reason != "" {
+		record.Outcome = reason
 		return
 	}
 
-	if token, err := authService.getToken(); err != nil {
-		klog.Error(err)
-		authService.handleError(w, err)
-		return
-	} else if token != roleRequest.Token {
-		err = &kerrors.StatusError{ErrStatus: metav1.Status{
-			Status: metav1.StatusFailure,
-			Code:   http.StatusForbidden,
-			Reason: metav1.StatusReasonForbidden,
-		}}
+	if len(roleRequest.CertificateSigningRequest) > 0 {
+		authService.certificateRole(w, r, roleRequest)
+		return
+	}
+
+	record.Method = "token"
+	tokenName, err := authService.useToken(roleRequest.Token, roleRequest.ClusterID)
+	if err != nil {
 		klog.Error(err)
-		authService.handleError(w, err)
+		authService.handleError(w, r, err)
 		return
 	}
+	record.Token = tokenName
+	// the use of the token is given back if the credentials are not issued, e.g. because the cluster already has them
+	issued := false
+	defer func() {
+		if !issued {
+			authService.releaseToken(tokenName, roleRequest.ClusterID)
+		}
+	}()
 
-	sa, err := authService.createServiceAccount(roleRequest.ClusterID)
+	sa, err := authService.createServiceAccount(roleRequest.ClusterID, tokenName)
 	if err != nil {
 		klog.Error(err)
-		authService.handleError(w, err)
+		authService.handleError(w, r, err)
 		return
 	}
+	record.Resources = append(record.Resources, resourceName("ServiceAccount", sa.Name))
 
 	role, err := authService.createRole(roleRequest.ClusterID, sa)
 	if err != nil {
 		klog.Error(err)
-		authService.handleError(w, err)
+		authService.handleError(w, r, err)
 		return
 	}
+	record.Resources = append(record.Resources, resourceName("Role", role.Name))
 
-	_, err = authService.createRoleBinding(roleRequest.ClusterID, sa, role)
+	roleBinding, err := authService.createRoleBinding(roleRequest.ClusterID, sa, role)
 	if err != nil {
 		klog.Error(err)
-		authService.handleError(w, err)
+		authService.handleError(w, r, err)
 		return
 	}
+	record.Resources = append(record.Resources, resourceName("RoleBinding", roleBinding.Name))
 
 	clusterRole, err := authService.createClusterRole(roleRequest.ClusterID, sa)
 	if err != nil {
 		klog.Error(err)
-		authService.handleError(w, err)
+		authService.handleError(w, r, err)
 		return
 	}
+	record.Resources = append(record.Resources, resourceName("ClusterRole", clusterRole.Name))
 
-	_, err = authService.createClusterRoleBinding(roleRequest.ClusterID, sa, clusterRole)
+	clusterRoleBinding, err := authService.createClusterRoleBinding(roleRequest.ClusterID, sa, clusterRole)
 	if err != nil {
 		klog.Error(err)
-		authService.handleError(w, err)
+		authService.handleError(w, r, err)
 		return
 	}
+	record.Resources = append(record.Resources, resourceName("ClusterRoleBinding", clusterRoleBinding.Name))
 
 	sa, err = authService.getServiceAccountCompleted(roleRequest.ClusterID)
 	if err != nil {
 		klog.Error(err)
-		authService.handleError(w, err)
+		authService.handleError(w, r, err)
 		return
 	}
 
 	kubeconfig, err := authService.createKubeConfig(sa)
 	if err != nil {
 		klog.Error(err)
-		authService.handleError(w, err)
+		authService.handleError(w, r, err)
 		return
 	}
 
+	issued = true
 	w.WriteHeader(http.StatusCreated)
 	_, err = w.Write([]byte(kubeconfig))
 	if err != nil {
@@ -99,9 +121,54 @@ func (authService *AuthServiceCtrl) role(w http.ResponseWriter, r *http.Request,
 	}
 }
 
-func (authService *AuthServiceCtrl) handleError(w http.ResponseWriter, err error) {
-	// TODO: switch on error type
-	authService.sendError(w, err.Error(), http.StatusInternalServerError)
+func (authService *AuthServiceCtrl) certificateRole(w http.ResponseWriter, r *http.Request, roleRequest *auth.RoleRequest) {
+	record := getAuditRecord(r)
+	record.Method = "certificate"
+	if !hasPeerCertificate(r, roleRequest.ClusterID) {
+		record.Method = "token"
+	}
+	response, err := authService.peerCertificate(r, roleRequest)
+	if err != nil {
+		klog.Error(err)
+		authService.handleError(w, r, err)
+		return
+	}
+
+	bytes, err := json.Marshal(response)
+	if err != nil {
+		klog.Error(err)
+		authService.handleError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if _, err = w.Write(bytes); err != nil {
+		klog.Error(err)
+		return
+	}
+}
+
+// handleError sends the error to the client with the status code matching its reason. The details of the internal
+// errors are only logged, not to disclose them to the client.
+func (authService *AuthServiceCtrl) handleError(w http.ResponseWriter, r *http.Request, err error) {
+	getAuditRecord(r).Error = err.Error()
+	switch kerrors.ReasonForError(err) {
+	case metav1.StatusReasonBadRequest:
+		authService.sendError(w, err.Error(), http.StatusBadRequest)
+	case metav1.StatusReasonUnauthorized:
+		authService.sendError(w, err.Error(), http.StatusUnauthorized)
+	case metav1.StatusReasonForbidden:
+		authService.sendError(w, err.Error(), http.StatusForbidden)
+	case metav1.StatusReasonTooManyRequests:
+		authService.sendError(w, err.Error(), http.StatusTooManyRequests)
+	case metav1.StatusReasonAlreadyExists, metav1.StatusReasonConflict:
+		authService.sendError(w, err.Error(), http.StatusConflict)
+	case metav1.StatusReasonNotFound:
+		authService.sendError(w, err.Error(), http.StatusNotFound)
+	default:
+		authService.sendError(w, "internal error", http.StatusInternalServerError)
+	}
 }
 
 func (authService *AuthServiceCtrl) sendError(w http.ResponseWriter, resp interface{}, code int) {
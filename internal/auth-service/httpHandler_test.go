@@ -0,0 +1,182 @@
+package auth_service
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/liqotech/liqo/pkg/auth"
+	"github.com/stretchr/testify/assert"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/client-go/tools/record"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func postRole(t *testing.T, authService *AuthServiceCtrl, source string, body interface{}) *httptest.ResponseRecorder {
+	var data []byte
+	if s, ok := body.(string); ok {
+		data = []byte(s)
+	} else {
+		var err error
+		data, err = json.Marshal(body)
+		assert.Nil(t, err)
+	}
+	r := httptest.NewRequest(http.MethodPost, "/role", bytes.NewReader(data))
+	r.RemoteAddr = source + ":40000"
+	w := httptest.NewRecorder()
+	authService.audited(authService.role)(w, r, nil)
+	return w
+}
+
+func assertEvent(t *testing.T, recorder *record.FakeRecorder, prefix string) {
+	select {
+	case event := <-recorder.Events:
+		assert.True(t, strings.HasPrefix(event, prefix), event)
+	default:
+		t.Errorf("no event recorded, expected %s", prefix)
+	}
+}
+
+func TestRoleStatusCodes(t *testing.T) {
+	authService := getAuthServiceCtrl(t)
+	recorder := record.NewFakeRecorder(10)
+	authService.eventRecorder = recorder
+
+	w := postRole(t, authService, "10.0.0.2", "{")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assertEvent(t, recorder, "Warning InvalidRequest")
+
+	w = postRole(t, authService, "10.0.0.2", auth.RoleRequest{Token: "token"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assertEvent(t, recorder, "Warning InvalidRequest")
+
+	w = postRole(t, authService, "10.0.0.2", auth.RoleRequest{ClusterID: "cluster-1", Token: "wrong"})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assertEvent(t, recorder, "Warning IdentityDenied")
+
+	w = postRole(t, authService, "10.0.0.2", getRoleRequest(t, "cluster-1", "token"))
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assertEvent(t, recorder, "Normal IdentityIssued /role request of cluster-1 from 10.0.0.2: success (201), authenticated with token, granted ServiceAccount/cluster-1")
+}
+
+func TestLockout(t *testing.T) {
+	fakeClock := clock.NewFakeClock(time.Now())
+	authService := getAuthServiceCtrl(t)
+	authService.limiter = newSourceLimiter(RateLimitConfig{
+		MaxFailedAttempts: 3,
+		LockoutDuration:   time.Minute,
+	}, fakeClock)
+	recorder := record.NewFakeRecorder(10)
+	authService.eventRecorder = recorder
+	wrong := auth.RoleRequest{ClusterID: "cluster-1", Token: "wrong"}
+
+	//a valid request resets the failed attempts
+	assert.Equal(t, http.StatusForbidden, postRole(t, authService, "10.0.0.2", wrong).Code)
+	assert.Equal(t, http.StatusForbidden, postRole(t, authService, "10.0.0.2", wrong).Code)
+	assert.Equal(t, http.StatusCreated, postRole(t, authService, "10.0.0.2", getRoleRequest(t, "cluster-1", "token")).Code)
+	assert.Equal(t, http.StatusForbidden, postRole(t, authService, "10.0.0.2", wrong).Code)
+	assert.Equal(t, http.StatusForbidden, postRole(t, authService, "10.0.0.2", wrong).Code)
+	assert.Equal(t, http.StatusForbidden, postRole(t, authService, "10.0.0.2", wrong).Code)
+	for len(recorder.Events) > 0 {
+		<-recorder.Events
+	}
+
+	//the cluster is locked out from the source
+	w := postRole(t, authService, "10.0.0.2", wrong)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "60", w.Header().Get("Retry-After"))
+	assertEvent(t, recorder, "Warning LockedOut /role request of cluster-1 from 10.0.0.2")
+
+	//while the other clusters behind the same source and the same cluster from other sources are not
+	assert.Equal(t, http.StatusCreated, postRole(t, authService, "10.0.0.2", getRoleRequest(t, "cluster-2", "token")).Code)
+	assert.Equal(t, http.StatusForbidden, postRole(t, authService, "10.0.0.3", wrong).Code)
+
+	fakeClock.Step(time.Minute)
+	assert.Equal(t, http.StatusForbidden, postRole(t, authService, "10.0.0.2", wrong).Code)
+}
+
+func TestSourceLockout(t *testing.T) {
+	fakeClock := clock.NewFakeClock(time.Now())
+	authService := getAuthServiceCtrl(t)
+	authService.limiter = newSourceLimiter(RateLimitConfig{
+		MaxFailedAttempts:       3,
+		MaxSourceFailedAttempts: 5,
+		LockoutDuration:         time.Minute,
+	}, fakeClock)
+
+	//a client changing the ClusterID at each attempt is locked out from its source
+	for i := 0; i < 5; i++ {
+		request := auth.RoleRequest{ClusterID: fmt.Sprintf("cluster-%d", i), Token: "wrong"}
+		assert.Equal(t, http.StatusForbidden, postRole(t, authService, "10.0.0.2", request).Code)
+	}
+	w := postRole(t, authService, "10.0.0.2", getRoleRequest(t, "cluster-10", "token"))
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "60", w.Header().Get("Retry-After"))
+
+	//the same ClusterIDs are not locked out from other sources
+	assert.Equal(t, http.StatusCreated, postRole(t, authService, "10.0.0.3", getRoleRequest(t, "cluster-0", "token")).Code)
+
+	fakeClock.Step(time.Minute)
+	assert.Equal(t, http.StatusCreated, postRole(t, authService, "10.0.0.2", getRoleRequest(t, "cluster-10", "token")).Code)
+
+	//the failures are counted in a window
+	for i := 0; i < 4; i++ {
+		assert.Equal(t, http.StatusForbidden, postRole(t, authService, "10.0.0.4", auth.RoleRequest{ClusterID: fmt.Sprintf("cluster-%d", i), Token: "wrong"}).Code)
+	}
+	fakeClock.Step(sourceRetention + time.Second)
+	assert.Equal(t, http.StatusForbidden, postRole(t, authService, "10.0.0.4", auth.RoleRequest{ClusterID: "cluster-4", Token: "wrong"}).Code)
+	assert.Equal(t, http.StatusCreated, postRole(t, authService, "10.0.0.4", getRoleRequest(t, "cluster-11", "token")).Code)
+}
+
+func TestErrorStatus(t *testing.T) {
+	authService := getAuthServiceCtrl(t)
+	resource := schema.GroupResource{Resource: "serviceaccounts"}
+	tests := []struct {
+		err  error
+		code int
+	}{
+		{kerrors.NewAlreadyExists(resource, "cluster-1"), http.StatusConflict},
+		{kerrors.NewConflict(resource, "cluster-1", errors.New("modified")), http.StatusConflict},
+		{kerrors.NewNotFound(resource, "cluster-1"), http.StatusNotFound},
+		{errors.New("connection refused"), http.StatusInternalServerError},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/role", nil)
+		w := httptest.NewRecorder()
+		authService.handleError(w, r, test.err)
+		assert.Equal(t, test.code, w.Code, test.err.Error())
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	fakeClock := clock.NewFakeClock(time.Now())
+	limiter := newSourceLimiter(RateLimitConfig{
+		RequestsPerSecond: 1,
+		Burst:             2,
+	}, fakeClock)
+
+	for i := 0; i < 2; i++ {
+		reason, _ := limiter.allow("10.0.0.2")
+		assert.Empty(t, reason)
+	}
+	reason, retryAfter := limiter.allow("10.0.0.2")
+	assert.Equal(t, auditOutcomeRateLimited, reason)
+	assert.Equal(t, time.Second, retryAfter)
+	reason, _ = limiter.allow("10.0.0.3")
+	assert.Empty(t, reason)
+
+	fakeClock.Step(time.Second)
+	reason, _ = limiter.allow("10.0.0.2")
+	assert.Empty(t, reason)
+
+	//the sources not seen for a while are forgotten
+	fakeClock.Step(sourceRetention + time.Second)
+	_, _ = limiter.allow("10.0.0.4")
+	assert.Len(t, limiter.sources, 1)
+}
@@ -0,0 +1,90 @@
+package auth_service
+
+import (
+	"crypto"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/julienschmidt/httprouter"
+	"github.com/liqotech/liqo/pkg/auth"
+	"k8s.io/klog"
+	"net/http"
+)
+
+// maximum length of the nonce chosen by the requester of the identity
+const maxNonceLength = 128
+
+// identity returns the signed identity of this cluster. It is not authenticated: the peers use it to check which
+// cluster they are talking to before trusting it.
+func (authService *AuthServiceCtrl) identity(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	nonce := r.URL.Query().Get("nonce")
+	if nonce == "" || len(nonce) > maxNonceLength {
+		authService.handleError(w, r, badRequest("a nonce of at most 128 characters is required"))
+		return
+	}
+
+	signed, err := authService.signIdentity(nonce)
+	if err != nil {
+		klog.Error(err)
+		authService.handleError(w, r, err)
+		return
+	}
+	bytes, err := json.Marshal(signed)
+	if err != nil {
+		klog.Error(err)
+		authService.handleError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if _, err = w.Write(bytes); err != nil {
+		klog.Error(err)
+		return
+	}
+}
+
+// signIdentity builds the identity of this cluster for the given nonce and signs it with the key of the cluster
+// identity, which is persistent and signs the announcements too, so that the peers can pin it
+func (authService *AuthServiceCtrl) signIdentity(nonce string) (*auth.SignedIdentity, error) {
+	clusterID := authService.clusterID.GetClusterID()
+	if clusterID == "" {
+		return nil, errors.New("the cluster ID is not set yet")
+	}
+
+	identity := &auth.Identity{
+		ClusterID:     clusterID,
+		CAFingerprint: auth.CAFingerprint(authService.clusterCA),
+		CAData:        authService.clusterCA,
+		Features:      auth.SupportedFeatures,
+		Nonce:         nonce,
+	}
+	authService.configMutex.RLock()
+	if authService.config != nil {
+		identity.ClusterName = authService.config.DiscoveryConfig.ClusterName
+	}
+	authService.configMutex.RUnlock()
+	if url, err := authService.getAuthServiceURL(); err != nil {
+		klog.V(4).Infof("the auth-service URL is not included in the identity: %v", err)
+	} else {
+		identity.AuthServiceURL = url
+	}
+
+	key, err := authService.getClusterKey()
+	if err != nil {
+		return nil, fmt.Errorf("the key of the cluster identity is not available: %w", err)
+	}
+	return auth.SignIdentity(identity, key)
+}
+
+// getClusterKey returns the key of the cluster identity, loading it at the first use since the cluster ID it is bound
+// to is set up by the discovery
+func (authService *AuthServiceCtrl) getClusterKey() (crypto.Signer, error) {
+	if key := authService.clusterID.GetKey(); key != nil {
+		return key, nil
+	}
+	if err := authService.clusterID.LoadKey(authService.namespace); err != nil {
+		return nil, err
+	}
+	return authService.clusterID.GetKey(), nil
+}
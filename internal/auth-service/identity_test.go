@@ -0,0 +1,123 @@
+package auth_service
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"github.com/julienschmidt/httprouter"
+	configv1alpha1 "github.com/liqotech/liqo/apis/config/v1alpha1"
+	"github.com/liqotech/liqo/pkg/auth"
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func getIdentityServer(t *testing.T, authService *AuthServiceCtrl) *httptest.Server {
+	router := httprouter.New()
+	router.GET(auth.IdentityPath, authService.limited(authService.identity))
+	return httptest.NewTLSServer(router)
+}
+
+func TestIdentity(t *testing.T) {
+	authService := getAuthServiceCtrl(t, &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: authServiceName, Namespace: "liqo"},
+		Spec: v1.ServiceSpec{
+			Type:  v1.ServiceTypeNodePort,
+			Ports: []v1.ServicePort{{NodePort: 30000}},
+		},
+	})
+	authService.config = &configv1alpha1.ClusterConfigSpec{
+		DiscoveryConfig: configv1alpha1.DiscoveryConfig{ClusterName: "local-cluster"},
+	}
+	authService.clusterCA = []byte("-----BEGIN CERTIFICATE-----\nY2E=\n-----END CERTIFICATE-----\n")
+	server := getIdentityServer(t, authService)
+	defer server.Close()
+
+	identity, keyFingerprint, err := auth.FetchIdentity(server.URL, time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, "local-cluster-id", identity.ClusterID)
+	assert.Equal(t, "local-cluster", identity.ClusterName)
+	assert.Equal(t, "https://10.0.0.1:30000", identity.AuthServiceURL)
+	assert.Equal(t, auth.CAFingerprint(authService.clusterCA), identity.CAFingerprint)
+	assert.NotEmpty(t, identity.CAFingerprint)
+	assert.Equal(t, authService.clusterCA, identity.CAData)
+	assert.Equal(t, auth.SupportedFeatures, identity.Features)
+	//the identity is signed with the key of the cluster identity, which survives the restarts of the auth-service
+	expected, err := auth.PublicKeyFingerprint(authService.clusterID.GetKey().Public())
+	assert.Nil(t, err)
+	assert.Equal(t, expected, keyFingerprint)
+	restarted := getIdentityServer(t, authService)
+	defer restarted.Close()
+	_, keyFingerprint, err = auth.FetchIdentity(restarted.URL, time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, keyFingerprint)
+
+	//the nonce is required
+	resp, err := server.Client().Get(server.URL + auth.IdentityPath)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	_ = resp.Body.Close()
+}
+
+func TestIdentitySignedWithAnotherKey(t *testing.T) {
+	authService := getAuthServiceCtrl(t)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	//the identity is sent with a key different from the one signing it, e.g. the one of the announcements of the cluster
+	router := httprouter.New()
+	router.GET(auth.IdentityPath, func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		signed, err := authService.signIdentity(r.URL.Query().Get("nonce"))
+		assert.Nil(t, err)
+		signed.PublicKey, err = x509.MarshalPKIXPublicKey(key.Public())
+		assert.Nil(t, err)
+		assert.Nil(t, json.NewEncoder(w).Encode(signed))
+	})
+	server := httptest.NewTLSServer(router)
+	defer server.Close()
+
+	_, _, err = auth.FetchIdentity(server.URL, time.Second)
+	assert.NotNil(t, err)
+}
+
+func TestIdentityNotServed(t *testing.T) {
+	//an older auth-service does not serve the identity
+	server := httptest.NewTLSServer(httprouter.New())
+	defer server.Close()
+
+	_, _, err := auth.FetchIdentity(server.URL, time.Second)
+	assert.True(t, errors.Is(err, auth.ErrIdentityNotServed), err)
+}
+
+func TestCheckServerCA(t *testing.T) {
+	server := httptest.NewTLSServer(httprouter.New())
+	defer server.Close()
+
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	assert.Nil(t, auth.CheckServerCA(server.URL, ca, time.Second))
+
+	//the certificate of the server is not issued by another CA
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "other-ca"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	assert.Nil(t, err)
+	otherCA := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	assert.NotNil(t, auth.CheckServerCA(server.URL, otherCA, time.Second))
+}
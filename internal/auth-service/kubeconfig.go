@@ -10,15 +10,71 @@ import (
 	"k8s.io/klog"
 	kubeconfigutil "k8s.io/kubernetes/cmd/kubeadm/app/util/kubeconfig"
 	"os"
+	"strconv"
 )
 
-// this function creates a kube-config file for a specified ServiceAccount
+// this function creates a kube-config file for a specified ServiceAccount, with the current token of the peer
 func (authService *AuthServiceCtrl) createKubeConfig(serviceAccount *v1.ServiceAccount) (string, error) {
-	secret, err := authService.clientset.CoreV1().Secrets(authService.namespace).Get(context.TODO(), serviceAccount.Secrets[0].Name, metav1.GetOptions{})
+	secret, err := authService.getPeerToken(serviceAccount)
 	if err != nil {
 		return "", err
 	}
+	if secret == nil {
+		secret, err = authService.clientset.CoreV1().Secrets(authService.namespace).Get(context.TODO(), serviceAccount.Secrets[0].Name, metav1.GetOptions{})
+		if err != nil {
+			return "", err
+		}
+	}
+
+	server, err := authService.getAPIServerURL()
+	if err != nil {
+		return "", err
+	}
+
+	token := string(secret.Data["token"])
+
+	cnf := kubeconfigutil.CreateWithToken(server, "service-cluster", serviceAccount.Name, secret.Data["ca.crt"], token)
+	r, err := runtime.Encode(clientcmdlatest.Codec, cnf)
+	if err != nil {
+		return "", err
+	}
+	return string(r), nil
+}
+
+// getAPIServerURL returns the URL where the peers can contact the API server of this cluster
+func (authService *AuthServiceCtrl) getAPIServerURL() (string, error) {
+	address, err := authService.getClusterAddress()
+	if err != nil {
+		return "", err
+	}
+
+	port, ok := os.LookupEnv("APISERVER_PORT")
+	if !ok {
+		port = "6443"
+	}
 
+	return "https://" + address + ":" + port, nil
+}
+
+// getAuthServiceURL returns the URL where the peers can contact the auth-service, exposed by a NodePort Service
+func (authService *AuthServiceCtrl) getAuthServiceURL() (string, error) {
+	address, err := authService.getClusterAddress()
+	if err != nil {
+		return "", err
+	}
+	svc, err := authService.clientset.CoreV1().Services(authService.namespace).Get(context.TODO(), authServiceName, metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+	if svc.Spec.Type != v1.ServiceTypeNodePort || len(svc.Spec.Ports) == 0 || svc.Spec.Ports[0].NodePort == 0 {
+		return "", errors.New("the auth-service is not exposed by a NodePort")
+	}
+	return "https://" + address + ":" + strconv.Itoa(int(svc.Spec.Ports[0].NodePort)), nil
+}
+
+// getClusterAddress returns the address where the peers can contact this cluster: the one set in the APISERVER env
+// variable, or else the one of the first master node
+func (authService *AuthServiceCtrl) getClusterAddress() (string, error) {
 	address, ok := os.LookupEnv("APISERVER")
 	if !ok || address == "" {
 		nodes := authService.nodeInformer.GetStore().List()
@@ -35,25 +91,11 @@ func (authService *AuthServiceCtrl) createKubeConfig(serviceAccount *v1.ServiceA
 		}
 
 		if node == nil {
-			err = errors.New("no APISERVER env variable found and no master node found, one of the two values must be present")
+			err := errors.New("no APISERVER env variable found and no master node found, one of the two values must be present")
 			klog.Error(err)
 			return "", err
 		}
 		address = node.Status.Addresses[0].Address
 	}
-
-	port, ok := os.LookupEnv("APISERVER_PORT")
-	if !ok {
-		port = "6443"
-	}
-
-	token := string(secret.Data["token"])
-	server := "https://" + address + ":" + port
-
-	cnf := kubeconfigutil.CreateWithToken(server, "service-cluster", serviceAccount.Name, secret.Data["ca.crt"], token)
-	r, err := runtime.Encode(clientcmdlatest.Codec, cnf)
-	if err != nil {
-		return "", err
-	}
-	return string(r), nil
+	return address, nil
 }
@@ -0,0 +1,216 @@
+package auth_service
+
+import (
+	"k8s.io/client-go/util/flowcontrol"
+	"k8s.io/klog"
+	"sync"
+	"time"
+)
+
+// the state of the sources not seen for this period is forgotten, unless they are locked out
+const sourceRetention = 10 * time.Minute
+
+// RateLimitConfig configures the limits applied to the requests coming from each source address.
+// Several clusters can share the same source address, e.g. when they are behind the same SNAT: the rate limit applies
+// to all of them, while the lockout is applied to the source address and the ClusterID which failed to authenticate,
+// so that a misconfigured peer does not lock out the other clusters behind its address. Since the ClusterID is chosen
+// by the client, the failures of all the ClusterIDs are counted for the source address too, with a higher threshold,
+// so that a client changing it at each attempt is locked out as well.
+type RateLimitConfig struct {
+	// sustained rate of requests accepted from a source, 0 disables the limit
+	RequestsPerSecond float32
+	// maximum number of requests accepted in a burst from a source
+	Burst int
+	// number of consecutive failed authentications after which a ClusterID is locked out from a source, 0 disables
+	// the lockout
+	MaxFailedAttempts int
+	// number of failed authentications from a source, with any ClusterID, after which the whole source is locked out.
+	// They are counted in a window of sourceRetention, 0 disables the lockout of the sources
+	MaxSourceFailedAttempts int
+	// time for which the requests of a locked out ClusterID or source are refused
+	LockoutDuration time.Duration
+}
+
+type sourceState struct {
+	limiter  flowcontrol.RateLimiter
+	lastSeen time.Time
+}
+
+// lockoutKey identifies a ClusterID from a source, the clusterID is empty for the state of the whole source
+type lockoutKey struct {
+	source    string
+	clusterID string
+}
+
+type lockoutState struct {
+	failedAttempts int
+	// beginning of the window in which the failed attempts of a whole source are counted
+	windowStart time.Time
+	lockedUntil time.Time
+	lastSeen    time.Time
+}
+
+// sourceLimiter tracks the requests of each source address and the failed authentications of each ClusterID from it
+type sourceLimiter struct {
+	config    RateLimitConfig
+	clock     flowcontrol.Clock
+	mutex     sync.Mutex
+	sources   map[string]*sourceState
+	lockouts  map[lockoutKey]*lockoutState
+	lastSweep time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time        { return time.Now() }
+func (realClock) Sleep(d time.Duration) { time.Sleep(d) }
+
+func newSourceLimiter(config RateLimitConfig, clock flowcontrol.Clock) *sourceLimiter {
+	if clock == nil {
+		clock = realClock{}
+	}
+	if config.Burst <= 0 {
+		config.Burst = 1
+	}
+	return &sourceLimiter{
+		config:   config,
+		clock:    clock,
+		sources:  map[string]*sourceState{},
+		lockouts: map[lockoutKey]*lockoutState{},
+	}
+}
+
+// allow checks if a request of the source can be served. If not, it returns the reason (an outcome of the audit log)
+// and the time after which the source can retry
+func (l *sourceLimiter) allow(source string) (reason string, retryAfter time.Duration) {
+	if l == nil {
+		return "", 0
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := l.clock.Now()
+	l.sweep(now)
+	state := l.getState(source, now)
+
+	if state.limiter != nil && !state.limiter.TryAccept() {
+		return auditOutcomeRateLimited, time.Duration(float32(time.Second) / l.config.RequestsPerSecond)
+	}
+	return "", 0
+}
+
+// lockedOut checks if the source, or the ClusterID from the source, is locked out, returning the time after which it
+// can retry
+func (l *sourceLimiter) lockedOut(source string, clusterID string) (bool, time.Duration) {
+	if l == nil {
+		return false, 0
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := l.clock.Now()
+	lockedUntil := l.getLockoutState(lockoutKey{source: source}, now).lockedUntil
+	if state := l.getLockoutState(lockoutKey{source: source, clusterID: clusterID}, now); state.lockedUntil.After(lockedUntil) {
+		lockedUntil = state.lockedUntil
+	}
+	if now.Before(lockedUntil) {
+		return true, lockedUntil.Sub(now)
+	}
+	return false, 0
+}
+
+// recordResult counts the failed authentications of the ClusterID from the source, locking it out when they are too
+// many consecutive ones, and the ones of the whole source, locking it out when they are too many in the window. It
+// returns true if the ClusterID or the source has been locked out.
+func (l *sourceLimiter) recordResult(source string, clusterID string, authenticated bool) bool {
+	if l == nil {
+		return false
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := l.clock.Now()
+	state := l.getLockoutState(lockoutKey{source: source, clusterID: clusterID}, now)
+	if authenticated {
+		// the failures of the whole source are not reset, they can be made by other clients behind the same address
+		state.failedAttempts = 0
+		return false
+	}
+	sourceLocked := l.recordSourceFailure(source, now)
+	clusterLocked := l.recordClusterFailure(state, source, clusterID, now)
+	return sourceLocked || clusterLocked
+}
+
+// recordClusterFailure counts a failed authentication of the ClusterID from the source, returning true if it has been
+// locked out
+func (l *sourceLimiter) recordClusterFailure(state *lockoutState, source string, clusterID string, now time.Time) bool {
+	state.failedAttempts++
+	if l.config.MaxFailedAttempts <= 0 || state.failedAttempts < l.config.MaxFailedAttempts {
+		return false
+	}
+	state.failedAttempts = 0
+	state.lockedUntil = now.Add(l.config.LockoutDuration)
+	klog.Warningf("cluster %s locked out from source %s until %s after %d failed attempts",
+		clusterID, source, state.lockedUntil.Format(time.RFC3339), l.config.MaxFailedAttempts)
+	return true
+}
+
+// recordSourceFailure counts a failed authentication from the source, with any ClusterID, returning true if the source
+// has been locked out
+func (l *sourceLimiter) recordSourceFailure(source string, now time.Time) bool {
+	state := l.getLockoutState(lockoutKey{source: source}, now)
+	if now.Sub(state.windowStart) > sourceRetention {
+		state.windowStart = now
+		state.failedAttempts = 0
+	}
+	state.failedAttempts++
+	if l.config.MaxSourceFailedAttempts <= 0 || state.failedAttempts < l.config.MaxSourceFailedAttempts {
+		return false
+	}
+	state.failedAttempts = 0
+	state.lockedUntil = now.Add(l.config.LockoutDuration)
+	klog.Warningf("source %s locked out until %s after %d failed attempts with any cluster ID",
+		source, state.lockedUntil.Format(time.RFC3339), l.config.MaxSourceFailedAttempts)
+	return true
+}
+
+func (l *sourceLimiter) getState(source string, now time.Time) *sourceState {
+	state, ok := l.sources[source]
+	if !ok {
+		state = &sourceState{}
+		if l.config.RequestsPerSecond > 0 {
+			state.limiter = flowcontrol.NewTokenBucketRateLimiterWithClock(l.config.RequestsPerSecond, l.config.Burst, l.clock)
+		}
+		l.sources[source] = state
+	}
+	state.lastSeen = now
+	return state
+}
+
+func (l *sourceLimiter) getLockoutState(key lockoutKey, now time.Time) *lockoutState {
+	state, ok := l.lockouts[key]
+	if !ok {
+		state = &lockoutState{}
+		l.lockouts[key] = state
+	}
+	state.lastSeen = now
+	return state
+}
+
+// sweep forgets the sources which have not been seen for a while, so that their state does not grow unbounded
+func (l *sourceLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+	for source, state := range l.sources {
+		if now.Sub(state.lastSeen) > sourceRetention {
+			delete(l.sources, source)
+		}
+	}
+	for key, state := range l.lockouts {
+		if now.Sub(state.lastSeen) > sourceRetention && now.After(state.lockedUntil) {
+			delete(l.lockouts, key)
+		}
+	}
+}
@@ -20,25 +20,7 @@ func (authService *AuthServiceCtrl) createRole(remoteClusterId string, sa *v1.Se
 				},
 			},
 		},
-		Rules: []rbacv1.PolicyRule{
-			{
-				APIGroups: []string{v1.SchemeGroupVersion.Group},
-				Resources: []string{"secrets"},
-				Verbs:     []string{"create"},
-			},
-			{
-				APIGroups:     []string{v1.SchemeGroupVersion.Group},
-				Resources:     []string{"secrets"},
-				Verbs:         []string{"get", "delete"},
-				ResourceNames: []string{remoteClusterId},
-			},
-			{
-				APIGroups:     []string{v1.SchemeGroupVersion.Group},
-				Resources:     []string{"secrets"},
-				Verbs:         []string{"get"},
-				ResourceNames: []string{"ca-data"},
-			},
-		},
+		Rules: authService.getPeerPermissions(remoteClusterId).NamespacedRules,
 	}
 	return authService.clientset.RbacV1().Roles(authService.namespace).Create(context.TODO(), role, metav1.CreateOptions{})
 }
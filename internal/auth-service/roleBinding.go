@@ -2,6 +2,7 @@ package auth_service
 
 import (
 	"context"
+	"github.com/liqotech/liqo/pkg/auth"
 	v1 "k8s.io/api/core/v1"
 	rbacv1 "k8s.io/api/rbac/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -26,6 +27,11 @@ func (authService *AuthServiceCtrl) createRoleBinding(remoteClusterId string, sa
 				Name:      sa.Name,
 				Namespace: sa.Namespace,
 			},
+			{
+				Kind:     rbacv1.UserKind,
+				APIGroup: rbacv1.GroupName,
+				Name:     auth.PeerSubject(remoteClusterId).CommonName,
+			},
 		},
 		RoleRef: rbacv1.RoleRef{
 			APIGroup: rbacv1.SchemeGroupVersion.Group,
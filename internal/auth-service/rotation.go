@@ -0,0 +1,70 @@
+package auth_service
+
+import (
+	"github.com/liqotech/liqo/internal/discovery/kubeconfig"
+	"github.com/liqotech/liqo/pkg/auth"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog"
+	"time"
+)
+
+// the credentials of the peers are checked with this period, to rotate the expired ones
+const rotationCheckPeriod = 10 * time.Minute
+
+// getRotationPeriod returns the period after which the credentials issued to the peers are rotated
+func (authService *AuthServiceCtrl) getRotationPeriod() time.Duration {
+	authService.configMutex.RLock()
+	defer authService.configMutex.RUnlock()
+	return auth.RotationPeriod(authService.config)
+}
+
+// rotatePeerCredentials mints a new token for the peers whose credentials are older than the rotation period, the
+// discovery delivers it with their PeeringRequest. The previous tokens are revoked once the current one has been
+// delivered.
+func (authService *AuthServiceCtrl) rotatePeerCredentials() {
+	period := authService.getRotationPeriod()
+	for _, obj := range authService.saInformer.GetStore().List() {
+		sa, ok := obj.(*v1.ServiceAccount)
+		if !ok || !isPeerServiceAccount(sa) {
+			continue
+		}
+		token, _, err := kubeconfig.RotateToken(authService.clientset, authService.namespace, sa.Name, sa.Name, true, period)
+		if err != nil {
+			klog.Error(err)
+			continue
+		}
+		if token == nil || sa.Annotations[kubeconfig.SentTokenAnnotation] != token.Name {
+			continue
+		}
+		if err = kubeconfig.RevokePreviousTokens(authService.clientset, authService.namespace, sa.Name, sa.Name, true, token); err != nil {
+			klog.Error(err)
+		}
+	}
+}
+
+// isPeerServiceAccount checks if the ServiceAccount holds the identity of a peer, issued by the auth-service or
+// created by the discovery for a ForeignCluster
+func isPeerServiceAccount(sa *v1.ServiceAccount) bool {
+	if _, ok := sa.Labels[IssuedWithTokenLabel]; ok {
+		return true
+	}
+	for _, ref := range sa.OwnerReferences {
+		if ref.Kind == "ForeignCluster" {
+			return true
+		}
+	}
+	return false
+}
+
+// getPeerToken returns the token the peer has to authenticate with, minting a new one if the current one has to be
+// rotated, and records it as delivered. The returned token is nil if the default one of the ServiceAccount is valid.
+func (authService *AuthServiceCtrl) getPeerToken(sa *v1.ServiceAccount) (*v1.Secret, error) {
+	token, _, err := kubeconfig.RotateToken(authService.clientset, authService.namespace, sa.Name, sa.Name, true, authService.getRotationPeriod())
+	if err != nil || token == nil {
+		return nil, err
+	}
+	if err = kubeconfig.MarkTokenSent(authService.clientset, authService.namespace, sa.Name, token.Name); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
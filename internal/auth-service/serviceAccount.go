@@ -75,10 +75,13 @@ func (authService *AuthServiceCtrl) getServiceAccount(remoteClusterId string) (*
 	return sa, nil
 }
 
-func (authService *AuthServiceCtrl) createServiceAccount(remoteClusterId string) (*v1.ServiceAccount, error) {
+func (authService *AuthServiceCtrl) createServiceAccount(remoteClusterId string, tokenName string) (*v1.ServiceAccount, error) {
 	sa := &v1.ServiceAccount{
 		ObjectMeta: metav1.ObjectMeta{
 			Name: remoteClusterId,
+			Labels: map[string]string{
+				IssuedWithTokenLabel: tokenName,
+			},
 		},
 	}
 	return authService.clientset.CoreV1().ServiceAccounts(authService.namespace).Create(context.TODO(), sa, metav1.CreateOptions{})
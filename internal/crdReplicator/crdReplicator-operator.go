@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"github.com/liqotech/liqo/apis/discovery/v1alpha1"
+	"github.com/liqotech/liqo/pkg/auth"
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -62,6 +63,8 @@ type CRDReplicatorReconciler struct {
 	LocalWatchers map[string]map[string]chan struct{}
 	//for each peering cluster we save all the running watchers monitoring the replicated resources:(clusterID, (registeredResource, chan))
 	RemoteWatchers map[string]map[string]chan struct{}
+	//for each remote cluster we save the token used by its dynamic client, replaced when the credentials are rotated
+	remoteTokens map[string]*auth.RotatingToken
 }
 
 func (d *CRDReplicatorReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
@@ -84,13 +87,17 @@ func (d *CRDReplicatorReconciler) Reconcile(req ctrl.Request) (ctrl.Result, erro
 	_, dynClientOk := d.RemoteDynClients[remoteClusterID]
 	_, dynFacOk := d.RemoteDynSharedInformerFactory[remoteClusterID]
 	if dynClientOk && dynFacOk {
+		//the identity of the peering cluster changes when its credentials are rotated
+		if err = d.reloadToken(&fc, remoteClusterID); err != nil {
+			klog.Errorf("%s -> unable to reload the credentials for remote peering cluster %s: %s", d.ClusterID, remoteClusterID, err)
+		}
 		return result, nil
 	}
 	//check if the config of the peering cluster is ready
 	//first we check the outgoing connection
 	if fc.Status.Outgoing.AvailableIdentity {
 		//retrieve the config
-		config, err := d.getKubeConfig(d.ClientSet, fc.Status.Outgoing.IdentityRef, remoteClusterID)
+		config, err := d.getKubeConfig(d.ClientSet, fc.Status.Outgoing.IdentityRef, &fc)
 		if err != nil {
 			klog.Errorf("%s -> unable to retrieve config from resource %s for remote peering cluster %s: %s", d.ClusterID, req.NamespacedName, remoteClusterID, err)
 			return result, nil
@@ -99,7 +106,7 @@ func (d *CRDReplicatorReconciler) Reconcile(req ctrl.Request) (ctrl.Result, erro
 
 	} else if fc.Status.Incoming.AvailableIdentity {
 		//retrieve the config
-		config, err := d.getKubeConfig(d.ClientSet, fc.Status.Incoming.IdentityRef, remoteClusterID)
+		config, err := d.getKubeConfig(d.ClientSet, fc.Status.Incoming.IdentityRef, &fc)
 		if err != nil {
 			klog.Errorf("%s -> unable to retrieve config from resource %s for remote peering cluster %s: %s", d.ClusterID, req.NamespacedName, remoteClusterID, err)
 			return result, err
@@ -115,7 +122,8 @@ func (d *CRDReplicatorReconciler) SetupWithManager(mgr ctrl.Manager) error {
 		Complete(d)
 }
 
-func (d *CRDReplicatorReconciler) getKubeConfig(clientset kubernetes.Interface, reference *corev1.ObjectReference, remoteClusterID string) (*rest.Config, error) {
+func (d *CRDReplicatorReconciler) getKubeConfig(clientset kubernetes.Interface, reference *corev1.ObjectReference, fc *v1alpha1.ForeignCluster) (*rest.Config, error) {
+	remoteClusterID := fc.Spec.ClusterIdentity.ClusterID
 	if reference == nil {
 		return nil, fmt.Errorf("%s -> object reference for the secret containing kubeconfig of foreign cluster %s not set yet", d.ClusterID, remoteClusterID)
 	}
@@ -130,9 +138,48 @@ func (d *CRDReplicatorReconciler) getKubeConfig(clientset kubernetes.Interface,
 	if err != nil {
 		return nil, err
 	}
+	if err = fc.ApplyConnectionConfig(cnf); err != nil {
+		return nil, err
+	}
+	//the clients authenticate with a token which can be replaced without recreating them
+	if d.remoteTokens == nil {
+		d.remoteTokens = map[string]*auth.RotatingToken{}
+	}
+	token := auth.NewRotatingToken(cnf.BearerToken)
+	token.WrapConfig(cnf)
+	d.remoteTokens[remoteClusterID] = token
 	return cnf, nil
 }
 
+//reloadToken replaces the token used by the clients of the remote cluster with the one of its current identity
+func (d *CRDReplicatorReconciler) reloadToken(fc *v1alpha1.ForeignCluster, remoteClusterID string) error {
+	token, ok := d.remoteTokens[remoteClusterID]
+	if !ok {
+		return nil
+	}
+	var reference *corev1.ObjectReference
+	if fc.Status.Outgoing.AvailableIdentity {
+		reference = fc.Status.Outgoing.IdentityRef
+	} else if fc.Status.Incoming.AvailableIdentity {
+		reference = fc.Status.Incoming.IdentityRef
+	}
+	if reference == nil {
+		return nil
+	}
+	secret, err := d.ClientSet.CoreV1().Secrets(reference.Namespace).Get(context.TODO(), reference.Name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	changed, err := token.SetFromKubeconfig(secret.Data["kubeconfig"])
+	if err != nil {
+		return err
+	}
+	if changed {
+		klog.Infof("%s -> credentials for remote peering cluster %s reloaded", d.ClusterID, remoteClusterID)
+	}
+	return nil
+}
+
 func (d *CRDReplicatorReconciler) setUpConnectionToPeeringCluster(config *rest.Config, remoteClusterID string) error {
 	//check if the dynamic dynamic client exists
 	if _, ok := d.RemoteDynClients[remoteClusterID]; !ok {
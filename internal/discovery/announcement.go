@@ -0,0 +1,203 @@
+package discovery
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/liqotech/liqo/apis/discovery/v1alpha1"
+	"github.com/liqotech/liqo/pkg/auth"
+	"k8s.io/klog"
+	"strconv"
+	"strings"
+)
+
+const (
+	// prefixes of the payloads covered by the signatures, they keep a signature from being valid for another record
+	txtSignaturePrefix      = "liqo-announcement"
+	authSignaturePrefix     = "liqo-auth-announcement"
+	registrySignaturePrefix = "liqo-registry-announcement"
+)
+
+// announcementSignature is the signature of an announcement, carried by its TXT record
+type announcementSignature struct {
+	// PKIX DER encoding of the public key
+	key       []byte
+	signature []byte
+}
+
+// loadAnnouncementKey loads the key of the cluster identity, which signs the announcements of the cluster
+func (discovery *DiscoveryCtrl) loadAnnouncementKey() error {
+	if err := discovery.ClusterId.LoadKey(discovery.Namespace); err != nil {
+		return err
+	}
+	key := discovery.ClusterId.GetKey()
+	discovery.announcementKey = key
+	if fingerprint, err := auth.PublicKeyFingerprint(key.Public()); err == nil {
+		klog.Infof("the announcements are signed with the key %s", fingerprint)
+	}
+	return nil
+}
+
+// signAnnouncement signs the payload with the key, returning the signature to be added to the TXT record
+func signAnnouncement(key crypto.Signer, payload func(key []byte) []byte) (*announcementSignature, error) {
+	der, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		return nil, err
+	}
+	signature, err := auth.Sign(payload(der), key)
+	if err != nil {
+		return nil, err
+	}
+	return &announcementSignature{key: der, signature: signature}, nil
+}
+
+// verify checks the signature of the payload and returns the fingerprint of the key
+func (s *announcementSignature) verify(payload func(key []byte) []byte) (string, error) {
+	key, err := x509.ParsePKIXPublicKey(s.key)
+	if err != nil {
+		return "", err
+	}
+	if err = auth.Verify(payload(s.key), s.signature, key); err != nil {
+		return "", fmt.Errorf("invalid signature of the announcement: %w", err)
+	}
+	return auth.PublicKeyFingerprint(key)
+}
+
+// encode returns the TXT fields carrying the signature
+func (s *announcementSignature) encode() []string {
+	return []string{
+		"key=" + base64.StdEncoding.EncodeToString(s.key),
+		"sig=" + base64.StdEncoding.EncodeToString(s.signature),
+	}
+}
+
+// decodeSignature returns the signature carried by the TXT fields, nil if the announcement is not signed
+func decodeSignature(data []string) (*announcementSignature, error) {
+	var key, signature string
+	for _, d := range data {
+		if strings.HasPrefix(d, "key=") {
+			key = d[len("key="):]
+		} else if strings.HasPrefix(d, "sig=") {
+			signature = d[len("sig="):]
+		}
+	}
+	if signature == "" {
+		return nil, nil
+	}
+	s := &announcementSignature{}
+	var err error
+	if s.key, err = base64.StdEncoding.DecodeString(key); err != nil || len(s.key) == 0 {
+		return nil, errors.New("the announcement is signed without a valid key")
+	}
+	if s.signature, err = base64.StdEncoding.DecodeString(signature); err != nil {
+		return nil, fmt.Errorf("invalid signature of the announcement: %v", err)
+	}
+	return s, nil
+}
+
+// payload returns the data covered by the signature of the cluster announcement
+func (txtData *TxtData) payload(key []byte) []byte {
+	return []byte(strings.Join([]string{
+		txtSignaturePrefix,
+		txtData.ID,
+		txtData.Name,
+		txtData.Namespace,
+		txtData.ApiUrl,
+		txtData.CAFingerprint,
+		base64.StdEncoding.EncodeToString(key),
+	}, "\n"))
+}
+
+// Sign signs the announcement with the key of the cluster, binding its fields to the fingerprint of the CA of the
+// cluster, in the format of auth.CAFingerprint
+func (txtData *TxtData) Sign(key crypto.Signer, caFingerprint string) error {
+	txtData.CAFingerprint = caFingerprint
+	s, err := signAnnouncement(key, txtData.payload)
+	if err != nil {
+		return err
+	}
+	txtData.signature = s
+	txtData.KeyFingerprint, err = auth.PublicKeyFingerprint(key.Public())
+	return err
+}
+
+// authPayload returns the data covered by the signature of the auth-service announcement
+func authPayload(clusterID string, port int) func(key []byte) []byte {
+	return func(key []byte) []byte {
+		return []byte(strings.Join([]string{
+			authSignaturePrefix,
+			clusterID,
+			strconv.Itoa(port),
+			base64.StdEncoding.EncodeToString(key),
+		}, "\n"))
+	}
+}
+
+// registryPayload returns the data covered by the signature of the ClusterAnnouncement
+func registryPayload(spec *v1alpha1.ClusterAnnouncementSpec) func(key []byte) []byte {
+	return func(key []byte) []byte {
+		return []byte(strings.Join([]string{
+			registrySignaturePrefix,
+			spec.ClusterIdentity.ClusterID,
+			spec.ClusterIdentity.ClusterName,
+			spec.Namespace,
+			spec.ApiUrl,
+			strconv.FormatUint(uint64(spec.Ttl), 10),
+			spec.CAFingerprint,
+			base64.StdEncoding.EncodeToString(key),
+		}, "\n"))
+	}
+}
+
+// signRegistryAnnouncement signs the ClusterAnnouncement with the key of the cluster, binding its fields to the
+// fingerprint of the CA of the cluster
+func signRegistryAnnouncement(spec *v1alpha1.ClusterAnnouncementSpec, key crypto.Signer, caFingerprint string) error {
+	spec.CAFingerprint = caFingerprint
+	s, err := signAnnouncement(key, registryPayload(spec))
+	if err != nil {
+		return err
+	}
+	spec.PublicKey = s.key
+	spec.Signature = s.signature
+	return nil
+}
+
+// verifyRegistryAnnouncement checks the signature of the ClusterAnnouncement and returns the fingerprint of the key,
+// the empty string if the announcement is not signed
+func verifyRegistryAnnouncement(spec *v1alpha1.ClusterAnnouncementSpec) (string, error) {
+	if len(spec.Signature) == 0 {
+		return "", nil
+	}
+	if len(spec.PublicKey) == 0 {
+		return "", errors.New("the announcement is signed without a valid key")
+	}
+	s := &announcementSignature{key: spec.PublicKey, signature: spec.Signature}
+	return s.verify(registryPayload(spec))
+}
+
+// getAuthTxt returns the TXT record of the auth-service announcement, signed if the announcement key is loaded
+func (discovery *DiscoveryCtrl) getAuthTxt(port int) ([]string, error) {
+	clusterID := discovery.ClusterId.GetClusterID()
+	if discovery.announcementKey == nil {
+		return nil, nil
+	}
+	s, err := signAnnouncement(discovery.announcementKey, authPayload(clusterID, port))
+	if err != nil {
+		return nil, err
+	}
+	return append([]string{"id=" + clusterID}, s.encode()...), nil
+}
+
+// checkSignatures refuses a signed cluster announcement matched with an auth-service announcement which has not been
+// signed with the same key, since it could point to another auth-service
+func (data *discoveryData) checkSignatures() error {
+	if data.TxtData == nil || data.AuthData == nil || data.TxtData.KeyFingerprint == "" {
+		return nil
+	}
+	if data.AuthData.keyFingerprint != data.TxtData.KeyFingerprint || data.AuthData.clusterID != data.TxtData.ID {
+		return fmt.Errorf("the auth-service of the cluster %s is not announced with its key", data.TxtData.ID)
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+package discovery
+
+import (
+	"context"
+	"crypto"
+	"encoding/base64"
+	"github.com/grandcat/zeroconf"
+	configv1alpha1 "github.com/liqotech/liqo/apis/config/v1alpha1"
+	"github.com/liqotech/liqo/apis/discovery/v1alpha1"
+	"github.com/liqotech/liqo/pkg/clusterID"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"strings"
+	"testing"
+	"time"
+)
+
+func getAnnouncementKey(t *testing.T) crypto.Signer {
+	keyPEM, err := clusterID.GenerateKey()
+	assert.Nil(t, err)
+	key, err := clusterID.ParseKey(keyPEM)
+	assert.Nil(t, err)
+	return key
+}
+
+func replaceField(txt []string, prefix string, value string) []string {
+	res := make([]string, 0, len(txt))
+	for _, d := range txt {
+		if strings.HasPrefix(d, prefix) {
+			d = prefix + value
+		}
+		res = append(res, d)
+	}
+	return res
+}
+
+func TestSignedTxtData(t *testing.T) {
+	key := getAnnouncementKey(t)
+	txtData := &TxtData{
+		ID:        "cluster1",
+		Name:      "cluster-1",
+		Namespace: "liqo",
+		ApiUrl:    "https://10.0.0.1:6443",
+	}
+	assert.Nil(t, txtData.Sign(key, "ca-fingerprint"))
+	txt, err := txtData.Encode()
+	assert.Nil(t, err)
+
+	decoded := &TxtData{}
+	assert.Nil(t, decoded.Decode("", "", txt))
+	assert.Equal(t, txtData.KeyFingerprint, decoded.KeyFingerprint)
+	assert.NotEmpty(t, decoded.KeyFingerprint)
+	assert.Equal(t, "ca-fingerprint", decoded.CAFingerprint)
+	assert.Equal(t, "https://10.0.0.1:6443", decoded.ApiUrl)
+
+	// in WAN discovery the signed URL is kept
+	decoded = &TxtData{}
+	assert.Nil(t, decoded.Decode("10.0.0.2", "8443", txt))
+	assert.Equal(t, "https://10.0.0.1:6443", decoded.ApiUrl)
+
+	// the modified announcements are refused
+	tests := []struct {
+		name string
+		txt  []string
+	}{
+		{"url", replaceField(txt, "url=", "https://10.0.0.3:6443")},
+		{"ca", replaceField(txt, "ca=", "other-fingerprint")},
+		{"key", replaceField(txt, "key=", base64.StdEncoding.EncodeToString([]byte("key")))},
+		{"no key", replaceField(txt, "key=", "")},
+	}
+	for _, test := range tests {
+		assert.NotNil(t, (&TxtData{}).Decode("", "", test.txt), test.name)
+	}
+
+	// the unsigned announcements are accepted, without a CA
+	decoded = &TxtData{}
+	assert.Nil(t, decoded.Decode("", "", []string{"id=cluster1", "namespace=liqo", "url=https://10.0.0.1:6443", "ca=ca-fingerprint"}))
+	assert.Empty(t, decoded.KeyFingerprint)
+	assert.Empty(t, decoded.CAFingerprint)
+}
+
+func TestSignedAuthData(t *testing.T) {
+	client := getFakeClient(t)
+	discovery := GetDiscoveryCtrl("liqo", client, nil, clusterID.GetNewClusterID("cluster1", client.Client()), 10, time.Second)
+	txt, err := discovery.getAuthTxt(30000)
+	assert.Nil(t, err)
+	assert.Nil(t, txt)
+
+	assert.Nil(t, discovery.loadAnnouncementKey())
+	txt, err = discovery.getAuthTxt(30000)
+	assert.Nil(t, err)
+	txtData := &TxtData{ID: "cluster1", Namespace: "liqo", ApiUrl: "https://10.0.0.1:6443"}
+	assert.Nil(t, txtData.Sign(discovery.announcementKey, ""))
+
+	// the signature is checked before the reachability
+	entry := &zeroconf.ServiceEntry{Port: 30001, Text: txt}
+	err = (&AuthData{}).Decode(entry, time.Millisecond)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "signature")
+
+	s, err := decodeSignature(txt)
+	assert.Nil(t, err)
+	fingerprint, err := s.verify(authPayload("cluster1", 30000))
+	assert.Nil(t, err)
+	assert.Equal(t, txtData.KeyFingerprint, fingerprint)
+
+	data := &discoveryData{
+		TxtData:  txtData,
+		AuthData: &AuthData{clusterID: "cluster1", keyFingerprint: fingerprint},
+	}
+	assert.Nil(t, data.checkSignatures())
+	data.AuthData = &AuthData{}
+	assert.NotNil(t, data.checkSignatures())
+	data.AuthData = &AuthData{clusterID: "cluster2", keyFingerprint: fingerprint}
+	assert.NotNil(t, data.checkSignatures())
+
+	// the key is kept across the restarts
+	discovery2 := GetDiscoveryCtrl("liqo", client, nil, discovery.ClusterId, 10, time.Second)
+	assert.Nil(t, discovery2.loadAnnouncementKey())
+	assert.Equal(t, discovery.announcementKey, discovery2.announcementKey)
+	_, err = client.Client().CoreV1().Secrets("liqo").Get(context.TODO(), clusterID.KeySecretName, metav1.GetOptions{})
+	assert.Nil(t, err)
+}
+
+func TestSetAnnouncement(t *testing.T) {
+	fc := &v1alpha1.ForeignCluster{}
+	updated, err := fc.SetAnnouncement("", "")
+	assert.Nil(t, err)
+	assert.True(t, updated)
+	assert.False(t, fc.IsAnnouncementSigned())
+
+	// the first key is pinned
+	updated, err = fc.SetAnnouncement("key1", "ca1")
+	assert.Nil(t, err)
+	assert.True(t, updated)
+	assert.True(t, fc.IsAnnouncementSigned())
+	updated, err = fc.SetAnnouncement("key1", "ca1")
+	assert.Nil(t, err)
+	assert.False(t, updated)
+
+	// the CA can change, not the key
+	updated, err = fc.SetAnnouncement("key1", "ca2")
+	assert.Nil(t, err)
+	assert.True(t, updated)
+	_, err = fc.SetAnnouncement("key2", "ca2")
+	assert.NotNil(t, err)
+	_, err = fc.SetAnnouncement("", "")
+	assert.NotNil(t, err)
+	assert.Equal(t, "key1", fc.Status.Announcement.KeyFingerprint)
+	assert.Equal(t, "ca2", fc.Status.Announcement.CAFingerprint)
+}
+
+func TestCheckPinnedKey(t *testing.T) {
+	discovery := &DiscoveryCtrl{Config: &configv1alpha1.DiscoveryConfig{
+		PinnedKeys: map[string]string{"pinned-id": "key"},
+	}}
+	getData := func(id string, key string) *discoveryData {
+		return &discoveryData{TxtData: &TxtData{ID: id, KeyFingerprint: key}}
+	}
+
+	assert.Nil(t, discovery.checkPinnedKey(getData("pinned-id", "key")))
+	assert.NotNil(t, discovery.checkPinnedKey(getData("pinned-id", "other")))
+	assert.NotNil(t, discovery.checkPinnedKey(getData("pinned-id", "")))
+	// the clusters without a pinned key are pinned at their first announcement
+	assert.Nil(t, discovery.checkPinnedKey(getData("other-id", "other")))
+	assert.Nil(t, discovery.checkPinnedKey(getData("other-id", "")))
+}
@@ -6,6 +6,8 @@ import (
 	"github.com/grandcat/zeroconf"
 	"k8s.io/klog"
 	"net"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
@@ -13,6 +15,10 @@ import (
 type AuthData struct {
 	address string
 	port    int
+
+	// set if the announcement is signed, Decode verifies the signature
+	clusterID      string
+	keyFingerprint string
 }
 
 func (authData *AuthData) Get(discovery *DiscoveryCtrl, entry *zeroconf.ServiceEntry) error {
@@ -27,11 +33,31 @@ func (authData *AuthData) IsComplete() bool {
 	return authData.address != "" && authData.port > 0
 }
 
+// get the URL of the auth-service
+func (authData *AuthData) GetURL() string {
+	return "https://" + net.JoinHostPort(authData.address, strconv.Itoa(authData.port))
+}
+
 // populate the AuthData struct from a DNS entry
 // takes as argument the DNS entry and a timeout used to find reachable remote services
 func (authData *AuthData) Decode(entry *zeroconf.ServiceEntry, timeout time.Duration) error {
 	authData.port = entry.Port
 
+	signature, err := decodeSignature(entry.Text)
+	if err != nil {
+		return err
+	}
+	if signature != nil {
+		for _, d := range entry.Text {
+			if strings.HasPrefix(d, "id=") {
+				authData.clusterID = d[len("id="):]
+			}
+		}
+		if authData.keyFingerprint, err = signature.verify(authPayload(authData.clusterID, authData.port)); err != nil {
+			return err
+		}
+	}
+
 	// checks if there is an IPv4 reachable
 	ip, err := getReachable(entry.AddrIPv4, entry.Port, timeout)
 	if err != nil {
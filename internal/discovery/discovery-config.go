@@ -3,20 +3,24 @@ package discovery
 import (
 	"context"
 	configv1alpha1 "github.com/liqotech/liqo/apis/config/v1alpha1"
+	"github.com/liqotech/liqo/pkg/auth"
 	"github.com/liqotech/liqo/pkg/clusterConfig"
 	"github.com/liqotech/liqo/pkg/crdClient"
 	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/klog"
+	"reflect"
 )
 
 func (discovery *DiscoveryCtrl) GetDiscoveryConfig(crdClient *crdClient.CRDClient, kubeconfigPath string) error {
 	waitFirst := make(chan bool)
 	isFirst := true
 	go clusterConfig.WatchConfiguration(func(configuration *configv1alpha1.ClusterConfig) {
+		discovery.ClusterConfig = configuration.Spec.DeepCopy()
 		discovery.handleConfiguration(configuration.Spec.DiscoveryConfig)
-		discovery.handleDispatcherConfig(configuration.Spec.DispatcherConfig)
+		discovery.handlePeerPermissions(discovery.ClusterConfig)
 		if isFirst {
 			waitFirst <- true
 			isFirst = false
@@ -28,16 +32,17 @@ func (discovery *DiscoveryCtrl) GetDiscoveryConfig(crdClient *crdClient.CRDClien
 	return nil
 }
 
-func (discovery *DiscoveryCtrl) handleDispatcherConfig(config configv1alpha1.DispatcherConfig) {
-	role, err := discovery.crdClient.Client().RbacV1().ClusterRoles().Get(context.TODO(), "crdreplicator-role", metav1.GetOptions{})
+// handlePeerPermissions updates the ClusterRole shared by the virtual kubelets of the peering clusters, the ones
+// granted to the other components are set by the ForeignCluster operator for each peer
+func (discovery *DiscoveryCtrl) handlePeerPermissions(config *configv1alpha1.ClusterConfigSpec) {
+	role, err := discovery.crdClient.Client().RbacV1().ClusterRoles().Get(context.TODO(), auth.VirtualKubeletClusterRole, metav1.GetOptions{})
 	create := false
 	if errors.IsNotFound(err) {
 		// create it
 		role = &rbacv1.ClusterRole{
 			ObjectMeta: metav1.ObjectMeta{
-				Name: "crdreplicator-role",
+				Name: auth.VirtualKubeletClusterRole,
 			},
-			Rules: []rbacv1.PolicyRule{},
 		}
 		create = true
 	} else if err != nil {
@@ -46,14 +51,9 @@ func (discovery *DiscoveryCtrl) handleDispatcherConfig(config configv1alpha1.Dis
 		return
 	}
 
-	// create rules array
-	rules := []rbacv1.PolicyRule{}
-	for _, res := range config.ResourcesToReplicate {
-		rules = append(rules, rbacv1.PolicyRule{
-			Verbs:     []string{"*"},
-			APIGroups: []string{res.Group},
-			Resources: []string{res.Resource, res.Resource + "/status"},
-		})
+	rules := auth.VirtualKubeletPermissions(config).ClusterRules
+	if !create && equality.Semantic.DeepEqual(role.Rules, rules) {
+		return
 	}
 	role.Rules = rules
 
@@ -124,6 +124,23 @@ func (discovery *DiscoveryCtrl) handleConfiguration(config configv1alpha1.Discov
 			discovery.Config.EnableDiscovery = config.EnableDiscovery
 			reloadClient = true
 		}
+		if !reflect.DeepEqual(discovery.Config.PinnedKeys, config.PinnedKeys) {
+			// checked on the next announcements and reconciliations of the ForeignClusters
+			discovery.Config.PinnedKeys = config.PinnedKeys
+		}
+		if !reflect.DeepEqual(discovery.Config.Interfaces, config.Interfaces) {
+			discovery.Config.Interfaces = config.Interfaces
+			reloadServer = true
+			reloadClient = true
+		}
+		if !reflect.DeepEqual(discovery.Config.DNSPublishing, config.DNSPublishing) {
+			// applied by the DNS publisher on its next iteration
+			discovery.Config.DNSPublishing = config.DNSPublishing
+		}
+		if !reflect.DeepEqual(discovery.Config.Registry, config.Registry) {
+			// applied by the registry client on its next iteration
+			discovery.Config.Registry = config.Registry
+		}
 		if reloadServer {
 			discovery.reloadServer()
 		}
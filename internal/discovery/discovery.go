@@ -1,12 +1,15 @@
 package discovery
 
 import (
+	"crypto"
 	"github.com/grandcat/zeroconf"
 	configv1alpha1 "github.com/liqotech/liqo/apis/config/v1alpha1"
 	discoveryv1alpha1 "github.com/liqotech/liqo/apis/discovery/v1alpha1"
 	advtypes "github.com/liqotech/liqo/apis/sharing/v1alpha1"
+	"github.com/liqotech/liqo/pkg/auth"
 	"github.com/liqotech/liqo/pkg/clusterID"
 	"github.com/liqotech/liqo/pkg/crdClient"
+	"io/ioutil"
 	"k8s.io/klog"
 	"os"
 	"sync"
@@ -17,6 +20,7 @@ type DiscoveryCtrl struct {
 	Namespace string
 
 	Config         *configv1alpha1.DiscoveryConfig
+	ClusterConfig  *configv1alpha1.ClusterConfigSpec // the whole configuration of the cluster, replaced on each update
 	stopMDNS       chan bool
 	stopMDNSClient chan bool
 	crdClient      *crdClient.CRDClient
@@ -29,6 +33,10 @@ type DiscoveryCtrl struct {
 	resolveContextRefreshTime int
 
 	dialTcpTimeout time.Duration
+
+	// key signing the announcements, and fingerprint of the cluster CA stated in them
+	announcementKey crypto.Signer
+	caFingerprint   string
 }
 
 func NewDiscoveryCtrl(namespace string, clusterId *clusterID.ClusterID, kubeconfigPath string, resolveContextRefreshTime int, dialTcpTimeout time.Duration) (*DiscoveryCtrl, error) {
@@ -58,6 +66,17 @@ func NewDiscoveryCtrl(namespace string, clusterId *clusterID.ClusterID, kubeconf
 	if discoveryCtrl.GetDiscoveryConfig(nil, kubeconfigPath) != nil {
 		os.Exit(1)
 	}
+
+	clusterCA := config.CAData
+	if len(clusterCA) == 0 && config.CAFile != "" {
+		if clusterCA, err = ioutil.ReadFile(config.CAFile); err != nil {
+			return nil, err
+		}
+	}
+	discoveryCtrl.caFingerprint = auth.CAFingerprint(clusterCA)
+	if err = discoveryCtrl.loadAnnouncementKey(); err != nil {
+		return nil, err
+	}
 	return &discoveryCtrl, nil
 }
 
@@ -80,4 +99,6 @@ func (discovery *DiscoveryCtrl) StartDiscovery() {
 	go discovery.StartResolver(discovery.stopMDNSClient)
 	go discovery.StartGratuitousAnswers()
 	go discovery.StartGarbageCollector()
+	go discovery.StartDNSPublisher()
+	go discovery.StartRegistry()
 }
@@ -0,0 +1,240 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	configv1alpha1 "github.com/liqotech/liqo/apis/config/v1alpha1"
+	"github.com/miekg/dns"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog"
+	"net"
+	"net/url"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+const (
+	tsigSecretKey    = "secret"
+	tsigFudge        = 300
+	dnsUpdateTimeout = 10 * time.Second
+	publishPeriod    = 30 * time.Second
+)
+
+// dnsPublication is the record set published for the local cluster, with the configuration used to publish it
+type dnsPublication struct {
+	config  configv1alpha1.DNSPublishingConfig
+	names   DNSNames
+	records []dns.RR
+}
+
+// DNSNames are the names of the records which register a cluster in a domain
+type DNSNames struct {
+	// Domain has the PTR records of the clusters
+	Domain string
+	// Instance has the SRV and TXT records of the cluster
+	Instance string
+	// APIServer has the address record of the API server, used when its URL contains an IP address
+	APIServer string
+}
+
+// StartDNSPublisher periodically publishes the DNS-SD records of the local cluster, they are updated only when
+// the API URL, the cluster name or the configuration change. The records are removed when the publishing is disabled.
+func (discovery *DiscoveryCtrl) StartDNSPublisher() {
+	var published *dnsPublication
+	for {
+		published = discovery.publishDNS(published)
+		time.Sleep(publishPeriod)
+	}
+}
+
+func (discovery *DiscoveryCtrl) publishDNS(published *dnsPublication) *dnsPublication {
+	config := discovery.Config.DNSPublishing
+	if config == nil || !discovery.Config.EnableAdvertisement {
+		if published != nil {
+			if err := discovery.unpublishDNS(published); err != nil {
+				klog.Error(err)
+				return published
+			}
+			klog.Infof("DNS-SD records removed from zone %s", published.config.Zone)
+		}
+		return nil
+	}
+
+	if !dns.IsSubDomain(dns.Fqdn(config.Zone), dns.Fqdn(getPublishingDomain(config))) {
+		klog.Errorf("the DNS-SD domain %s is not in zone %s", config.Domain, config.Zone)
+		return published
+	}
+	txtData, err := discovery.GetTxtData()
+	if err != nil {
+		klog.Error(err)
+		return published
+	}
+	names := GetDNSNames(config, discovery.Config.Service, txtData.ID)
+	records, err := GetDNSRecords(names, discovery.Config.Ttl, txtData)
+	if err != nil {
+		klog.Error(err)
+		return published
+	}
+	if published != nil && reflect.DeepEqual(published.config, *config) && published.names == names && sameRecords(published.records, records) {
+		return published
+	}
+
+	if published != nil && (!reflect.DeepEqual(published.config, *config) || published.names != names) {
+		// the records have to be removed from the previous zone or domain
+		if err = discovery.unpublishDNS(published); err != nil {
+			klog.Error(err)
+			return published
+		}
+	}
+	secret, err := discovery.getTsigSecret(config)
+	if err != nil {
+		klog.Error(err)
+		return published
+	}
+	if err = UpdateDNSRecords(config, secret, GetDNSUpdate(config, names, records)); err != nil {
+		klog.Error(err)
+		return nil
+	}
+	klog.Infof("DNS-SD records published in zone %s for %s", config.Zone, txtData.ApiUrl)
+	return &dnsPublication{
+		config:  *config.DeepCopy(),
+		names:   names,
+		records: records,
+	}
+}
+
+func (discovery *DiscoveryCtrl) unpublishDNS(published *dnsPublication) error {
+	secret, err := discovery.getTsigSecret(&published.config)
+	if err != nil {
+		return err
+	}
+	return UpdateDNSRecords(&published.config, secret, GetDNSRemoval(&published.config, published.names))
+}
+
+func (discovery *DiscoveryCtrl) getTsigSecret(config *configv1alpha1.DNSPublishingConfig) (string, error) {
+	secret, err := discovery.crdClient.Client().CoreV1().Secrets(discovery.Namespace).Get(context.TODO(), config.TsigSecretName, metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+	value, ok := secret.Data[tsigSecretKey]
+	if !ok || len(value) == 0 {
+		return "", fmt.Errorf("secret %s has no %s key", config.TsigSecretName, tsigSecretKey)
+	}
+	return string(value), nil
+}
+
+// GetDNSNames returns the names of the records of the cluster, the instance is named after the cluster ID
+func GetDNSNames(config *configv1alpha1.DNSPublishingConfig, service string, clusterID string) DNSNames {
+	domain := dns.Fqdn(getPublishingDomain(config))
+	return DNSNames{
+		Domain:    domain,
+		Instance:  clusterID + "." + dns.Fqdn(service) + domain,
+		APIServer: clusterID + "-api-server." + domain,
+	}
+}
+
+// GetDNSRecords returns the PTR, SRV and TXT records which register the cluster, with the A or AAAA record of the API
+// server if its URL contains an IP address
+func GetDNSRecords(names DNSNames, ttl uint32, txtData *TxtData) ([]dns.RR, error) {
+	u, err := url.Parse(txtData.ApiUrl)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.ParseUint(u.Port(), 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port in API URL %s: %v", txtData.ApiUrl, err)
+	}
+	txt, err := txtData.Encode()
+	if err != nil {
+		return nil, err
+	}
+
+	records := []dns.RR{
+		&dns.PTR{Hdr: header(names.Domain, dns.TypePTR, ttl), Ptr: names.Instance},
+		&dns.TXT{Hdr: header(names.Instance, dns.TypeTXT, ttl), Txt: txt},
+	}
+	target := dns.Fqdn(u.Hostname())
+	if ip := net.ParseIP(u.Hostname()); ip != nil {
+		target = names.APIServer
+		if ip.To4() != nil {
+			records = append(records, &dns.A{Hdr: header(target, dns.TypeA, ttl), A: ip.To4()})
+		} else {
+			records = append(records, &dns.AAAA{Hdr: header(target, dns.TypeAAAA, ttl), AAAA: ip})
+		}
+	}
+	records = append(records, &dns.SRV{Hdr: header(names.Instance, dns.TypeSRV, ttl), Port: uint16(port), Target: target})
+	return records, nil
+}
+
+// GetDNSUpdate returns the update replacing the records of the cluster
+func GetDNSUpdate(config *configv1alpha1.DNSPublishingConfig, names DNSNames, records []dns.RR) *dns.Msg {
+	msg := GetDNSRemoval(config, names)
+	inserted := make([]dns.RR, len(records))
+	for i, rr := range records {
+		inserted[i] = dns.Copy(rr)
+	}
+	msg.Insert(inserted)
+	return msg
+}
+
+// GetDNSRemoval returns the update removing the records of the cluster, including the ones published with a previous
+// API URL. The PTR records of the other clusters registered in the domain are kept.
+func GetDNSRemoval(config *configv1alpha1.DNSPublishingConfig, names DNSNames) *dns.Msg {
+	msg := &dns.Msg{}
+	msg.SetUpdate(dns.Fqdn(config.Zone))
+	msg.Remove([]dns.RR{&dns.PTR{Hdr: header(names.Domain, dns.TypePTR, 0), Ptr: names.Instance}})
+	msg.RemoveName([]dns.RR{
+		&dns.ANY{Hdr: header(names.Instance, dns.TypeANY, 0)},
+		&dns.ANY{Hdr: header(names.APIServer, dns.TypeANY, 0)},
+	})
+	return msg
+}
+
+// UpdateDNSRecords sends the update to the server, signed with the TSIG key
+func UpdateDNSRecords(config *configv1alpha1.DNSPublishingConfig, secret string, msg *dns.Msg) error {
+	keyName := dns.Fqdn(config.TsigKeyName)
+	algorithm := config.TsigAlgorithm
+	if algorithm == "" {
+		algorithm = "hmac-sha256"
+	}
+	msg.SetTsig(keyName, dns.Fqdn(algorithm), tsigFudge, time.Now().Unix())
+
+	c := &dns.Client{
+		Net:         "tcp",
+		Timeout:     dnsUpdateTimeout,
+		TsigSecret:  map[string]string{keyName: secret},
+		DialTimeout: dnsUpdateTimeout,
+	}
+	in, _, err := c.Exchange(msg, config.Server)
+	if err != nil {
+		return fmt.Errorf("DNS update of zone %s failed: %v", config.Zone, err)
+	}
+	if in.Rcode != dns.RcodeSuccess {
+		return fmt.Errorf("DNS update of zone %s refused by %s: %s", config.Zone, config.Server, dns.RcodeToString[in.Rcode])
+	}
+	return nil
+}
+
+func getPublishingDomain(config *configv1alpha1.DNSPublishingConfig) string {
+	if config.Domain != "" {
+		return config.Domain
+	}
+	return config.Zone
+}
+
+func header(name string, rrType uint16, ttl uint32) dns.RR_Header {
+	return dns.RR_Header{Name: name, Rrtype: rrType, Class: dns.ClassINET, Ttl: ttl}
+}
+
+func sameRecords(a []dns.RR, b []dns.RR) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !dns.IsDuplicate(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,194 @@
+package discovery_test
+
+import (
+	configv1alpha1 "github.com/liqotech/liqo/apis/config/v1alpha1"
+	"github.com/liqotech/liqo/internal/discovery"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testZone   = "example.com."
+	testKey    = "liqo-key."
+	testSecret = "bGlxby10c2lnLXNlY3JldC1mb3ItdGVzdHM="
+)
+
+// zone is a minimal authoritative server accepting the RFC 2136 updates signed with the test key
+type zone struct {
+	mtx     sync.Mutex
+	records []dns.RR
+}
+
+func (z *zone) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+	z.mtx.Lock()
+	defer z.mtx.Unlock()
+
+	msg := &dns.Msg{}
+	msg.SetReply(r)
+	if r.Opcode == dns.OpcodeUpdate {
+		if r.IsTsig() == nil || w.TsigStatus() != nil {
+			msg.Rcode = dns.RcodeNotAuth
+		} else {
+			z.update(r.Ns)
+			msg.SetTsig(testKey, dns.HmacSHA256, 300, time.Now().Unix())
+		}
+		_ = w.WriteMsg(msg)
+		return
+	}
+
+	for _, rr := range z.records {
+		if rr.Header().Name == r.Question[0].Name && rr.Header().Rrtype == r.Question[0].Qtype {
+			msg.Answer = append(msg.Answer, rr)
+		}
+	}
+	_ = w.WriteMsg(msg)
+}
+
+func (z *zone) update(updates []dns.RR) {
+	for _, u := range updates {
+		h := u.Header()
+		switch {
+		case h.Class == dns.ClassANY:
+			// the whole name or RRset
+			z.remove(func(rr dns.RR) bool {
+				return rr.Header().Name == h.Name && (h.Rrtype == dns.TypeANY || rr.Header().Rrtype == h.Rrtype)
+			})
+		case h.Class == dns.ClassNONE:
+			removed := dns.Copy(u)
+			removed.Header().Class = dns.ClassINET
+			z.remove(func(rr dns.RR) bool {
+				return dns.IsDuplicate(rr, removed)
+			})
+		default:
+			z.remove(func(rr dns.RR) bool {
+				return dns.IsDuplicate(rr, u)
+			})
+			z.records = append(z.records, u)
+		}
+	}
+}
+
+func (z *zone) remove(match func(dns.RR) bool) {
+	records := z.records[:0]
+	for _, rr := range z.records {
+		if !match(rr) {
+			records = append(records, rr)
+		}
+	}
+	z.records = records
+}
+
+func (z *zone) get(name string, rrType uint16) []dns.RR {
+	z.mtx.Lock()
+	defer z.mtx.Unlock()
+	var res []dns.RR
+	for _, rr := range z.records {
+		if rr.Header().Name == name && rr.Header().Rrtype == rrType {
+			res = append(res, rr)
+		}
+	}
+	return res
+}
+
+func startZone(t *testing.T, z *zone) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	server := &dns.Server{
+		Listener:   listener,
+		Handler:    z,
+		TsigSecret: map[string]string{testKey: testSecret},
+		// the default one refuses the updates
+		MsgAcceptFunc: func(dh dns.Header) dns.MsgAcceptAction {
+			return dns.MsgAccept
+		},
+	}
+	started := make(chan struct{})
+	server.NotifyStartedFunc = func() { close(started) }
+	go func() {
+		_ = server.ActivateAndServe()
+	}()
+	<-started
+	t.Cleanup(func() { _ = server.Shutdown() })
+	return listener.Addr().String()
+}
+
+func publish(t *testing.T, config *configv1alpha1.DNSPublishingConfig, secret string, txtData *discovery.TxtData) error {
+	names := discovery.GetDNSNames(config, "_liqo._tcp", txtData.ID)
+	records, err := discovery.GetDNSRecords(names, 90, txtData)
+	assert.Nil(t, err)
+	return discovery.UpdateDNSRecords(config, secret, discovery.GetDNSUpdate(config, names, records))
+}
+
+func TestDNSPublishing(t *testing.T) {
+	z := &zone{}
+	address := startZone(t, z)
+	config := &configv1alpha1.DNSPublishingConfig{
+		Server:         address,
+		Zone:           testZone,
+		Domain:         "clusters." + testZone,
+		TsigKeyName:    testKey,
+		TsigAlgorithm:  "hmac-sha256",
+		TsigSecretName: "tsig",
+	}
+	domain := "clusters." + testZone
+	other := &dns.PTR{
+		Hdr: dns.RR_Header{Name: domain, Rrtype: dns.TypePTR, Class: dns.ClassINET, Ttl: 90},
+		Ptr: "other._liqo._tcp." + domain,
+	}
+	z.records = append(z.records, other)
+
+	txtData := &discovery.TxtData{
+		ID:        "cluster1",
+		Name:      "cluster-1",
+		Namespace: "liqo",
+		ApiUrl:    "https://10.0.0.1:6443",
+	}
+	assert.Nil(t, publish(t, config, testSecret, txtData))
+
+	assert.Len(t, z.get(domain, dns.TypePTR), 2)
+	a := z.get("cluster1-api-server."+domain, dns.TypeA)
+	assert.Len(t, a, 1)
+	assert.Equal(t, "10.0.0.1", a[0].(*dns.A).A.String())
+	srv := z.get("cluster1._liqo._tcp."+domain, dns.TypeSRV)
+	assert.Len(t, srv, 1)
+	assert.Equal(t, uint16(6443), srv[0].(*dns.SRV).Port)
+	assert.Equal(t, "cluster1-api-server."+domain, srv[0].(*dns.SRV).Target)
+
+	// the records can be decoded in WAN discovery
+	txt := z.get("cluster1._liqo._tcp."+domain, dns.TypeTXT)
+	assert.Len(t, txt, 1)
+	decoded := &discovery.TxtData{}
+	assert.Nil(t, decoded.Decode(srv[0].(*dns.SRV).Target, "6443", txt[0].(*dns.TXT).Txt))
+	assert.Equal(t, "cluster1", decoded.ID)
+	assert.Equal(t, "cluster-1", decoded.Name)
+	assert.Equal(t, "liqo", decoded.Namespace)
+
+	// the API URL changes
+	txtData.ApiUrl = "https://api.cluster1.example.com:8443"
+	assert.Nil(t, publish(t, config, testSecret, txtData))
+	assert.Len(t, z.get(domain, dns.TypePTR), 2)
+	assert.Empty(t, z.get("cluster1-api-server."+domain, dns.TypeA))
+	srv = z.get("cluster1._liqo._tcp."+domain, dns.TypeSRV)
+	assert.Len(t, srv, 1)
+	assert.Equal(t, uint16(8443), srv[0].(*dns.SRV).Port)
+	assert.Equal(t, "api.cluster1.example.com.", srv[0].(*dns.SRV).Target)
+	assert.Len(t, z.get("cluster1._liqo._tcp."+domain, dns.TypeTXT), 1)
+
+	// the update is refused without the right key
+	txtData.ApiUrl = "https://api.cluster1.example.com:9443"
+	assert.NotNil(t, publish(t, config, "d3Jvbmctc2VjcmV0", txtData))
+	assert.Equal(t, uint16(8443), z.get("cluster1._liqo._tcp."+domain, dns.TypeSRV)[0].(*dns.SRV).Port)
+
+	// the removal keeps the other clusters
+	names := discovery.GetDNSNames(config, "_liqo._tcp", txtData.ID)
+	assert.Nil(t, discovery.UpdateDNSRecords(config, testSecret, discovery.GetDNSRemoval(config, names)))
+	ptrs := z.get(domain, dns.TypePTR)
+	assert.Len(t, ptrs, 1)
+	assert.Equal(t, other.Ptr, ptrs[0].(*dns.PTR).Ptr)
+	assert.Empty(t, z.get("cluster1._liqo._tcp."+domain, dns.TypeSRV))
+	assert.Empty(t, z.get("cluster1._liqo._tcp."+domain, dns.TypeTXT))
+}
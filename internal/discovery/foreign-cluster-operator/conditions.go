@@ -0,0 +1,90 @@
+package foreign_cluster_operator
+
+import (
+	goerrors "errors"
+	"fmt"
+	discoveryv1alpha1 "github.com/liqotech/liqo/apis/discovery/v1alpha1"
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"strings"
+)
+
+// setAuthenticationFailed reports that the local cluster cannot authenticate to the foreign one
+func setAuthenticationFailed(fc *discoveryv1alpha1.ForeignCluster, reason string, err error) bool {
+	return fc.SetCondition(discoveryv1alpha1.Condition{
+		Type:    discoveryv1alpha1.AuthenticationSucceededCondition,
+		Status:  apiv1.ConditionFalse,
+		Reason:  reason,
+		Message: err.Error(),
+	})
+}
+
+// isPeeringRequestDenied returns true if the PeeringRequest has been denied by the admission webhook enforcing the
+// peering policy of the foreign cluster
+func isPeeringRequestDenied(err error) bool {
+	return errors.IsForbidden(err) && strings.Contains(err.Error(), "denied the request")
+}
+
+// setPeeringRequestConditions reports the outcome of the creation of the PeeringRequest in the foreign cluster, the
+// PeeringRequest is nil if the CA of the foreign cluster is unknown
+func setPeeringRequestConditions(fc *discoveryv1alpha1.ForeignCluster, pr *discoveryv1alpha1.PeeringRequest, err error) {
+	switch {
+	case err == nil && pr == nil:
+		setAuthenticationFailed(fc, "UnknownAuthority", goerrors.New("the API server of the foreign cluster presents a certificate signed by an unknown authority"))
+	case err == nil:
+		fc.SetCondition(discoveryv1alpha1.Condition{
+			Type:    discoveryv1alpha1.AuthenticationSucceededCondition,
+			Status:  apiv1.ConditionTrue,
+			Reason:  "Authenticated",
+			Message: "the API server of the foreign cluster accepted the local cluster",
+		})
+		fc.SetCondition(discoveryv1alpha1.Condition{
+			Type:    discoveryv1alpha1.PeeringRequestAcceptedCondition,
+			Status:  apiv1.ConditionTrue,
+			Reason:  "Admitted",
+			Message: fmt.Sprintf("PeeringRequest %s admitted by the foreign cluster", pr.Name),
+		})
+	case isPeeringRequestDenied(err):
+		fc.SetCondition(discoveryv1alpha1.Condition{
+			Type:    discoveryv1alpha1.PeeringRequestAcceptedCondition,
+			Status:  apiv1.ConditionFalse,
+			Reason:  "Denied",
+			Message: err.Error(),
+		})
+	case errors.IsUnauthorized(err) || errors.IsForbidden(err):
+		setAuthenticationFailed(fc, string(errors.ReasonForError(err)), err)
+	default:
+		// it is retried at the next reconciliation
+		fc.SetCondition(discoveryv1alpha1.Condition{
+			Type:    discoveryv1alpha1.PeeringRequestAcceptedCondition,
+			Status:  apiv1.ConditionUnknown,
+			Reason:  "CreationFailed",
+			Message: err.Error(),
+		})
+	}
+}
+
+// getNetworkConfigCondition reports if both the local and the remote NetworkConfigs are available
+func getNetworkConfigCondition(network *discoveryv1alpha1.Network) discoveryv1alpha1.Condition {
+	var missing []string
+	if !network.LocalNetworkConfig.Available {
+		missing = append(missing, "local")
+	}
+	if !network.RemoteNetworkConfig.Available {
+		missing = append(missing, "remote")
+	}
+	if len(missing) > 0 {
+		return discoveryv1alpha1.Condition{
+			Type:    discoveryv1alpha1.NetworkConfigExchangedCondition,
+			Status:  apiv1.ConditionFalse,
+			Reason:  "NetworkConfigMissing",
+			Message: fmt.Sprintf("waiting for the %s NetworkConfig", strings.Join(missing, " and the ")),
+		}
+	}
+	return discoveryv1alpha1.Condition{
+		Type:    discoveryv1alpha1.NetworkConfigExchangedCondition,
+		Status:  apiv1.ConditionTrue,
+		Reason:  "NetworkConfigAvailable",
+		Message: "the local and the remote NetworkConfigs are available",
+	}
+}
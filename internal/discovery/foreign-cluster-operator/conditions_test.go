@@ -0,0 +1,141 @@
+package foreign_cluster_operator
+
+import (
+	goerrors "errors"
+	discoveryv1alpha1 "github.com/liqotech/liqo/apis/discovery/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"testing"
+)
+
+func setConditions(fc *discoveryv1alpha1.ForeignCluster, status apiv1.ConditionStatus, conditionTypes ...discoveryv1alpha1.ConditionType) {
+	for _, conditionType := range conditionTypes {
+		fc.SetCondition(discoveryv1alpha1.Condition{Type: conditionType, Status: status})
+	}
+}
+
+func TestPhase(t *testing.T) {
+	fc := &discoveryv1alpha1.ForeignCluster{}
+	assert.Equal(t, discoveryv1alpha1.DiscoveredPhase, fc.GetPhase())
+
+	//the phase follows the first condition of the outgoing peering which is not satisfied
+	fc.Spec.Join = true
+	assert.Equal(t, discoveryv1alpha1.AuthenticatingPhase, fc.GetPhase())
+	setConditions(fc, apiv1.ConditionFalse, discoveryv1alpha1.AuthenticationSucceededCondition)
+	assert.Equal(t, discoveryv1alpha1.AuthenticatingPhase, fc.GetPhase())
+	setConditions(fc, apiv1.ConditionTrue, discoveryv1alpha1.AuthenticationSucceededCondition)
+	assert.Equal(t, discoveryv1alpha1.PeeringPhase, fc.GetPhase())
+	setConditions(fc, apiv1.ConditionTrue, discoveryv1alpha1.PeeringRequestAcceptedCondition, discoveryv1alpha1.AdvertisementAcceptedCondition)
+	assert.Equal(t, discoveryv1alpha1.NetworkingPhase, fc.GetPhase())
+	setConditions(fc, apiv1.ConditionTrue, discoveryv1alpha1.NetworkConfigExchangedCondition)
+	setConditions(fc, apiv1.ConditionUnknown, discoveryv1alpha1.TunnelEstablishedCondition)
+	assert.Equal(t, discoveryv1alpha1.NetworkingPhase, fc.GetPhase())
+	setConditions(fc, apiv1.ConditionTrue, discoveryv1alpha1.TunnelEstablishedCondition)
+	assert.Equal(t, discoveryv1alpha1.PeeringPhase, fc.GetPhase())
+	setConditions(fc, apiv1.ConditionTrue, discoveryv1alpha1.VirtualNodeReadyCondition)
+	assert.Equal(t, discoveryv1alpha1.PeeredPhase, fc.GetPhase())
+	assert.True(t, fc.UpdatePhase())
+	assert.False(t, fc.UpdatePhase())
+	assert.Equal(t, discoveryv1alpha1.PeeredPhase, fc.Status.Phase)
+
+	//a broken tunnel is reported
+	setConditions(fc, apiv1.ConditionFalse, discoveryv1alpha1.TunnelEstablishedCondition)
+	assert.Equal(t, discoveryv1alpha1.NetworkingPhase, fc.GetPhase())
+
+	//the refusals are not retried
+	setConditions(fc, apiv1.ConditionFalse, discoveryv1alpha1.AdvertisementAcceptedCondition)
+	assert.Equal(t, discoveryv1alpha1.FailedPhase, fc.GetPhase())
+	setConditions(fc, apiv1.ConditionTrue, discoveryv1alpha1.AdvertisementAcceptedCondition)
+	setConditions(fc, apiv1.ConditionTrue, discoveryv1alpha1.CAMismatchCondition)
+	assert.Equal(t, discoveryv1alpha1.FailedPhase, fc.GetPhase())
+	fc.RemoveCondition(discoveryv1alpha1.CAMismatchCondition)
+
+	//unpeering
+	fc.Spec.Join = false
+	fc.Status.Outgoing.Joined = true
+	assert.Equal(t, discoveryv1alpha1.UnpeeringPhase, fc.GetPhase())
+	fc.Status.Outgoing.Joined = false
+	assert.True(t, fc.RemovePeeringConditions())
+	assert.Nil(t, fc.GetCondition(discoveryv1alpha1.VirtualNodeReadyCondition))
+	assert.NotNil(t, fc.GetCondition(discoveryv1alpha1.AuthenticationSucceededCondition))
+	assert.Equal(t, discoveryv1alpha1.DiscoveredPhase, fc.GetPhase())
+	fc.Status.Incoming.Joined = true
+	assert.Equal(t, discoveryv1alpha1.PeeredPhase, fc.GetPhase())
+}
+
+func TestSetCondition(t *testing.T) {
+	fc := &discoveryv1alpha1.ForeignCluster{ObjectMeta: metav1.ObjectMeta{Generation: 1}}
+	condition := discoveryv1alpha1.Condition{
+		Type:   discoveryv1alpha1.TunnelConnectedCondition,
+		Status: apiv1.ConditionFalse,
+		Reason: "Down",
+	}
+	assert.True(t, fc.SetNetworkCondition(condition))
+	set := discoveryv1alpha1.FindCondition(fc.Status.Network.Conditions, discoveryv1alpha1.TunnelConnectedCondition)
+	assert.NotNil(t, set)
+	assert.Equal(t, int64(1), set.ObservedGeneration)
+	transition := set.LastTransitionTime
+
+	//the same condition does not modify the ForeignCluster, even if its generation changed
+	fc.Generation = 2
+	assert.False(t, fc.SetNetworkCondition(condition))
+	assert.Equal(t, int64(1), set.ObservedGeneration)
+
+	//a different reason updates the generation, but not the transition time
+	condition.Reason = "Unreachable"
+	assert.True(t, fc.SetNetworkCondition(condition))
+	assert.Equal(t, int64(2), set.ObservedGeneration)
+	assert.Equal(t, transition, set.LastTransitionTime)
+
+	assert.True(t, fc.RemoveNetworkCondition(discoveryv1alpha1.TunnelConnectedCondition))
+	assert.False(t, fc.RemoveNetworkCondition(discoveryv1alpha1.TunnelConnectedCondition))
+	assert.Empty(t, fc.Status.Conditions)
+}
+
+func TestPeeringRequestConditions(t *testing.T) {
+	resource := schema.GroupResource{Group: discoveryv1alpha1.GroupVersion.Group, Resource: "peeringrequests"}
+	tests := []struct {
+		name       string
+		pr         *discoveryv1alpha1.PeeringRequest
+		err        error
+		authStatus apiv1.ConditionStatus
+		prStatus   apiv1.ConditionStatus
+	}{
+		{"admitted", &discoveryv1alpha1.PeeringRequest{ObjectMeta: metav1.ObjectMeta{Name: "local"}}, nil, apiv1.ConditionTrue, apiv1.ConditionTrue},
+		{"unknown authority", nil, nil, apiv1.ConditionFalse, ""},
+		{"denied", nil, errors.NewForbidden(resource, "local", goerrors.New("admission webhook \"peering-request.liqo.io\" denied the request: peering denied by the policy")), apiv1.ConditionTrue, apiv1.ConditionFalse},
+		{"forbidden", nil, errors.NewForbidden(resource, "local", goerrors.New("no RBAC policy matched")), apiv1.ConditionFalse, ""},
+		{"unauthorized", nil, errors.NewUnauthorized("invalid token"), apiv1.ConditionFalse, ""},
+		{"unreachable", nil, goerrors.New("connection refused"), apiv1.ConditionTrue, apiv1.ConditionUnknown},
+	}
+	for _, test := range tests {
+		fc := &discoveryv1alpha1.ForeignCluster{}
+		//the previous authentication is kept by the errors not related to it
+		setConditions(fc, apiv1.ConditionTrue, discoveryv1alpha1.AuthenticationSucceededCondition)
+		setPeeringRequestConditions(fc, test.pr, test.err)
+		assert.Equal(t, test.authStatus, fc.GetCondition(discoveryv1alpha1.AuthenticationSucceededCondition).Status, test.name)
+		if condition := fc.GetCondition(discoveryv1alpha1.PeeringRequestAcceptedCondition); test.prStatus == "" {
+			assert.Nil(t, condition, test.name)
+		} else {
+			assert.Equal(t, test.prStatus, condition.Status, test.name)
+		}
+	}
+}
+
+func TestNetworkConfigCondition(t *testing.T) {
+	network := &discoveryv1alpha1.Network{}
+	condition := getNetworkConfigCondition(network)
+	assert.Equal(t, apiv1.ConditionFalse, condition.Status)
+	assert.Equal(t, "waiting for the local and the remote NetworkConfig", condition.Message)
+
+	network.LocalNetworkConfig.Available = true
+	condition = getNetworkConfigCondition(network)
+	assert.Equal(t, apiv1.ConditionFalse, condition.Status)
+	assert.Equal(t, "waiting for the remote NetworkConfig", condition.Message)
+
+	network.RemoteNetworkConfig.Available = true
+	assert.Equal(t, apiv1.ConditionTrue, getNetworkConfigCondition(network).Status)
+}
@@ -0,0 +1,151 @@
+package foreign_cluster_operator
+
+import (
+	"encoding/pem"
+	discoveryv1alpha1 "github.com/liqotech/liqo/apis/discovery/v1alpha1"
+	"github.com/liqotech/liqo/pkg/auth"
+	"github.com/stretchr/testify/assert"
+	"io"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//newConnectProxy returns an HTTP proxy tunneling the CONNECT requests, it counts the tunnels opened
+func newConnectProxy(t *testing.T, tunnels *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		upstream, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		conn, _, err := w.(http.Hijacker).Hijack()
+		assert.Nil(t, err)
+		atomic.AddInt32(tunnels, 1)
+		go func() {
+			_, _ = io.Copy(upstream, conn)
+			upstream.Close()
+		}()
+		go func() {
+			_, _ = io.Copy(conn, upstream)
+			conn.Close()
+		}()
+	}))
+}
+
+func TestConnectionConfig(t *testing.T) {
+	apiServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"major": "1", "minor": "18"}`))
+	}))
+	defer apiServer.Close()
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: apiServer.Certificate().Raw})
+
+	var tunnels int32
+	proxy := newConnectProxy(t, &tunnels)
+	defer proxy.Close()
+
+	//the API URL is not reachable, the alternative one is reached through the proxy
+	unreachable := httptest.NewServer(http.NotFoundHandler())
+	unreachable.Close()
+	fc := &discoveryv1alpha1.ForeignCluster{
+		Spec: discoveryv1alpha1.ForeignClusterSpec{
+			ApiUrl: "https://" + unreachable.Listener.Addr().String(),
+			Connection: &discoveryv1alpha1.ConnectionConfig{
+				ProxyURL: proxy.URL,
+				//the certificate of the test server is signed by its own CA and issued to example.com
+				CABundle:           caPEM,
+				ServerName:         "example.com",
+				AlternativeApiUrls: []string{apiServer.URL},
+			},
+		},
+	}
+
+	fingerprint, err := getServerCAFingerprint(fc)
+	assert.Nil(t, err)
+	assert.Equal(t, auth.CAFingerprint(caPEM), fingerprint)
+	assert.True(t, atomic.LoadInt32(&tunnels) > 0)
+
+	fc.Status.TrustMode = discoveryv1alpha1.TrustModeTrusted
+	cnf, err := fc.GetConfig(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, apiServer.URL, cnf.Host)
+	assert.Equal(t, "example.com", cnf.ServerName)
+	assert.Equal(t, caPEM, cnf.CAData)
+
+	//a wrong server name is refused
+	fc.Spec.Connection.ServerName = "liqo.io"
+	fc.Spec.Connection.AlternativeApiUrls = nil
+	fc.Spec.ApiUrl = apiServer.URL
+	_, err = getServerCAFingerprint(fc)
+	assert.NotNil(t, err)
+
+	//an invalid proxy is reported
+	fc.Spec.Connection.ProxyURL = "http://%zz"
+	_, err = fc.GetConfig(nil)
+	assert.NotNil(t, err)
+}
+
+func TestRefreshApiUrl(t *testing.T) {
+	var probes int32
+	apiServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&probes, 1)
+		_, _ = w.Write([]byte(`{"major": "1", "minor": "18"}`))
+	}))
+	defer apiServer.Close()
+	unreachable := httptest.NewServer(http.NotFoundHandler())
+	unreachable.Close()
+	fc := &discoveryv1alpha1.ForeignCluster{
+		Spec: discoveryv1alpha1.ForeignClusterSpec{
+			ApiUrl: "https://" + unreachable.Listener.Addr().String(),
+			Connection: &discoveryv1alpha1.ConnectionConfig{
+				AlternativeApiUrls: []string{apiServer.URL},
+			},
+		},
+	}
+	fc.Status.TrustMode = discoveryv1alpha1.TrustModeTrusted
+
+	//the first URL answering is recorded
+	updated, err := fc.RefreshApiUrl()
+	assert.Nil(t, err)
+	assert.True(t, updated)
+	assert.Equal(t, apiServer.URL, fc.Status.Connection.ApiUrl)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&probes))
+
+	//the recorded URL is used by the clients and it is not probed again within the interval
+	cnf, err := fc.GetConfig(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, apiServer.URL, cnf.Host)
+	updated, err = fc.RefreshApiUrl()
+	assert.Nil(t, err)
+	assert.False(t, updated)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&probes))
+
+	//the URLs are probed again after the interval
+	fc.Status.Connection.LastProbeTime = metav1.NewTime(time.Now().Add(-discoveryv1alpha1.ApiUrlProbeInterval))
+	updated, err = fc.RefreshApiUrl()
+	assert.Nil(t, err)
+	assert.True(t, updated)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&probes))
+
+	//a recorded URL which is not configured anymore is not used
+	fc.Spec.Connection.AlternativeApiUrls = []string{"https://" + unreachable.Listener.Addr().String()}
+	updated, err = fc.RefreshApiUrl()
+	assert.Nil(t, err)
+	assert.True(t, updated)
+	assert.Equal(t, fc.Spec.ApiUrl, fc.Status.Connection.ApiUrl)
+
+	fc.Spec.Connection.AlternativeApiUrls = nil
+	updated, err = fc.RefreshApiUrl()
+	assert.Nil(t, err)
+	assert.True(t, updated)
+	assert.Nil(t, fc.Status.Connection)
+}
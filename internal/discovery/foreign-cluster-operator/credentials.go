@@ -0,0 +1,72 @@
+package foreign_cluster_operator
+
+import (
+	"context"
+	goerrors "errors"
+	discoveryv1alpha1 "github.com/liqotech/liqo/apis/discovery/v1alpha1"
+	"github.com/liqotech/liqo/internal/discovery/kubeconfig"
+	"github.com/liqotech/liqo/pkg/crdClient"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog"
+)
+
+// rotateCredentials sends to the foreign cluster the current credentials minted for it by the auth-service, if they
+// have not been sent yet. The auth-service revokes the previous ones once they have been delivered.
+func (r *ForeignClusterReconciler) rotateCredentials(fc *discoveryv1alpha1.ForeignCluster, foreignClient *crdClient.CRDClient) error {
+	clusterID := fc.Spec.ClusterIdentity.ClusterID
+	client := r.crdClient.Client()
+
+	token, err := kubeconfig.CurrentToken(client, r.Namespace, clusterID, clusterID)
+	if err != nil || token == nil {
+		return err
+	}
+	sa, err := client.CoreV1().ServiceAccounts(r.Namespace).Get(context.TODO(), clusterID, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if sa.Annotations[kubeconfig.SentTokenAnnotation] == token.Name {
+		return nil
+	}
+
+	if err = r.sendCredentials(fc, foreignClient, token.Name); err != nil {
+		return err
+	}
+	if err = kubeconfig.MarkTokenSent(client, r.Namespace, clusterID, token.Name); err != nil {
+		return err
+	}
+	klog.Infof("credentials of cluster %s rotated", clusterID)
+	return nil
+}
+
+// sendCredentials sends to the foreign cluster a kube-config authenticated with the given token and makes its
+// PeeringRequest refer to it
+func (r *ForeignClusterReconciler) sendCredentials(fc *discoveryv1alpha1.ForeignCluster, foreignClient *crdClient.CRDClient, tokenName string) error {
+	clusterID := fc.Spec.ClusterIdentity.ClusterID
+	token, err := r.crdClient.Client().CoreV1().Secrets(r.Namespace).Get(context.TODO(), tokenName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	fConfig, err := kubeconfig.CreateKubeConfigFromToken(r.crdClient.Client(), clusterID, token)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := foreignClient.Resource("peeringrequests").Get(fc.Status.Outgoing.RemotePeeringRequestName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	pr, ok := tmp.(*discoveryv1alpha1.PeeringRequest)
+	if !ok {
+		return goerrors.New("retrieved object is not a PeeringRequest")
+	}
+	ref, err := r.sendForeignConfig(pr, fConfig, foreignClient)
+	if err != nil {
+		return err
+	}
+	pr.Spec.KubeConfigRef = ref
+	pr.TypeMeta.Kind = "PeeringRequest"
+	pr.TypeMeta.APIVersion = "discovery.liqo.io/v1alpha1"
+	// the foreign cluster deletes the Secret with the previous kube-config once the PeeringRequest refers to the new one
+	_, err = foreignClient.Resource("peeringrequests").Update(pr.Name, pr, metav1.UpdateOptions{})
+	return err
+}
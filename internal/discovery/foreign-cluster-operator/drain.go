@@ -0,0 +1,158 @@
+package foreign_cluster_operator
+
+import (
+	"context"
+	"fmt"
+	discoveryv1alpha1 "github.com/liqotech/liqo/apis/discovery/v1alpha1"
+	"github.com/liqotech/liqo/pkg/virtualKubelet"
+	apiv1 "k8s.io/api/core/v1"
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/klog"
+	"time"
+)
+
+const (
+	// maximum time waited for the offloaded pods to be evicted, if it is not configured
+	defaultDrainTimeout = 5 * time.Minute
+	// how often the eviction of the offloaded pods is checked while draining the virtual node
+	drainCheckPeriod = 5 * time.Second
+)
+
+// drainVirtualNode cordons the virtual node of the foreign cluster and evicts the pods offloaded to it, respecting their
+// PodDisruptionBudgets. The evicted pods are recreated by their controllers on the local nodes, since the virtual node
+// is not schedulable anymore. It returns true when the outgoing peering can be torn down: the virtual node is empty,
+// or the pods have not been evicted within the timeout
+func (r *ForeignClusterReconciler) drainVirtualNode(fc *discoveryv1alpha1.ForeignCluster) (bool, error) {
+	timeout, enabled := r.getDrainTimeout()
+	if !enabled {
+		return true, nil
+	}
+	if condition := fc.GetCondition(discoveryv1alpha1.WorkloadDrainedCondition); condition != nil && condition.Status == apiv1.ConditionTrue {
+		return true, nil
+	}
+
+	client := r.crdClient.Client()
+	nodeName := virtualKubelet.VirtualNodePrefix + fc.Spec.ClusterIdentity.ClusterID
+	node, err := client.CoreV1().Nodes().Get(context.TODO(), nodeName, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		setWorkloadDrained(fc, apiv1.ConditionTrue, "NoVirtualNode", fmt.Sprintf("the virtual node %s does not exist", nodeName))
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if !node.Spec.Unschedulable {
+		node.Spec.Unschedulable = true
+		if _, err = client.CoreV1().Nodes().Update(context.TODO(), node, metav1.UpdateOptions{}); err != nil {
+			return false, err
+		}
+		klog.Infof("virtual node %s cordoned, the pods offloaded to %s are evicted", nodeName, fc.Name)
+	}
+
+	pods, err := client.CoreV1().Pods(metav1.NamespaceAll).List(context.TODO(), metav1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("spec.nodeName", nodeName).String(),
+	})
+	if err != nil {
+		return false, err
+	}
+	remaining := getEvictablePods(pods.Items)
+	if len(remaining) == 0 {
+		setWorkloadDrained(fc, apiv1.ConditionTrue, "Drained", fmt.Sprintf("the offloaded pods have been evicted from the virtual node %s", nodeName))
+		return true, nil
+	}
+
+	setWorkloadDrained(fc, apiv1.ConditionFalse, "Draining", fmt.Sprintf("%d pods left on the virtual node %s", len(remaining), nodeName))
+	// the transition time of the condition is the beginning of the drain
+	started := fc.GetCondition(discoveryv1alpha1.WorkloadDrainedCondition).LastTransitionTime
+	if time.Since(started.Time) > timeout {
+		klog.Warningf("%d pods have not been evicted from the virtual node %s within %v, tearing down the peering with %s", len(remaining), nodeName, timeout, fc.Name)
+		setWorkloadDrained(fc, apiv1.ConditionFalse, "DrainTimeout", fmt.Sprintf("%d pods have not been evicted from the virtual node %s within %v", len(remaining), nodeName, timeout))
+		return true, nil
+	}
+
+	for i := range remaining {
+		pod := &remaining[i]
+		if pod.DeletionTimestamp != nil {
+			// already evicted, waiting for the virtual kubelet to delete it in the foreign cluster
+			continue
+		}
+		err = client.PolicyV1beta1().Evictions(pod.Namespace).Evict(context.TODO(), &policyv1beta1.Eviction{
+			ObjectMeta: metav1.ObjectMeta{Name: pod.Name, Namespace: pod.Namespace},
+		})
+		switch {
+		case err == nil:
+			klog.Infof("pod %s/%s evicted from the virtual node %s", pod.Namespace, pod.Name, nodeName)
+		case errors.IsTooManyRequests(err):
+			// the eviction would violate a PodDisruptionBudget, it is retried at the next check
+			klog.V(4).Infof("eviction of the pod %s/%s delayed: %v", pod.Namespace, pod.Name, err)
+		case errors.IsNotFound(err):
+		default:
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+// cancelDrain uncordons the virtual node of the foreign cluster if it was being drained, since the outgoing peering has
+// been enabled again
+func (r *ForeignClusterReconciler) cancelDrain(fc *discoveryv1alpha1.ForeignCluster) (bool, error) {
+	if fc.GetCondition(discoveryv1alpha1.WorkloadDrainedCondition) == nil {
+		return false, nil
+	}
+	client := r.crdClient.Client()
+	nodeName := virtualKubelet.VirtualNodePrefix + fc.Spec.ClusterIdentity.ClusterID
+	node, err := client.CoreV1().Nodes().Get(context.TODO(), nodeName, metav1.GetOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return false, err
+	}
+	if err == nil && node.Spec.Unschedulable {
+		node.Spec.Unschedulable = false
+		if _, err = client.CoreV1().Nodes().Update(context.TODO(), node, metav1.UpdateOptions{}); err != nil {
+			return false, err
+		}
+		klog.Infof("virtual node %s uncordoned, the peering with %s has been enabled again", nodeName, fc.Name)
+	}
+	return fc.RemoveCondition(discoveryv1alpha1.WorkloadDrainedCondition), nil
+}
+
+// getEvictablePods returns the pods which have to be evicted from the virtual node, the ones managed by DaemonSets are
+// bound to the node and they are deleted with it
+func getEvictablePods(pods []apiv1.Pod) []apiv1.Pod {
+	var res []apiv1.Pod
+	for i := range pods {
+		pod := &pods[i]
+		if pod.Status.Phase == apiv1.PodSucceeded || pod.Status.Phase == apiv1.PodFailed {
+			continue
+		}
+		if owner := metav1.GetControllerOf(pod); owner != nil && owner.Kind == "DaemonSet" {
+			continue
+		}
+		res = append(res, *pod)
+	}
+	return res
+}
+
+func setWorkloadDrained(fc *discoveryv1alpha1.ForeignCluster, status apiv1.ConditionStatus, reason string, message string) {
+	fc.SetCondition(discoveryv1alpha1.Condition{
+		Type:    discoveryv1alpha1.WorkloadDrainedCondition,
+		Status:  status,
+		Reason:  reason,
+		Message: message,
+	})
+}
+
+// getDrainTimeout returns the maximum time waited for the offloaded pods to be evicted, and false if the drain is
+// disabled
+func (r *ForeignClusterReconciler) getDrainTimeout() (time.Duration, bool) {
+	if r.DiscoveryCtrl == nil || r.DiscoveryCtrl.Config == nil || r.DiscoveryCtrl.Config.UnpeeringDrain == nil {
+		return defaultDrainTimeout, true
+	}
+	config := r.DiscoveryCtrl.Config.UnpeeringDrain
+	if config.TimeoutSeconds <= 0 {
+		return defaultDrainTimeout, !config.Disabled
+	}
+	return time.Duration(config.TimeoutSeconds) * time.Second, !config.Disabled
+}
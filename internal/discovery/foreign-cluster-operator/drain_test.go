@@ -0,0 +1,155 @@
+package foreign_cluster_operator
+
+import (
+	"context"
+	discoveryv1alpha1 "github.com/liqotech/liqo/apis/discovery/v1alpha1"
+	"github.com/liqotech/liqo/pkg/crdClient"
+	"github.com/liqotech/liqo/pkg/virtualKubelet"
+	"github.com/stretchr/testify/assert"
+	apiv1 "k8s.io/api/core/v1"
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+	k8stesting "k8s.io/client-go/testing"
+	"testing"
+	"time"
+)
+
+func getOffloadedPod(name string, nodeName string) *apiv1.Pod {
+	return &apiv1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec:       apiv1.PodSpec{NodeName: nodeName},
+		Status:     apiv1.PodStatus{Phase: apiv1.PodRunning},
+	}
+}
+
+func TestDrainVirtualNode(t *testing.T) {
+	crdClient.Fake = true
+	client, err := crdClient.NewFromConfig(&rest.Config{ContentConfig: rest.ContentConfig{GroupVersion: &discoveryv1alpha1.GroupVersion}})
+	assert.Nil(t, err)
+	r := &ForeignClusterReconciler{crdClient: client}
+	fakeClient := client.Client().(*fake.Clientset)
+
+	fc := &discoveryv1alpha1.ForeignCluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "fc"},
+		Spec: discoveryv1alpha1.ForeignClusterSpec{
+			ClusterIdentity: discoveryv1alpha1.ClusterIdentity{ClusterID: "foreign-id"},
+		},
+		Status: discoveryv1alpha1.ForeignClusterStatus{
+			Outgoing: discoveryv1alpha1.Outgoing{Joined: true},
+		},
+	}
+	nodeName := virtualKubelet.VirtualNodePrefix + "foreign-id"
+
+	//without the virtual node there is nothing to drain
+	drained, err := r.drainVirtualNode(fc)
+	assert.Nil(t, err)
+	assert.True(t, drained)
+	assert.Equal(t, "NoVirtualNode", fc.GetCondition(discoveryv1alpha1.WorkloadDrainedCondition).Reason)
+	assert.Equal(t, discoveryv1alpha1.UnpeeringPhase, fc.GetPhase())
+	fc.RemoveCondition(discoveryv1alpha1.WorkloadDrainedCondition)
+
+	_, err = fakeClient.CoreV1().Nodes().Create(context.TODO(), &apiv1.Node{ObjectMeta: metav1.ObjectMeta{Name: nodeName}}, metav1.CreateOptions{})
+	assert.Nil(t, err)
+	_, err = fakeClient.CoreV1().Pods("default").Create(context.TODO(), getOffloadedPod("pod", nodeName), metav1.CreateOptions{})
+	assert.Nil(t, err)
+
+	//the evictions are blocked by a PodDisruptionBudget
+	evictions := 0
+	pdbViolated := true
+	fakeClient.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() != "eviction" {
+			return false, nil, nil
+		}
+		evictions++
+		if pdbViolated {
+			return true, nil, errors.NewTooManyRequests("cannot evict pod as it would violate the pod's disruption budget", 10)
+		}
+		eviction := action.(k8stesting.CreateAction).GetObject().(*policyv1beta1.Eviction)
+		return true, nil, fakeClient.Tracker().Delete(apiv1.SchemeGroupVersion.WithResource("pods"), eviction.Namespace, eviction.Name)
+	})
+
+	drained, err = r.drainVirtualNode(fc)
+	assert.Nil(t, err)
+	assert.False(t, drained)
+	assert.Equal(t, 1, evictions)
+	assert.Equal(t, apiv1.ConditionFalse, fc.GetCondition(discoveryv1alpha1.WorkloadDrainedCondition).Status)
+	assert.Equal(t, discoveryv1alpha1.DrainingPhase, fc.GetPhase())
+	node, err := fakeClient.CoreV1().Nodes().Get(context.TODO(), nodeName, metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.True(t, node.Spec.Unschedulable)
+
+	//the re-join uncordons the virtual node
+	cancelled, err := r.cancelDrain(fc)
+	assert.Nil(t, err)
+	assert.True(t, cancelled)
+	assert.Nil(t, fc.GetCondition(discoveryv1alpha1.WorkloadDrainedCondition))
+	node, err = fakeClient.CoreV1().Nodes().Get(context.TODO(), nodeName, metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.False(t, node.Spec.Unschedulable)
+
+	//the pods are evicted once the budget allows it
+	drained, err = r.drainVirtualNode(fc)
+	assert.Nil(t, err)
+	assert.False(t, drained)
+	pdbViolated = false
+	drained, err = r.drainVirtualNode(fc)
+	assert.Nil(t, err)
+	assert.False(t, drained)
+	drained, err = r.drainVirtualNode(fc)
+	assert.Nil(t, err)
+	assert.True(t, drained)
+	assert.Equal(t, "Drained", fc.GetCondition(discoveryv1alpha1.WorkloadDrainedCondition).Reason)
+	assert.Equal(t, discoveryv1alpha1.UnpeeringPhase, fc.GetPhase())
+}
+
+func TestDrainTimeout(t *testing.T) {
+	crdClient.Fake = true
+	client, err := crdClient.NewFromConfig(&rest.Config{ContentConfig: rest.ContentConfig{GroupVersion: &discoveryv1alpha1.GroupVersion}})
+	assert.Nil(t, err)
+	r := &ForeignClusterReconciler{crdClient: client}
+	fakeClient := client.Client().(*fake.Clientset)
+	fakeClient.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() != "eviction" {
+			return false, nil, nil
+		}
+		return true, nil, errors.NewTooManyRequests("cannot evict pod as it would violate the pod's disruption budget", 10)
+	})
+
+	fc := &discoveryv1alpha1.ForeignCluster{
+		Spec: discoveryv1alpha1.ForeignClusterSpec{
+			ClusterIdentity: discoveryv1alpha1.ClusterIdentity{ClusterID: "foreign-id"},
+		},
+	}
+	nodeName := virtualKubelet.VirtualNodePrefix + "foreign-id"
+	_, err = fakeClient.CoreV1().Nodes().Create(context.TODO(), &apiv1.Node{ObjectMeta: metav1.ObjectMeta{Name: nodeName}}, metav1.CreateOptions{})
+	assert.Nil(t, err)
+	_, err = fakeClient.CoreV1().Pods("default").Create(context.TODO(), getOffloadedPod("pod", nodeName), metav1.CreateOptions{})
+	assert.Nil(t, err)
+
+	drained, err := r.drainVirtualNode(fc)
+	assert.Nil(t, err)
+	assert.False(t, drained)
+
+	//the peering is torn down when the timeout expires, even if some pods are left
+	fc.GetCondition(discoveryv1alpha1.WorkloadDrainedCondition).LastTransitionTime = metav1.NewTime(time.Now().Add(-defaultDrainTimeout - time.Second))
+	drained, err = r.drainVirtualNode(fc)
+	assert.Nil(t, err)
+	assert.True(t, drained)
+	assert.Equal(t, "DrainTimeout", fc.GetCondition(discoveryv1alpha1.WorkloadDrainedCondition).Reason)
+}
+
+func TestGetEvictablePods(t *testing.T) {
+	controller := true
+	daemonSetPod := getOffloadedPod("ds", "node")
+	daemonSetPod.OwnerReferences = []metav1.OwnerReference{{Kind: "DaemonSet", Name: "ds", Controller: &controller}}
+	completedPod := getOffloadedPod("completed", "node")
+	completedPod.Status.Phase = apiv1.PodSucceeded
+
+	pods := getEvictablePods([]apiv1.Pod{*getOffloadedPod("pod", "node"), *daemonSetPod, *completedPod})
+	assert.Equal(t, 1, len(pods))
+	assert.Equal(t, "pod", pods[0].Name)
+}
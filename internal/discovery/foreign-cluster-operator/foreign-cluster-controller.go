@@ -20,6 +20,7 @@ import (
 	"context"
 	"crypto/x509"
 	goerrors "errors"
+	"fmt"
 	discoveryv1alpha1 "github.com/liqotech/liqo/apis/discovery/v1alpha1"
 	nettypes "github.com/liqotech/liqo/apis/net/v1alpha1"
 	advtypes "github.com/liqotech/liqo/apis/sharing/v1alpha1"
@@ -29,7 +30,6 @@ import (
 	"github.com/liqotech/liqo/pkg/clusterID"
 	"github.com/liqotech/liqo/pkg/crdClient"
 	apiv1 "k8s.io/api/core/v1"
-	rbacv1 "k8s.io/api/rbac/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
@@ -37,6 +37,7 @@ import (
 	"k8s.io/client-go/util/retry"
 	"k8s.io/klog"
 	"k8s.io/kubernetes/pkg/util/slice"
+	"reflect"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"strings"
 	"time"
@@ -94,6 +95,28 @@ func (r *ForeignClusterReconciler) Reconcile(req ctrl.Request) (ctrl.Result, err
 				RequeueAfter: r.RequeueAfter,
 			}, err
 		}
+		if trust {
+			// the clusters retrieved from DNS answers not authenticated by DNSSEC are not trusted
+			trust, err = r.isDNSSECValidated(fc)
+			if err != nil {
+				klog.Error(err)
+				return ctrl.Result{
+					Requeue:      true,
+					RequeueAfter: r.RequeueAfter,
+				}, err
+			}
+		}
+		if trust {
+			// the CA stated in the signed announcements has to be the one trusted by the system
+			trust, err = r.checkAnnouncedCA(fc)
+			if err != nil {
+				klog.Error(err)
+				return ctrl.Result{
+					Requeue:      true,
+					RequeueAfter: r.RequeueAfter,
+				}, err
+			}
+		}
 		if trust {
 			fc.Status.TrustMode = discoveryv1alpha1.TrustModeTrusted
 		} else {
@@ -102,7 +125,7 @@ func (r *ForeignClusterReconciler) Reconcile(req ctrl.Request) (ctrl.Result, err
 		// set join flag
 		// if it was discovery with WAN discovery, this value is overwritten by SearchDomain value
 		if fc.Spec.DiscoveryType != discoveryv1alpha1.WanDiscovery && fc.Spec.DiscoveryType != discoveryv1alpha1.IncomingPeeringDiscovery && fc.Spec.DiscoveryType != discoveryv1alpha1.ManualDiscovery {
-			fc.Spec.Join = (r.getAutoJoin(fc) && fc.Status.TrustMode == discoveryv1alpha1.TrustModeTrusted) || (r.getAutoJoinUntrusted(fc) && fc.Status.TrustMode == discoveryv1alpha1.TrustModeUntrusted)
+			fc.Spec.Join = (r.getAutoJoin(fc) && fc.Status.TrustMode == discoveryv1alpha1.TrustModeTrusted) || (r.getAutoJoinUntrusted(fc) && fc.Status.TrustMode == discoveryv1alpha1.TrustModeUntrusted && r.isAuthenticated(fc))
 		}
 
 		requireUpdate = true
@@ -143,6 +166,16 @@ func (r *ForeignClusterReconciler) Reconcile(req ctrl.Request) (ctrl.Result, err
 		}, err
 	}
 
+	// check the permissions granted to the foreign cluster
+	err = r.checkPermissions(fc, &requireUpdate)
+	if err != nil {
+		klog.Error(err)
+		return ctrl.Result{
+			Requeue:      true,
+			RequeueAfter: r.RequeueAfter,
+		}, err
+	}
+
 	// check if linked advertisement exists
 	if fc.Status.Outgoing.Advertisement != nil {
 		tmp, err = r.advertisementClient.Resource("advertisements").Get(fc.Status.Outgoing.Advertisement.Name, metav1.GetOptions{})
@@ -169,15 +202,17 @@ func (r *ForeignClusterReconciler) Reconcile(req ctrl.Request) (ctrl.Result, err
 			if adv.Spec.KubeConfigRef.Name != "" && adv.Spec.KubeConfigRef.Namespace != "" {
 				_, err = r.crdClient.Client().CoreV1().Secrets(adv.Spec.KubeConfigRef.Namespace).Get(context.TODO(), adv.Spec.KubeConfigRef.Name, metav1.GetOptions{})
 				available := err == nil
-				if fc.Status.Outgoing.AvailableIdentity != available {
+				identityRef := &apiv1.ObjectReference{
+					Kind:       "Secret",
+					Namespace:  adv.Spec.KubeConfigRef.Namespace,
+					Name:       adv.Spec.KubeConfigRef.Name,
+					APIVersion: "v1",
+				}
+				// the referred Secret changes when the credentials are rotated
+				if fc.Status.Outgoing.AvailableIdentity != available || (available && !reflect.DeepEqual(fc.Status.Outgoing.IdentityRef, identityRef)) {
 					fc.Status.Outgoing.AvailableIdentity = available
 					if available {
-						fc.Status.Outgoing.IdentityRef = &apiv1.ObjectReference{
-							Kind:       "Secret",
-							Namespace:  adv.Spec.KubeConfigRef.Namespace,
-							Name:       adv.Spec.KubeConfigRef.Name,
-							APIVersion: "v1",
-						}
+						fc.Status.Outgoing.IdentityRef = identityRef
 					}
 					requireUpdate = true
 				}
@@ -213,36 +248,56 @@ func (r *ForeignClusterReconciler) Reconcile(req ctrl.Request) (ctrl.Result, err
 				}, err
 			}
 
-			if !fc.Status.Incoming.Joined {
-				// PeeringRequest exists, set flag to true
-				fc.Status.Incoming.Joined = true
+			if !fc.IsIncomingAllowed() {
+				// the foreign cluster is not allowed to offload to the local one anymore
+				klog.Infof("Incoming peering from %s is not allowed, deleting PeeringRequest %s", fc.Name, pr.Name)
+				err = r.crdClient.Resource("peeringrequests").Delete(pr.Name, metav1.DeleteOptions{})
+				if err != nil && !errors.IsNotFound(err) {
+					klog.Error(err)
+					return ctrl.Result{
+						Requeue:      true,
+						RequeueAfter: r.RequeueAfter,
+					}, err
+				}
+				fc.Status.Incoming.PeeringRequest = nil
+				fc.Status.Incoming.AvailableIdentity = false
+				fc.Status.Incoming.IdentityRef = nil
+				fc.Status.Incoming.AdvertisementStatus = ""
+				fc.Status.Incoming.Joined = false
 				requireUpdate = true
-			}
+			} else {
+				if !fc.Status.Incoming.Joined {
+					// PeeringRequest exists, set flag to true
+					fc.Status.Incoming.Joined = true
+					requireUpdate = true
+				}
 
-			// check if kubeconfig secret exists
-			if pr.Spec.KubeConfigRef != nil && pr.Spec.KubeConfigRef.Name != "" && pr.Spec.KubeConfigRef.Namespace != "" {
-				_, err = r.crdClient.Client().CoreV1().Secrets(pr.Spec.KubeConfigRef.Namespace).Get(context.TODO(), pr.Spec.KubeConfigRef.Name, metav1.GetOptions{})
-				available := err == nil
-				if fc.Status.Incoming.AvailableIdentity != available {
-					fc.Status.Incoming.AvailableIdentity = available
-					if available {
-						fc.Status.Incoming.IdentityRef = pr.Spec.KubeConfigRef
+				// check if kubeconfig secret exists
+				if pr.Spec.KubeConfigRef != nil && pr.Spec.KubeConfigRef.Name != "" && pr.Spec.KubeConfigRef.Namespace != "" {
+					_, err = r.crdClient.Client().CoreV1().Secrets(pr.Spec.KubeConfigRef.Namespace).Get(context.TODO(), pr.Spec.KubeConfigRef.Name, metav1.GetOptions{})
+					available := err == nil
+					if fc.Status.Incoming.AvailableIdentity != available || (available && !reflect.DeepEqual(fc.Status.Incoming.IdentityRef, pr.Spec.KubeConfigRef)) {
+						fc.Status.Incoming.AvailableIdentity = available
+						if available {
+							fc.Status.Incoming.IdentityRef = pr.Spec.KubeConfigRef
+						}
+						requireUpdate = true
 					}
-					requireUpdate = true
 				}
-			}
 
-			// update advertisement status
-			status := pr.Status.AdvertisementStatus
-			if status != fc.Status.Incoming.AdvertisementStatus {
-				fc.Status.Incoming.AdvertisementStatus = status
-				requireUpdate = true
+				// update advertisement status
+				status := pr.Status.AdvertisementStatus
+				if status != fc.Status.Incoming.AdvertisementStatus {
+					fc.Status.Incoming.AdvertisementStatus = status
+					requireUpdate = true
+				}
 			}
 		}
 	}
 
 	// if it has been discovered thanks to incoming peeringRequest and it has no active connections, delete it
-	if fc.Spec.DiscoveryType == discoveryv1alpha1.IncomingPeeringDiscovery && fc.Status.Incoming.PeeringRequest == nil && fc.Status.Outgoing.Advertisement == nil {
+	// it is kept if its incoming peering has been disabled, not to allow it again
+	if fc.Spec.DiscoveryType == discoveryv1alpha1.IncomingPeeringDiscovery && fc.Status.Incoming.PeeringRequest == nil && fc.Status.Outgoing.Advertisement == nil && fc.IsIncomingAllowed() {
 		err = r.crdClient.Resource("foreignclusters").Delete(fc.Name, metav1.DeleteOptions{})
 		if err != nil {
 			klog.Error(err, err.Error())
@@ -256,11 +311,64 @@ func (r *ForeignClusterReconciler) Reconcile(req ctrl.Request) (ctrl.Result, err
 		return ctrl.Result{}, nil
 	}
 
+	// select the API URL used by the clients of the foreign cluster among the alternative ones
+	updated, err := fc.RefreshApiUrl()
+	if err != nil {
+		klog.Error(err)
+		return ctrl.Result{
+			Requeue:      true,
+			RequeueAfter: r.RequeueAfter,
+		}, err
+	}
+	if updated {
+		requireUpdate = true
+	}
+
+	// refuse the foreign cluster if it presents a CA different from the pinned one
+	refused, err := r.checkCAPinning(fc, &requireUpdate)
+	if err != nil {
+		klog.Error(err)
+		return ctrl.Result{
+			Requeue:      true,
+			RequeueAfter: r.RequeueAfter,
+		}, err
+	}
+	if refused {
+		klog.Warningf("ForeignCluster %s presents a CA different from the pinned one, the peering is refused", fc.Name)
+		if requireUpdate {
+			if _, err = r.Update(fc); err != nil {
+				klog.Error(err)
+				return ctrl.Result{
+					Requeue:      true,
+					RequeueAfter: r.RequeueAfter,
+				}, err
+			}
+		}
+		return ctrl.Result{
+			Requeue:      true,
+			RequeueAfter: r.RequeueAfter,
+		}, nil
+	}
+
 	if fc.Status.Outgoing.CaDataRef == nil && fc.Status.TrustMode == discoveryv1alpha1.TrustModeUntrusted {
 		klog.Info("Get CA Data")
 		err = fc.LoadForeignCA(r.crdClient.Client(), r.Namespace, r.ForeignConfig)
+		if handleCAMismatch(fc, err) {
+			if _, err = r.Update(fc); err != nil {
+				klog.Error(err)
+			}
+			return ctrl.Result{
+				Requeue:      true,
+				RequeueAfter: r.RequeueAfter,
+			}, err
+		}
 		if err != nil {
 			klog.Error(err, err.Error())
+			if setAuthenticationFailed(fc, "CAUnavailable", err) {
+				if _, err2 := r.Update(fc); err2 != nil {
+					klog.Error(err2)
+				}
+			}
 			return ctrl.Result{
 				Requeue:      true,
 				RequeueAfter: r.RequeueAfter,
@@ -292,9 +400,28 @@ func (r *ForeignClusterReconciler) Reconcile(req ctrl.Request) (ctrl.Result, err
 
 	// if join is required (both automatically or by user) and status is not set to joined
 	// create new peering request
+	// if the peering has been enabled again while the virtual node was being drained, uncordon it
+	if fc.Spec.Join && fc.DeletionTimestamp.IsZero() {
+		cancelled, err := r.cancelDrain(fc)
+		if err != nil {
+			klog.Error(err)
+			return ctrl.Result{
+				Requeue:      true,
+				RequeueAfter: r.RequeueAfter,
+			}, err
+		}
+		if cancelled {
+			requireUpdate = true
+		}
+	}
+
 	if fc.Spec.Join && !fc.Status.Outgoing.Joined {
 		fc, err = r.Peer(fc, foreignDiscoveryClient)
 		if err != nil {
+			// the conditions report why the peering failed
+			if _, err2 := r.Update(fc); err2 != nil {
+				klog.Error(err2)
+			}
 			return ctrl.Result{
 				Requeue:      true,
 				RequeueAfter: r.RequeueAfter,
@@ -307,6 +434,24 @@ func (r *ForeignClusterReconciler) Reconcile(req ctrl.Request) (ctrl.Result, err
 	// or if this foreign cluster is being deleted
 	// delete peering request
 	if (!fc.Spec.Join || !fc.DeletionTimestamp.IsZero()) && fc.Status.Outgoing.Joined {
+		// the offloaded pods are moved back to the local cluster before tearing down the peering
+		drained, err := r.drainVirtualNode(fc)
+		if err != nil {
+			klog.Error(err)
+			return ctrl.Result{
+				Requeue:      true,
+				RequeueAfter: r.RequeueAfter,
+			}, err
+		}
+		if !drained {
+			if _, err = r.Update(fc); err != nil {
+				klog.Error(err)
+			}
+			return ctrl.Result{
+				Requeue:      true,
+				RequeueAfter: drainCheckPeriod,
+			}, nil
+		}
 		fc, err = r.Unpeer(fc, foreignDiscoveryClient)
 		if err != nil {
 			return ctrl.Result{
@@ -322,6 +467,10 @@ func (r *ForeignClusterReconciler) Reconcile(req ctrl.Request) (ctrl.Result, err
 		requireUpdate = true
 	}
 
+	if fc.UpdatePhase() {
+		requireUpdate = true
+	}
+
 	if requireUpdate {
 		_, err = r.Update(fc)
 		if err != nil {
@@ -348,6 +497,16 @@ func (r *ForeignClusterReconciler) Reconcile(req ctrl.Request) (ctrl.Result, err
 				RequeueAfter: r.RequeueAfter,
 			}, err
 		}
+
+		// rotate the credentials sent to the foreign cluster
+		err = r.rotateCredentials(fc, foreignDiscoveryClient)
+		if err != nil {
+			klog.Error(err)
+			return ctrl.Result{
+				Requeue:      true,
+				RequeueAfter: r.RequeueAfter,
+			}, err
+		}
 	}
 
 	klog.V(4).Infof("ForeignCluster %s successfully reconciled", fc.Name)
@@ -358,6 +517,7 @@ func (r *ForeignClusterReconciler) Reconcile(req ctrl.Request) (ctrl.Result, err
 }
 
 func (r *ForeignClusterReconciler) Update(fc *discoveryv1alpha1.ForeignCluster) (*discoveryv1alpha1.ForeignCluster, error) {
+	fc.UpdatePhase()
 	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
 		if tmp, err := r.crdClient.Resource("foreignclusters").Update(fc.Name, fc, metav1.UpdateOptions{}); err == nil {
 			var ok bool
@@ -390,12 +550,22 @@ func (r *ForeignClusterReconciler) Update(fc *discoveryv1alpha1.ForeignCluster)
 }
 
 func (r *ForeignClusterReconciler) Peer(fc *discoveryv1alpha1.ForeignCluster, foreignDiscoveryClient *crdClient.CRDClient) (*discoveryv1alpha1.ForeignCluster, error) {
+	// pin the CA of the foreign cluster at the first join, or check it against the pinned one
+	if err := r.pinCA(fc); err != nil {
+		if handleCAMismatch(fc, err) {
+			return fc, nil
+		}
+		klog.Error(err)
+		return fc, err
+	}
+
 	// create PeeringRequest
 	klog.Info("Creating PeeringRequest")
 	pr, err := r.createPeeringRequestIfNotExists(fc.Name, fc, foreignDiscoveryClient)
+	setPeeringRequestConditions(fc, pr, err)
 	if err != nil {
 		klog.Error(err)
-		return nil, err
+		return fc, err
 	}
 	if pr != nil {
 		fc.Status.Outgoing.Joined = true
@@ -424,6 +594,7 @@ func (r *ForeignClusterReconciler) Unpeer(fc *discoveryv1alpha1.ForeignCluster,
 	}
 	fc.Status.Outgoing.Joined = false
 	fc.Status.Outgoing.RemotePeeringRequestName = ""
+	fc.RemovePeeringConditions()
 	if slice.ContainsString(fc.Finalizers, FinalizerString, nil) {
 		fc.Finalizers = slice.RemoveString(fc.Finalizers, FinalizerString, nil)
 	}
@@ -436,6 +607,7 @@ func (r *ForeignClusterReconciler) getRemoteClient(fc *discoveryv1alpha1.Foreign
 		klog.Error(err)
 		// delete reference, in this way at next iteration it will be reloaded
 		fc.Status.Outgoing.CaDataRef = nil
+		setAuthenticationFailed(fc, "CAUnavailable", err)
 		_, err2 := r.Update(fc)
 		if err2 != nil {
 			klog.Error(err2)
@@ -470,6 +642,35 @@ func (r *ForeignClusterReconciler) checkJoined(fc *discoveryv1alpha1.ForeignClus
 	return fc, nil
 }
 
+// isDNSSECValidated returns false if the ForeignCluster has been retrieved by a SearchDomain requiring DNSSEC, whose
+// last answers have not been authenticated
+func (r *ForeignClusterReconciler) isDNSSECValidated(fc *discoveryv1alpha1.ForeignCluster) (bool, error) {
+	if fc.Spec.DiscoveryType != discoveryv1alpha1.WanDiscovery {
+		return true, nil
+	}
+	for _, owner := range fc.OwnerReferences {
+		if owner.Kind != "SearchDomain" {
+			continue
+		}
+		tmp, err := r.crdClient.Resource("searchdomains").Get(owner.Name, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+		sd, ok := tmp.(*discoveryv1alpha1.SearchDomain)
+		if !ok {
+			return false, goerrors.New("retrieved object is not a SearchDomain")
+		}
+		if sd.Spec.DNSSEC != "" && sd.Spec.DNSSEC != discoveryv1alpha1.DNSSECDisabled && !sd.Status.DNSSECValidated {
+			klog.Infof("ForeignCluster %s has been retrieved from DNS answers not authenticated by DNSSEC", fc.Name)
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
 // check if the error is due to a TLS certificate signed by unknown authority
 func isUnknownAuthority(err error) bool {
 	var err509 x509.UnknownAuthorityError
@@ -528,24 +729,7 @@ func (r *ForeignClusterReconciler) createPeeringRequestIfNotExists(clusterID str
 				return nil, goerrors.New("created object is not a ForeignCluster")
 			}
 		}
-		secret := &apiv1.Secret{
-			ObjectMeta: metav1.ObjectMeta{
-				// generate name will lead to different names every time, avoiding name collisions
-				GenerateName: strings.Join([]string{"pr", r.clusterID.GetClusterID(), ""}, "-"),
-				OwnerReferences: []metav1.OwnerReference{
-					{
-						APIVersion: "discovery.liqo.io/v1alpha1",
-						Kind:       "PeeringRequest",
-						Name:       pr.Name,
-						UID:        pr.UID,
-					},
-				},
-			},
-			StringData: map[string]string{
-				"kubeconfig": fConfig,
-			},
-		}
-		secret, err := foreignClient.Client().CoreV1().Secrets(r.Namespace).Create(context.TODO(), secret, metav1.CreateOptions{})
+		ref, err := r.sendForeignConfig(pr, fConfig, foreignClient)
 		if err != nil {
 			klog.Error(err)
 			// there was an error during secret creation, delete peering request
@@ -556,13 +740,7 @@ func (r *ForeignClusterReconciler) createPeeringRequestIfNotExists(clusterID str
 			}
 			return nil, err
 		}
-		pr.Spec.KubeConfigRef = &apiv1.ObjectReference{
-			Kind:       "Secret",
-			Namespace:  secret.Namespace,
-			Name:       secret.Name,
-			UID:        secret.UID,
-			APIVersion: "v1",
-		}
+		pr.Spec.KubeConfigRef = ref
 		pr.TypeMeta.Kind = "PeeringRequest"
 		pr.TypeMeta.APIVersion = "discovery.liqo.io/v1alpha1"
 		tmp, err = foreignClient.Resource("peeringrequests").Update(pr.Name, pr, metav1.UpdateOptions{})
@@ -586,31 +764,48 @@ func (r *ForeignClusterReconciler) createPeeringRequestIfNotExists(clusterID str
 	return pr, nil
 }
 
-// this function return a kube-config file to send to foreign cluster and crate everything needed for it
-func (r *ForeignClusterReconciler) getForeignConfig(clusterID string, owner *discoveryv1alpha1.ForeignCluster) (string, error) {
-	_, err := r.createClusterRoleIfNotExists(clusterID, owner)
-	if err != nil {
-		return "", err
+// sendForeignConfig creates in the foreign cluster a Secret, owned by the PeeringRequest, containing the kube-config
+// to access our cluster, and returns a reference to it
+func (r *ForeignClusterReconciler) sendForeignConfig(pr *discoveryv1alpha1.PeeringRequest, fConfig string, foreignClient *crdClient.CRDClient) (*apiv1.ObjectReference, error) {
+	secret := &apiv1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			// generate name will lead to different names every time, avoiding name collisions
+			GenerateName: strings.Join([]string{"pr", r.clusterID.GetClusterID(), ""}, "-"),
+			OwnerReferences: []metav1.OwnerReference{
+				{
+					APIVersion: "discovery.liqo.io/v1alpha1",
+					Kind:       "PeeringRequest",
+					Name:       pr.Name,
+					UID:        pr.UID,
+				},
+			},
+		},
+		StringData: map[string]string{
+			"kubeconfig": fConfig,
+		},
 	}
-	_, err = r.createRoleIfNotExists(clusterID, owner)
+	secret, err := foreignClient.Client().CoreV1().Secrets(r.Namespace).Create(context.TODO(), secret, metav1.CreateOptions{})
 	if err != nil {
-		return "", err
+		return nil, err
 	}
+	return &apiv1.ObjectReference{
+		Kind:       "Secret",
+		Namespace:  secret.Namespace,
+		Name:       secret.Name,
+		UID:        secret.UID,
+		APIVersion: "v1",
+	}, nil
+}
+
+// this function return a kube-config file to send to foreign cluster and crate everything needed for it
+func (r *ForeignClusterReconciler) getForeignConfig(clusterID string, owner *discoveryv1alpha1.ForeignCluster) (string, error) {
 	sa, err := r.createServiceAccountIfNotExists(clusterID, owner)
 	if err != nil {
 		return "", err
 	}
-	_, err = r.createClusterRoleBindingIfNotExists(clusterID, owner)
-	if err != nil {
-		return "", err
-	}
-	_, err = r.createRoleBindingIfNotExists(clusterID, owner)
-	if err != nil {
-		return "", err
-	}
 
-	// crdreplicator role binding
-	err = r.setDispatcherRole(clusterID, sa)
+	// advertisement broadcaster and crdreplicator roles
+	err = r.grantPeerPermissions(clusterID, owner, sa)
 	if err != nil {
 		return "", err
 	}
@@ -647,74 +842,16 @@ func (r *ForeignClusterReconciler) getForeignConfig(clusterID string, owner *dis
 			}
 		}
 	}
-	cnf, err := kubeconfig.CreateKubeConfig(r.crdClient.Client(), clusterID, r.Namespace)
-	return cnf, err
-}
-
-func (r *ForeignClusterReconciler) createClusterRoleIfNotExists(clusterID string, owner *discoveryv1alpha1.ForeignCluster) (*rbacv1.ClusterRole, error) {
-	role, err := r.crdClient.Client().RbacV1().ClusterRoles().Get(context.TODO(), clusterID, metav1.GetOptions{})
-	if errors.IsNotFound(err) {
-		// does not exist
-		role = &rbacv1.ClusterRole{
-			ObjectMeta: metav1.ObjectMeta{
-				Name: clusterID,
-				OwnerReferences: []metav1.OwnerReference{
-					{
-						APIVersion: "v1alpha1",
-						Kind:       "ForeignCluster",
-						Name:       owner.Name,
-						UID:        owner.UID,
-					},
-				},
-			},
-			Rules: []rbacv1.PolicyRule{
-				{
-					Verbs:     []string{"get", "list", "create", "update", "delete", "watch"},
-					APIGroups: []string{"sharing.liqo.io"},
-					Resources: []string{"advertisements", "advertisements/status"},
-				},
-			},
-		}
-		return r.crdClient.Client().RbacV1().ClusterRoles().Create(context.TODO(), role, metav1.CreateOptions{})
-	} else if err != nil {
-		klog.Error(err)
-		return nil, err
-	} else {
-		return role, nil
+	// if the credentials have already been rotated by the auth-service, the current token has to be sent
+	token, err := kubeconfig.CurrentToken(r.crdClient.Client(), r.Namespace, clusterID, clusterID)
+	if err != nil {
+		return "", err
 	}
-}
-
-func (r *ForeignClusterReconciler) createRoleIfNotExists(clusterID string, owner *discoveryv1alpha1.ForeignCluster) (*rbacv1.Role, error) {
-	role, err := r.crdClient.Client().RbacV1().Roles(r.Namespace).Get(context.TODO(), clusterID, metav1.GetOptions{})
-	if errors.IsNotFound(err) {
-		// does not exist
-		role = &rbacv1.Role{
-			ObjectMeta: metav1.ObjectMeta{
-				Name: clusterID,
-				OwnerReferences: []metav1.OwnerReference{
-					{
-						APIVersion: "v1alpha1",
-						Kind:       "ForeignCluster",
-						Name:       owner.Name,
-						UID:        owner.UID,
-					},
-				},
-			},
-			Rules: []rbacv1.PolicyRule{
-				{
-					Verbs:     []string{"get", "list", "create", "update", "delete", "watch"},
-					APIGroups: []string{""},
-					Resources: []string{"secrets"},
-				},
-			},
-		}
-		return r.crdClient.Client().RbacV1().Roles(r.Namespace).Create(context.TODO(), role, metav1.CreateOptions{})
-	} else if err != nil {
-		klog.Error(err)
-		return nil, err
-	} else {
-		return role, nil
+	if token != nil {
+		return kubeconfig.CreateKubeConfigFromToken(r.crdClient.Client(), clusterID, token)
 	}
+	cnf, err := kubeconfig.CreateKubeConfig(r.crdClient.Client(), clusterID, r.Namespace)
+	return cnf, err
 }
 
 func (r *ForeignClusterReconciler) createServiceAccountIfNotExists(clusterID string, owner *discoveryv1alpha1.ForeignCluster) (*apiv1.ServiceAccount, error) {
@@ -743,124 +880,6 @@ func (r *ForeignClusterReconciler) createServiceAccountIfNotExists(clusterID str
 	}
 }
 
-func (r *ForeignClusterReconciler) createClusterRoleBindingIfNotExists(clusterID string, owner *discoveryv1alpha1.ForeignCluster) (*rbacv1.ClusterRoleBinding, error) {
-	rb, err := r.crdClient.Client().RbacV1().ClusterRoleBindings().Get(context.TODO(), clusterID, metav1.GetOptions{})
-	if errors.IsNotFound(err) {
-		// does not exist
-		rb = &rbacv1.ClusterRoleBinding{
-			ObjectMeta: metav1.ObjectMeta{
-				Name: clusterID,
-				OwnerReferences: []metav1.OwnerReference{
-					{
-						APIVersion: "v1alpha1",
-						Kind:       "ForeignCluster",
-						Name:       owner.Name,
-						UID:        owner.UID,
-					},
-				},
-			},
-			Subjects: []rbacv1.Subject{
-				{
-					Kind:      "ServiceAccount",
-					Name:      clusterID,
-					Namespace: r.Namespace,
-				},
-			},
-			RoleRef: rbacv1.RoleRef{
-				APIGroup: "rbac.authorization.k8s.io",
-				Kind:     "ClusterRole",
-				Name:     clusterID,
-			},
-		}
-		return r.crdClient.Client().RbacV1().ClusterRoleBindings().Create(context.TODO(), rb, metav1.CreateOptions{})
-	} else if err != nil {
-		klog.Error(err)
-		return nil, err
-	} else {
-		return rb, nil
-	}
-}
-
-func (r *ForeignClusterReconciler) createRoleBindingIfNotExists(clusterID string, owner *discoveryv1alpha1.ForeignCluster) (*rbacv1.RoleBinding, error) {
-	rb, err := r.crdClient.Client().RbacV1().RoleBindings(r.Namespace).Get(context.TODO(), clusterID, metav1.GetOptions{})
-	if errors.IsNotFound(err) {
-		// does not exist
-		rb = &rbacv1.RoleBinding{
-			ObjectMeta: metav1.ObjectMeta{
-				Name: clusterID,
-				OwnerReferences: []metav1.OwnerReference{
-					{
-						APIVersion: "v1alpha1",
-						Kind:       "ForeignCluster",
-						Name:       owner.Name,
-						UID:        owner.UID,
-					},
-				},
-			},
-			Subjects: []rbacv1.Subject{
-				{
-					Kind:      "ServiceAccount",
-					Name:      clusterID,
-					Namespace: r.Namespace,
-				},
-			},
-			RoleRef: rbacv1.RoleRef{
-				APIGroup: "rbac.authorization.k8s.io",
-				Kind:     "Role",
-				Name:     clusterID,
-			},
-		}
-		return r.crdClient.Client().RbacV1().RoleBindings(r.Namespace).Create(context.TODO(), rb, metav1.CreateOptions{})
-	} else if err != nil {
-		klog.Error(err)
-		return nil, err
-	} else {
-		return rb, nil
-	}
-}
-
-func (r *ForeignClusterReconciler) setDispatcherRole(clusterID string, sa *apiv1.ServiceAccount) error {
-	_, err := r.crdClient.Client().RbacV1().ClusterRoleBindings().Get(context.TODO(), clusterID+"-crdreplicator", metav1.GetOptions{})
-	if errors.IsNotFound(err) {
-		// does not exist
-		rb := &rbacv1.ClusterRoleBinding{
-			ObjectMeta: metav1.ObjectMeta{
-				Name: clusterID + "-crdreplicator",
-				OwnerReferences: []metav1.OwnerReference{
-					{
-						APIVersion: "v1",
-						Kind:       "ServiceAccount",
-						Name:       sa.Name,
-						UID:        sa.UID,
-					},
-				},
-			},
-			Subjects: []rbacv1.Subject{
-				{
-					Kind:      "ServiceAccount",
-					Name:      sa.Name,
-					Namespace: sa.Namespace,
-				},
-			},
-			RoleRef: rbacv1.RoleRef{
-				APIGroup: "rbac.authorization.k8s.io",
-				Kind:     "ClusterRole",
-				Name:     "crdreplicator-role",
-			},
-		}
-		_, err = r.crdClient.Client().RbacV1().ClusterRoleBindings().Create(context.TODO(), rb, metav1.CreateOptions{})
-		if err != nil {
-			klog.Error(err)
-		}
-		return err
-	} else if err != nil {
-		klog.Error(err)
-		return err
-	} else {
-		return nil
-	}
-}
-
 func (r *ForeignClusterReconciler) deleteAdvertisement(fc *discoveryv1alpha1.ForeignCluster) error {
 	return fc.DeleteAdvertisement(r.advertisementClient)
 }
@@ -895,7 +914,21 @@ func (r *ForeignClusterReconciler) checkNetwork(fc *discoveryv1alpha1.ForeignClu
 
 	// remote NetworkConfig
 	labelSelector = strings.Join([]string{crdReplicator.RemoteLabelSelector, fc.Spec.ClusterIdentity.ClusterID}, "=")
-	return r.updateNetwork(labelSelector, &fc.Status.Network.RemoteNetworkConfig, requireUpdate)
+	if err := r.updateNetwork(labelSelector, &fc.Status.Network.RemoteNetworkConfig, requireUpdate); err != nil {
+		klog.Error(err)
+		return err
+	}
+
+	// the NetworkConfigs are exchanged only while peering
+	network := &fc.Status.Network
+	if !fc.Spec.Join && !fc.IsPeered() && !network.LocalNetworkConfig.Available && !network.RemoteNetworkConfig.Available {
+		if fc.RemoveCondition(discoveryv1alpha1.NetworkConfigExchangedCondition) {
+			*requireUpdate = true
+		}
+	} else if fc.SetCondition(getNetworkConfigCondition(network)) {
+		*requireUpdate = true
+	}
+	return nil
 }
 
 func (r *ForeignClusterReconciler) updateNetwork(labelSelector string, resourceLink *discoveryv1alpha1.ResourceLink, requireUpdate *bool) error {
@@ -963,5 +996,44 @@ func (r *ForeignClusterReconciler) checkTEP(fc *discoveryv1alpha1.ForeignCluster
 		}
 		*requireUpdate = true
 	}
+	// report the health of the tunnel measured by the tunnel operator
+	if len(teps.Items) == 0 {
+		if fc.RemoveNetworkCondition(discoveryv1alpha1.TunnelConnectedCondition) {
+			*requireUpdate = true
+		}
+	} else if fc.SetNetworkCondition(getTunnelConnectedCondition(&teps.Items[0])) {
+		*requireUpdate = true
+	}
 	return nil
 }
+
+func getTunnelConnectedCondition(tep *nettypes.TunnelEndpoint) discoveryv1alpha1.Condition {
+	connection := tep.Status.Connection
+	switch connection.Status {
+	case nettypes.Connected:
+		return discoveryv1alpha1.Condition{
+			Type:    discoveryv1alpha1.TunnelConnectedCondition,
+			Status:  apiv1.ConditionTrue,
+			Reason:  "ProbeSucceeded",
+			Message: fmt.Sprintf("rtt %v, packet loss %d%%", connection.RTT.Duration, connection.PacketLoss),
+		}
+	case nettypes.ConnectionDown:
+		message := "no probe answered by the remote cluster"
+		if connection.LastSuccessTime != nil {
+			message = fmt.Sprintf("%s since %s", message, connection.LastSuccessTime.UTC().Format(time.RFC3339))
+		}
+		return discoveryv1alpha1.Condition{
+			Type:    discoveryv1alpha1.TunnelConnectedCondition,
+			Status:  apiv1.ConditionFalse,
+			Reason:  "ProbeFailed",
+			Message: message,
+		}
+	default:
+		return discoveryv1alpha1.Condition{
+			Type:    discoveryv1alpha1.TunnelConnectedCondition,
+			Status:  apiv1.ConditionUnknown,
+			Reason:  "NotProbed",
+			Message: "the tunnel has not been probed yet",
+		}
+	}
+}
@@ -0,0 +1,262 @@
+package foreign_cluster_operator
+
+import (
+	"context"
+	configv1alpha1 "github.com/liqotech/liqo/apis/config/v1alpha1"
+	discoveryv1alpha1 "github.com/liqotech/liqo/apis/discovery/v1alpha1"
+	"github.com/liqotech/liqo/pkg/auth"
+	apiv1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog"
+)
+
+// grantPeerPermissions grants to the ServiceAccount of the foreign cluster the permissions configured for its
+// advertisement broadcaster and its CRD replicator, updating them if the configuration has changed
+func (r *ForeignClusterReconciler) grantPeerPermissions(clusterID string, owner *discoveryv1alpha1.ForeignCluster, sa *apiv1.ServiceAccount) error {
+	config := r.getClusterConfig()
+
+	fcOwner := metav1.OwnerReference{
+		APIVersion: "v1alpha1",
+		Kind:       "ForeignCluster",
+		Name:       owner.Name,
+		UID:        owner.UID,
+	}
+	if err := r.grantPermissions(clusterID, fcOwner, sa, auth.AdvertisementBroadcasterPermissions(config, clusterID)); err != nil {
+		return err
+	}
+
+	saOwner := metav1.OwnerReference{
+		APIVersion: "v1",
+		Kind:       "ServiceAccount",
+		Name:       sa.Name,
+		UID:        sa.UID,
+	}
+	return r.grantPermissions(clusterID+"-crdreplicator", saOwner, sa, auth.CRDReplicatorPermissions(config, clusterID))
+}
+
+func (r *ForeignClusterReconciler) getClusterConfig() *configv1alpha1.ClusterConfigSpec {
+	if r.DiscoveryCtrl == nil || r.DiscoveryCtrl.ClusterConfig == nil {
+		klog.Warning("Cluster Config is not set, using default permissions")
+		return nil
+	}
+	return r.DiscoveryCtrl.ClusterConfig
+}
+
+// grantPermissions binds the ServiceAccount to a ClusterRole and to a Role with the given name, containing the rules
+// of the permissions
+func (r *ForeignClusterReconciler) grantPermissions(name string, owner metav1.OwnerReference, sa *apiv1.ServiceAccount, permissions configv1alpha1.PermissionsTemplate) error {
+	client := r.crdClient.Client().RbacV1()
+	subjects := []rbacv1.Subject{
+		{
+			Kind:      "ServiceAccount",
+			Name:      sa.Name,
+			Namespace: sa.Namespace,
+		},
+	}
+
+	clusterRole, err := client.ClusterRoles().Get(context.TODO(), name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		clusterRole = &rbacv1.ClusterRole{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            name,
+				OwnerReferences: []metav1.OwnerReference{owner},
+			},
+			Rules: permissions.ClusterRules,
+		}
+		_, err = client.ClusterRoles().Create(context.TODO(), clusterRole, metav1.CreateOptions{})
+	} else if err == nil && !equality.Semantic.DeepEqual(clusterRole.Rules, permissions.ClusterRules) {
+		clusterRole.Rules = permissions.ClusterRules
+		_, err = client.ClusterRoles().Update(context.TODO(), clusterRole, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		klog.Error(err)
+		return err
+	}
+
+	roleRef := rbacv1.RoleRef{
+		APIGroup: "rbac.authorization.k8s.io",
+		Kind:     "ClusterRole",
+		Name:     name,
+	}
+	clusterRoleBinding, err := client.ClusterRoleBindings().Get(context.TODO(), name, metav1.GetOptions{})
+	if err == nil && (clusterRoleBinding.RoleRef != roleRef || !equality.Semantic.DeepEqual(clusterRoleBinding.Subjects, subjects)) {
+		// the role of a binding cannot be changed, it has to be recreated
+		if err = client.ClusterRoleBindings().Delete(context.TODO(), name, metav1.DeleteOptions{}); err == nil {
+			err = errors.NewNotFound(rbacv1.Resource("clusterrolebindings"), name)
+		}
+	}
+	if errors.IsNotFound(err) {
+		clusterRoleBinding = &rbacv1.ClusterRoleBinding{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            name,
+				OwnerReferences: []metav1.OwnerReference{owner},
+			},
+			Subjects: subjects,
+			RoleRef:  roleRef,
+		}
+		_, err = client.ClusterRoleBindings().Create(context.TODO(), clusterRoleBinding, metav1.CreateOptions{})
+	}
+	if err != nil {
+		klog.Error(err)
+		return err
+	}
+
+	role, err := client.Roles(r.Namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		role = &rbacv1.Role{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            name,
+				OwnerReferences: []metav1.OwnerReference{owner},
+			},
+			Rules: permissions.NamespacedRules,
+		}
+		_, err = client.Roles(r.Namespace).Create(context.TODO(), role, metav1.CreateOptions{})
+	} else if err == nil && !equality.Semantic.DeepEqual(role.Rules, permissions.NamespacedRules) {
+		role.Rules = permissions.NamespacedRules
+		_, err = client.Roles(r.Namespace).Update(context.TODO(), role, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		klog.Error(err)
+		return err
+	}
+
+	roleRef.Kind = "Role"
+	roleBinding, err := client.RoleBindings(r.Namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if err == nil && (roleBinding.RoleRef != roleRef || !equality.Semantic.DeepEqual(roleBinding.Subjects, subjects)) {
+		if err = client.RoleBindings(r.Namespace).Delete(context.TODO(), name, metav1.DeleteOptions{}); err == nil {
+			err = errors.NewNotFound(rbacv1.Resource("rolebindings"), name)
+		}
+	}
+	if errors.IsNotFound(err) {
+		roleBinding = &rbacv1.RoleBinding{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            name,
+				OwnerReferences: []metav1.OwnerReference{owner},
+			},
+			Subjects: subjects,
+			RoleRef:  roleRef,
+		}
+		_, err = client.RoleBindings(r.Namespace).Create(context.TODO(), roleBinding, metav1.CreateOptions{})
+	}
+	if err != nil {
+		klog.Error(err)
+	}
+	return err
+}
+
+// checkPermissions keeps the permissions granted to the foreign cluster in line with the configuration and
+// publishes them in its status
+func (r *ForeignClusterReconciler) checkPermissions(fc *discoveryv1alpha1.ForeignCluster, requireUpdate *bool) error {
+	clusterID := fc.Spec.ClusterIdentity.ClusterID
+	if fc.Status.Outgoing.Joined {
+		sa, err := r.crdClient.Client().CoreV1().ServiceAccounts(r.Namespace).Get(context.TODO(), clusterID, metav1.GetOptions{})
+		if err == nil {
+			err = r.grantPeerPermissions(clusterID, fc, sa)
+		}
+		if err != nil && !errors.IsNotFound(err) {
+			klog.Error(err)
+			return err
+		}
+	}
+
+	granted, err := r.getGrantedPermissions(fc)
+	if err != nil {
+		klog.Error(err)
+		return err
+	}
+	if !equality.Semantic.DeepEqual(granted, fc.Status.GrantedPermissions) {
+		fc.Status.GrantedPermissions = granted
+		*requireUpdate = true
+	}
+	return nil
+}
+
+// getGrantedPermissions returns the rules bound to the identities of the foreign cluster: its ServiceAccount,
+// its certificate user and, if it is using our resources, the ServiceAccount of the virtual kubelets
+func (r *ForeignClusterReconciler) getGrantedPermissions(fc *discoveryv1alpha1.ForeignCluster) ([]discoveryv1alpha1.GrantedPermissions, error) {
+	clusterID := fc.Spec.ClusterIdentity.ClusterID
+	isPeerSubject := func(subject rbacv1.Subject) bool {
+		switch subject.Kind {
+		case "ServiceAccount":
+			return subject.Name == clusterID && subject.Namespace == r.Namespace
+		case "User":
+			return subject.Name == auth.PeerSubject(clusterID).CommonName
+		}
+		return false
+	}
+
+	var granted []discoveryv1alpha1.GrantedPermissions
+	appendGranted := func(binding string, namespace string, subjects []rbacv1.Subject, roleRef rbacv1.RoleRef, allSubjects bool) error {
+		var rules []rbacv1.PolicyRule
+		for _, subject := range subjects {
+			if !allSubjects && !isPeerSubject(subject) {
+				continue
+			}
+			if rules == nil {
+				var err error
+				if rules, err = r.getRules(namespace, roleRef); err != nil {
+					return err
+				}
+			}
+			granted = append(granted, discoveryv1alpha1.GrantedPermissions{
+				Subject:   subject,
+				Binding:   binding,
+				Namespace: namespace,
+				RoleRef:   roleRef,
+				Rules:     rules,
+			})
+		}
+		return nil
+	}
+
+	clusterRoleBindings, err := r.crdClient.Client().RbacV1().ClusterRoleBindings().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for i := range clusterRoleBindings.Items {
+		crb := &clusterRoleBindings.Items[i]
+		isVirtualKubelet := crb.RoleRef.Kind == "ClusterRole" && crb.RoleRef.Name == auth.VirtualKubeletClusterRole
+		if isVirtualKubelet && !fc.Status.Incoming.Joined {
+			continue
+		}
+		if err = appendGranted(crb.Name, "", crb.Subjects, crb.RoleRef, isVirtualKubelet); err != nil {
+			return nil, err
+		}
+	}
+
+	roleBindings, err := r.crdClient.Client().RbacV1().RoleBindings(r.Namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for i := range roleBindings.Items {
+		rb := &roleBindings.Items[i]
+		if err = appendGranted(rb.Name, r.Namespace, rb.Subjects, rb.RoleRef, false); err != nil {
+			return nil, err
+		}
+	}
+	return granted, nil
+}
+
+// getRules returns the rules of the role referred by a binding, none if it does not exist
+func (r *ForeignClusterReconciler) getRules(namespace string, roleRef rbacv1.RoleRef) ([]rbacv1.PolicyRule, error) {
+	var rules []rbacv1.PolicyRule
+	var err error
+	if roleRef.Kind == "ClusterRole" {
+		var clusterRole *rbacv1.ClusterRole
+		if clusterRole, err = r.crdClient.Client().RbacV1().ClusterRoles().Get(context.TODO(), roleRef.Name, metav1.GetOptions{}); err == nil {
+			rules = clusterRole.Rules
+		}
+	} else {
+		var role *rbacv1.Role
+		if role, err = r.crdClient.Client().RbacV1().Roles(namespace).Get(context.TODO(), roleRef.Name, metav1.GetOptions{}); err == nil {
+			rules = role.Rules
+		}
+	}
+	if errors.IsNotFound(err) {
+		return []rbacv1.PolicyRule{}, nil
+	}
+	return rules, err
+}
@@ -0,0 +1,172 @@
+package foreign_cluster_operator
+
+import (
+	"context"
+	goerrors "errors"
+	"fmt"
+	discoveryv1alpha1 "github.com/liqotech/liqo/apis/discovery/v1alpha1"
+	"github.com/liqotech/liqo/pkg/auth"
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/klog"
+	"time"
+)
+
+// maximum time to connect to the foreign API server to get the CA trusted by the system
+const caDialTimeout = 5 * time.Second
+
+// checkCAPinning handles the re-pinning of the CA requested by the administrator and returns true if the foreign
+// cluster has to be refused, since it presented a CA different from the pinned one
+func (r *ForeignClusterReconciler) checkCAPinning(fc *discoveryv1alpha1.ForeignCluster, requireUpdate *bool) (bool, error) {
+	if fc.Annotations[discoveryv1alpha1.RepinCAAnnotation] == "true" {
+		klog.Infof("re-pinning the CA of the ForeignCluster %s", fc.Name)
+		// the CA is loaded again and the trust is checked again, the new CA is pinned at the next join
+		if fc.Status.Outgoing.CaDataRef != nil {
+			err := r.crdClient.Client().CoreV1().Secrets(fc.Status.Outgoing.CaDataRef.Namespace).Delete(context.TODO(), fc.Status.Outgoing.CaDataRef.Name, metav1.DeleteOptions{})
+			if err != nil && !errors.IsNotFound(err) {
+				return false, err
+			}
+			fc.Status.Outgoing.CaDataRef = nil
+		}
+		fc.Status.PinnedCA = nil
+		fc.Status.Announcement = nil
+		fc.Status.Identity = nil
+		fc.Status.TrustMode = discoveryv1alpha1.TrustModeUnknown
+		fc.RemoveCondition(discoveryv1alpha1.CAMismatchCondition)
+		delete(fc.Annotations, discoveryv1alpha1.RepinCAAnnotation)
+		*requireUpdate = true
+		return false, nil
+	}
+
+	condition := fc.GetCondition(discoveryv1alpha1.CAMismatchCondition)
+	return condition != nil && condition.Status == apiv1.ConditionTrue, nil
+}
+
+// pinCA pins the CA presented by the foreign cluster at the first join, and checks it at the following ones
+func (r *ForeignClusterReconciler) pinCA(fc *discoveryv1alpha1.ForeignCluster) error {
+	fingerprint, err := r.getPresentedCAFingerprint(fc)
+	if err != nil {
+		return err
+	}
+	if announced := getAnnouncedCA(fc); announced != "" && announced != fingerprint {
+		// the CA is bound to the key signing the announcements
+		return &discoveryv1alpha1.CAMismatchError{Pinned: announced, Presented: fingerprint}
+	}
+	pinned, err := fc.PinCA(fingerprint)
+	if err != nil {
+		return err
+	}
+	if pinned {
+		klog.Infof("CA %s pinned for the ForeignCluster %s", fingerprint, fc.Name)
+	}
+	return nil
+}
+
+// getPresentedCAFingerprint returns the fingerprint of the CA the foreign cluster is trusted with: the one trusted by
+// the system, or the one retrieved from the foreign cluster
+func (r *ForeignClusterReconciler) getPresentedCAFingerprint(fc *discoveryv1alpha1.ForeignCluster) (string, error) {
+	if fc.Status.TrustMode == discoveryv1alpha1.TrustModeTrusted {
+		return getServerCAFingerprint(fc)
+	}
+	if fc.Status.Outgoing.CaDataRef == nil {
+		return "", goerrors.New("the CA of the foreign cluster has not been loaded")
+	}
+	secret, err := r.crdClient.Client().CoreV1().Secrets(fc.Status.Outgoing.CaDataRef.Namespace).Get(context.TODO(), fc.Status.Outgoing.CaDataRef.Name, metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+	fingerprint := auth.CAFingerprint(secret.Data["caData"])
+	if fingerprint == "" {
+		return "", fmt.Errorf("the secret %s does not contain a valid CA", secret.Name)
+	}
+	return fingerprint, nil
+}
+
+// handleCAMismatch sets the condition refusing the foreign cluster if the error is due to a changed CA, it returns
+// false if the error has another cause
+func handleCAMismatch(fc *discoveryv1alpha1.ForeignCluster, err error) bool {
+	var mismatch *discoveryv1alpha1.CAMismatchError
+	if !goerrors.As(err, &mismatch) {
+		return false
+	}
+	klog.Warningf("ForeignCluster %s refused: %v", fc.Name, err)
+	fc.SetCondition(discoveryv1alpha1.Condition{
+		Type:    discoveryv1alpha1.CAMismatchCondition,
+		Status:  apiv1.ConditionTrue,
+		Reason:  "CAChanged",
+		Message: fmt.Sprintf("%s, set the annotation %s=true to accept it", err.Error(), discoveryv1alpha1.RepinCAAnnotation),
+	})
+	return true
+}
+
+// checkAnnouncedCA returns false if the CA stated in the signed announcements of the foreign cluster is not the one its
+// API server is trusted with by the system
+func (r *ForeignClusterReconciler) checkAnnouncedCA(fc *discoveryv1alpha1.ForeignCluster) (bool, error) {
+	announced := getAnnouncedCA(fc)
+	if announced == "" {
+		return true, nil
+	}
+	fingerprint, err := getServerCAFingerprint(fc)
+	if err != nil {
+		return false, err
+	}
+	if fingerprint != announced {
+		klog.Warningf("ForeignCluster %s presents the CA %s, its announcements state %s", fc.Name, fingerprint, announced)
+		return false, nil
+	}
+	return true, nil
+}
+
+// getServerCAFingerprint returns the fingerprint of the CA the API server of the foreign cluster is trusted with by
+// the system, contacting it as stated in the connection settings of the ForeignCluster
+func getServerCAFingerprint(fc *discoveryv1alpha1.ForeignCluster) (string, error) {
+	cnf := &rest.Config{Host: fc.Spec.ApiUrl}
+	if err := fc.ApplyConnectionConfig(cnf); err != nil {
+		return "", err
+	}
+	return auth.ServerCAFingerprintForConfig(cnf, caDialTimeout)
+}
+
+// getAnnouncedCA returns the fingerprint of the CA stated in the signed announcements, empty if there is none
+func getAnnouncedCA(fc *discoveryv1alpha1.ForeignCluster) string {
+	if !fc.IsAnnouncementSigned() {
+		return ""
+	}
+	return fc.Status.Announcement.CAFingerprint
+}
+
+// isAuthenticated returns false if the foreign cluster has been discovered in the LAN with announcements which are not
+// signed with the key pinned by the administrator, in that case it is not joined automatically unless it is trusted.
+// The key pinned at the first announcement does not authenticate it, since it is only asserted by the cluster itself.
+func (r *ForeignClusterReconciler) isAuthenticated(fc *discoveryv1alpha1.ForeignCluster) bool {
+	if fc.Spec.DiscoveryType != discoveryv1alpha1.LanDiscovery {
+		return true
+	}
+	if !fc.IsAnnouncementSigned() {
+		klog.Infof("ForeignCluster %s has been announced without a signature", fc.Name)
+		return false
+	}
+	pinned := r.getPinnedKey(fc.Spec.ClusterIdentity.ClusterID)
+	if pinned == "" || pinned != fc.Status.Announcement.KeyFingerprint {
+		klog.Infof("ForeignCluster %s has been announced with the key %s, which has not been pinned by the administrator",
+			fc.Name, fc.Status.Announcement.KeyFingerprint)
+		return false
+	}
+	if fc.Status.Identity != nil && fc.Status.Identity.KeyFingerprint != "" && fc.Status.Identity.KeyFingerprint != pinned {
+		klog.Infof("ForeignCluster %s presents an identity signed with the key %s instead of the pinned %s",
+			fc.Name, fc.Status.Identity.KeyFingerprint, pinned)
+		return false
+	}
+	return true
+}
+
+// getPinnedKey returns the fingerprint of the key pinned by the administrator for the announcements of the cluster,
+// empty if there is none
+func (r *ForeignClusterReconciler) getPinnedKey(clusterID string) string {
+	if r.DiscoveryCtrl == nil || r.DiscoveryCtrl.Config == nil {
+		return ""
+	}
+	return r.DiscoveryCtrl.Config.PinnedKeys[clusterID]
+}
@@ -0,0 +1,140 @@
+package foreign_cluster_operator
+
+import (
+	"context"
+	configv1alpha1 "github.com/liqotech/liqo/apis/config/v1alpha1"
+	discoveryv1alpha1 "github.com/liqotech/liqo/apis/discovery/v1alpha1"
+	"github.com/liqotech/liqo/internal/discovery"
+	"github.com/liqotech/liqo/pkg/auth"
+	"github.com/liqotech/liqo/pkg/crdClient"
+	"github.com/stretchr/testify/assert"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
+	"testing"
+)
+
+func getCAPEM(data string) []byte {
+	return []byte("-----BEGIN CERTIFICATE-----\n" + data + "\n-----END CERTIFICATE-----\n")
+}
+
+func setForeignCA(t *testing.T, client *crdClient.CRDClient, caPEM []byte) {
+	secret := &apiv1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "fc-ca-data", Namespace: "liqo"},
+		Data:       map[string][]byte{"caData": caPEM},
+	}
+	_, err := client.Client().CoreV1().Secrets("liqo").Update(context.TODO(), secret, metav1.UpdateOptions{})
+	if err != nil {
+		_, err = client.Client().CoreV1().Secrets("liqo").Create(context.TODO(), secret, metav1.CreateOptions{})
+	}
+	assert.Nil(t, err)
+}
+
+func TestCAPinning(t *testing.T) {
+	crdClient.Fake = true
+	client, err := crdClient.NewFromConfig(&rest.Config{ContentConfig: rest.ContentConfig{GroupVersion: &discoveryv1alpha1.GroupVersion}})
+	assert.Nil(t, err)
+	r := &ForeignClusterReconciler{crdClient: client}
+
+	fc := &discoveryv1alpha1.ForeignCluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "fc"},
+		Status: discoveryv1alpha1.ForeignClusterStatus{
+			TrustMode: discoveryv1alpha1.TrustModeUntrusted,
+			Outgoing: discoveryv1alpha1.Outgoing{
+				CaDataRef: &apiv1.ObjectReference{Name: "fc-ca-data", Namespace: "liqo"},
+			},
+		},
+	}
+
+	//the CA is pinned at the first join
+	setForeignCA(t, client, getCAPEM("Y2E="))
+	assert.Nil(t, r.pinCA(fc))
+	assert.NotNil(t, fc.Status.PinnedCA)
+	assert.Equal(t, auth.CAFingerprint(getCAPEM("Y2E=")), fc.Status.PinnedCA.Fingerprint)
+	assert.Nil(t, r.pinCA(fc))
+
+	//a different CA is refused
+	setForeignCA(t, client, getCAPEM("Y2Ey"))
+	err = r.pinCA(fc)
+	assert.NotNil(t, err)
+	assert.True(t, handleCAMismatch(fc, err))
+	assert.Equal(t, auth.CAFingerprint(getCAPEM("Y2E=")), fc.Status.PinnedCA.Fingerprint)
+	requireUpdate := false
+	refused, err := r.checkCAPinning(fc, &requireUpdate)
+	assert.Nil(t, err)
+	assert.True(t, refused)
+	assert.False(t, requireUpdate)
+
+	//until the administrator pins it again
+	fc.Annotations = map[string]string{discoveryv1alpha1.RepinCAAnnotation: "true"}
+	refused, err = r.checkCAPinning(fc, &requireUpdate)
+	assert.Nil(t, err)
+	assert.False(t, refused)
+	assert.True(t, requireUpdate)
+	assert.Nil(t, fc.Status.PinnedCA)
+	assert.Nil(t, fc.Status.Outgoing.CaDataRef)
+	assert.Nil(t, fc.GetCondition(discoveryv1alpha1.CAMismatchCondition))
+	assert.Empty(t, fc.Annotations[discoveryv1alpha1.RepinCAAnnotation])
+	_, err = client.Client().CoreV1().Secrets("liqo").Get(context.TODO(), "fc-ca-data", metav1.GetOptions{})
+	assert.NotNil(t, err)
+}
+
+func TestHandleOtherErrors(t *testing.T) {
+	fc := &discoveryv1alpha1.ForeignCluster{}
+	assert.False(t, handleCAMismatch(fc, nil))
+	assert.False(t, handleCAMismatch(fc, context.DeadlineExceeded))
+	assert.Empty(t, fc.Status.Conditions)
+}
+
+func TestAnnouncedCA(t *testing.T) {
+	crdClient.Fake = true
+	client, err := crdClient.NewFromConfig(&rest.Config{ContentConfig: rest.ContentConfig{GroupVersion: &discoveryv1alpha1.GroupVersion}})
+	assert.Nil(t, err)
+	r := &ForeignClusterReconciler{crdClient: client}
+
+	fc := &discoveryv1alpha1.ForeignCluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "fc"},
+		Spec: discoveryv1alpha1.ForeignClusterSpec{
+			ClusterIdentity: discoveryv1alpha1.ClusterIdentity{ClusterID: "fc-id"},
+			DiscoveryType:   discoveryv1alpha1.LanDiscovery,
+		},
+		Status: discoveryv1alpha1.ForeignClusterStatus{
+			TrustMode: discoveryv1alpha1.TrustModeUntrusted,
+			Outgoing: discoveryv1alpha1.Outgoing{
+				CaDataRef: &apiv1.ObjectReference{Name: "fc-ca-data", Namespace: "liqo"},
+			},
+		},
+	}
+	//the unsigned clusters discovered in the LAN are not joined automatically
+	assert.False(t, r.isAuthenticated(fc))
+	//neither the ones signed with a key asserted only by the announcement
+	_, err = fc.SetAnnouncement("key", auth.CAFingerprint(getCAPEM("Y2E=")))
+	assert.Nil(t, err)
+	assert.False(t, r.isAuthenticated(fc))
+	//only the ones signed with the key pinned by the administrator
+	r.DiscoveryCtrl = &discovery.DiscoveryCtrl{Config: &configv1alpha1.DiscoveryConfig{
+		PinnedKeys: map[string]string{"fc-id": "key"},
+	}}
+	assert.True(t, r.isAuthenticated(fc))
+	fc.Status.Identity = &discoveryv1alpha1.IdentityStatus{Verification: discoveryv1alpha1.IdentityVerified, KeyFingerprint: "other"}
+	assert.False(t, r.isAuthenticated(fc))
+	fc.Status.Identity = nil
+
+	//the CA loaded from the foreign cluster has to be the announced one
+	setForeignCA(t, client, getCAPEM("Y2Ey"))
+	err = r.pinCA(fc)
+	assert.NotNil(t, err)
+	assert.True(t, handleCAMismatch(fc, err))
+	assert.Nil(t, fc.Status.PinnedCA)
+
+	setForeignCA(t, client, getCAPEM("Y2E="))
+	assert.Nil(t, r.pinCA(fc))
+	assert.NotNil(t, fc.Status.PinnedCA)
+
+	//the key is pinned again with the CA
+	fc.Annotations = map[string]string{discoveryv1alpha1.RepinCAAnnotation: "true"}
+	requireUpdate := false
+	_, err = r.checkCAPinning(fc, &requireUpdate)
+	assert.Nil(t, err)
+	assert.Nil(t, fc.Status.Announcement)
+}
@@ -3,15 +3,21 @@ package discovery
 import (
 	"context"
 	"errors"
+	"fmt"
 	"github.com/liqotech/liqo/apis/discovery/v1alpha1"
+	"github.com/liqotech/liqo/pkg/auth"
 	k8serror "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/client-go/util/retry"
 	"k8s.io/klog"
 	"strings"
+	"time"
 )
 
+// maximum time to get the identity of a discovered cluster from its auth-service
+const identityRequestTimeout = 10 * time.Second
+
 // 1. checks if cluster ID is already known
 // 2. if not exists, create it
 // 3. else
@@ -19,7 +25,15 @@ import (
 //   3b. else it is ok
 
 func (discovery *DiscoveryCtrl) UpdateForeignLAN(data *discoveryData) {
-	discoveryType := v1alpha1.LanDiscovery
+	discovery.updateForeign(data, v1alpha1.LanDiscovery)
+}
+
+// UpdateForeignRegistry creates or updates the ForeignCluster of a cluster announced in the registry cluster
+func (discovery *DiscoveryCtrl) UpdateForeignRegistry(data *discoveryData) {
+	discovery.updateForeign(data, v1alpha1.RegistryDiscovery)
+}
+
+func (discovery *DiscoveryCtrl) updateForeign(data *discoveryData, discoveryType v1alpha1.DiscoveryType) {
 	if data.TxtData.ID == discovery.ClusterId.GetClusterID() {
 		// is local cluster
 		return
@@ -67,7 +81,12 @@ func (discovery *DiscoveryCtrl) UpdateForeignWAN(data []*TxtData, sd *v1alpha1.S
 func (discovery *DiscoveryCtrl) createOrUpdate(data *discoveryData, sd *v1alpha1.SearchDomain, discoveryType v1alpha1.DiscoveryType, createdUpdatedForeign *[]*v1alpha1.ForeignCluster) error {
 	fc, err := discovery.GetForeignClusterByID(data.TxtData.ID)
 	if k8serror.IsNotFound(err) {
-		fc, err := discovery.createForeign(data, sd, discoveryType)
+		identity, err := verifyIdentity(data)
+		if err != nil {
+			klog.Errorf("the cluster %s has not been added: %v", data.TxtData.ID, err)
+			return err
+		}
+		fc, err := discovery.createForeign(data, sd, discoveryType, identity)
 		if err != nil {
 			klog.Error(err)
 			return err
@@ -99,7 +118,7 @@ func (discovery *DiscoveryCtrl) createOrUpdate(data *discoveryData, sd *v1alpha1
 	return nil
 }
 
-func (discovery *DiscoveryCtrl) createForeign(data *discoveryData, sd *v1alpha1.SearchDomain, discoveryType v1alpha1.DiscoveryType) (*v1alpha1.ForeignCluster, error) {
+func (discovery *DiscoveryCtrl) createForeign(data *discoveryData, sd *v1alpha1.SearchDomain, discoveryType v1alpha1.DiscoveryType, identity *v1alpha1.IdentityStatus) (*v1alpha1.ForeignCluster, error) {
 	fc := &v1alpha1.ForeignCluster{
 		ObjectMeta: metav1.ObjectMeta{
 			Name: data.TxtData.ID,
@@ -127,10 +146,25 @@ func (discovery *DiscoveryCtrl) createForeign(data *discoveryData, sd *v1alpha1.
 			},
 		}
 	}
-	if discoveryType == v1alpha1.LanDiscovery {
+	if hasTTL(discoveryType) {
 		// set TTL
 		fc.Status.Ttl = data.TxtData.Ttl
 	}
+	if isAnnounced(discoveryType) {
+		if err := discovery.checkPinnedKey(data); err != nil {
+			return nil, err
+		}
+		// the key of a signed announcement is pinned
+		if _, err := fc.SetAnnouncement(data.TxtData.KeyFingerprint, data.TxtData.CAFingerprint); err != nil {
+			return nil, err
+		}
+	}
+	if identity != nil {
+		// the key signing the identity is pinned
+		if err := fc.SetIdentity(identity); err != nil {
+			return nil, err
+		}
+	}
 	tmp, err := discovery.crdClient.Resource("foreignclusters").Create(fc, metav1.CreateOptions{})
 	if err != nil {
 		klog.Error(err, err.Error())
@@ -143,20 +177,120 @@ func (discovery *DiscoveryCtrl) createForeign(data *discoveryData, sd *v1alpha1.
 	return fc, err
 }
 
+// verifyIdentity checks that the auth-service of the discovered cluster, if known, presents a signed identity matching
+// the discovered data, and that the API server presents a certificate issued by the CA stated in it. It returns the
+// identity to record in the ForeignCluster, nil if the cluster has been discovered without an auth-service (i.e. in
+// the WAN). The clusters whose auth-service does not serve the identity are recorded as unverified.
+func verifyIdentity(data *discoveryData) (*v1alpha1.IdentityStatus, error) {
+	if data.AuthData == nil || !data.AuthData.IsComplete() {
+		return nil, nil
+	}
+	identity, keyFingerprint, err := auth.FetchIdentity(data.AuthData.GetURL(), identityRequestTimeout)
+	if errors.Is(err, auth.ErrIdentityNotServed) {
+		klog.Warningf("the identity of the cluster %s cannot be verified: %v", data.TxtData.ID, err)
+		return &v1alpha1.IdentityStatus{Verification: v1alpha1.IdentityUnverified}, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("cannot verify the identity: %w", err)
+	}
+	if identity.ClusterID != data.TxtData.ID {
+		return nil, fmt.Errorf("the auth-service presents the identity of the cluster %s", identity.ClusterID)
+	}
+	if identity.ClusterName != "" && data.TxtData.Name != "" && identity.ClusterName != data.TxtData.Name {
+		return nil, fmt.Errorf("the auth-service presents the cluster name %s instead of %s", identity.ClusterName, data.TxtData.Name)
+	}
+	// the identity is bound to the announcement by the key of the cluster identity, which signs both
+	if data.TxtData.KeyFingerprint != "" && keyFingerprint != data.TxtData.KeyFingerprint {
+		return nil, fmt.Errorf("the identity is signed with the key %s, the announcement with %s", keyFingerprint, data.TxtData.KeyFingerprint)
+	}
+	if err = verifyIdentityCA(identity, data.TxtData); err != nil {
+		return nil, err
+	}
+	klog.V(4).Infof("identity of the cluster %s verified with the key %s", identity.ClusterID, keyFingerprint)
+	return &v1alpha1.IdentityStatus{
+		Verification:   v1alpha1.IdentityVerified,
+		KeyFingerprint: keyFingerprint,
+	}, nil
+}
+
+// verifyIdentityCA checks that the CA stated in the identity is the one the API server of the cluster presents
+func verifyIdentityCA(identity *auth.Identity, txtData *TxtData) error {
+	if identity.CAFingerprint == "" {
+		return nil
+	}
+	if auth.CAFingerprint(identity.CAData) != identity.CAFingerprint {
+		return fmt.Errorf("the auth-service presents the CA %s without its certificate", identity.CAFingerprint)
+	}
+	if txtData.CAFingerprint != "" && txtData.CAFingerprint != identity.CAFingerprint {
+		return fmt.Errorf("the auth-service presents the CA %s, the announcement states %s", identity.CAFingerprint, txtData.CAFingerprint)
+	}
+	if err := auth.CheckServerCA(txtData.ApiUrl, identity.CAData, identityRequestTimeout); err != nil {
+		return fmt.Errorf("the API server %s does not present a certificate issued by the CA %s of the identity: %w", txtData.ApiUrl, identity.CAFingerprint, err)
+	}
+	return nil
+}
+
+// checkPinnedKey returns an error if the administrator pinned a key for the announcements of the cluster, and the
+// announcement is not signed with it
+func (discovery *DiscoveryCtrl) checkPinnedKey(data *discoveryData) error {
+	if discovery.Config == nil {
+		return nil
+	}
+	pinned, ok := discovery.Config.PinnedKeys[data.TxtData.ID]
+	if !ok || pinned == data.TxtData.KeyFingerprint {
+		return nil
+	}
+	if data.TxtData.KeyFingerprint == "" {
+		return fmt.Errorf("the announcement is not signed, the administrator pinned the key %s", pinned)
+	}
+	return fmt.Errorf("the announcement is signed with the key %s instead of the %s pinned by the administrator", data.TxtData.KeyFingerprint, pinned)
+}
+
+// hasTTL returns true if the clusters are announced with a TTL by the discovery type
+func hasTTL(discoveryType v1alpha1.DiscoveryType) bool {
+	return discoveryType == v1alpha1.LanDiscovery || discoveryType == v1alpha1.WanDiscovery || discoveryType == v1alpha1.RegistryDiscovery
+}
+
+// isAnnounced returns true if the clusters are discovered from their TXT records or their ClusterAnnouncements,
+// which can be signed
+func isAnnounced(discoveryType v1alpha1.DiscoveryType) bool {
+	return discoveryType == v1alpha1.LanDiscovery || discoveryType == v1alpha1.WanDiscovery || discoveryType == v1alpha1.RegistryDiscovery
+}
+
 // indicates that the remote cluster changed location, we have to reload all our infos about the remote cluster
 func needsToDeleteRemoteResources(fc *v1alpha1.ForeignCluster, data *discoveryData) bool {
 	return fc.Spec.ApiUrl != data.TxtData.ApiUrl || fc.Spec.Namespace != data.TxtData.Namespace
 }
 
 func (discovery *DiscoveryCtrl) CheckUpdate(data *discoveryData, fc *v1alpha1.ForeignCluster, discoveryType v1alpha1.DiscoveryType, searchDomain *v1alpha1.SearchDomain) (fcUpdated *v1alpha1.ForeignCluster, updated bool, err error) {
+	if isAnnounced(discoveryType) {
+		if err = discovery.checkPinnedKey(data); err != nil {
+			return nil, false, fmt.Errorf("announcement of the ForeignCluster %s refused: %w", fc.Name, err)
+		}
+		// once the key is pinned, the announcements which are not signed with it are ignored
+		if _, err = fc.SetAnnouncement(data.TxtData.KeyFingerprint, data.TxtData.CAFingerprint); err != nil {
+			return nil, false, fmt.Errorf("announcement of the ForeignCluster %s refused: %w", fc.Name, err)
+		}
+	}
 	needsToReload := needsToDeleteRemoteResources(fc, data)
+	if fc.Status.Identity == nil || needsToReload {
+		// the identity is verified again when the cluster moves, it has to be signed with the pinned key
+		identity, err := verifyIdentity(data)
+		if err != nil {
+			return nil, false, fmt.Errorf("identity of the ForeignCluster %s refused: %w", fc.Name, err)
+		}
+		if identity != nil {
+			if err = fc.SetIdentity(identity); err != nil {
+				return nil, false, fmt.Errorf("identity of the ForeignCluster %s refused: %w", fc.Name, err)
+			}
+		}
+	}
 	higherPriority := fc.HasHigherPriority(discoveryType) // the remote cluster didn't move, but we discovered it with an higher priority discovery type
 	if needsToReload || higherPriority {
 		// something is changed in ForeignCluster specs, update it
 		fc.Spec.ApiUrl = data.TxtData.ApiUrl
 		fc.Spec.Namespace = data.TxtData.Namespace
 		fc.Spec.DiscoveryType = discoveryType
-		if higherPriority && discoveryType == v1alpha1.LanDiscovery {
+		if higherPriority && (discoveryType == v1alpha1.LanDiscovery || discoveryType == v1alpha1.RegistryDiscovery) {
 			// if the cluster was previously discovered with IncomingPeering discovery type, set join flag accordingly to LanDiscovery sets and set TTL
 			fc.Spec.Join = discovery.Config.AutoJoin
 			fc.Status.Ttl = data.TxtData.Ttl
@@ -214,6 +348,9 @@ func (discovery *DiscoveryCtrl) CheckUpdate(data *discoveryData, fc *v1alpha1.Fo
 	} else {
 		// update "lastUpdate" annotation
 		fc.LastUpdateNow()
+		if fc.Spec.DiscoveryType == discoveryType && hasTTL(discoveryType) {
+			fc.Status.Ttl = data.TxtData.Ttl
+		}
 		tmp, err := discovery.crdClient.Resource("foreignclusters").Update(fc.Name, fc, metav1.UpdateOptions{})
 		if err != nil {
 			if !k8serror.IsConflict(err) {
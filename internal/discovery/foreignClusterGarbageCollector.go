@@ -2,24 +2,32 @@ package discovery
 
 import (
 	goerrors "errors"
+	"fmt"
+	configv1alpha1 "github.com/liqotech/liqo/apis/config/v1alpha1"
 	"github.com/liqotech/liqo/apis/discovery/v1alpha1"
+	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/klog"
-	"strings"
 	"time"
 )
 
+// default grace periods of the discovery types, the missing ones are not collected
+var defaultGracePeriods = map[v1alpha1.DiscoveryType]time.Duration{
+	v1alpha1.LanDiscovery:      0,
+	v1alpha1.WanDiscovery:      5 * time.Minute,
+	v1alpha1.RegistryDiscovery: 5 * time.Minute,
+}
+
 func (discovery *DiscoveryCtrl) StartGarbageCollector() {
 	for range time.NewTicker(30 * time.Second).C {
 		_ = discovery.CollectGarbage()
 	}
 }
 
-// The GarbageCollector deletes all ForeignClusters discovered with LAN that have expired TTL
+// The GarbageCollector deletes the ForeignClusters which have not been seen by their discovery source within their
+// TTL and the grace period of their discovery type. The peered ones are handled according to the PeeredPolicy.
 func (discovery *DiscoveryCtrl) CollectGarbage() error {
-	tmp, err := discovery.crdClient.Resource("foreignclusters").List(metav1.ListOptions{
-		LabelSelector: strings.Join([]string{"discovery-type", string(v1alpha1.LanDiscovery)}, "="),
-	})
+	tmp, err := discovery.crdClient.Resource("foreignclusters").List(metav1.ListOptions{})
 	if err != nil {
 		klog.Error(err)
 		return err
@@ -31,10 +39,36 @@ func (discovery *DiscoveryCtrl) CollectGarbage() error {
 		return err
 	}
 
-	for _, fc := range fcs.Items {
-		if fc.IsExpired() {
+	for i := range fcs.Items {
+		fc := &fcs.Items[i]
+		gracePeriod, enabled := discovery.getGracePeriod(fc.Spec.DiscoveryType)
+		if !enabled {
+			continue
+		}
+
+		var update bool
+		if !fc.IsExpiredAfter(gracePeriod) {
+			// it has been seen again, the peerings disabled when it expired are restored
+			restored, err := fc.RestorePeerings()
+			if err != nil {
+				klog.Error(err)
+			} else if restored {
+				klog.Infof("ForeignCluster %s has been seen again, restoring its peerings", fc.Name)
+			}
+			update = fc.RemoveCondition(v1alpha1.ExpiredCondition) || restored
+		} else if fc.IsPeered() {
+			update = discovery.handleExpiredPeered(fc)
+		} else {
 			klog.V(4).Infof("delete foreignCluster %v (TTL expired)", fc.Name)
 			err = discovery.crdClient.Resource("foreignclusters").Delete(fc.Name, metav1.DeleteOptions{})
+			if err != nil {
+				klog.Error(err)
+			}
+			continue
+		}
+
+		if update {
+			_, err = discovery.crdClient.Resource("foreignclusters").Update(fc.Name, fc, metav1.UpdateOptions{})
 			if err != nil {
 				klog.Error(err)
 				continue
@@ -43,3 +77,57 @@ func (discovery *DiscoveryCtrl) CollectGarbage() error {
 	}
 	return nil
 }
+
+// handleExpiredPeered applies the PeeredPolicy to an expired ForeignCluster which is peered, it returns true if the
+// ForeignCluster has to be updated
+func (discovery *DiscoveryCtrl) handleExpiredPeered(fc *v1alpha1.ForeignCluster) bool {
+	lastSeen, _ := fc.GetLastSeen()
+	message := fmt.Sprintf("the cluster has not been seen since %s", lastSeen.Format(time.RFC3339))
+	switch discovery.getPeeredPolicy() {
+	case configv1alpha1.ExpiredPeeredKeep:
+		klog.V(4).Infof("ForeignCluster %s is expired, it is kept because it is peered", fc.Name)
+		return false
+	case configv1alpha1.ExpiredPeeredUnpeer:
+		// the settings are recorded, to restore them if the cluster is seen again before being deleted
+		update, err := fc.DisablePeerings()
+		if err != nil {
+			klog.Error(err)
+			return false
+		}
+		if update {
+			klog.Infof("ForeignCluster %s is expired, unpeering it", fc.Name)
+		}
+		return fc.SetCondition(v1alpha1.Condition{
+			Type:    v1alpha1.ExpiredCondition,
+			Status:  v1.ConditionTrue,
+			Reason:  "Unpeering",
+			Message: message + ", it will be deleted when the peering is over",
+		}) || update
+	default:
+		klog.Warningf("ForeignCluster %s is expired, it is kept because it is peered", fc.Name)
+		return fc.SetCondition(v1alpha1.Condition{
+			Type:    v1alpha1.ExpiredCondition,
+			Status:  v1.ConditionTrue,
+			Reason:  "Peered",
+			Message: message + ", it is kept because it is peered",
+		})
+	}
+}
+
+// getGracePeriod returns the grace period of the discovery type, and false if its ForeignClusters are not collected
+func (discovery *DiscoveryCtrl) getGracePeriod(discoveryType v1alpha1.DiscoveryType) (time.Duration, bool) {
+	if discovery.Config != nil && discovery.Config.ForeignClusterGC != nil {
+		if seconds, ok := discovery.Config.ForeignClusterGC.GracePeriods[string(discoveryType)]; ok {
+			return time.Duration(seconds) * time.Second, seconds >= 0
+		}
+	}
+	gracePeriod, ok := defaultGracePeriods[discoveryType]
+	return gracePeriod, ok
+}
+
+func (discovery *DiscoveryCtrl) getPeeredPolicy() configv1alpha1.ExpiredPeeredPolicy {
+	if discovery.Config == nil || discovery.Config.ForeignClusterGC == nil || discovery.Config.ForeignClusterGC.PeeredPolicy == "" {
+		return configv1alpha1.ExpiredPeeredWarn
+	}
+	return discovery.Config.ForeignClusterGC.PeeredPolicy
+}
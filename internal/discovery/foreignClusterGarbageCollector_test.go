@@ -0,0 +1,119 @@
+package discovery
+
+import (
+	configv1alpha1 "github.com/liqotech/liqo/apis/config/v1alpha1"
+	"github.com/liqotech/liqo/apis/discovery/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"testing"
+	"time"
+)
+
+func TestGetGracePeriod(t *testing.T) {
+	discovery := &DiscoveryCtrl{Config: &configv1alpha1.DiscoveryConfig{}}
+
+	gracePeriod, enabled := discovery.getGracePeriod(v1alpha1.LanDiscovery)
+	assert.True(t, enabled)
+	assert.Equal(t, time.Duration(0), gracePeriod)
+	gracePeriod, enabled = discovery.getGracePeriod(v1alpha1.WanDiscovery)
+	assert.True(t, enabled)
+	assert.Equal(t, 5*time.Minute, gracePeriod)
+	_, enabled = discovery.getGracePeriod(v1alpha1.ManualDiscovery)
+	assert.False(t, enabled)
+	assert.Equal(t, configv1alpha1.ExpiredPeeredWarn, discovery.getPeeredPolicy())
+
+	discovery.Config.ForeignClusterGC = &configv1alpha1.ForeignClusterGCConfig{
+		GracePeriods: map[string]int64{
+			string(v1alpha1.WanDiscovery):    -1,
+			string(v1alpha1.ManualDiscovery): 3600,
+		},
+		PeeredPolicy: configv1alpha1.ExpiredPeeredUnpeer,
+	}
+	_, enabled = discovery.getGracePeriod(v1alpha1.WanDiscovery)
+	assert.False(t, enabled)
+	gracePeriod, enabled = discovery.getGracePeriod(v1alpha1.ManualDiscovery)
+	assert.True(t, enabled)
+	assert.Equal(t, time.Hour, gracePeriod)
+	gracePeriod, enabled = discovery.getGracePeriod(v1alpha1.RegistryDiscovery)
+	assert.True(t, enabled)
+	assert.Equal(t, 5*time.Minute, gracePeriod)
+	assert.Equal(t, configv1alpha1.ExpiredPeeredUnpeer, discovery.getPeeredPolicy())
+}
+
+func TestIsExpiredAfter(t *testing.T) {
+	fc := &v1alpha1.ForeignCluster{
+		Status: v1alpha1.ForeignClusterStatus{
+			Ttl: 60,
+		},
+	}
+	// never seen
+	assert.False(t, fc.IsExpiredAfter(0))
+
+	fc.LastUpdateNow()
+	assert.False(t, fc.IsExpiredAfter(0))
+	fc.Status.LastSeen = metav1.NewTime(time.Now().Add(-2 * time.Minute))
+	assert.True(t, fc.IsExpiredAfter(0))
+	assert.False(t, fc.IsExpiredAfter(5*time.Minute))
+
+	// set by the previous versions
+	fc.Status.LastSeen = metav1.Time{}
+	fc.Annotations[v1alpha1.LastUpdateAnnotation] = "0"
+	assert.True(t, fc.IsExpired())
+}
+
+func TestHandleExpiredPeered(t *testing.T) {
+	getForeignCluster := func() *v1alpha1.ForeignCluster {
+		return &v1alpha1.ForeignCluster{
+			ObjectMeta: metav1.ObjectMeta{Name: "fc"},
+			Spec: v1alpha1.ForeignClusterSpec{
+				Join: true,
+			},
+			Status: v1alpha1.ForeignClusterStatus{
+				LastSeen: metav1.NewTime(time.Now().Add(-time.Hour)),
+				Outgoing: v1alpha1.Outgoing{Joined: true},
+			},
+		}
+	}
+	discovery := &DiscoveryCtrl{Config: &configv1alpha1.DiscoveryConfig{
+		ForeignClusterGC: &configv1alpha1.ForeignClusterGCConfig{},
+	}}
+
+	// warn
+	fc := getForeignCluster()
+	assert.True(t, fc.IsPeered())
+	assert.True(t, discovery.handleExpiredPeered(fc))
+	condition := fc.GetCondition(v1alpha1.ExpiredCondition)
+	assert.NotNil(t, condition)
+	assert.Equal(t, v1.ConditionTrue, condition.Status)
+	assert.Equal(t, "Peered", condition.Reason)
+	assert.True(t, fc.Spec.Join)
+	assert.False(t, discovery.handleExpiredPeered(fc))
+
+	// keep
+	discovery.Config.ForeignClusterGC.PeeredPolicy = configv1alpha1.ExpiredPeeredKeep
+	fc = getForeignCluster()
+	assert.False(t, discovery.handleExpiredPeered(fc))
+	assert.Nil(t, fc.GetCondition(v1alpha1.ExpiredCondition))
+	assert.True(t, fc.Spec.Join)
+
+	// unpeer
+	discovery.Config.ForeignClusterGC.PeeredPolicy = configv1alpha1.ExpiredPeeredUnpeer
+	fc = getForeignCluster()
+	assert.True(t, discovery.handleExpiredPeered(fc))
+	assert.False(t, fc.Spec.Join)
+	assert.False(t, fc.IsIncomingAllowed())
+	assert.Equal(t, "Unpeering", fc.GetCondition(v1alpha1.ExpiredCondition).Reason)
+	assert.False(t, discovery.handleExpiredPeered(fc))
+
+	// the settings are restored when the cluster is seen again
+	restored, err := fc.RestorePeerings()
+	assert.Nil(t, err)
+	assert.True(t, restored)
+	assert.True(t, fc.Spec.Join)
+	assert.Nil(t, fc.Spec.AllowIncoming)
+	assert.NotContains(t, fc.Annotations, v1alpha1.ExpiredPeeringAnnotation)
+	restored, err = fc.RestorePeerings()
+	assert.Nil(t, err)
+	assert.False(t, restored)
+}
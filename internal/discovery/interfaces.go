@@ -0,0 +1,111 @@
+package discovery
+
+import (
+	"fmt"
+	"github.com/grandcat/zeroconf"
+	configv1alpha1 "github.com/liqotech/liqo/apis/config/v1alpha1"
+	"net"
+	"path/filepath"
+)
+
+// interfaceFilter selects the network interfaces and the addresses used by mDNS, a nil filter accepts everything
+type interfaceFilter struct {
+	include  []string
+	exclude  []string
+	allowed  []*net.IPNet
+	excluded []*net.IPNet
+}
+
+func newInterfaceFilter(config *configv1alpha1.InterfacesConfig) (*interfaceFilter, error) {
+	if config == nil {
+		return nil, nil
+	}
+	filter := &interfaceFilter{
+		include: config.Include,
+		exclude: config.Exclude,
+	}
+	var err error
+	if filter.allowed, err = parseCIDRs(config.AllowedCIDRs); err != nil {
+		return nil, err
+	}
+	if filter.excluded, err = parseCIDRs(config.ExcludedCIDRs); err != nil {
+		return nil, err
+	}
+	for _, pattern := range append(config.Include, config.Exclude...) {
+		if _, err = filepath.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid interface pattern %q: %v", pattern, err)
+		}
+	}
+	return filter, nil
+}
+
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	res := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, ipnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, ipnet)
+	}
+	return res, nil
+}
+
+// matchInterface returns true if the interface can be used
+func (filter *interfaceFilter) matchInterface(name string) bool {
+	if filter == nil {
+		return true
+	}
+	if matchName(filter.exclude, name) {
+		return false
+	}
+	return len(filter.include) == 0 || matchName(filter.include, name)
+}
+
+// matchIP returns true if the address can be used
+func (filter *interfaceFilter) matchIP(ip net.IP) bool {
+	if filter == nil {
+		return true
+	}
+	if contains(filter.excluded, ip) {
+		return false
+	}
+	return len(filter.allowed) == 0 || contains(filter.allowed, ip)
+}
+
+// filterEntry removes from the resolved entry the addresses which cannot be used
+func (filter *interfaceFilter) filterEntry(entry *zeroconf.ServiceEntry) {
+	if filter == nil {
+		return
+	}
+	entry.AddrIPv4 = filter.filterIPs(entry.AddrIPv4)
+	entry.AddrIPv6 = filter.filterIPs(entry.AddrIPv6)
+}
+
+func (filter *interfaceFilter) filterIPs(ips []net.IP) []net.IP {
+	var res []net.IP
+	for _, ip := range ips {
+		if filter.matchIP(ip) {
+			res = append(res, ip)
+		}
+	}
+	return res
+}
+
+func matchName(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(nets []*net.IPNet, ip net.IP) bool {
+	for _, ipnet := range nets {
+		if ipnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,96 @@
+package discovery
+
+import (
+	"github.com/grandcat/zeroconf"
+	configv1alpha1 "github.com/liqotech/liqo/apis/config/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+)
+
+func TestInterfaceFilter(t *testing.T) {
+	// no configuration
+	filter, err := newInterfaceFilter(nil)
+	assert.Nil(t, err)
+	assert.Nil(t, filter)
+	assert.True(t, filter.matchInterface("eth0"))
+	assert.True(t, filter.matchIP(net.ParseIP("10.0.0.1")))
+
+	filter, err = newInterfaceFilter(&configv1alpha1.InterfacesConfig{
+		Include:       []string{"eth*", "ens3"},
+		Exclude:       []string{"eth2"},
+		AllowedCIDRs:  []string{"10.0.0.0/8"},
+		ExcludedCIDRs: []string{"10.1.0.0/16"},
+	})
+	assert.Nil(t, err)
+	assert.True(t, filter.matchInterface("eth0"))
+	assert.True(t, filter.matchInterface("ens3"))
+	assert.False(t, filter.matchInterface("eth2"))
+	assert.False(t, filter.matchInterface("mgmt0"))
+	assert.True(t, filter.matchIP(net.ParseIP("10.0.0.1")))
+	assert.False(t, filter.matchIP(net.ParseIP("10.1.0.1")))
+	assert.False(t, filter.matchIP(net.ParseIP("192.168.0.1")))
+
+	// only the excluded lists
+	filter, err = newInterfaceFilter(&configv1alpha1.InterfacesConfig{
+		Exclude:       []string{"mgmt*"},
+		ExcludedCIDRs: []string{"192.168.100.0/24"},
+	})
+	assert.Nil(t, err)
+	assert.True(t, filter.matchInterface("eth0"))
+	assert.False(t, filter.matchInterface("mgmt0"))
+	assert.True(t, filter.matchIP(net.ParseIP("10.0.0.1")))
+	assert.False(t, filter.matchIP(net.ParseIP("192.168.100.1")))
+
+	_, err = newInterfaceFilter(&configv1alpha1.InterfacesConfig{AllowedCIDRs: []string{"10.0.0.0"}})
+	assert.NotNil(t, err)
+	_, err = newInterfaceFilter(&configv1alpha1.InterfacesConfig{Include: []string{"eth["}})
+	assert.NotNil(t, err)
+}
+
+func TestFilterEntry(t *testing.T) {
+	filter, err := newInterfaceFilter(&configv1alpha1.InterfacesConfig{
+		AllowedCIDRs: []string{"10.0.0.0/8"},
+	})
+	assert.Nil(t, err)
+	discovery := &DiscoveryCtrl{}
+
+	entry := &zeroconf.ServiceEntry{
+		AddrIPv4: []net.IP{net.ParseIP("192.168.0.1"), net.ParseIP("10.0.0.1")},
+		AddrIPv6: []net.IP{net.ParseIP("fd00::1")},
+	}
+	filter.filterEntry(entry)
+	assert.Equal(t, []net.IP{net.ParseIP("10.0.0.1")}, entry.AddrIPv4)
+	assert.Empty(t, entry.AddrIPv6)
+
+	// a cluster announced only on excluded addresses is not considered
+	entry = &zeroconf.ServiceEntry{
+		AddrIPv4: []net.IP{net.ParseIP("192.168.0.1")},
+	}
+	filter.filterEntry(entry)
+	assert.False(t, discovery.isForeign(entry.AddrIPv4))
+}
+
+func TestSelectAddresses(t *testing.T) {
+	filter, err := newInterfaceFilter(&configv1alpha1.InterfacesConfig{
+		ExcludedCIDRs: []string{"192.168.100.0/24"},
+	})
+	assert.Nil(t, err)
+	_, podNet, _ := net.ParseCIDR("10.244.0.0/16")
+	addrs := []net.Addr{
+		&net.IPNet{IP: net.ParseIP("10.0.0.1"), Mask: net.CIDRMask(24, 32)},
+		&net.IPNet{IP: net.ParseIP("192.168.100.1"), Mask: net.CIDRMask(24, 32)},
+		&net.IPNet{IP: net.ParseIP("10.244.0.1"), Mask: net.CIDRMask(24, 32)},
+		&net.IPNet{IP: net.ParseIP("fd00::1"), Mask: net.CIDRMask(64, 128)},
+		&net.IPNet{IP: net.ParseIP("fe80::1"), Mask: net.CIDRMask(64, 128)},
+	}
+
+	// the excluded and the pod addresses are not advertised, even if the interface is selected
+	ips, sel := selectAddresses(filter, []*net.IPNet{podNet}, addrs)
+	assert.True(t, sel)
+	assert.Equal(t, []string{"10.0.0.1", "fd00::1"}, ips)
+
+	// an interface with only excluded IPv4 addresses is not selected
+	_, sel = selectAddresses(filter, []*net.IPNet{podNet}, addrs[1:])
+	assert.False(t, sel)
+}
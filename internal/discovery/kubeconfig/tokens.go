@@ -0,0 +1,222 @@
+package kubeconfig
+
+import (
+	"context"
+	"fmt"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog"
+	"time"
+)
+
+const (
+	// label of the tokens minted for a ServiceAccount, containing the ClusterID of the peer they are issued to
+	RemoteClusterIDLabel = "auth.liqo.io/remote-cluster-id"
+	// annotation of the ServiceAccount of a peer, containing the name of the last token delivered to it
+	SentTokenAnnotation = "discovery.liqo.io/sent-token"
+
+	// the credentials issued to the peers are rotated after this period, if not configured otherwise
+	DefaultRotationPeriod = 30 * 24 * time.Hour
+	// the previous tokens are revoked once the current one is older than this period, leaving to all the components
+	// of the peer the time to pick it up
+	RevocationGracePeriod = 30 * time.Minute
+
+	tokenTimeout = 30 * time.Second
+)
+
+// RotateToken returns the token the remote cluster has to use to authenticate as the ServiceAccount, minting a new one
+// if the current one has been issued more than period ago. The returned token is nil if no token has been minted yet
+// and the default one of the ServiceAccount is still valid; rotated is true if a new token has been minted.
+// When the ServiceAccount is not dedicated to the remote cluster, its default token is shared with other clusters and
+// a dedicated one is minted immediately.
+func RotateToken(clientset kubernetes.Interface, namespace string, serviceAccountName string, remoteClusterID string, dedicated bool, period time.Duration) (token *corev1.Secret, rotated bool, err error) {
+	if period <= 0 {
+		period = DefaultRotationPeriod
+	}
+	serviceAccount, err := clientset.CoreV1().ServiceAccounts(namespace).Get(context.TODO(), serviceAccountName, v1.GetOptions{})
+	if err != nil {
+		return nil, false, err
+	}
+	token, err = getCurrentToken(clientset, serviceAccount, remoteClusterID)
+	if err != nil {
+		return nil, false, err
+	}
+
+	issued := serviceAccount.CreationTimestamp.Time
+	if token != nil {
+		issued = token.CreationTimestamp.Time
+	} else if !dedicated {
+		issued = time.Time{}
+	}
+	if time.Since(issued) < period {
+		return token, false, nil
+	}
+	if token, err = mintToken(clientset, serviceAccount, remoteClusterID); err != nil {
+		return nil, false, err
+	}
+	klog.Infof("new token %s minted for the credentials of cluster %s", token.Name, remoteClusterID)
+	return token, true, nil
+}
+
+// RevokePreviousTokens revokes the tokens issued to the remote cluster before the current one, once it is older than
+// RevocationGracePeriod. When the ServiceAccount is dedicated to the remote cluster also its default token is revoked,
+// else only the ones minted for it.
+func RevokePreviousTokens(clientset kubernetes.Interface, namespace string, serviceAccountName string, remoteClusterID string, dedicated bool, current *corev1.Secret) error {
+	if current == nil || time.Since(current.CreationTimestamp.Time) < RevocationGracePeriod {
+		return nil
+	}
+	serviceAccount, err := clientset.CoreV1().ServiceAccounts(namespace).Get(context.TODO(), serviceAccountName, v1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	return revokeTokens(clientset, serviceAccount, remoteClusterID, dedicated, current.Name)
+}
+
+// CurrentToken returns the newest token minted for the remote cluster, nil if the ServiceAccount still has only its
+// default token
+func CurrentToken(clientset kubernetes.Interface, namespace string, serviceAccountName string, remoteClusterID string) (*corev1.Secret, error) {
+	serviceAccount, err := clientset.CoreV1().ServiceAccounts(namespace).Get(context.TODO(), serviceAccountName, v1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return getCurrentToken(clientset, serviceAccount, remoteClusterID)
+}
+
+// MarkTokenSent records in the ServiceAccount that the token has been delivered to the remote cluster, so that the
+// previous ones can be revoked
+func MarkTokenSent(clientset kubernetes.Interface, namespace string, serviceAccountName string, tokenName string) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		serviceAccount, err := clientset.CoreV1().ServiceAccounts(namespace).Get(context.TODO(), serviceAccountName, v1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if serviceAccount.Annotations[SentTokenAnnotation] == tokenName {
+			return nil
+		}
+		if serviceAccount.Annotations == nil {
+			serviceAccount.Annotations = map[string]string{}
+		}
+		serviceAccount.Annotations[SentTokenAnnotation] = tokenName
+		_, err = clientset.CoreV1().ServiceAccounts(namespace).Update(context.TODO(), serviceAccount, v1.UpdateOptions{})
+		return err
+	})
+}
+
+func isTokenOf(secret *corev1.Secret, serviceAccount *corev1.ServiceAccount) bool {
+	return secret.Type == corev1.SecretTypeServiceAccountToken && secret.Annotations[corev1.ServiceAccountNameKey] == serviceAccount.Name
+}
+
+// getCurrentToken returns the newest token minted for the remote cluster, nil if there is none
+func getCurrentToken(clientset kubernetes.Interface, serviceAccount *corev1.ServiceAccount, remoteClusterID string) (*corev1.Secret, error) {
+	secrets, err := clientset.CoreV1().Secrets(serviceAccount.Namespace).List(context.TODO(), v1.ListOptions{
+		LabelSelector: RemoteClusterIDLabel + "=" + remoteClusterID,
+	})
+	if err != nil {
+		return nil, err
+	}
+	var current *corev1.Secret
+	for i := range secrets.Items {
+		secret := &secrets.Items[i]
+		if !isTokenOf(secret, serviceAccount) || len(secret.Data[corev1.ServiceAccountTokenKey]) == 0 {
+			continue
+		}
+		if current == nil || current.CreationTimestamp.Before(&secret.CreationTimestamp) {
+			current = secret
+		}
+	}
+	return current, nil
+}
+
+// mintToken creates a new token for the ServiceAccount, labeled with the ClusterID of the remote cluster, and waits
+// for the token controller to issue it
+func mintToken(clientset kubernetes.Interface, serviceAccount *corev1.ServiceAccount, remoteClusterID string) (*corev1.Secret, error) {
+	secret := &corev1.Secret{
+		ObjectMeta: v1.ObjectMeta{
+			GenerateName: serviceAccount.Name + "-token-",
+			Labels: map[string]string{
+				RemoteClusterIDLabel: remoteClusterID,
+			},
+			Annotations: map[string]string{
+				corev1.ServiceAccountNameKey: serviceAccount.Name,
+				corev1.ServiceAccountUIDKey:  string(serviceAccount.UID),
+			},
+		},
+		Type: corev1.SecretTypeServiceAccountToken,
+	}
+	secret, err := clientset.CoreV1().Secrets(serviceAccount.Namespace).Create(context.TODO(), secret, v1.CreateOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	// the token is referenced by the ServiceAccount, so that the token controller does not generate a new default one
+	// when the previous tokens are revoked
+	err = updateServiceAccountSecrets(clientset, serviceAccount, func(refs []corev1.ObjectReference) []corev1.ObjectReference {
+		return append(refs, corev1.ObjectReference{Name: secret.Name})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = wait.PollImmediate(time.Second, tokenTimeout, func() (bool, error) {
+		current, err := clientset.CoreV1().Secrets(secret.Namespace).Get(context.TODO(), secret.Name, v1.GetOptions{})
+		if err != nil {
+			klog.Error(err)
+			return false, nil
+		}
+		secret = current
+		return len(secret.Data[corev1.ServiceAccountTokenKey]) > 0, nil
+	})
+	if err == wait.ErrWaitTimeout {
+		err = fmt.Errorf("the token %s has not been issued", secret.Name)
+	}
+	return secret, err
+}
+
+// revokeTokens deletes the tokens of the ServiceAccount issued to the remote cluster, except the one to keep
+func revokeTokens(clientset kubernetes.Interface, serviceAccount *corev1.ServiceAccount, remoteClusterID string, dedicated bool, keep string) error {
+	secrets, err := clientset.CoreV1().Secrets(serviceAccount.Namespace).List(context.TODO(), v1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	revoked := map[string]bool{}
+	for i := range secrets.Items {
+		secret := &secrets.Items[i]
+		clusterID, minted := secret.Labels[RemoteClusterIDLabel]
+		if secret.Name == keep || !isTokenOf(secret, serviceAccount) || (minted && clusterID != remoteClusterID) || (!minted && !dedicated) {
+			continue
+		}
+		if err = clientset.CoreV1().Secrets(secret.Namespace).Delete(context.TODO(), secret.Name, v1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+		klog.Infof("token %s of the credentials of cluster %s revoked", secret.Name, remoteClusterID)
+		revoked[secret.Name] = true
+	}
+	if len(revoked) == 0 {
+		return nil
+	}
+	return updateServiceAccountSecrets(clientset, serviceAccount, func(refs []corev1.ObjectReference) []corev1.ObjectReference {
+		var kept []corev1.ObjectReference
+		for _, ref := range refs {
+			if !revoked[ref.Name] {
+				kept = append(kept, ref)
+			}
+		}
+		return kept
+	})
+}
+
+func updateServiceAccountSecrets(clientset kubernetes.Interface, serviceAccount *corev1.ServiceAccount, update func([]corev1.ObjectReference) []corev1.ObjectReference) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		current, err := clientset.CoreV1().ServiceAccounts(serviceAccount.Namespace).Get(context.TODO(), serviceAccount.Name, v1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		current.Secrets = update(current.Secrets)
+		_, err = clientset.CoreV1().ServiceAccounts(serviceAccount.Namespace).Update(context.TODO(), current, v1.UpdateOptions{})
+		return err
+	})
+}
@@ -0,0 +1,233 @@
+package kubeconfig
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	ktesting "k8s.io/client-go/testing"
+	"strconv"
+	"testing"
+	"time"
+)
+
+const (
+	namespace       = "liqo"
+	remoteClusterID = "remote-cluster-id"
+)
+
+// getClientset returns a fake clientset issuing the tokens of the ServiceAccounts, as the token controller does
+func getClientset(objects ...runtime.Object) *fake.Clientset {
+	clientset := fake.NewSimpleClientset(objects...)
+	minted := 0
+	clientset.PrependReactor("create", "secrets", func(action ktesting.Action) (bool, runtime.Object, error) {
+		secret := action.(ktesting.CreateAction).GetObject().(*corev1.Secret)
+		if secret.Name == "" {
+			minted++
+			secret.Name = secret.GenerateName + strconv.Itoa(minted)
+		}
+		secret.CreationTimestamp = v1.Now()
+		secret.Data = map[string][]byte{
+			corev1.ServiceAccountTokenKey: []byte("token-" + secret.Name),
+		}
+		return false, nil, nil
+	})
+	return clientset
+}
+
+func getServiceAccount(name string, age time.Duration, secrets ...string) *corev1.ServiceAccount {
+	sa := &corev1.ServiceAccount{
+		ObjectMeta: v1.ObjectMeta{
+			Name:              name,
+			Namespace:         namespace,
+			CreationTimestamp: v1.NewTime(time.Now().Add(-age)),
+		},
+	}
+	for _, secret := range secrets {
+		sa.Secrets = append(sa.Secrets, corev1.ObjectReference{Name: secret})
+	}
+	return sa
+}
+
+func getToken(name string, saName string, clusterID string, age time.Duration) *corev1.Secret {
+	secret := &corev1.Secret{
+		ObjectMeta: v1.ObjectMeta{
+			Name:              name,
+			Namespace:         namespace,
+			CreationTimestamp: v1.NewTime(time.Now().Add(-age)),
+			Annotations: map[string]string{
+				corev1.ServiceAccountNameKey: saName,
+			},
+		},
+		Type: corev1.SecretTypeServiceAccountToken,
+		Data: map[string][]byte{
+			corev1.ServiceAccountTokenKey: []byte("token-" + name),
+		},
+	}
+	if clusterID != "" {
+		secret.Labels = map[string]string{
+			RemoteClusterIDLabel: clusterID,
+		}
+	}
+	return secret
+}
+
+func TestRotateToken(t *testing.T) {
+	day := 24 * time.Hour
+
+	tests := []struct {
+		name      string
+		objects   []runtime.Object
+		dedicated bool
+		rotated   bool
+		current   string
+	}{
+		{
+			name:      "dedicated ServiceAccount with a valid default token",
+			objects:   []runtime.Object{getServiceAccount("sa", day, "sa-token")},
+			dedicated: true,
+			rotated:   false,
+			current:   "",
+		},
+		{
+			name:      "dedicated ServiceAccount with an expired default token",
+			objects:   []runtime.Object{getServiceAccount("sa", 31*day, "sa-token")},
+			dedicated: true,
+			rotated:   true,
+		},
+		{
+			name:      "shared ServiceAccount",
+			objects:   []runtime.Object{getServiceAccount("sa", day, "sa-token")},
+			dedicated: false,
+			rotated:   true,
+		},
+		{
+			name: "valid minted token",
+			objects: []runtime.Object{
+				getServiceAccount("sa", 31*day, "sa-token", "sa-token-old"),
+				getToken("sa-token-old", "sa", remoteClusterID, day),
+			},
+			dedicated: false,
+			rotated:   false,
+			current:   "sa-token-old",
+		},
+		{
+			name: "expired minted token",
+			objects: []runtime.Object{
+				getServiceAccount("sa", 62*day, "sa-token", "sa-token-old"),
+				getToken("sa-token-old", "sa", remoteClusterID, 31*day),
+			},
+			dedicated: true,
+			rotated:   true,
+		},
+		{
+			name: "valid token minted for another cluster",
+			objects: []runtime.Object{
+				getServiceAccount("sa", day, "sa-token", "sa-token-other"),
+				getToken("sa-token-other", "sa", "other-cluster-id", day),
+			},
+			dedicated: false,
+			rotated:   true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clientset := getClientset(test.objects...)
+
+			token, rotated, err := RotateToken(clientset, namespace, "sa", remoteClusterID, test.dedicated, 0)
+			assert.NoError(t, err)
+			assert.Equal(t, test.rotated, rotated)
+			if !test.rotated {
+				if test.current == "" {
+					assert.Nil(t, token)
+				} else {
+					assert.Equal(t, test.current, token.Name)
+				}
+				return
+			}
+
+			assert.NotNil(t, token)
+			assert.Equal(t, remoteClusterID, token.Labels[RemoteClusterIDLabel])
+			assert.Equal(t, "sa", token.Annotations[corev1.ServiceAccountNameKey])
+			assert.NotEmpty(t, token.Data[corev1.ServiceAccountTokenKey])
+
+			sa, err := clientset.CoreV1().ServiceAccounts(namespace).Get(context.TODO(), "sa", v1.GetOptions{})
+			assert.NoError(t, err)
+			assert.Contains(t, sa.Secrets, corev1.ObjectReference{Name: token.Name})
+
+			// the new token is the current one until it expires
+			current, rotated, err := RotateToken(clientset, namespace, "sa", remoteClusterID, test.dedicated, 0)
+			assert.NoError(t, err)
+			assert.False(t, rotated)
+			assert.Equal(t, token.Name, current.Name)
+		})
+	}
+}
+
+func TestRevokePreviousTokens(t *testing.T) {
+	getObjects := func(currentAge time.Duration) []runtime.Object {
+		return []runtime.Object{
+			getServiceAccount("sa", time.Hour, "sa-token", "sa-token-old", "sa-token-current", "sa-token-other"),
+			getToken("sa-token", "sa", "", time.Hour),
+			getToken("sa-token-old", "sa", remoteClusterID, time.Hour),
+			getToken("sa-token-current", "sa", remoteClusterID, currentAge),
+			getToken("sa-token-other", "sa", "other-cluster-id", time.Hour),
+		}
+	}
+	exists := func(clientset *fake.Clientset, name string) bool {
+		_, err := clientset.CoreV1().Secrets(namespace).Get(context.TODO(), name, v1.GetOptions{})
+		return err == nil
+	}
+
+	// the current token is too recent, the previous ones may still be in use
+	clientset := getClientset(getObjects(time.Minute)...)
+	current := getToken("sa-token-current", "sa", remoteClusterID, time.Minute)
+	assert.NoError(t, RevokePreviousTokens(clientset, namespace, "sa", remoteClusterID, true, current))
+	assert.True(t, exists(clientset, "sa-token"))
+	assert.True(t, exists(clientset, "sa-token-old"))
+
+	// shared ServiceAccount, only the tokens minted for the remote cluster are revoked
+	clientset = getClientset(getObjects(time.Hour)...)
+	current = getToken("sa-token-current", "sa", remoteClusterID, time.Hour)
+	assert.NoError(t, RevokePreviousTokens(clientset, namespace, "sa", remoteClusterID, false, current))
+	assert.True(t, exists(clientset, "sa-token"))
+	assert.False(t, exists(clientset, "sa-token-old"))
+	assert.True(t, exists(clientset, "sa-token-current"))
+	assert.True(t, exists(clientset, "sa-token-other"))
+	sa, err := clientset.CoreV1().ServiceAccounts(namespace).Get(context.TODO(), "sa", v1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, []corev1.ObjectReference{{Name: "sa-token"}, {Name: "sa-token-current"}, {Name: "sa-token-other"}}, sa.Secrets)
+
+	// dedicated ServiceAccount, also the default token is revoked
+	clientset = getClientset(getObjects(time.Hour)...)
+	assert.NoError(t, RevokePreviousTokens(clientset, namespace, "sa", remoteClusterID, true, current))
+	assert.False(t, exists(clientset, "sa-token"))
+	assert.False(t, exists(clientset, "sa-token-old"))
+	assert.True(t, exists(clientset, "sa-token-current"))
+	assert.True(t, exists(clientset, "sa-token-other"))
+}
+
+func TestCurrentToken(t *testing.T) {
+	clientset := getClientset(
+		getServiceAccount("sa", time.Hour, "sa-token", "sa-token-old", "sa-token-current"),
+		getToken("sa-token", "sa", "", time.Hour),
+		getToken("sa-token-old", "sa", remoteClusterID, time.Hour),
+		getToken("sa-token-current", "sa", remoteClusterID, time.Minute),
+	)
+	token, err := CurrentToken(clientset, namespace, "sa", remoteClusterID)
+	assert.NoError(t, err)
+	assert.Equal(t, "sa-token-current", token.Name)
+
+	// only the default token, no token has been minted yet
+	token, err = CurrentToken(clientset, namespace, "sa", "other-cluster-id")
+	assert.NoError(t, err)
+	assert.Nil(t, token)
+
+	assert.NoError(t, MarkTokenSent(clientset, namespace, "sa", "sa-token-current"))
+	sa, err := clientset.CoreV1().ServiceAccounts(namespace).Get(context.TODO(), "sa", v1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, "sa-token-current", sa.Annotations[SentTokenAnnotation])
+}
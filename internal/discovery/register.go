@@ -9,6 +9,7 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/klog"
 	"net"
+	"os"
 )
 
 const (
@@ -34,15 +35,27 @@ func (discovery *DiscoveryCtrl) Register() {
 			return
 		}
 
-		var ttl = discovery.Config.Ttl
+		authTxt, err := discovery.getAuthTxt(authPort)
+		if err != nil {
+			klog.Error(err)
+			return
+		}
+
+		interfaces, addresses, err := discovery.getAdvertisedInterfaces()
+		if err != nil {
+			klog.Error(err)
+			return
+		}
+
+		instance := fmt.Sprintf("%s_%s", discovery.Config.Name, discovery.ClusterId.GetClusterID())
 		discovery.serverMux.Lock()
-		discovery.mdnsServer, err = zeroconf.Register(fmt.Sprintf("%s_%s", discovery.Config.Name, discovery.ClusterId.GetClusterID()), discovery.Config.Service, discovery.Config.Domain, discovery.Config.Port, txt, discovery.getInterfaces(), ttl)
+		discovery.mdnsServer, err = discovery.registerService(instance, discovery.Config.Service, discovery.Config.Port, txt, interfaces, addresses)
 		if err != nil {
 			discovery.serverMux.Unlock()
 			klog.Error(err)
 			return
 		}
-		discovery.mdnsServerAuth, err = zeroconf.Register(fmt.Sprintf("%s_%s", discovery.Config.Name, discovery.ClusterId.GetClusterID()), discovery.Config.AuthService, discovery.Config.Domain, authPort, nil, discovery.getInterfaces(), ttl)
+		discovery.mdnsServerAuth, err = discovery.registerService(instance, discovery.Config.AuthService, authPort, authTxt, interfaces, addresses)
 		discovery.serverMux.Unlock()
 		if err != nil {
 			klog.Error(err)
@@ -53,6 +66,25 @@ func (discovery *DiscoveryCtrl) Register() {
 	}
 }
 
+// registerService announces a service on the interfaces. If the addresses are not nil, only them are advertised in the
+// A/AAAA records, instead of all the ones of the interfaces.
+func (discovery *DiscoveryCtrl) registerService(instance string, service string, port int, txt []string,
+	interfaces []net.Interface, addresses []string) (*zeroconf.Server, error) {
+	if addresses == nil {
+		return zeroconf.Register(instance, service, discovery.Config.Domain, port, txt, interfaces, discovery.Config.Ttl)
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+	server, err := zeroconf.RegisterProxy(instance, service, discovery.Config.Domain, port, hostname, addresses, txt, interfaces)
+	if err != nil {
+		return nil, err
+	}
+	server.TTL(discovery.Config.Ttl)
+	return server, nil
+}
+
 func (discovery *DiscoveryCtrl) shutdownServer() {
 	discovery.serverMux.Lock()
 	defer discovery.serverMux.Unlock()
@@ -81,43 +113,87 @@ func (discovery *DiscoveryCtrl) getAuthServicePort() (int, error) {
 	return int(svc.Spec.Ports[0].NodePort), nil
 }
 
-func (discovery *DiscoveryCtrl) getInterfaces() []net.Interface {
+// getInterfaces returns the interfaces used by mDNS, if it is nil all the multicast interfaces are used. When the
+// Interfaces filter is configured, it is an error if no interface is selected
+func (discovery *DiscoveryCtrl) getInterfaces() ([]net.Interface, error) {
+	interfaces, _, err := discovery.getAdvertisedInterfaces()
+	return interfaces, err
+}
+
+// getAdvertisedInterfaces returns the interfaces used by mDNS and the addresses to advertise on them. If the Interfaces
+// filter is not configured the addresses are nil, and all the ones of the interfaces are advertised.
+func (discovery *DiscoveryCtrl) getAdvertisedInterfaces() ([]net.Interface, []string, error) {
+	filter, err := newInterfaceFilter(discovery.Config.Interfaces)
+	if err != nil {
+		return nil, nil, err
+	}
 	var interfaces []net.Interface
+	var addresses []string
 	ifaces, err := net.Interfaces()
 	if err != nil {
-		return nil
+		return nil, nil, filterError(filter, err)
 	}
 	podNets, err := discovery.getPodNets()
 	if err != nil {
-		return nil
+		return nil, nil, filterError(filter, err)
 	}
 	for _, ifi := range ifaces {
+		if !filter.matchInterface(ifi.Name) {
+			continue
+		}
+		if (ifi.Flags&net.FlagUp) == 0 || (ifi.Flags&net.FlagMulticast) == 0 {
+			continue
+		}
 		addrs, err := ifi.Addrs()
 		if err != nil {
 			continue
 		}
 		// select interfaces with IP addresses not in pod local network
-		sel := false
-		for _, addr := range addrs {
-			ip := getIP(addr)
-			if !isPod(podNets, ip) {
-				if ip != nil && ip.To4() != nil {
-					sel = true
-				}
-			}
-		}
+		ips, sel := selectAddresses(filter, podNets, addrs)
 		if !sel {
 			continue
 		}
+		interfaces = append(interfaces, ifi)
+		addresses = append(addresses, ips...)
+	}
+	if filter == nil {
+		return interfaces, nil, nil
+	}
+	if len(interfaces) == 0 {
+		return nil, nil, errors.New("no network interface matches the mDNS interfaces configuration")
+	}
+	return interfaces, addresses, nil
+}
 
-		if (ifi.Flags & net.FlagUp) == 0 {
+// selectAddresses returns the addresses of an interface which can be advertised, excluding the pod ones and the ones
+// refused by the filter, and true if the interface has an IPv4 address among them
+func selectAddresses(filter *interfaceFilter, podNets []*net.IPNet, addrs []net.Addr) ([]string, bool) {
+	var ips []string
+	sel := false
+	for _, addr := range addrs {
+		ip := getIP(addr)
+		if ip == nil || isPod(podNets, ip) || !filter.matchIP(ip) {
+			continue
+		}
+		if ip.To4() != nil {
+			sel = true
+		} else if ip.IsLinkLocalUnicast() {
+			// they cannot be used without the zone of the interface
 			continue
 		}
-		if (ifi.Flags & net.FlagMulticast) > 0 {
-			interfaces = append(interfaces, ifi)
+		if !ip.IsLoopback() {
+			ips = append(ips, ip.String())
 		}
 	}
-	return interfaces
+	return ips, sel
+}
+
+// filterError returns the error only if the interfaces are filtered, otherwise all the interfaces can be used
+func filterError(filter *interfaceFilter, err error) error {
+	if filter == nil {
+		return nil
+	}
+	return err
 }
 
 func (discovery *DiscoveryCtrl) getPodNets() ([]*net.IPNet, error) {
@@ -0,0 +1,225 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	configv1alpha1 "github.com/liqotech/liqo/apis/config/v1alpha1"
+	"github.com/liqotech/liqo/apis/discovery/v1alpha1"
+	"github.com/liqotech/liqo/pkg/crdClient"
+	k8serror "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog"
+	"time"
+)
+
+// period after that the announcement of the local cluster is refreshed and the announcements of the other clusters
+// are processed again, to keep their ForeignClusters from expiring
+const registryRefreshPeriod = 30 * time.Second
+
+// registryClient is the connection to the registry cluster
+type registryClient struct {
+	config configv1alpha1.RegistryConfig
+	client *crdClient.CRDClient
+	// closed to stop the watcher of the announcements, nil if they are not watched
+	stop      chan struct{}
+	announced bool
+}
+
+// StartRegistry announces the local cluster in the registry cluster and watches the announcements of the other
+// clusters, according to the current configuration
+func (discovery *DiscoveryCtrl) StartRegistry() {
+	var registry *registryClient
+	for {
+		registry = discovery.syncRegistry(registry)
+		time.Sleep(registryRefreshPeriod)
+	}
+}
+
+func (discovery *DiscoveryCtrl) syncRegistry(registry *registryClient) *registryClient {
+	config := discovery.Config.Registry
+	if registry != nil && (config == nil || *config != registry.config) {
+		// the registry has been changed or disabled
+		discovery.closeRegistry(registry)
+		registry = nil
+	}
+	if config == nil {
+		return nil
+	}
+	if registry == nil {
+		client, err := discovery.getRegistryClient(config)
+		if err != nil {
+			klog.Error(err)
+			return nil
+		}
+		registry = &registryClient{
+			config: *config,
+			client: client,
+		}
+	}
+
+	if discovery.Config.EnableDiscovery && registry.stop == nil {
+		_, stop, err := crdClient.WatchResources(registry.client, "clusterannouncements", "", registryRefreshPeriod, cache.ResourceEventHandlerFuncs{
+			AddFunc: discovery.onAnnouncement,
+			UpdateFunc: func(oldObj interface{}, newObj interface{}) {
+				discovery.onAnnouncement(newObj)
+			},
+		}, metav1.ListOptions{})
+		if err != nil {
+			klog.Error(err)
+		} else {
+			registry.stop = stop
+		}
+	} else if !discovery.Config.EnableDiscovery && registry.stop != nil {
+		close(registry.stop)
+		registry.stop = nil
+	}
+
+	if discovery.Config.EnableAdvertisement {
+		if err := discovery.announce(registry.client); err != nil {
+			klog.Error(err)
+		} else {
+			registry.announced = true
+		}
+	} else if registry.announced {
+		if err := discovery.withdrawAnnouncement(registry.client); err != nil {
+			klog.Error(err)
+		} else {
+			registry.announced = false
+		}
+	}
+	return registry
+}
+
+func (discovery *DiscoveryCtrl) closeRegistry(registry *registryClient) {
+	if registry.stop != nil {
+		close(registry.stop)
+	}
+	if registry.announced {
+		if err := discovery.withdrawAnnouncement(registry.client); err != nil {
+			klog.Error(err)
+		}
+	}
+}
+
+func (discovery *DiscoveryCtrl) getRegistryClient(config *configv1alpha1.RegistryConfig) (*crdClient.CRDClient, error) {
+	secret, err := discovery.crdClient.Client().CoreV1().Secrets(discovery.Namespace).Get(context.TODO(), config.KubeconfigSecretName, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	restConfig, err := crdClient.NewKubeconfigFromSecret(secret, &v1alpha1.GroupVersion)
+	if err != nil {
+		return nil, err
+	}
+	return crdClient.NewFromConfig(restConfig)
+}
+
+// announce creates the ClusterAnnouncement of the local cluster in the registry, or refreshes its data and heartbeat
+func (discovery *DiscoveryCtrl) announce(client *crdClient.CRDClient) error {
+	txtData, err := discovery.GetTxtData()
+	if err != nil {
+		return err
+	}
+	spec := v1alpha1.ClusterAnnouncementSpec{
+		ClusterIdentity: v1alpha1.ClusterIdentity{
+			ClusterID:   txtData.ID,
+			ClusterName: txtData.Name,
+		},
+		Namespace: txtData.Namespace,
+		ApiUrl:    txtData.ApiUrl,
+		Ttl:       discovery.Config.Ttl,
+	}
+	if discovery.announcementKey != nil {
+		if err = signRegistryAnnouncement(&spec, discovery.announcementKey, discovery.caFingerprint); err != nil {
+			return err
+		}
+	}
+
+	tmp, err := client.Resource("clusterannouncements").Get(txtData.ID, metav1.GetOptions{})
+	if k8serror.IsNotFound(err) {
+		ca := &v1alpha1.ClusterAnnouncement{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: txtData.ID,
+			},
+			Spec: spec,
+			Status: v1alpha1.ClusterAnnouncementStatus{
+				LastHeartbeatTime: metav1.Now(),
+			},
+		}
+		if _, err = client.Resource("clusterannouncements").Create(ca, metav1.CreateOptions{}); err != nil {
+			return err
+		}
+		klog.Infof("ClusterAnnouncement %s created in the registry", ca.Name)
+		return nil
+	} else if err != nil {
+		return err
+	}
+	ca, ok := tmp.(*v1alpha1.ClusterAnnouncement)
+	if !ok {
+		return errors.New("retrieved object is not a ClusterAnnouncement")
+	}
+	ca.Spec = spec
+	ca.Status.LastHeartbeatTime = metav1.Now()
+	_, err = client.Resource("clusterannouncements").Update(ca.Name, ca, metav1.UpdateOptions{})
+	return err
+}
+
+func (discovery *DiscoveryCtrl) withdrawAnnouncement(client *crdClient.CRDClient) error {
+	err := client.Resource("clusterannouncements").Delete(discovery.ClusterId.GetClusterID(), metav1.DeleteOptions{})
+	if err != nil && !k8serror.IsNotFound(err) {
+		return err
+	}
+	klog.Info("ClusterAnnouncement deleted from the registry")
+	return nil
+}
+
+func (discovery *DiscoveryCtrl) onAnnouncement(obj interface{}) {
+	ca, ok := obj.(*v1alpha1.ClusterAnnouncement)
+	if !ok {
+		klog.Error("retrieved object is not a ClusterAnnouncement")
+		return
+	}
+	data, ok := getAnnouncementData(ca, discovery.ClusterId.GetClusterID())
+	if !ok {
+		return
+	}
+	discovery.UpdateForeignRegistry(data)
+}
+
+// getAnnouncementData returns the data of the announced cluster, if the announcement is valid and it is not the one of
+// the local cluster. The signature is verified as the one of the TXT records, the key is pinned in the ForeignCluster.
+func getAnnouncementData(ca *v1alpha1.ClusterAnnouncement, localClusterID string) (*discoveryData, bool) {
+	if ca.Spec.ClusterIdentity.ClusterID == localClusterID {
+		return nil, false
+	}
+	if ca.Spec.ClusterIdentity.ClusterID != ca.Name {
+		klog.Warningf("ClusterAnnouncement %s announces the cluster %s", ca.Name, ca.Spec.ClusterIdentity.ClusterID)
+		return nil, false
+	}
+	if ca.IsExpired() {
+		klog.V(4).Infof("ClusterAnnouncement %s is expired", ca.Name)
+		return nil, false
+	}
+	txtData := &TxtData{
+		ID:        ca.Spec.ClusterIdentity.ClusterID,
+		Name:      ca.Spec.ClusterIdentity.ClusterName,
+		Namespace: ca.Spec.Namespace,
+		ApiUrl:    ca.Spec.ApiUrl,
+		Ttl:       ca.Spec.Ttl,
+	}
+	if txtData.Namespace == "" || txtData.ApiUrl == "" {
+		klog.Warningf("ClusterAnnouncement %s is missing required fields", ca.Name)
+		return nil, false
+	}
+	keyFingerprint, err := verifyRegistryAnnouncement(&ca.Spec)
+	if err != nil {
+		klog.Warningf("ClusterAnnouncement %s refused: %v", ca.Name, err)
+		return nil, false
+	}
+	if keyFingerprint != "" {
+		// the CA is meaningful only if it is signed
+		txtData.KeyFingerprint = keyFingerprint
+		txtData.CAFingerprint = ca.Spec.CAFingerprint
+	}
+	return &discoveryData{TxtData: txtData}, true
+}
@@ -0,0 +1,170 @@
+package discovery
+
+import (
+	configv1alpha1 "github.com/liqotech/liqo/apis/config/v1alpha1"
+	"github.com/liqotech/liqo/apis/discovery/v1alpha1"
+	"github.com/liqotech/liqo/pkg/auth"
+	"github.com/liqotech/liqo/pkg/clusterID"
+	"github.com/liqotech/liqo/pkg/crdClient"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"os"
+	"testing"
+	"time"
+)
+
+func getFakeClient(t *testing.T) *crdClient.CRDClient {
+	crdClient.Fake = true
+	client, err := crdClient.NewFromConfig(&rest.Config{ContentConfig: rest.ContentConfig{GroupVersion: &v1alpha1.GroupVersion}})
+	assert.Nil(t, err)
+	return client
+}
+
+func TestAnnounce(t *testing.T) {
+	assert.Nil(t, os.Setenv("APISERVER", "10.0.0.1"))
+	defer os.Unsetenv("APISERVER")
+
+	client := getFakeClient(t)
+	registry := getFakeClient(t)
+	store, stop, err := crdClient.WatchfakeResources("clusterannouncements", cache.ResourceEventHandlerFuncs{})
+	assert.Nil(t, err)
+	defer close(stop)
+	registry.Store = store
+	discovery := GetDiscoveryCtrl("liqo", client, nil, clusterID.GetNewClusterID("local-id", client.Client()), 10, time.Second)
+	discovery.Config = &configv1alpha1.DiscoveryConfig{
+		ClusterName: "local",
+		Ttl:         90,
+	}
+
+	assert.Nil(t, discovery.announce(registry))
+	tmp, err := registry.Resource("clusterannouncements").Get("local-id", metav1.GetOptions{})
+	assert.Nil(t, err)
+	ca, ok := tmp.(*v1alpha1.ClusterAnnouncement)
+	assert.True(t, ok)
+	assert.Equal(t, "local-id", ca.Spec.ClusterIdentity.ClusterID)
+	assert.Equal(t, "local", ca.Spec.ClusterIdentity.ClusterName)
+	assert.Equal(t, "liqo", ca.Spec.Namespace)
+	assert.Equal(t, "https://10.0.0.1:6443", ca.Spec.ApiUrl)
+	assert.Equal(t, uint32(90), ca.Spec.Ttl)
+	assert.False(t, ca.IsExpired())
+
+	// the heartbeat and the data are refreshed
+	ca.Status.LastHeartbeatTime = metav1.NewTime(time.Now().Add(-time.Hour))
+	assert.True(t, ca.IsExpired())
+	_, err = registry.Resource("clusterannouncements").Update(ca.Name, ca, metav1.UpdateOptions{})
+	assert.Nil(t, err)
+	assert.Nil(t, os.Setenv("APISERVER", "10.0.0.2"))
+	assert.Nil(t, discovery.announce(registry))
+	tmp, err = registry.Resource("clusterannouncements").Get("local-id", metav1.GetOptions{})
+	assert.Nil(t, err)
+	ca = tmp.(*v1alpha1.ClusterAnnouncement)
+	assert.Equal(t, "https://10.0.0.2:6443", ca.Spec.ApiUrl)
+	assert.False(t, ca.IsExpired())
+	assert.Nil(t, ca.Spec.Signature)
+}
+
+func TestSignedAnnouncement(t *testing.T) {
+	assert.Nil(t, os.Setenv("APISERVER", "10.0.0.1"))
+	defer os.Unsetenv("APISERVER")
+
+	client := getFakeClient(t)
+	registry := getFakeClient(t)
+	store, stop, err := crdClient.WatchfakeResources("clusterannouncements", cache.ResourceEventHandlerFuncs{})
+	assert.Nil(t, err)
+	defer close(stop)
+	registry.Store = store
+	discovery := GetDiscoveryCtrl("liqo", client, nil, clusterID.GetNewClusterID("remote-id", client.Client()), 10, time.Second)
+	discovery.Config = &configv1alpha1.DiscoveryConfig{Ttl: 90}
+	discovery.announcementKey = getAnnouncementKey(t)
+	discovery.caFingerprint = "ca-fingerprint"
+	keyFingerprint, err := auth.PublicKeyFingerprint(discovery.announcementKey.Public())
+	assert.Nil(t, err)
+
+	assert.Nil(t, discovery.announce(registry))
+	tmp, err := registry.Resource("clusterannouncements").Get("remote-id", metav1.GetOptions{})
+	assert.Nil(t, err)
+	ca := tmp.(*v1alpha1.ClusterAnnouncement)
+	data, ok := getAnnouncementData(ca, "local-id")
+	assert.True(t, ok)
+	assert.Equal(t, keyFingerprint, data.TxtData.KeyFingerprint)
+	assert.Equal(t, "ca-fingerprint", data.TxtData.CAFingerprint)
+
+	// the fields are covered by the signature
+	tampered := ca.DeepCopy()
+	tampered.Spec.ApiUrl = "https://10.0.0.2:6443"
+	_, ok = getAnnouncementData(tampered, "local-id")
+	assert.False(t, ok)
+	// a signature made with another key is refused
+	tampered = ca.DeepCopy()
+	tampered.Spec.PublicKey = nil
+	_, ok = getAnnouncementData(tampered, "local-id")
+	assert.False(t, ok)
+	other := &v1alpha1.ClusterAnnouncementSpec{}
+	assert.Nil(t, signRegistryAnnouncement(other, getAnnouncementKey(t), ""))
+	tampered.Spec.PublicKey = other.PublicKey
+	_, ok = getAnnouncementData(tampered, "local-id")
+	assert.False(t, ok)
+	// the signature of a TXT record is not valid for a ClusterAnnouncement
+	txtData, err := discovery.GetTxtData()
+	assert.Nil(t, err)
+	tampered = ca.DeepCopy()
+	tampered.Spec.Signature = txtData.signature.signature
+	_, ok = getAnnouncementData(tampered, "local-id")
+	assert.False(t, ok)
+
+	// the CA of an unsigned announcement is ignored
+	unsigned := ca.DeepCopy()
+	unsigned.Spec.PublicKey = nil
+	unsigned.Spec.Signature = nil
+	data, ok = getAnnouncementData(unsigned, "local-id")
+	assert.True(t, ok)
+	assert.Empty(t, data.TxtData.KeyFingerprint)
+	assert.Empty(t, data.TxtData.CAFingerprint)
+}
+
+func TestGetAnnouncementData(t *testing.T) {
+	getAnnouncement := func(name string, clusterID string, heartbeat time.Time) *v1alpha1.ClusterAnnouncement {
+		return &v1alpha1.ClusterAnnouncement{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: name,
+			},
+			Spec: v1alpha1.ClusterAnnouncementSpec{
+				ClusterIdentity: v1alpha1.ClusterIdentity{
+					ClusterID:   clusterID,
+					ClusterName: "name-" + clusterID,
+				},
+				Namespace: "liqo",
+				ApiUrl:    "https://10.0.0.1:6443",
+				Ttl:       90,
+			},
+			Status: v1alpha1.ClusterAnnouncementStatus{
+				LastHeartbeatTime: metav1.NewTime(heartbeat),
+			},
+		}
+	}
+
+	data, ok := getAnnouncementData(getAnnouncement("remote-id", "remote-id", time.Now()), "local-id")
+	assert.True(t, ok)
+	assert.Equal(t, "remote-id", data.TxtData.ID)
+	assert.Equal(t, "name-remote-id", data.TxtData.Name)
+	assert.Equal(t, "liqo", data.TxtData.Namespace)
+	assert.Equal(t, "https://10.0.0.1:6443", data.TxtData.ApiUrl)
+	assert.Equal(t, uint32(90), data.TxtData.Ttl)
+	assert.Nil(t, data.AuthData)
+
+	tests := []struct {
+		name         string
+		announcement *v1alpha1.ClusterAnnouncement
+	}{
+		{"local cluster", getAnnouncement("local-id", "local-id", time.Now())},
+		{"expired", getAnnouncement("remote-id", "remote-id", time.Now().Add(-2*time.Minute))},
+		{"name not matching the cluster ID", getAnnouncement("remote-id", "other-id", time.Now())},
+		{"no heartbeat", getAnnouncement("remote-id", "remote-id", time.Time{})},
+	}
+	for _, test := range tests {
+		_, ok := getAnnouncementData(test.announcement, "local-id")
+		assert.False(t, ok, test.name)
+	}
+}
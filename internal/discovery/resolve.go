@@ -29,7 +29,21 @@ func (discovery *DiscoveryCtrl) StartResolver(stopChan <-chan bool) {
 }
 
 func (discovery *DiscoveryCtrl) Resolve(ctx context.Context, service string, domain string, resultChan chan DiscoverableData, isAuth bool) {
-	resolver, err := zeroconf.NewResolver(zeroconf.SelectIPTraffic(zeroconf.IPv4))
+	filter, err := newInterfaceFilter(discovery.Config.Interfaces)
+	if err != nil {
+		klog.Error(err)
+		return
+	}
+	options := []zeroconf.ClientOption{zeroconf.SelectIPTraffic(zeroconf.IPv4)}
+	if filter != nil {
+		interfaces, err := discovery.getInterfaces()
+		if err != nil {
+			klog.Error(err)
+			return
+		}
+		options = append(options, zeroconf.SelectIfaces(interfaces))
+	}
+	resolver, err := zeroconf.NewResolver(options...)
 	if err != nil {
 		klog.Error(err, err.Error())
 		os.Exit(1)
@@ -38,6 +52,8 @@ func (discovery *DiscoveryCtrl) Resolve(ctx context.Context, service string, dom
 	entries := make(chan *zeroconf.ServiceEntry)
 	go func(results <-chan *zeroconf.ServiceEntry, isAuth bool) {
 		for entry := range results {
+			// the excluded addresses are neither checked by isForeign nor contacted
+			filter.filterEntry(entry)
 			var data DiscoverableData
 			if isAuth {
 				data = &AuthData{}
@@ -65,6 +81,11 @@ func (discovery *DiscoveryCtrl) Resolve(ctx context.Context, service string, dom
 					if dData.TxtData.ID == discovery.ClusterId.GetClusterID() || dData.TxtData.ID == "" {
 						continue
 					}
+					if err = dData.checkSignatures(); err != nil {
+						klog.Warning(err)
+						resolvedData.delete(entry.Instance)
+						continue
+					}
 					klog.V(4).Infof("update %s", entry.Instance)
 					discovery.UpdateForeignLAN(dData)
 					resolvedData.delete(entry.Instance)
@@ -103,7 +124,8 @@ func (discovery *DiscoveryCtrl) getIPs() map[string]bool {
 	return myIps
 }
 
-// a cluster is considered as foreign if it has at least one IP different from our IPs
+// a cluster is considered as foreign if it has at least one IP different from our IPs, the addresses excluded by the
+// Interfaces filter have already been removed
 func (discovery *DiscoveryCtrl) isForeign(foreignIps []net.IP) bool {
 	myIps := discovery.getIPs()
 	for _, fIp := range foreignIps {
@@ -51,7 +51,14 @@ func (r *SearchDomainReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error
 
 	update := false
 
-	txts, err := Wan(r.DnsAddress, sd.Spec.Domain)
+	resolver := &WanResolver{
+		Servers: sd.Spec.DNSServers,
+		DNSSEC:  sd.Spec.DNSSEC,
+	}
+	if len(resolver.Servers) == 0 && r.DnsAddress != "" {
+		resolver.Servers = []string{r.DnsAddress}
+	}
+	res, err := resolver.Resolve(sd.Spec.Domain)
 	if err != nil {
 		klog.Error(err, err.Error())
 		return ctrl.Result{
@@ -59,6 +66,28 @@ func (r *SearchDomainReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error
 			RequeueAfter: r.requeueAfter,
 		}, err
 	}
+	txts := res.Txts
+	// the validation result is an input of the trust of the retrieved clusters, it is stored before they are created
+	if sd.Status.DNSSECValidated != res.Validated || sd.Status.DNSServer != res.Server {
+		sd.Status.DNSSECValidated = res.Validated
+		sd.Status.DNSServer = res.Server
+		tmp, err = r.crdClient.Resource("searchdomains").Update(sd.Name, sd, metav1.UpdateOptions{})
+		if err != nil {
+			klog.Error(err, err.Error())
+			return ctrl.Result{
+				Requeue:      true,
+				RequeueAfter: r.requeueAfter,
+			}, err
+		}
+		if sd, ok = tmp.(*discoveryv1alpha1.SearchDomain); !ok {
+			err = errors.New("updated resource is not a SearchDomain")
+			klog.Error(err, err.Error())
+			return ctrl.Result{
+				Requeue:      true,
+				RequeueAfter: r.requeueAfter,
+			}, err
+		}
+	}
 	fcs := r.DiscoveryCtrl.UpdateForeignWAN(txts, sd)
 	if len(fcs) > 0 {
 		// new FCs added, so update the list
@@ -66,8 +95,9 @@ func (r *SearchDomainReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error
 		update = true
 	}
 
-	// check deletion, ForeignClusters that are no more in DNS list will be deleted
-	toDelete, err := r.CheckForDeletion(sd, txts)
+	// the ForeignClusters which are no more in the DNS answers are deleted by the garbage collector when they expire,
+	// remove the deleted ones from the list
+	deleted, err := r.CheckForDeletion(sd)
 	if err != nil {
 		klog.Error(err, err.Error())
 		return ctrl.Result{
@@ -75,29 +105,8 @@ func (r *SearchDomainReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error
 			RequeueAfter: r.requeueAfter,
 		}, err
 	}
-	if len(toDelete) > 0 {
-		for _, fcName := range toDelete {
-			err := r.crdClient.Resource("foreignclusters").Delete(fcName, metav1.DeleteOptions{})
-			if err != nil {
-				klog.Error(err, err.Error())
-				continue
-			}
-			// find index of element to delete
-			index := -1
-			for i, fc := range sd.Status.ForeignClusters {
-				if fc.Name == fcName {
-					index = i
-					break
-				}
-			}
-			if index < 0 || index > len(sd.Status.ForeignClusters) {
-				klog.Error("index out of range on FC deletion")
-				continue
-			}
-			// delete element from list
-			sd.Status.ForeignClusters[index] = sd.Status.ForeignClusters[len(sd.Status.ForeignClusters)-1]
-			sd.Status.ForeignClusters = sd.Status.ForeignClusters[:len(sd.Status.ForeignClusters)-1]
-		}
+	if len(deleted) > 0 {
+		RemoveFromList(sd, deleted)
 		update = true
 	}
 
@@ -153,19 +162,33 @@ func AddToList(sd *discoveryv1alpha1.SearchDomain, refs []v1.ObjectReference) {
 	}
 }
 
-func (r *SearchDomainReconciler) CheckForDeletion(sd *discoveryv1alpha1.SearchDomain, txts []*discovery.TxtData) ([]string, error) {
-	toDelete := []string{}
+// CheckForDeletion returns the ForeignClusters in the list of the SearchDomain which have been deleted
+func (r *SearchDomainReconciler) CheckForDeletion(sd *discoveryv1alpha1.SearchDomain) ([]string, error) {
+	deleted := []string{}
 	for _, fc := range sd.Status.ForeignClusters {
-		contains := false
-		for _, txt := range txts {
-			if txt.ID == fc.Name {
-				contains = true
+		_, err := r.crdClient.Resource("foreignclusters").Get(fc.Name, metav1.GetOptions{})
+		if k8serrors.IsNotFound(err) {
+			deleted = append(deleted, fc.Name)
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	return deleted, nil
+}
+
+func RemoveFromList(sd *discoveryv1alpha1.SearchDomain, names []string) {
+	refs := []v1.ObjectReference{}
+	for _, fc := range sd.Status.ForeignClusters {
+		removed := false
+		for _, name := range names {
+			if fc.Name == name {
+				removed = true
 				break
 			}
 		}
-		if !contains {
-			toDelete = append(toDelete, fc.Name)
+		if !removed {
+			refs = append(refs, fc)
 		}
 	}
-	return toDelete, nil
+	sd.Status.ForeignClusters = refs
 }
@@ -1,76 +1,148 @@
 package search_domain_operator
 
 import (
+	"crypto/tls"
 	"errors"
+	"fmt"
+	discoveryv1alpha1 "github.com/liqotech/liqo/apis/discovery/v1alpha1"
 	"github.com/liqotech/liqo/internal/discovery"
 	"github.com/miekg/dns"
 	"k8s.io/klog"
 	"net"
 	"strconv"
+	"strings"
 	"time"
 )
 
+const (
+	// prefix of the DNS-over-TLS servers
+	dotPrefix = "tls://"
+	dotPort   = "853"
+	dnsPort   = "53"
+
+	resolvConfPath = "/etc/resolv.conf"
+	// size of the UDP buffer announced with EDNS0, required to receive the DNSSEC records
+	ednsBufferSize = 4096
+)
+
+// WanResolver gets the clusters registered in a DNS domain.
+// The DNSSEC signatures are not validated locally: the resolver relies on the authenticated flag set by the DNS server,
+// which is trusted only if it cannot be forged on the path, i.e. when the server is contacted with DNS-over-TLS or it
+// runs on the local host.
+type WanResolver struct {
+	// servers queried in order until one answers, as host:port or tls://host:port, if empty the ones in
+	// /etc/resolv.conf are used
+	Servers []string
+	DNSSEC  discoveryv1alpha1.DNSSECMode
+	// configuration of the DNS-over-TLS connections, if nil the server certificates are verified with the system CAs
+	TLSConfig *tls.Config
+	Timeout   time.Duration
+}
+
+// WanResult contains the clusters registered in a DNS domain
+type WanResult struct {
+	Txts []*discovery.TxtData
+	// true if all the answers have been authenticated by DNSSEC, as stated by servers contacted on a secure channel
+	Validated bool
+	// server which answered the last query
+	Server string
+}
+
+type dnsServer struct {
+	address string
+	tls     bool
+}
+
 func Wan(dnsAddr string, name string) ([]*discovery.TxtData, error) {
-	txtData := []*discovery.TxtData{}
+	resolver := &WanResolver{}
+	if dnsAddr != "" {
+		resolver.Servers = []string{dnsAddr}
+	}
+	res, err := resolver.Resolve(name)
+	if err != nil {
+		return nil, err
+	}
+	return res.Txts, nil
+}
 
-	if dnsAddr == "" {
-		clientConfig, err := dns.ClientConfigFromFile("/etc/resolv.conf")
-		if err != nil {
-			klog.Error(err)
-			return nil, err
-		}
-		if len(clientConfig.Servers) == 0 {
-			err = errors.New("no DNS server config found")
-			klog.Error(err)
-			return nil, err
+// Resolve gets the clusters registered in the domain through their PTR, SRV and TXT records
+func (r *WanResolver) Resolve(name string) (*WanResult, error) {
+	servers, err := r.getServers()
+	if err != nil {
+		klog.Error(err)
+		return nil, err
+	}
+	if r.DNSSEC == discoveryv1alpha1.DNSSECEnforced {
+		for _, server := range servers {
+			if !server.isSecure() {
+				err = fmt.Errorf("DNSSEC cannot be enforced with the server %s, it has to be contacted with DNS-over-TLS "+
+					"or run on the local host", server.address)
+				klog.Error(err)
+				return nil, err
+			}
 		}
-		dnsAddr = net.JoinHostPort(clientConfig.Servers[0], "53")
 	}
-
-	c := new(dns.Client)
-	c.DialTimeout = 30 * time.Second
+	res := &WanResult{
+		Txts:      []*discovery.TxtData{},
+		Validated: true,
+	}
 
 	// PTR query
-	msg := GetDnsMsg(name, dns.TypePTR)
-	in, _, err := c.Exchange(msg, dnsAddr)
+	in, err := r.exchange(servers, GetDnsMsg(name, dns.TypePTR), res)
 	if err != nil {
 		klog.Error(err, err.Error())
 		return nil, err
 	}
 
 	for _, ans := range in.Answer {
+		if _, ok := ans.(*dns.RRSIG); ok {
+			continue
+		}
 		ptr, ok := ans.(*dns.PTR)
 		if !ok {
 			klog.Warning("Not PTR record: ", ans)
 			continue
 		}
-		txt, err := ResolveWan(c, dnsAddr, ptr)
+		txt, err := r.resolveWan(servers, ptr, res)
 		if err != nil {
 			klog.Error(err, err.Error())
 			return nil, err
 		}
-		txtData = append(txtData, txt)
+		res.Txts = append(res.Txts, txt)
 	}
-	return txtData, nil
+	return res, nil
 }
 
 func ResolveWan(c *dns.Client, dnsAddr string, ptr *dns.PTR) (*discovery.TxtData, error) {
+	resolver := &WanResolver{Servers: []string{dnsAddr}, Timeout: c.DialTimeout}
+	servers, err := resolver.getServers()
+	if err != nil {
+		return nil, err
+	}
+	return resolver.resolveWan(servers, ptr, &WanResult{})
+}
+
+func (r *WanResolver) resolveWan(servers []dnsServer, ptr *dns.PTR, res *WanResult) (*discovery.TxtData, error) {
 	// SRV query
-	msg := GetDnsMsg(ptr.Ptr, dns.TypeSRV)
-	in, _, err := c.Exchange(msg, dnsAddr)
+	in, err := r.exchange(servers, GetDnsMsg(ptr.Ptr, dns.TypeSRV), res)
 	if err != nil {
 		klog.Error(err, err.Error())
 		return nil, err
 	}
-	if len(in.Answer) == 0 {
+	var srv *dns.SRV
+	for _, ans := range in.Answer {
+		if record, ok := ans.(*dns.SRV); ok {
+			srv = record
+			break
+		}
+	}
+	if srv == nil {
 		klog.Error("SRV record is not set for " + ptr.Ptr)
 		return nil, errors.New("SRV record is not set for " + ptr.Ptr)
 	}
-	srv := in.Answer[0].(*dns.SRV)
 
 	// TXT query
-	msg = GetDnsMsg(ptr.Ptr, dns.TypeTXT)
-	in, _, err = c.Exchange(msg, dnsAddr)
+	in, err = r.exchange(servers, GetDnsMsg(ptr.Ptr, dns.TypeTXT), res)
 	if err != nil {
 		klog.Error(err, err.Error())
 		return nil, err
@@ -90,6 +162,144 @@ func ResolveWan(c *dns.Client, dnsAddr string, ptr *dns.PTR) (*discovery.TxtData
 	return txtData, nil
 }
 
+// exchange sends the query to the servers in order, until one of them answers. The UDP queries are retried with TCP
+// if the answer is truncated. With DNSSEC enforced the answers which are not authenticated are refused, and the servers
+// not contacted on a secure channel are not queried at all, since their authenticated flag could be forged on the path.
+// In the other modes the answers of these servers are never considered as authenticated.
+func (r *WanResolver) exchange(servers []dnsServer, msg *dns.Msg, res *WanResult) (*dns.Msg, error) {
+	if r.DNSSEC == discoveryv1alpha1.DNSSECOptional || r.DNSSEC == discoveryv1alpha1.DNSSECEnforced {
+		msg.SetEdns0(ednsBufferSize, true)
+		msg.AuthenticatedData = true
+	}
+
+	var errs []string
+	for _, server := range servers {
+		if r.DNSSEC == discoveryv1alpha1.DNSSECEnforced && !server.isSecure() {
+			err := fmt.Errorf("the answers of %s cannot be authenticated, it is not contacted on a secure channel", server.address)
+			klog.V(4).Infof("query %s %s skipped: %v", msg.Question[0].Name, dns.TypeToString[msg.Question[0].Qtype], err)
+			errs = append(errs, err.Error())
+			continue
+		}
+		in, err := r.exchangeWith(server, msg)
+		if err == nil && in.Rcode != dns.RcodeSuccess && in.Rcode != dns.RcodeNameError {
+			err = fmt.Errorf("%s answered %s", server.address, dns.RcodeToString[in.Rcode])
+		}
+		if err == nil && r.DNSSEC == discoveryv1alpha1.DNSSECEnforced && !in.AuthenticatedData {
+			err = fmt.Errorf("the answer of %s is not authenticated by DNSSEC", server.address)
+		}
+		if err != nil {
+			klog.V(4).Infof("query %s %s failed: %v", msg.Question[0].Name, dns.TypeToString[msg.Question[0].Qtype], err)
+			errs = append(errs, err.Error())
+			continue
+		}
+
+		res.Server = server.address
+		res.Validated = res.Validated && in.AuthenticatedData && server.isSecure()
+		return in, nil
+	}
+	return nil, fmt.Errorf("no DNS server answered the query %s %s: %s", msg.Question[0].Name,
+		dns.TypeToString[msg.Question[0].Qtype], strings.Join(errs, ", "))
+}
+
+func (r *WanResolver) exchangeWith(server dnsServer, msg *dns.Msg) (*dns.Msg, error) {
+	timeout := r.Timeout
+	if timeout == 0 {
+		timeout = 30 * time.Second
+	}
+
+	c := &dns.Client{DialTimeout: timeout}
+	if server.tls {
+		c.Net = "tcp-tls"
+		c.TLSConfig = r.getTLSConfig(server.address)
+	}
+	in, _, err := c.Exchange(msg, server.address)
+	if err != nil || !in.Truncated || server.tls {
+		return in, err
+	}
+
+	// the answer does not fit in a UDP message
+	klog.V(4).Infof("truncated answer from %s, retrying with TCP", server.address)
+	c.Net = "tcp"
+	in, _, err = c.Exchange(msg, server.address)
+	return in, err
+}
+
+func (r *WanResolver) getTLSConfig(address string) *tls.Config {
+	var config *tls.Config
+	if r.TLSConfig != nil {
+		config = r.TLSConfig.Clone()
+	} else {
+		config = &tls.Config{}
+	}
+	if config.ServerName == "" {
+		if host, _, err := net.SplitHostPort(address); err == nil {
+			config.ServerName = host
+		}
+	}
+	return config
+}
+
+// isSecure returns true if the answers of the server cannot be tampered with on the path: it is contacted with
+// DNS-over-TLS, or it runs on the local host
+func (s dnsServer) isSecure() bool {
+	if s.tls {
+		return true
+	}
+	host, _, err := net.SplitHostPort(s.address)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// getServers returns the configured servers, or the ones in /etc/resolv.conf if there are none
+func (r *WanResolver) getServers() ([]dnsServer, error) {
+	if len(r.Servers) > 0 {
+		servers := make([]dnsServer, 0, len(r.Servers))
+		for _, s := range r.Servers {
+			servers = append(servers, parseServer(s))
+		}
+		return servers, nil
+	}
+
+	clientConfig, err := dns.ClientConfigFromFile(resolvConfPath)
+	if err != nil {
+		return nil, err
+	}
+	if len(clientConfig.Servers) == 0 {
+		return nil, errors.New("no DNS server config found")
+	}
+	port := clientConfig.Port
+	if port == "" {
+		port = dnsPort
+	}
+	servers := make([]dnsServer, 0, len(clientConfig.Servers))
+	for _, s := range clientConfig.Servers {
+		servers = append(servers, dnsServer{address: net.JoinHostPort(s, port)})
+	}
+	return servers, nil
+}
+
+// parseServer parses a server as host, host:port, tls://host or tls://host:port
+func parseServer(server string) dnsServer {
+	res := dnsServer{}
+	port := dnsPort
+	if strings.HasPrefix(server, dotPrefix) {
+		server = strings.TrimPrefix(server, dotPrefix)
+		res.tls = true
+		port = dotPort
+	}
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(strings.Trim(server, "[]"), port)
+	}
+	res.address = server
+	return res
+}
+
 func GetDnsMsg(name string, qType uint16) *dns.Msg {
 	msg := new(dns.Msg)
 	msg.Id = dns.Id()
@@ -106,6 +316,10 @@ func GetDnsMsg(name string, qType uint16) *dns.Msg {
 func AnswerToTxt(answers []dns.RR) ([]string, error) {
 	res := []string{}
 	for _, ans := range answers {
+		if _, ok := ans.(*dns.RRSIG); ok {
+			// the signatures returned with DNSSEC
+			continue
+		}
 		txt, ok := ans.(*dns.TXT)
 		if !ok {
 			return nil, errors.New("Not TXT record: " + ans.String())
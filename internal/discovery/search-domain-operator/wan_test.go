@@ -0,0 +1,269 @@
+package search_domain_operator
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	discoveryv1alpha1 "github.com/liqotech/liqo/apis/discovery/v1alpha1"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testDomain = "test.liqo.io."
+
+// testHandler serves the records of a cluster registered in testDomain, like a resolver validating DNSSEC if
+// authenticated is set
+type testHandler struct {
+	authenticated bool
+	servfail      bool
+	// the UDP answers to the TXT queries are truncated
+	truncate   bool
+	tcpQueries int32
+}
+
+func (h *testHandler) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+	msg := &dns.Msg{}
+	msg.SetReply(r)
+	_, udp := w.RemoteAddr().(*net.UDPAddr)
+	if !udp {
+		atomic.AddInt32(&h.tcpQueries, 1)
+	}
+	if h.servfail {
+		msg.Rcode = dns.RcodeServerFailure
+		_ = w.WriteMsg(msg)
+		return
+	}
+	// the resolver sets the authenticated flag only if the client asked for DNSSEC
+	if opt := r.IsEdns0(); opt != nil && opt.Do() {
+		msg.AuthenticatedData = h.authenticated
+		msg.SetEdns0(ednsBufferSize, true)
+	}
+
+	name := r.Question[0].Name
+	cluster := "cluster1." + testDomain
+	hdr := dns.RR_Header{Name: name, Rrtype: r.Question[0].Qtype, Class: dns.ClassINET, Ttl: 60}
+	switch {
+	case r.Question[0].Qtype == dns.TypePTR && name == testDomain:
+		msg.Answer = append(msg.Answer, &dns.PTR{Hdr: hdr, Ptr: cluster})
+	case r.Question[0].Qtype == dns.TypeSRV && name == cluster:
+		msg.Answer = append(msg.Answer, &dns.SRV{Hdr: hdr, Port: 6443, Target: "api." + cluster})
+	case r.Question[0].Qtype == dns.TypeTXT && name == cluster:
+		if udp && h.truncate {
+			msg.Truncated = true
+			break
+		}
+		msg.Answer = append(msg.Answer, &dns.TXT{Hdr: hdr, Txt: []string{"id=cluster1", "namespace=liqo"}})
+	default:
+		msg.Rcode = dns.RcodeNameError
+	}
+	_ = w.WriteMsg(msg)
+}
+
+// startServers starts the UDP and TCP servers on the same port, it returns their address
+func startServers(t *testing.T, handler dns.Handler) string {
+	for {
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		assert.Nil(t, err)
+		listener, err := net.Listen("tcp", conn.LocalAddr().String())
+		if err != nil {
+			// the port is already used for TCP
+			_ = conn.Close()
+			continue
+		}
+		startServer(t, &dns.Server{PacketConn: conn, Handler: handler})
+		startServer(t, &dns.Server{Listener: listener, Handler: handler})
+		return conn.LocalAddr().String()
+	}
+}
+
+// startTLSServer starts a DNS-over-TLS server, it returns its address and the pool trusting its certificate
+func startTLSServer(t *testing.T, handler dns.Handler) (string, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "dns"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.Nil(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.Nil(t, err)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+	})
+	assert.Nil(t, err)
+	startServer(t, &dns.Server{Listener: listener, Net: "tcp-tls", Handler: handler})
+	return listener.Addr().String(), pool
+}
+
+func startServer(t *testing.T, server *dns.Server) {
+	started := make(chan struct{})
+	server.NotifyStartedFunc = func() { close(started) }
+	go func() {
+		_ = server.ActivateAndServe()
+	}()
+	<-started
+	t.Cleanup(func() { _ = server.Shutdown() })
+}
+
+func TestResolveFallback(t *testing.T) {
+	failing := startServers(t, &testHandler{servfail: true})
+	handler := &testHandler{truncate: true}
+	working := startServers(t, handler)
+
+	resolver := &WanResolver{
+		Servers: []string{failing, working},
+		Timeout: time.Second,
+	}
+	res, err := resolver.Resolve(testDomain)
+	assert.Nil(t, err)
+	assert.Len(t, res.Txts, 1)
+	assert.Equal(t, "cluster1", res.Txts[0].ID)
+	assert.Equal(t, "liqo", res.Txts[0].Namespace)
+	assert.Equal(t, "https://api.cluster1."+testDomain+":6443", res.Txts[0].ApiUrl)
+	assert.Equal(t, working, res.Server)
+	assert.False(t, res.Validated)
+	// the truncated answer has been retried with TCP
+	assert.Equal(t, int32(1), atomic.LoadInt32(&handler.tcpQueries))
+
+	resolver.Servers = []string{failing}
+	_, err = resolver.Resolve(testDomain)
+	assert.NotNil(t, err)
+}
+
+func TestResolveDNSSEC(t *testing.T) {
+	validating := startServers(t, &testHandler{authenticated: true})
+	notValidating := startServers(t, &testHandler{})
+
+	tests := []struct {
+		name      string
+		servers   []string
+		mode      discoveryv1alpha1.DNSSECMode
+		fail      bool
+		validated bool
+	}{
+		{"disabled", []string{validating}, discoveryv1alpha1.DNSSECDisabled, false, false},
+		{"optional, validated", []string{validating}, discoveryv1alpha1.DNSSECOptional, false, true},
+		{"optional, not validated", []string{notValidating}, discoveryv1alpha1.DNSSECOptional, false, false},
+		{"enforced, validated", []string{validating}, discoveryv1alpha1.DNSSECEnforced, false, true},
+		{"enforced, not validated", []string{notValidating}, discoveryv1alpha1.DNSSECEnforced, true, false},
+		{"enforced, falls back to the validating server", []string{notValidating, validating}, discoveryv1alpha1.DNSSECEnforced, false, true},
+		{"enforced, server not on the local host", []string{"192.0.2.1", validating}, discoveryv1alpha1.DNSSECEnforced, true, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resolver := &WanResolver{
+				Servers: test.servers,
+				DNSSEC:  test.mode,
+				Timeout: time.Second,
+			}
+			res, err := resolver.Resolve(testDomain)
+			if test.fail {
+				assert.NotNil(t, err)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Len(t, res.Txts, 1)
+			assert.Equal(t, test.validated, res.Validated)
+		})
+	}
+}
+
+func TestExchangeDNSSECEnforced(t *testing.T) {
+	validating := startServers(t, &testHandler{authenticated: true})
+	resolver := &WanResolver{
+		DNSSEC:  discoveryv1alpha1.DNSSECEnforced,
+		Timeout: time.Second,
+	}
+	// the authenticated answers are accepted only from the servers contacted on a secure channel
+	servers := []dnsServer{parseServer("192.0.2.1"), parseServer(validating)}
+	res := &WanResult{Validated: true}
+	in, err := resolver.exchange(servers, GetDnsMsg(testDomain, dns.TypePTR), res)
+	assert.Nil(t, err)
+	assert.True(t, in.AuthenticatedData)
+	assert.Equal(t, validating, res.Server)
+	assert.True(t, res.Validated)
+
+	// the insecure servers are not even queried
+	_, err = resolver.exchange(servers[:1], GetDnsMsg(testDomain, dns.TypePTR), &WanResult{})
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "not contacted on a secure channel")
+}
+
+func TestResolveTLS(t *testing.T) {
+	address, pool := startTLSServer(t, &testHandler{authenticated: true})
+
+	resolver := &WanResolver{
+		Servers:   []string{dotPrefix + address},
+		DNSSEC:    discoveryv1alpha1.DNSSECEnforced,
+		TLSConfig: &tls.Config{RootCAs: pool},
+		Timeout:   time.Second,
+	}
+	res, err := resolver.Resolve(testDomain)
+	assert.Nil(t, err)
+	assert.Len(t, res.Txts, 1)
+	assert.True(t, res.Validated)
+	assert.Equal(t, address, res.Server)
+
+	// the certificate of the server is verified
+	resolver.TLSConfig = nil
+	_, err = resolver.Resolve(testDomain)
+	assert.NotNil(t, err)
+}
+
+func TestParseServer(t *testing.T) {
+	tests := []struct {
+		server   string
+		expected dnsServer
+	}{
+		{"10.0.0.1", dnsServer{address: "10.0.0.1:53"}},
+		{"10.0.0.1:5353", dnsServer{address: "10.0.0.1:5353"}},
+		{"::1", dnsServer{address: "[::1]:53"}},
+		{"[::1]:5353", dnsServer{address: "[::1]:5353"}},
+		{"tls://dns.example.com", dnsServer{address: "dns.example.com:853", tls: true}},
+		{"tls://dns.example.com:8853", dnsServer{address: "dns.example.com:8853", tls: true}},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, parseServer(test.server), test.server)
+	}
+}
+
+func TestServerIsSecure(t *testing.T) {
+	tests := []struct {
+		server string
+		secure bool
+	}{
+		{"127.0.0.1", true},
+		{"[::1]:5353", true},
+		{"localhost:53", true},
+		{"10.0.0.1", false},
+		{"dns.example.com", false},
+		{"tls://dns.example.com", true},
+		{"tls://10.0.0.1", true},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.secure, parseServer(test.server).isSecure(), test.server)
+	}
+}
@@ -16,6 +16,11 @@ type TxtData struct {
 	Namespace string
 	ApiUrl    string
 	Ttl       uint32
+
+	// set if the announcement is signed, Decode verifies the signature
+	CAFingerprint  string
+	KeyFingerprint string
+	signature      *announcementSignature
 }
 
 func (txtData TxtData) Encode() ([]string, error) {
@@ -27,6 +32,12 @@ func (txtData TxtData) Encode() ([]string, error) {
 	if txtData.Name != "" {
 		res = append(res, "name="+txtData.Name)
 	}
+	if txtData.signature != nil {
+		if txtData.CAFingerprint != "" {
+			res = append(res, "ca="+txtData.CAFingerprint)
+		}
+		res = append(res, txtData.signature.encode()...)
+	}
 	return res, nil
 }
 
@@ -41,11 +52,27 @@ func (txtData *TxtData) Decode(address string, port string, data []string) error
 		} else if strings.HasPrefix(d, "url=") {
 			// used in LAN discovery
 			txtData.ApiUrl = d[len("url="):]
+		} else if strings.HasPrefix(d, "ca=") {
+			txtData.CAFingerprint = d[len("ca="):]
+		}
+	}
+
+	signature, err := decodeSignature(data)
+	if err != nil {
+		return err
+	}
+	if signature != nil {
+		if txtData.KeyFingerprint, err = signature.verify(txtData.payload); err != nil {
+			return err
 		}
+		txtData.signature = signature
+	} else {
+		// the CA is meaningful only if it is signed
+		txtData.CAFingerprint = ""
 	}
 
-	// used in WAN discovery
-	if address != "" && port != "" {
+	// used in WAN discovery, the signed URL is kept
+	if address != "" && port != "" && signature == nil {
 		txtData.ApiUrl = "https://" + address + ":" + port
 	}
 	if txtData.ID == "" || txtData.Namespace == "" || txtData.ApiUrl == "" {
@@ -68,6 +95,12 @@ func (discovery *DiscoveryCtrl) GetTxtData() (*TxtData, error) {
 	if discovery.Config.ClusterName != "" {
 		txtData.Name = discovery.Config.ClusterName
 	}
+	if discovery.announcementKey != nil {
+		if err = txtData.Sign(discovery.announcementKey, discovery.caFingerprint); err != nil {
+			klog.Error(err)
+			return nil, err
+		}
+	}
 	return txtData, nil
 }
 
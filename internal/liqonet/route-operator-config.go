@@ -24,6 +24,7 @@ func (r *RouteController) WatchConfiguration(config *rest.Config, gv *schema.Gro
 	go clusterConfig.WatchConfiguration(func(configuration *configv1alpha1.ClusterConfig) {
 		if !r.IsConfigured {
 			r.ClusterPodCIDR = configuration.Spec.LiqonetConfig.PodCIDR
+			r.ClusterServiceCIDR = configuration.Spec.LiqonetConfig.ServiceCIDR
 			r.Configured <- true
 		}
 		//check if the podCIDR is different from the one on the cluster config
@@ -31,5 +32,8 @@ func (r *RouteController) WatchConfiguration(config *rest.Config, gv *schema.Gro
 		if r.ClusterPodCIDR != configuration.Spec.LiqonetConfig.PodCIDR {
 			r.ClusterPodCIDR = configuration.Spec.LiqonetConfig.PodCIDR
 		}
+		if r.ClusterServiceCIDR != configuration.Spec.LiqonetConfig.ServiceCIDR {
+			r.ClusterServiceCIDR = configuration.Spec.LiqonetConfig.ServiceCIDR
+		}
 	}, CRDclient, "")
 }
@@ -17,12 +17,14 @@ package liqonetOperators
 import (
 	"context"
 	"fmt"
-	"github.com/coreos/go-iptables/iptables"
 	netv1alpha1 "github.com/liqotech/liqo/apis/net/v1alpha1"
 	liqonetOperator "github.com/liqotech/liqo/pkg/liqonet"
+	"github.com/liqotech/liqo/pkg/virtualKubelet/forge"
 	"github.com/vishvananda/netlink"
+	corev1 "k8s.io/api/core/v1"
 	k8sApiErrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/record"
 	"k8s.io/klog"
@@ -30,9 +32,14 @@ import (
 	"os"
 	"os/signal"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+	"sort"
 	"strconv"
 	"strings"
 	"syscall"
@@ -52,6 +59,7 @@ const (
 	LiqonetPreroutingClusterChainPrefix  = "LIQO-PRRT-CLS-"
 	LiqonetForwardingClusterChainPrefix  = "LIQO-FRWD-CLS-"
 	LiqonetInputClusterChainPrefix       = "LIQO-INPT-CLS-"
+	LiqonetIngressClusterChainPrefix     = "LIQO-FRWD-IN-CLS-" //filters the traffic from a remote cluster according to its ingress policy
 	NatTable                             = "nat"
 	FilterTable                          = "filter"
 )
@@ -73,8 +81,10 @@ type RouteController struct {
 	IPtables       liqonetOperator.IPTables
 	NetLink        liqonetOperator.NetLink
 	ClusterPodCIDR string
-	Configured     chan bool //channel to comunicate when the podCIDR has been set
-	IsConfigured   bool      //true when the operator is configured and ready to be started
+	//the subnet used by the local cluster for the services, needed when only the service traffic is routed
+	ClusterServiceCIDR string
+	Configured         chan bool //channel to comunicate when the podCIDR has been set
+	IsConfigured       bool      //true when the operator is configured and ready to be started
 	//here we save only the rules that reference the custom chains added by us
 	//we need them at deletion time
 	IPTablesRuleSpecsReferencingChains map[string]liqonetOperator.IPtableRule //using a map to avoid duplicate entries. the key is the rulespec
@@ -87,7 +97,9 @@ type RouteController struct {
 
 // +kubebuilder:rbac:groups=net.liqo.io,resources=tunnelendpoints,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=net.liqo.io,resources=tunnelendpoints/status,verbs=get;update;patch
-// +kubebuilder:rbac:groups=core,resources=nodes,verbs=get;list
+// +kubebuilder:rbac:groups=core,resources=nodes,verbs=get;list;watch
+// +kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch
+// +kubebuilder:rbac:groups=core,resources=services,verbs=get;list;watch
 
 func (r *RouteController) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 	ctx := context.Background()
@@ -100,6 +112,10 @@ func (r *RouteController) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
 	clusterID := tep.Spec.ClusterID
+	//the rules and routes depend on the role of the node, which changes when the tunnel-operator fails over
+	if err := r.CheckGateway(); err != nil {
+		klog.Errorf("unable to check the active gateway, keeping the current configuration: %s", err)
+	}
 	//we can process a tunnelendpoint resource only if the tunnel interface has been created and configured
 	//this network interface is managed by the tunnel operator who sets this field when process the same tunnelendpoint
 	//resource and creates the network interface.
@@ -169,6 +185,36 @@ func (r *RouteController) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 	return result, nil
 }
 
+//CheckGateway updates the role of the node and the vxlan IP used to reach the gateway when the active
+//gateway changes, i.e. after a failover of the tunnel-operator. The iptables rules and the routes follow
+//the new configuration when the tunnelEndpoint resources are reconciled, which happens on every change
+//of the gateway nodes.
+func (r *RouteController) CheckGateway() error {
+	nodes := &corev1.NodeList{}
+	if err := r.List(context.Background(), nodes, client.MatchingLabels{liqonetOperator.GatewayLabelKey: "true"}); err != nil {
+		return err
+	}
+	gateway, err := liqonetOperator.GetActiveGateway(nodes.Items)
+	if err != nil {
+		return err
+	}
+	internalIP, err := liqonetOperator.GetInternalIPOfNode(*gateway)
+	if err != nil {
+		return err
+	}
+	isGateway := gateway.Name == r.NodeName
+	gatewayVxlanIP := liqonetOperator.GetVxlanIP(internalIP, r.VxlanNetwork)
+	if isGateway != r.IsGateway || gatewayVxlanIP != r.GatewayVxlanIP {
+		klog.Infof("the active gateway is running on node %s with vxlan IP %s", gateway.Name, gatewayVxlanIP)
+		if r.IsGateway && !isGateway {
+			klog.Infof("node %s is no longer the gateway: the NAT rules and the routes through the tunnel are replaced", r.NodeName)
+		}
+		r.IsGateway = isGateway
+		r.GatewayVxlanIP = gatewayVxlanIP
+	}
+	return nil
+}
+
 func (r *RouteController) GetPodCIDRS(tep *netv1alpha1.TunnelEndpoint) (string, string) {
 	var remotePodCIDR, localRemappedPodCIDR string
 	if tep.Status.RemoteRemappedPodCIDR != "None" {
@@ -180,8 +226,44 @@ func (r *RouteController) GetPodCIDRS(tep *netv1alpha1.TunnelEndpoint) (string,
 	return localRemappedPodCIDR, remotePodCIDR
 }
 
+//IsServiceCIDRMode returns true if only the traffic destined to the services of the remote cluster
+//has to be routed through the tunnel. The tunnelEndpointCreator sets the service subnets only when
+//both the peering clusters are configured in ServiceCIDR network mode.
+func (r *RouteController) IsServiceCIDRMode(tep *netv1alpha1.TunnelEndpoint) bool {
+	return tep.Spec.ServiceCIDR != "" && tep.Status.LocalRemappedServiceCIDR != "" && tep.Status.RemoteRemappedServiceCIDR != ""
+}
+
+func (r *RouteController) GetServiceCIDRS(tep *netv1alpha1.TunnelEndpoint) (string, string) {
+	var remoteServiceCIDR, localRemappedServiceCIDR string
+	if tep.Status.RemoteRemappedServiceCIDR != defaultPodCIDRValue {
+		remoteServiceCIDR = tep.Status.RemoteRemappedServiceCIDR
+	} else {
+		remoteServiceCIDR = tep.Spec.ServiceCIDR
+	}
+	if tep.Status.LocalRemappedServiceCIDR != defaultPodCIDRValue {
+		localRemappedServiceCIDR = tep.Status.LocalRemappedServiceCIDR
+	} else {
+		localRemappedServiceCIDR = r.ClusterServiceCIDR
+	}
+	return localRemappedServiceCIDR, remoteServiceCIDR
+}
+
+//GetRemoteCIDR returns the subnet of the remote cluster which is routed through the tunnel:
+//the services' subnet in ServiceCIDR mode, the pods' subnet otherwise
+func (r *RouteController) GetRemoteCIDR(tep *netv1alpha1.TunnelEndpoint) string {
+	if r.IsServiceCIDRMode(tep) {
+		_, remoteServiceCIDR := r.GetServiceCIDRS(tep)
+		return remoteServiceCIDR
+	}
+	_, remotePodCIDR := r.GetPodCIDRS(tep)
+	return remotePodCIDR
+}
+
 func (r *RouteController) GetPostroutingRules(tep *netv1alpha1.TunnelEndpoint) ([]string, error) {
 	clusterID := tep.Spec.ClusterID
+	if r.IsServiceCIDRMode(tep) {
+		return r.getServicePostroutingRules(tep)
+	}
 	localRemappedPodCIDR, remotePodCIDR := r.GetPodCIDRS(tep)
 	if r.IsGateway {
 		if localRemappedPodCIDR != defaultPodCIDRValue {
@@ -213,6 +295,41 @@ func (r *RouteController) GetPostroutingRules(tep *netv1alpha1.TunnelEndpoint) (
 	}, nil
 }
 
+//in ServiceCIDR mode the traffic destined to the remote services leaves the gateway with the first host address
+//of the subnet used by the remote cluster to remap the local services, which is routed back through the tunnel.
+//This way the pods' subnets of the two clusters do not need to be routed nor remapped.
+//On the remote side the remapped ClusterIPs are translated back by the DNAT rules of the prerouting chain.
+func (r *RouteController) getServicePostroutingRules(tep *netv1alpha1.TunnelEndpoint) ([]string, error) {
+	localRemappedServiceCIDR, remoteServiceCIDR := r.GetServiceCIDRS(tep)
+	if !r.IsGateway {
+		return []string{
+			strings.Join([]string{"-s", r.ClusterPodCIDR, "-d", remoteServiceCIDR, "-j", "ACCEPT"}, " "),
+		}, nil
+	}
+	_, subnet, err := net.ParseCIDR(localRemappedServiceCIDR)
+	if err != nil {
+		klog.Errorf("%s -> unable to get the IP from localServiceCidr %s used to NAT the traffic from local hosts to remote services", tep.Spec.ClusterID, localRemappedServiceCIDR)
+		return nil, err
+	}
+	natIP := firstHostAddress(subnet)
+	return []string{
+		strings.Join([]string{"-d", remoteServiceCIDR, "-j", "SNAT", "--to-source", natIP.String()}, " "),
+	}, nil
+}
+
+//firstHostAddress returns the address following the network address of the subnet
+func firstHostAddress(subnet *net.IPNet) net.IP {
+	ip := make(net.IP, len(subnet.IP))
+	copy(ip, subnet.IP)
+	for i := len(ip) - 1; i >= 0; i-- {
+		ip[i]++
+		if ip[i] != 0 {
+			break
+		}
+	}
+	return ip
+}
+
 func (r *RouteController) InsertRulesIfNotPresent(clusterID, table, chain string, rules []string) error {
 	for _, rule := range rules {
 		exists, err := r.IPtables.Exists(table, chain, strings.Split(rule, " ")...)
@@ -231,6 +348,7 @@ func (r *RouteController) InsertRulesIfNotPresent(clusterID, table, chain string
 }
 
 func (r *RouteController) UpdateRulesPerChain(clusterID, chain, table string, existingRules, newRules []string) error {
+	var kept []string
 	//remove the outdated rules
 	//if the chain has been newly created than the for loop will do nothing
 	for _, existingRule := range existingRules {
@@ -245,6 +363,17 @@ func (r *RouteController) UpdateRulesPerChain(clusterID, chain, table string, ex
 				return err
 			}
 			klog.Infof("%s -> removing outdated rule '%s' from chain %s in table %s", clusterID, existingRule, chain, table)
+		} else {
+			kept = append(kept, existingRule)
+		}
+	}
+	//the missing rules are appended: if they have to precede the existing ones all the rules are reinstalled
+	//in order to preserve their order, which matters for the non terminating targets
+	if !isPrefix(kept, newRules) {
+		for _, existingRule := range kept {
+			if err := r.IPtables.Delete(table, chain, strings.Split(existingRule, " ")...); err != nil {
+				return err
+			}
 		}
 	}
 	if err := r.InsertRulesIfNotPresent(clusterID, table, chain, newRules); err != nil {
@@ -253,6 +382,18 @@ func (r *RouteController) UpdateRulesPerChain(clusterID, chain, table string, ex
 	return nil
 }
 
+func isPrefix(prefix, rules []string) bool {
+	if len(prefix) > len(rules) {
+		return false
+	}
+	for i := range prefix {
+		if prefix[i] != rules[i] {
+			return false
+		}
+	}
+	return true
+}
+
 func (r *RouteController) ListRulesInChain(table, chain string) ([]string, error) {
 	existingRules, err := r.IPtables.List(table, chain)
 	if err != nil {
@@ -273,6 +414,18 @@ func (r *RouteController) ensureIPTablesRulesPerCluster(tep *netv1alpha1.TunnelE
 	if err := r.ensureChainRulespecs(tep); err != nil {
 		return err
 	}
+	//if the backend supports it the chains of the cluster are updated in a single transaction
+	if updater, ok := r.IPtables.(liqonetOperator.RuleSetUpdater); ok {
+		ruleSet, err := r.GetRuleSetPerCluster(tep)
+		if err != nil {
+			return err
+		}
+		if err := updater.UpdateRuleSet(ruleSet); err != nil {
+			return err
+		}
+		klog.Infof("%s -> updated rules in %d chains", tep.Spec.ClusterID, len(ruleSet))
+		return nil
+	}
 	if err := r.ensurePostroutingRules(tep); err != nil {
 		return err
 	}
@@ -285,9 +438,38 @@ func (r *RouteController) ensureIPTablesRulesPerCluster(tep *netv1alpha1.TunnelE
 	if err := r.ensureInputRules(tep); err != nil {
 		return err
 	}
+	if err := r.ensureIngressRules(tep); err != nil {
+		return err
+	}
 	return nil
 }
 
+//GetRuleSetPerCluster returns the rules of all the chains of a remote cluster, keyed by chain
+func (r *RouteController) GetRuleSetPerCluster(tep *netv1alpha1.TunnelEndpoint) (map[liqonetOperator.IPTableChain][]string, error) {
+	ruleSet := make(map[liqonetOperator.IPTableChain][]string)
+	for _, chain := range r.GetChainRulespecs(tep) {
+		var rules []string
+		var err error
+		switch {
+		case strings.HasPrefix(chain.chainName, LiqonetIngressClusterChainPrefix):
+			rules, err = r.GetIngressRules(tep)
+		case chain.chain == LiqonetPostroutingChain:
+			rules, err = r.GetPostroutingRules(tep)
+		case chain.chain == LiqonetPreroutingChain:
+			rules, err = r.GetPreroutingRules(tep)
+		case chain.chain == LiqonetForwardingChain:
+			rules = r.GetForwardRules(tep)
+		case chain.chain == LiqonetInputChain:
+			rules = r.GetInputRules(tep)
+		}
+		if err != nil {
+			return nil, err
+		}
+		ruleSet[liqonetOperator.IPTableChain{Table: chain.table, Name: chain.chainName}] = rules
+	}
+	return ruleSet, nil
+}
+
 func (r *RouteController) CreateIptablesChainIfNotExists(table string, newChain string) error {
 	//get existing chains
 	chains_list, err := r.IPtables.ListChains(table)
@@ -394,12 +576,15 @@ func (r *RouteController) GetChainRulespecs(tep *netv1alpha1.TunnelEndpoint) []s
 	chain     string
 } {
 	clusterID := tep.Spec.ClusterID
-	localRemappedPodCIDR, remotePodCIDR := r.GetPodCIDRS(tep)
+	localRemappedCIDR := r.getLocalRemappedCIDR(tep)
+	remotePodCIDR := r.GetRemoteCIDR(tep)
 	postRoutingChain := strings.Join([]string{LiqonetPostroutingClusterChainPrefix, strings.Split(clusterID, "-")[0]}, "")
 	preRoutingChain := strings.Join([]string{LiqonetPreroutingClusterChainPrefix, strings.Split(clusterID, "-")[0]}, "")
 	forwardChain := strings.Join([]string{LiqonetForwardingClusterChainPrefix, strings.Split(clusterID, "-")[0]}, "")
 	inputChain := strings.Join([]string{LiqonetInputClusterChainPrefix, strings.Split(clusterID, "-")[0]}, "")
-	if localRemappedPodCIDR != defaultPodCIDRValue {
+	ingressChain := strings.Join([]string{LiqonetIngressClusterChainPrefix, strings.Split(clusterID, "-")[0]}, "")
+	//the incoming traffic is NATed only if the local subnet has been remapped by the remote cluster
+	if localRemappedCIDR != "" {
 		return []struct {
 			chainName string
 			rulespec  string
@@ -414,7 +599,7 @@ func (r *RouteController) GetChainRulespecs(tep *netv1alpha1.TunnelEndpoint) []s
 			},
 			{
 				preRoutingChain,
-				strings.Join([]string{"-d", localRemappedPodCIDR, "-j", preRoutingChain}, " "),
+				strings.Join([]string{"-d", localRemappedCIDR, "-j", preRoutingChain}, " "),
 				NatTable,
 				LiqonetPreroutingChain,
 			},
@@ -430,6 +615,12 @@ func (r *RouteController) GetChainRulespecs(tep *netv1alpha1.TunnelEndpoint) []s
 				FilterTable,
 				LiqonetInputChain,
 			},
+			{
+				ingressChain,
+				strings.Join([]string{"-s", remotePodCIDR, "-j", ingressChain}, " "),
+				FilterTable,
+				LiqonetForwardingChain,
+			},
 		}
 	}
 	return []struct {
@@ -456,6 +647,12 @@ func (r *RouteController) GetChainRulespecs(tep *netv1alpha1.TunnelEndpoint) []s
 			FilterTable,
 			LiqonetInputChain,
 		},
+		{
+			ingressChain,
+			strings.Join([]string{"-s", remotePodCIDR, "-j", ingressChain}, " "),
+			FilterTable,
+			LiqonetForwardingChain,
+		},
 	}
 
 }
@@ -509,18 +706,95 @@ func (r *RouteController) ensurePostroutingRules(tep *netv1alpha1.TunnelEndpoint
 	return r.UpdateRulesPerChain(clusterID, postRoutingChain, NatTable, existingRules, rules)
 }
 
-func (r *RouteController) ensurePreroutingRules(tep *netv1alpha1.TunnelEndpoint) error {
-	//if the node is not a gateway node then return
-	if !r.IsGateway {
-		return nil
+//getLocalRemappedCIDR returns the subnet used by the remote cluster to reach the local one, i.e. the pods' subnet
+//or the services' one in ServiceCIDR mode, if it has been remapped. The empty string is returned otherwise.
+func (r *RouteController) getLocalRemappedCIDR(tep *netv1alpha1.TunnelEndpoint) string {
+	if r.IsServiceCIDRMode(tep) {
+		if tep.Status.LocalRemappedServiceCIDR == defaultPodCIDRValue {
+			return ""
+		}
+		return tep.Status.LocalRemappedServiceCIDR
 	}
-	//check if we need to NAT the incoming traffic from the peering cluster
 	localRemappedPodCIDR, _ := r.GetPodCIDRS(tep)
 	if localRemappedPodCIDR == defaultPodCIDRValue {
+		return ""
+	}
+	return localRemappedPodCIDR
+}
+
+func (r *RouteController) GetPreroutingRules(tep *netv1alpha1.TunnelEndpoint) ([]string, error) {
+	//if the node is not a gateway node there is nothing to NAT
+	if !r.IsGateway {
+		return nil, nil
+	}
+	//check if we need to NAT the incoming traffic from the peering cluster
+	localRemappedCIDR := r.getLocalRemappedCIDR(tep)
+	if localRemappedCIDR == "" {
+		return nil, nil
+	}
+	if r.IsServiceCIDRMode(tep) {
+		return r.getServicePreroutingRules(tep, localRemappedCIDR)
+	}
+	return []string{
+		strings.Join([]string{"-d", localRemappedCIDR, "-i", tep.Status.TunnelIFaceName, "-j", "NETMAP", "--to", r.ClusterPodCIDR}, " "),
+	}, nil
+}
+
+//getServicePreroutingRules translates the remapped ClusterIPs, used by the remote cluster to reach the local services,
+//back to the original ones. A rule is needed for each service, since the services' subnet can be narrower than the
+//remapped one. A wider services' subnet is refused, since different ClusterIPs would be remapped to the same IP.
+func (r *RouteController) getServicePreroutingRules(tep *netv1alpha1.TunnelEndpoint, localRemappedServiceCIDR string) ([]string, error) {
+	if err := checkRemappedSubnet(r.ClusterServiceCIDR, localRemappedServiceCIDR); err != nil {
+		klog.Errorf("%s -> unable to translate the remapped ClusterIPs: %s", tep.Spec.ClusterID, err)
+		return nil, err
+	}
+	services := &corev1.ServiceList{}
+	if err := r.List(context.Background(), services); err != nil {
+		klog.Errorf("%s -> unable to list the services: %s", tep.Spec.ClusterID, err)
+		return nil, err
+	}
+	var rules []string
+	for i := range services.Items {
+		if !hasClusterIP(&services.Items[i]) {
+			continue
+		}
+		clusterIP := services.Items[i].Spec.ClusterIP
+		remappedIP := forge.ChangeServiceIp(localRemappedServiceCIDR, clusterIP)
+		rules = append(rules, strings.Join([]string{"-d", remappedIP + "/32", "-i", tep.Status.TunnelIFaceName, "-j", "DNAT", "--to-destination", clusterIP}, " "))
+	}
+	sort.Strings(rules)
+	return rules, nil
+}
+
+//checkRemappedSubnet returns an error if the subnet is wider than the one it is remapped to
+func checkRemappedSubnet(subnet string, remappedSubnet string) error {
+	_, original, err := net.ParseCIDR(subnet)
+	if err != nil {
+		return err
+	}
+	_, remapped, err := net.ParseCIDR(remappedSubnet)
+	if err != nil {
+		return err
+	}
+	originalOnes, _ := original.Mask.Size()
+	remappedOnes, _ := remapped.Mask.Size()
+	if originalOnes < remappedOnes {
+		return fmt.Errorf("the subnet %s is wider than the subnet %s it is remapped to", subnet, remappedSubnet)
+	}
+	return nil
+}
+
+func (r *RouteController) ensurePreroutingRules(tep *netv1alpha1.TunnelEndpoint) error {
+	//the chain exists only if the local subnet has been remapped by the remote cluster
+	if r.getLocalRemappedCIDR(tep) == "" {
 		return nil
 	}
+	//no rules are returned if the node is not the gateway: the ones installed while it was are removed
+	rules, err := r.GetPreroutingRules(tep)
+	if err != nil {
+		return err
+	}
 	clusterID := tep.Spec.ClusterID
-	tunnelIFace := tep.Status.TunnelIFaceName
 	preRoutingChain := strings.Join([]string{LiqonetPreroutingClusterChainPrefix, strings.Split(clusterID, "-")[0]}, "")
 	//list rules in the chain
 	existingRules, err := r.ListRulesInChain(NatTable, preRoutingChain)
@@ -528,14 +802,20 @@ func (r *RouteController) ensurePreroutingRules(tep *netv1alpha1.TunnelEndpoint)
 		klog.Errorf("%s -> unable to list rules for chain %s in table %s: %s", clusterID, preRoutingChain, NatTable, err)
 		return err
 	}
-	rules := []string{
-		strings.Join([]string{"-d", localRemappedPodCIDR, "-i", tunnelIFace, "-j", "NETMAP", "--to", r.ClusterPodCIDR}, " "),
-	}
 	return r.UpdateRulesPerChain(clusterID, preRoutingChain, NatTable, existingRules, rules)
 }
 
+func (r *RouteController) GetForwardRules(tep *netv1alpha1.TunnelEndpoint) []string {
+	remotePodCIDR := r.GetRemoteCIDR(tep)
+	return []string{
+		//the MSS of the new TCP connections is clamped to the MTU of the route towards the remote cluster,
+		//that is the vxlan interface or, on the gateway, the tunnel interface
+		strings.Join([]string{"-d", remotePodCIDR, "-p", "tcp", "-m", "tcp", "--tcp-flags", "SYN,RST", "SYN", "-j", "TCPMSS", "--clamp-mss-to-pmtu"}, " "),
+		strings.Join([]string{"-d", remotePodCIDR, "-j", "ACCEPT"}, " "),
+	}
+}
+
 func (r *RouteController) ensureForwardRules(tep *netv1alpha1.TunnelEndpoint) error {
-	_, remotePodCIDR := r.GetPodCIDRS(tep)
 	clusterID := tep.Spec.ClusterID
 	forwardChain := strings.Join([]string{LiqonetForwardingClusterChainPrefix, strings.Split(clusterID, "-")[0]}, "")
 
@@ -545,14 +825,17 @@ func (r *RouteController) ensureForwardRules(tep *netv1alpha1.TunnelEndpoint) er
 		klog.Errorf("%s -> unable to list rules for chain %s in table %s: %s", clusterID, forwardChain, NatTable, err)
 		return err
 	}
-	rules := []string{
-		strings.Join([]string{"-d", remotePodCIDR, "-j", "ACCEPT"}, " "),
+	return r.UpdateRulesPerChain(clusterID, forwardChain, FilterTable, existingRules, r.GetForwardRules(tep))
+}
+
+func (r *RouteController) GetInputRules(tep *netv1alpha1.TunnelEndpoint) []string {
+	remotePodCIDR := r.GetRemoteCIDR(tep)
+	return []string{
+		strings.Join([]string{"-s", r.ClusterPodCIDR, "-d", remotePodCIDR, "-j", "ACCEPT"}, " "),
 	}
-	return r.UpdateRulesPerChain(clusterID, forwardChain, FilterTable, existingRules, rules)
 }
 
 func (r *RouteController) ensureInputRules(tep *netv1alpha1.TunnelEndpoint) error {
-	_, remotePodCIDR := r.GetPodCIDRS(tep)
 	clusterID := tep.Spec.ClusterID
 	inputChain := strings.Join([]string{LiqonetInputClusterChainPrefix, strings.Split(clusterID, "-")[0]}, "")
 
@@ -562,10 +845,104 @@ func (r *RouteController) ensureInputRules(tep *netv1alpha1.TunnelEndpoint) erro
 		klog.Errorf("%s -> unable to list rules for chain %s in table %s: %s", clusterID, inputChain, FilterTable, err)
 		return err
 	}
+	return r.UpdateRulesPerChain(clusterID, inputChain, FilterTable, existingRules, r.GetInputRules(tep))
+}
+
+//GetIngressRules returns the rules which filter the traffic coming from the remote cluster according to the ingress
+//policy of the tunnelEndpoint. Without a policy the chain is empty and the traffic is handled by the other chains of the host.
+//The namespaces are translated in the addresses of their pods, the replies to the connections opened by the local pods are
+//always allowed and everything else is dropped.
+func (r *RouteController) GetIngressRules(tep *netv1alpha1.TunnelEndpoint) ([]string, error) {
+	policy := tep.Spec.IngressPolicy
+	if policy == nil {
+		return nil, nil
+	}
+	remotePodCIDR := r.GetRemoteCIDR(tep)
 	rules := []string{
-		strings.Join([]string{"-s", r.ClusterPodCIDR, "-d", remotePodCIDR, "-j", "ACCEPT"}, " "),
+		strings.Join([]string{"-s", remotePodCIDR, "-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED", "-j", "ACCEPT"}, " "),
+	}
+	//the same rule may be generated more than once, i.e. when a pod is selected by several rules of the policy
+	seen := make(map[string]bool)
+	add := func(rule string) {
+		if !seen[rule] {
+			seen[rule] = true
+			rules = append(rules, rule)
+		}
+	}
+	for _, rule := range policy.Rules {
+		destinations, err := r.getIngressDestinations(tep.Spec.ClusterID, rule)
+		if err != nil {
+			return nil, err
+		}
+		for _, destination := range destinations {
+			match := []string{"-s", remotePodCIDR}
+			if destination != "" {
+				match = append(match, "-d", destination)
+			}
+			if len(rule.Ports) == 0 {
+				add(strings.Join(append(match, "-j", "ACCEPT"), " "))
+				continue
+			}
+			for _, port := range rule.Ports {
+				protocol := strings.ToLower(string(port.Protocol))
+				if protocol == "" {
+					protocol = "tcp"
+				}
+				portMatch := []string{"-p", protocol, "-m", protocol, "--dport", strconv.Itoa(int(port.Port)), "-j", "ACCEPT"}
+				add(strings.Join(append(append([]string{}, match...), portMatch...), " "))
+			}
+		}
+	}
+	return append(rules, strings.Join([]string{"-s", remotePodCIDR, "-j", "DROP"}, " ")), nil
+}
+
+//getIngressDestinations returns the local addresses allowed by a rule of an ingress policy, in the format
+//used by iptables when listing the rules. A rule without namespaces and subnets allows any destination,
+//identified by the empty string. The invalid subnets are skipped so that the policy never allows more than requested.
+func (r *RouteController) getIngressDestinations(clusterID string, rule netv1alpha1.IngressRule) ([]string, error) {
+	if len(rule.Namespaces) == 0 && len(rule.CIDRs) == 0 {
+		return []string{""}, nil
+	}
+	var destinations []string
+	for _, cidr := range rule.CIDRs {
+		_, subnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			klog.Errorf("%s -> skipping invalid subnet %s of the ingress policy: %s", clusterID, cidr, err)
+			continue
+		}
+		destinations = append(destinations, subnet.String())
+	}
+	for _, namespace := range rule.Namespaces {
+		pods := &corev1.PodList{}
+		if err := r.List(context.Background(), pods, client.InNamespace(namespace)); err != nil {
+			klog.Errorf("%s -> unable to list the pods of namespace %s: %s", clusterID, namespace, err)
+			return nil, err
+		}
+		for i := range pods.Items {
+			pod := &pods.Items[i]
+			if pod.Status.PodIP == "" || pod.Spec.HostNetwork {
+				continue
+			}
+			destinations = append(destinations, pod.Status.PodIP+"/32")
+		}
 	}
-	return r.UpdateRulesPerChain(clusterID, inputChain, FilterTable, existingRules, rules)
+	return destinations, nil
+}
+
+func (r *RouteController) ensureIngressRules(tep *netv1alpha1.TunnelEndpoint) error {
+	clusterID := tep.Spec.ClusterID
+	ingressChain := strings.Join([]string{LiqonetIngressClusterChainPrefix, strings.Split(clusterID, "-")[0]}, "")
+	rules, err := r.GetIngressRules(tep)
+	if err != nil {
+		return err
+	}
+	//list rules in the chain
+	existingRules, err := r.ListRulesInChain(FilterTable, ingressChain)
+	if err != nil {
+		klog.Errorf("%s -> unable to list rules for chain %s in table %s: %s", clusterID, ingressChain, FilterTable, err)
+		return err
+	}
+	return r.UpdateRulesPerChain(clusterID, ingressChain, FilterTable, existingRules, rules)
 }
 
 //this function is called at startup of the operator
@@ -690,7 +1067,8 @@ func (r *RouteController) removeAllIPTablesChains(teps netv1alpha1.TunnelEndpoin
 	//second we delete the references to the chains
 	for k, rulespec := range r.IPTablesRuleSpecsReferencingChains {
 		if err = ipt.Delete(rulespec.Table, rulespec.Chain, rulespec.RuleSpec...); err != nil {
-			e, ok := err.(*iptables.Error)
+			//both go-iptables and the nftables backend expose IsNotExist on their errors
+			e, ok := err.(interface{ IsNotExist() bool })
 			if ok && e.IsNotExist() {
 				delete(r.IPTablesRuleSpecsReferencingChains, k)
 			} else if !ok {
@@ -708,7 +1086,7 @@ func (r *RouteController) removeAllIPTablesChains(teps netv1alpha1.TunnelEndpoin
 
 func (r *RouteController) ensureRoutesPerCluster(tep *netv1alpha1.TunnelEndpoint) error {
 	clusterID := tep.Spec.ClusterID
-	_, remotePodCIDR := r.GetPodCIDRS(tep)
+	remotePodCIDR := r.GetRemoteCIDR(tep)
 	if r.IsGateway {
 		existing, ok := r.RoutesPerRemoteCluster[clusterID]
 		if ok {
@@ -829,7 +1207,154 @@ func (r *RouteController) SetupWithManager(mgr ctrl.Manager) error {
 			return false
 		},
 	}
-	return ctrl.NewControllerManagedBy(mgr).WithEventFilter(resourceToBeProccesedPredicate).
-		For(&netv1alpha1.TunnelEndpoint{}).
+	//the gateway nodes are watched to follow the failover of the tunnel-operator
+	gatewayChangedPredicate := predicate.Funcs{
+		CreateFunc: func(e event.CreateEvent) bool {
+			return e.Meta.GetLabels()[liqonetOperator.GatewayLabelKey] == "true"
+		},
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			oldNode, okOld := e.ObjectOld.(*corev1.Node)
+			newNode, okNew := e.ObjectNew.(*corev1.Node)
+			return okOld && okNew && gatewayChanged(oldNode, newNode)
+		},
+		DeleteFunc: func(e event.DeleteEvent) bool {
+			return e.Meta.GetLabels()[liqonetOperator.GatewayLabelKey] == "true"
+		},
+		GenericFunc: func(e event.GenericEvent) bool {
+			return false
+		},
+	}
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&netv1alpha1.TunnelEndpoint{}, builder.WithPredicates(resourceToBeProccesedPredicate, ignoreConnectionUpdates)).
+		Watches(&source.Kind{Type: &corev1.Node{}}, &handler.EnqueueRequestsFromMapFunc{
+			ToRequests: handler.ToRequestsFunc(r.allTunnelEndpoints),
+		}, builder.WithPredicates(gatewayChangedPredicate)).
+		//the pods are watched to keep up to date the addresses allowed by the ingress policies
+		Watches(&source.Kind{Type: &corev1.Pod{}}, &handler.EnqueueRequestsFromMapFunc{
+			ToRequests: handler.ToRequestsFunc(r.tunnelEndpointsSelectingPod),
+		}, builder.WithPredicates(podAddressChangedPredicate)).
+		//the services are watched to keep up to date the translation of the remapped ClusterIPs
+		Watches(&source.Kind{Type: &corev1.Service{}}, &handler.EnqueueRequestsFromMapFunc{
+			ToRequests: handler.ToRequestsFunc(r.tunnelEndpointsRoutingServices),
+		}, builder.WithPredicates(clusterIPChangedPredicate)).
 		Complete(r)
 }
+
+//podAddressChangedPredicate filters the events of the pods which change the addresses allowed by the ingress
+//policies: the pods are created without an IP, hence only the updates setting it and the deletions are processed
+var podAddressChangedPredicate = predicate.Funcs{
+	CreateFunc: func(e event.CreateEvent) bool {
+		return false
+	},
+	UpdateFunc: func(e event.UpdateEvent) bool {
+		oldPod, okOld := e.ObjectOld.(*corev1.Pod)
+		newPod, okNew := e.ObjectNew.(*corev1.Pod)
+		return okOld && okNew && oldPod.Status.PodIP != newPod.Status.PodIP
+	},
+	DeleteFunc: func(e event.DeleteEvent) bool {
+		pod, ok := e.Object.(*corev1.Pod)
+		return ok && pod.Status.PodIP != ""
+	},
+	GenericFunc: func(e event.GenericEvent) bool {
+		return false
+	},
+}
+
+//clusterIPChangedPredicate filters the events of the services which change the translation of the remapped ClusterIPs
+var clusterIPChangedPredicate = predicate.Funcs{
+	CreateFunc: func(e event.CreateEvent) bool {
+		svc, ok := e.Object.(*corev1.Service)
+		return ok && hasClusterIP(svc)
+	},
+	UpdateFunc: func(e event.UpdateEvent) bool {
+		oldSvc, okOld := e.ObjectOld.(*corev1.Service)
+		newSvc, okNew := e.ObjectNew.(*corev1.Service)
+		return okOld && okNew && oldSvc.Spec.ClusterIP != newSvc.Spec.ClusterIP
+	},
+	DeleteFunc: func(e event.DeleteEvent) bool {
+		svc, ok := e.Object.(*corev1.Service)
+		return ok && hasClusterIP(svc)
+	},
+	GenericFunc: func(e event.GenericEvent) bool {
+		return false
+	},
+}
+
+func hasClusterIP(svc *corev1.Service) bool {
+	return svc.Spec.ClusterIP != "" && svc.Spec.ClusterIP != corev1.ClusterIPNone
+}
+
+//gatewayChanged returns true if the node has become or is no longer a gateway node, the active one
+//has changed or the address used to reach it through the vxlan network has changed
+func gatewayChanged(oldNode, newNode *corev1.Node) bool {
+	if oldNode.Labels[liqonetOperator.GatewayLabelKey] != newNode.Labels[liqonetOperator.GatewayLabelKey] ||
+		oldNode.Labels[liqonetOperator.ActiveGatewayLabelKey] != newNode.Labels[liqonetOperator.ActiveGatewayLabelKey] {
+		return true
+	}
+	if newNode.Labels[liqonetOperator.GatewayLabelKey] != "true" {
+		return false
+	}
+	oldIP, _ := liqonetOperator.GetInternalIPOfNode(*oldNode)
+	newIP, _ := liqonetOperator.GetInternalIPOfNode(*newNode)
+	return oldIP != newIP
+}
+
+//allTunnelEndpoints returns all the tunnelEndpoints, since the rules and the routes of every remote cluster
+//depend on the active gateway
+func (r *RouteController) allTunnelEndpoints(obj handler.MapObject) []reconcile.Request {
+	teps := &netv1alpha1.TunnelEndpointList{}
+	if err := r.List(context.Background(), teps); err != nil {
+		klog.Errorf("unable to list the tunnelEndpoints: %s", err)
+		return nil
+	}
+	requests := make([]reconcile.Request, 0, len(teps.Items))
+	for i := range teps.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: teps.Items[i].Name}})
+	}
+	return requests
+}
+
+//tunnelEndpointsRoutingServices returns the tunnelEndpoints of the remote clusters which reach the local services
+//through their remapped ClusterIPs
+func (r *RouteController) tunnelEndpointsRoutingServices(obj handler.MapObject) []reconcile.Request {
+	teps := &netv1alpha1.TunnelEndpointList{}
+	if err := r.List(context.Background(), teps); err != nil {
+		klog.Errorf("unable to list the tunnelEndpoints: %s", err)
+		return nil
+	}
+	var requests []reconcile.Request
+	for i := range teps.Items {
+		if r.IsServiceCIDRMode(&teps.Items[i]) {
+			requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: teps.Items[i].Name}})
+		}
+	}
+	return requests
+}
+
+//tunnelEndpointsSelectingPod returns the tunnelEndpoints whose ingress policy allows the namespace of the given pod
+func (r *RouteController) tunnelEndpointsSelectingPod(obj handler.MapObject) []reconcile.Request {
+	teps := &netv1alpha1.TunnelEndpointList{}
+	if err := r.List(context.Background(), teps); err != nil {
+		klog.Errorf("unable to list the tunnelEndpoints: %s", err)
+		return nil
+	}
+	var requests []reconcile.Request
+	for i := range teps.Items {
+		if ingressPolicySelectsNamespace(teps.Items[i].Spec.IngressPolicy, obj.Meta.GetNamespace()) {
+			requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: teps.Items[i].Name}})
+		}
+	}
+	return requests
+}
+
+func ingressPolicySelectsNamespace(policy *netv1alpha1.IngressPolicy, namespace string) bool {
+	if policy == nil {
+		return false
+	}
+	for _, rule := range policy.Rules {
+		if liqonetOperator.ContainsString(rule.Namespaces, namespace) {
+			return true
+		}
+	}
+	return false
+}
@@ -3,12 +3,17 @@ package liqonetOperators
 import (
 	netv1alpha1 "github.com/liqotech/liqo/apis/net/v1alpha1"
 	"github.com/liqotech/liqo/pkg/liqonet"
+	"github.com/liqotech/liqo/pkg/virtualKubelet/forge"
 	"github.com/stretchr/testify/assert"
 	"github.com/vishvananda/netlink"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
 	"reflect"
 	"strings"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
 	"testing"
 )
 
@@ -300,6 +305,20 @@ func TestRouteController_UpdateRulesPerChain(t *testing.T) {
 	}
 }
 
+func TestRouteController_UpdateRulesPerChainOrder(t *testing.T) {
+	r := getRouteController()
+	existingRules := []string{"-d 10.2.0.0/16 -j ACCEPT"}
+	newRules := []string{"-d 10.2.0.0/16 -p tcp -m tcp --tcp-flags SYN,RST SYN -j TCPMSS --clamp-mss-to-pmtu", "-d 10.2.0.0/16 -j ACCEPT"}
+	_ = r.InsertRulesIfNotPresent("routeOperatorUnitTests", FilterTable, "testChain", existingRules)
+	assert.Nil(t, r.UpdateRulesPerChain("routeOperatorUnitTests", "testChain", FilterTable, existingRules, newRules))
+	//the new rule has to precede the existing one
+	var rules []string
+	for _, rule := range ip.Rules {
+		rules = append(rules, strings.Join(rule.RuleSpec, " "))
+	}
+	assert.Equal(t, newRules, rules)
+}
+
 func TestRouteController_ListRulesInChain(t *testing.T) {
 	r := getRouteController()
 	//here we emulute how the rules are present when listing theme with option -S i.e iptables -S chain -t table
@@ -331,11 +350,11 @@ func TestRouteController_GetChainRulespecs(t *testing.T) {
 		expectedNumberofChains int
 	}{
 		{"10.1.0.0/16",
-			4,
+			5,
 		},
 		{
 			defaultPodCIDRValue,
-			3,
+			4,
 		},
 	}
 	for _, test := range tests {
@@ -344,3 +363,270 @@ func TestRouteController_GetChainRulespecs(t *testing.T) {
 		assert.Equal(t, test.expectedNumberofChains, len(chainRulespecs))
 	}
 }
+
+func TestRouteController_NFTablesBackend(t *testing.T) {
+	r := getRouteController()
+	nftExec := &liqonet.MockNftExecutor{}
+	r.IPtables = &liqonet.NFTables{Exec: nftExec}
+	r.IsGateway = true
+	tep := GetTunnelEndpointCR()
+	tep.Status.LocalRemappedPodCIDR = "10.1.0.0/16"
+	assert.Nil(t, r.CreateAndEnsureIPTablesChains())
+	assert.Nil(t, r.ensureIPTablesRulesPerCluster(tep))
+	//once the chains exist the rules of the cluster are updated in a single transaction
+	scripts := len(nftExec.Scripts)
+	assert.Nil(t, r.ensureIPTablesRulesPerCluster(tep))
+	assert.Equal(t, scripts+1, len(nftExec.Scripts))
+	ruleSet, err := r.GetRuleSetPerCluster(tep)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, len(ruleSet))
+	for chain, expectedRules := range ruleSet {
+		rules, err := r.ListRulesInChain(chain.Table, chain.Name)
+		assert.Nil(t, err)
+		assert.Equal(t, len(expectedRules), len(rules))
+		for i := range expectedRules {
+			assert.Equal(t, expectedRules[i], rules[i])
+		}
+	}
+	//removing the cluster deletes its chains and the rules referencing them
+	assert.Nil(t, r.removeIPTablesPerCluster(tep))
+	for chain := range ruleSet {
+		chains, err := r.IPtables.ListChains(chain.Table)
+		assert.Nil(t, err)
+		assert.NotContains(t, chains, chain.Name)
+	}
+}
+
+func TestRouteController_ServiceCIDRMode(t *testing.T) {
+	r := getRouteController()
+	r.ClusterServiceCIDR = "10.100.0.0/16"
+	tep := GetTunnelEndpointCR()
+	tep.Status.LocalRemappedPodCIDR = "10.2.0.0/16"
+	//without the service subnets the pods' subnet is routed
+	assert.False(t, r.IsServiceCIDRMode(tep))
+	assert.Equal(t, tep.Spec.PodCIDR, r.GetRemoteCIDR(tep))
+	tep.Spec.ServiceCIDR = "10.96.0.0/12"
+	tep.Status.LocalRemappedServiceCIDR = "10.5.0.0/16"
+	tep.Status.RemoteRemappedServiceCIDR = "10.6.0.0/16"
+	assert.True(t, r.IsServiceCIDRMode(tep))
+	assert.Equal(t, "10.6.0.0/16", r.GetRemoteCIDR(tep))
+	//the remapped ClusterIPs are translated back on the gateway, the users' services are left untouched
+	r.Client = fake.NewFakeClientWithScheme(scheme.Scheme,
+		&corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "svc", Namespace: "default"}, Spec: corev1.ServiceSpec{ClusterIP: "10.100.3.4"}},
+		&corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "headless", Namespace: "default"}, Spec: corev1.ServiceSpec{ClusterIP: corev1.ClusterIPNone}})
+	tep.Status.TunnelIFaceName = "gre-1"
+	for _, isGateway := range []bool{false, true} {
+		r.IsGateway = isGateway
+		for _, chain := range r.GetChainRulespecs(tep) {
+			switch {
+			case chain.chain == LiqonetPreroutingChain:
+				assert.Equal(t, "-d 10.5.0.0/16 -j "+chain.chainName, chain.rulespec)
+			case strings.HasPrefix(chain.chainName, LiqonetIngressClusterChainPrefix):
+				assert.Equal(t, "-s 10.6.0.0/16 -j "+chain.chainName, chain.rulespec)
+			default:
+				assert.Equal(t, "-d 10.6.0.0/16 -j "+chain.chainName, chain.rulespec)
+			}
+		}
+	}
+	rules, err := r.GetPreroutingRules(tep)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"-d 10.5.3.4/32 -i gre-1 -j DNAT --to-destination 10.100.3.4"}, rules)
+	//a narrower services' subnet keeps its host bits in the remapped one
+	r.ClusterServiceCIDR = "10.100.16.0/20"
+	r.Client = fake.NewFakeClientWithScheme(scheme.Scheme,
+		&corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "svc", Namespace: "default"}, Spec: corev1.ServiceSpec{ClusterIP: "10.100.19.4"}})
+	rules, err = r.GetPreroutingRules(tep)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"-d 10.5.19.4/32 -i gre-1 -j DNAT --to-destination 10.100.19.4"}, rules)
+	//a wider services' subnet is refused, different ClusterIPs would be remapped to the same IP
+	r.ClusterServiceCIDR = "10.96.0.0/12"
+	r.Client = fake.NewFakeClientWithScheme(scheme.Scheme,
+		&corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "svc1", Namespace: "default"}, Spec: corev1.ServiceSpec{ClusterIP: "10.96.3.4"}},
+		&corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "svc2", Namespace: "default"}, Spec: corev1.ServiceSpec{ClusterIP: "10.100.3.4"}})
+	assert.Equal(t, forge.ChangeServiceIp("10.5.0.0/16", "10.96.3.4"), forge.ChangeServiceIp("10.5.0.0/16", "10.100.3.4"))
+	_, err = r.GetPreroutingRules(tep)
+	assert.NotNil(t, err)
+	r.ClusterServiceCIDR = "10.100.0.0/16"
+	r.IsGateway = false
+	rules, err = r.GetPreroutingRules(tep)
+	assert.Nil(t, err)
+	assert.Nil(t, rules)
+	r.IsGateway = true
+	//the traffic leaves the gateway with a host address of the remapped subnet
+	rules, err = r.GetPostroutingRules(tep)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"-d 10.6.0.0/16 -j SNAT --to-source 10.5.0.1"}, rules)
+	r.IsGateway = false
+	rules, err = r.GetPostroutingRules(tep)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"-s 10.200.0.0/16 -d 10.6.0.0/16 -j ACCEPT"}, rules)
+	//if the subnets are not remapped the original ones are used
+	tep.Status.LocalRemappedServiceCIDR = "None"
+	tep.Status.RemoteRemappedServiceCIDR = "None"
+	r.IsGateway = true
+	rules, err = r.GetPostroutingRules(tep)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"-d 10.96.0.0/12 -j SNAT --to-source 10.100.0.1"}, rules)
+	rules, err = r.GetPreroutingRules(tep)
+	assert.Nil(t, err)
+	assert.Nil(t, rules)
+	assert.Equal(t, []string{"-d 10.96.0.0/12 -p tcp -m tcp --tcp-flags SYN,RST SYN -j TCPMSS --clamp-mss-to-pmtu", "-d 10.96.0.0/12 -j ACCEPT"}, r.GetForwardRules(tep))
+}
+
+func TestRouteController_CheckGateway(t *testing.T) {
+	r := getRouteController()
+	r.VxlanNetwork = "192.168.200.0/24"
+	gateway := func(name, ip string, active bool) *corev1.Node {
+		node := &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{liqonet.GatewayLabelKey: "true"}},
+			Status:     corev1.NodeStatus{Addresses: []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: ip}}},
+		}
+		if active {
+			node.Labels[liqonet.ActiveGatewayLabelKey] = "true"
+		}
+		return node
+	}
+	r.Client = fake.NewFakeClientWithScheme(scheme.Scheme, gateway("gw1", "10.0.0.1", true), gateway("test", "10.0.0.2", false))
+	assert.Nil(t, r.CheckGateway())
+	assert.False(t, r.IsGateway)
+	assert.Equal(t, "192.168.200.1", r.GatewayVxlanIP)
+	//after the failover the node hosting the route-operator is the gateway
+	r.Client = fake.NewFakeClientWithScheme(scheme.Scheme, gateway("gw1", "10.0.0.1", false), gateway("test", "10.0.0.2", true))
+	assert.Nil(t, r.CheckGateway())
+	assert.True(t, r.IsGateway)
+	assert.Equal(t, "192.168.200.2", r.GatewayVxlanIP)
+	//while the label is moving the current configuration is kept
+	r.Client = fake.NewFakeClientWithScheme(scheme.Scheme, gateway("gw1", "10.0.0.1", true), gateway("test", "10.0.0.2", true))
+	assert.NotNil(t, r.CheckGateway())
+	assert.True(t, r.IsGateway)
+}
+
+//iptablesBackend hides the transactional updates of the nftables backend, to exercise the update of the single chains
+type iptablesBackend struct {
+	liqonet.IPTables
+}
+
+func TestRouteController_GatewayDemotion(t *testing.T) {
+	r := getRouteController()
+	r.IPtables = iptablesBackend{&liqonet.NFTables{Exec: &liqonet.MockNftExecutor{}}}
+	routes := r.NetLink.(*liqonet.MockRouteManager)
+	r.RoutesPerRemoteCluster = make(map[string]netlink.Route)
+	r.IsGateway = true
+	tep := GetTunnelEndpointCR()
+	tep.Status.LocalRemappedPodCIDR = "10.1.0.0/16"
+	preRoutingChain := LiqonetPreroutingClusterChainPrefix + "cluster"
+	postRoutingChain := LiqonetPostroutingClusterChainPrefix + "cluster"
+	assert.Nil(t, r.CreateAndEnsureIPTablesChains())
+	assert.Nil(t, r.ensureIPTablesRulesPerCluster(tep))
+	assert.Nil(t, r.ensureRoutesPerCluster(tep))
+	rules, err := r.ListRulesInChain(NatTable, preRoutingChain)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"-d 10.1.0.0/16 -i testtunnel -j NETMAP --to 10.200.0.0/16"}, rules)
+	assert.Equal(t, 1, len(routes.RouteList))
+	assert.Nil(t, routes.RouteList[0].Gw)
+	//once the node is no longer the gateway the NAT rules are removed and the traffic is sent to the new one
+	r.IsGateway = false
+	assert.Nil(t, r.ensureIPTablesRulesPerCluster(tep))
+	assert.Nil(t, r.ensureRoutesPerCluster(tep))
+	rules, err = r.ListRulesInChain(NatTable, preRoutingChain)
+	assert.Nil(t, err)
+	assert.Empty(t, rules)
+	rules, err = r.ListRulesInChain(NatTable, postRoutingChain)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"-s 10.200.0.0/16 -d 10.100.0.0/16 -j ACCEPT"}, rules)
+	assert.Equal(t, 1, len(routes.RouteList))
+	assert.Equal(t, r.GatewayVxlanIP, routes.RouteList[0].Gw.String())
+}
+
+func TestGatewayChanged(t *testing.T) {
+	node := func(gateway, active, ip string) *corev1.Node {
+		return &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "node", Labels: map[string]string{liqonet.GatewayLabelKey: gateway, liqonet.ActiveGatewayLabelKey: active}},
+			Status:     corev1.NodeStatus{Addresses: []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: ip}}},
+		}
+	}
+	assert.False(t, gatewayChanged(node("true", "true", "10.0.0.1"), node("true", "true", "10.0.0.1")))
+	assert.True(t, gatewayChanged(node("true", "true", "10.0.0.1"), node("true", "", "10.0.0.1")))
+	assert.True(t, gatewayChanged(node("true", "", "10.0.0.1"), node("", "", "10.0.0.1")))
+	assert.True(t, gatewayChanged(node("true", "true", "10.0.0.1"), node("true", "true", "10.0.0.2")))
+	//the addresses of the other nodes do not matter
+	assert.False(t, gatewayChanged(node("", "", "10.0.0.1"), node("", "", "10.0.0.2")))
+}
+
+func TestPodAddressChangedPredicate(t *testing.T) {
+	pod := func(ip string) *corev1.Pod {
+		return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod"}, Status: corev1.PodStatus{PodIP: ip}}
+	}
+	assert.False(t, podAddressChangedPredicate.Create(event.CreateEvent{Meta: pod(""), Object: pod("")}))
+	assert.True(t, podAddressChangedPredicate.Update(event.UpdateEvent{MetaOld: pod(""), ObjectOld: pod(""), MetaNew: pod("10.200.1.1"), ObjectNew: pod("10.200.1.1")}))
+	assert.False(t, podAddressChangedPredicate.Update(event.UpdateEvent{MetaOld: pod("10.200.1.1"), ObjectOld: pod("10.200.1.1"), MetaNew: pod("10.200.1.1"), ObjectNew: pod("10.200.1.1")}))
+	assert.True(t, podAddressChangedPredicate.Delete(event.DeleteEvent{Meta: pod("10.200.1.1"), Object: pod("10.200.1.1")}))
+	assert.False(t, podAddressChangedPredicate.Delete(event.DeleteEvent{Meta: pod(""), Object: pod("")}))
+	assert.False(t, podAddressChangedPredicate.Generic(event.GenericEvent{Meta: pod("10.200.1.1"), Object: pod("10.200.1.1")}))
+}
+
+func TestClusterIPChangedPredicate(t *testing.T) {
+	svc := func(clusterIP string) *corev1.Service {
+		return &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "svc"}, Spec: corev1.ServiceSpec{ClusterIP: clusterIP}}
+	}
+	assert.True(t, clusterIPChangedPredicate.Create(event.CreateEvent{Meta: svc("10.96.0.10"), Object: svc("10.96.0.10")}))
+	assert.False(t, clusterIPChangedPredicate.Create(event.CreateEvent{Meta: svc(corev1.ClusterIPNone), Object: svc(corev1.ClusterIPNone)}))
+	assert.True(t, clusterIPChangedPredicate.Update(event.UpdateEvent{MetaOld: svc(""), ObjectOld: svc(""), MetaNew: svc("10.96.0.10"), ObjectNew: svc("10.96.0.10")}))
+	assert.False(t, clusterIPChangedPredicate.Update(event.UpdateEvent{MetaOld: svc("10.96.0.10"), ObjectOld: svc("10.96.0.10"), MetaNew: svc("10.96.0.10"), ObjectNew: svc("10.96.0.10")}))
+	assert.True(t, clusterIPChangedPredicate.Delete(event.DeleteEvent{Meta: svc("10.96.0.10"), Object: svc("10.96.0.10")}))
+	assert.False(t, clusterIPChangedPredicate.Delete(event.DeleteEvent{Meta: svc(""), Object: svc("")}))
+	assert.False(t, clusterIPChangedPredicate.Generic(event.GenericEvent{Meta: svc("10.96.0.10"), Object: svc("10.96.0.10")}))
+}
+
+func TestRouteController_GetIngressRules(t *testing.T) {
+	r := getRouteController()
+	pod := func(namespace, name, ip string, hostNetwork bool) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+			Spec:       corev1.PodSpec{HostNetwork: hostNetwork},
+			Status:     corev1.PodStatus{PodIP: ip},
+		}
+	}
+	r.Client = fake.NewFakeClientWithScheme(scheme.Scheme,
+		pod("shared", "web", "10.200.1.1", false),
+		pod("shared", "pending", "", false),
+		pod("shared", "agent", "192.168.1.10", true),
+		pod("private", "db", "10.200.1.2", false))
+	tep := GetTunnelEndpointCR()
+	//without a policy the traffic is not filtered
+	rules, err := r.GetIngressRules(tep)
+	assert.Nil(t, err)
+	assert.Nil(t, rules)
+	tep.Spec.IngressPolicy = &netv1alpha1.IngressPolicy{
+		Rules: []netv1alpha1.IngressRule{
+			{
+				Namespaces: []string{"shared"},
+				Ports:      []netv1alpha1.IngressPort{{Port: 80}, {Protocol: corev1.ProtocolUDP, Port: 53}},
+			},
+			{
+				CIDRs: []string{"10.200.2.1/16", "invalid"},
+			},
+			{
+				Namespaces: []string{"empty"},
+			},
+		},
+	}
+	rules, err = r.GetIngressRules(tep)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{
+		"-s 10.100.0.0/16 -m conntrack --ctstate RELATED,ESTABLISHED -j ACCEPT",
+		"-s 10.100.0.0/16 -d 10.200.1.1/32 -p tcp -m tcp --dport 80 -j ACCEPT",
+		"-s 10.100.0.0/16 -d 10.200.1.1/32 -p udp -m udp --dport 53 -j ACCEPT",
+		"-s 10.100.0.0/16 -d 10.200.0.0/16 -j ACCEPT",
+		"-s 10.100.0.0/16 -j DROP",
+	}, rules)
+	//a rule without destinations allows all the traffic on its ports
+	tep.Spec.IngressPolicy = &netv1alpha1.IngressPolicy{
+		Rules: []netv1alpha1.IngressRule{{Ports: []netv1alpha1.IngressPort{{Port: 443}}}},
+	}
+	rules, err = r.GetIngressRules(tep)
+	assert.Nil(t, err)
+	assert.Equal(t, "-s 10.100.0.0/16 -p tcp -m tcp --dport 443 -j ACCEPT", rules[1])
+	assert.True(t, ingressPolicySelectsNamespace(&netv1alpha1.IngressPolicy{Rules: []netv1alpha1.IngressRule{{Namespaces: []string{"shared"}}}}, "shared"))
+	assert.False(t, ingressPolicySelectsNamespace(tep.Spec.IngressPolicy, "shared"))
+}
@@ -16,19 +16,28 @@ package liqonetOperators
 
 import (
 	"context"
+	discoveryv1alpha1 "github.com/liqotech/liqo/apis/discovery/v1alpha1"
 	netv1alpha1 "github.com/liqotech/liqo/apis/net/v1alpha1"
+	"github.com/liqotech/liqo/pkg/crdClient"
 	liqonetOperator "github.com/liqotech/liqo/pkg/liqonet"
 	"github.com/vishvananda/netlink"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/retry"
 	"k8s.io/klog"
+	"net"
 	"os"
 	"os/signal"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sync"
 	"time"
 )
 
@@ -39,10 +48,41 @@ type TunnelController struct {
 	Recorder                     record.EventRecorder
 	TunnelIFacesPerRemoteCluster map[string]int
 	RetryTimeout                 time.Duration
+	//used to label the node as the active gateway when the operator acquires the leadership
+	ClientSet kubernetes.Interface
+	NodeName  string
+	//used to report the state of the tunnels in the ForeignClusters
+	DiscoveryClient *crdClient.CRDClient
+	//one prober per tunnel, keyed by the clusterID of the remote cluster
+	probers     map[string]*tunnelProber
+	probersLock sync.Mutex
+}
+
+const (
+	//how often we retry to label the node as the active gateway
+	activeGatewayRetryPeriod = 5 * time.Second
+	//each probe round sends tunnelProbeCount probes, waiting tunnelProbeTimeout for each answer
+	tunnelProbePeriod  = 10 * time.Second
+	tunnelProbeCount   = 3
+	tunnelProbeTimeout = time.Second
+	//the connection status is written at least this often, to keep up to date the RTT and the last success time
+	tunnelConnectionRefreshPeriod = time.Minute
+)
+
+//tunnelProber answers to the probes of the remote gateway and periodically probes it through the tunnel
+type tunnelProber struct {
+	iFaceName string
+	//address of the remote end of the tunnel, reachable only through it
+	remoteIP string
+	conn     net.PacketConn
+	stop     chan struct{}
+	//time of the last probe round answered by the remote gateway, the status is not written at each round
+	lastSuccess *metav1.Time
 }
 
 // +kubebuilder:rbac:groups=net.liqo.io,resources=tunnelendpoints,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=net.liqo.io,resources=tunnelendpoints/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=discovery.liqo.io,resources=foreignclusters,verbs=get;list;update
 
 func (r *TunnelController) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 	ctx := context.Background()
@@ -81,6 +121,12 @@ func (r *TunnelController) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 				return ctrl.Result{}, err
 			}
 			r.Recorder.Event(&endpoint, "Normal", "Processing", "tunnel network interface removed")
+			r.stopProber(endpoint.Spec.ClusterID)
+			r.setTunnelCondition(endpoint.Spec.ClusterID, discoveryv1alpha1.Condition{
+				Status:  corev1.ConditionFalse,
+				Reason:  "TunnelRemoved",
+				Message: "the tunnel network interface has been removed",
+			})
 			//safe to do, even if the key does not exist in the map
 			delete(r.TunnelIFacesPerRemoteCluster, endpoint.Spec.ClusterID)
 			klog.Infof("%s -> tunnel network interface %s removed for resource %s", endpoint.Spec.ClusterID, endpoint.Status.TunnelIFaceName, endpoint.Name)
@@ -108,12 +154,22 @@ func (r *TunnelController) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 	if err != nil {
 		klog.Errorf("%s -> unable to create tunnel network interface for resource %s :%s", endpoint.Spec.ClusterID, endpoint.Name, err)
 		r.Recorder.Event(&endpoint, "Warning", "Processing", err.Error())
+		r.setTunnelCondition(endpoint.Spec.ClusterID, discoveryv1alpha1.Condition{
+			Status:  corev1.ConditionFalse,
+			Reason:  "InstallationFailed",
+			Message: err.Error(),
+		})
 		return ctrl.Result{RequeueAfter: r.RetryTimeout}, err
 	}
 	r.Recorder.Event(&endpoint, "Normal", "Processing", "tunnel network interface installed")
 	klog.Infof("%s -> tunnel network interface with name %s for resource %s created successfully", endpoint.Spec.ClusterID, iFaceName, endpoint.Name)
 	//save the IFace index in the map
 	r.TunnelIFacesPerRemoteCluster[endpoint.Spec.ClusterID] = iFaceIndex
+	if err := r.startProber(&endpoint, iFaceName); err != nil {
+		klog.Errorf("%s -> unable to start probing the tunnel for resource %s: %s", endpoint.Spec.ClusterID, endpoint.Name, err)
+		r.Recorder.Event(&endpoint, "Warning", "Processing", err.Error())
+	}
+	r.setTunnelCondition(endpoint.Spec.ClusterID, getTunnelCondition(endpoint.Status.Connection))
 	//update the status of CR if needed
 	//here we recover from conflicting resource versions
 	retryError := retry.RetryOnConflict(retry.DefaultRetry, func() error {
@@ -143,10 +199,214 @@ func (r *TunnelController) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 	return ctrl.Result{RequeueAfter: r.RetryTimeout}, nil
 }
 
+//ActivateGateway is run by the manager only in the tunnel-operator holding the leadership, hence when a
+//standby replica takes over. It labels the node as the active gateway: the route-operators use it to
+//route the traffic towards the remote clusters and the tunnelEndpointCreator publishes its IP to the peers.
+//The tunnels are reinstalled on the new node by the reconciliation of the tunnelEndpoint resources.
+//The MTU supported by the tunnels of the node is published too, in order to be advertised to the peering clusters.
+func (r *TunnelController) ActivateGateway(stop <-chan struct{}) error {
+	for {
+		err := r.activateGateway()
+		if err == nil {
+			break
+		}
+		klog.Errorf("unable to set node %s as the active gateway: %s", r.NodeName, err)
+		select {
+		case <-stop:
+			return nil
+		case <-time.After(activeGatewayRetryPeriod):
+		}
+	}
+	<-stop
+	return nil
+}
+
+//startProber starts answering and sending the probes through the tunnel towards the remote cluster.
+//They are restarted if the tunnel has been recreated with different parameters.
+func (r *TunnelController) startProber(endpoint *netv1alpha1.TunnelEndpoint, iFaceName string) error {
+	r.probersLock.Lock()
+	defer r.probersLock.Unlock()
+	if r.probers == nil {
+		r.probers = make(map[string]*tunnelProber)
+	}
+	clusterID := endpoint.Spec.ClusterID
+	_, remoteAddress, err := liqonetOperator.GetTunnelAddresses(endpoint)
+	if err != nil {
+		return err
+	}
+	remoteIP := remoteAddress.String()
+	if p, ok := r.probers[clusterID]; ok {
+		if p.iFaceName == iFaceName && p.remoteIP == remoteIP {
+			return nil
+		}
+		p.close()
+		delete(r.probers, clusterID)
+	}
+	conn, err := liqonetOperator.ListenProbes(iFaceName, liqonetOperator.ProbePort)
+	if err != nil {
+		return err
+	}
+	p := &tunnelProber{
+		iFaceName: iFaceName,
+		remoteIP:  remoteIP,
+		conn:      conn,
+		stop:      make(chan struct{}),
+	}
+	go liqonetOperator.ServeProbes(conn)
+	go r.probeTunnel(endpoint.Name, p)
+	r.probers[clusterID] = p
+	klog.Infof("%s -> probing the tunnel through interface %s towards %s", clusterID, iFaceName, remoteIP)
+	return nil
+}
+
+func (r *TunnelController) stopProber(clusterID string) {
+	r.probersLock.Lock()
+	defer r.probersLock.Unlock()
+	if p, ok := r.probers[clusterID]; ok {
+		p.close()
+		delete(r.probers, clusterID)
+	}
+}
+
+func (p *tunnelProber) close() {
+	close(p.stop)
+	if err := p.conn.Close(); err != nil {
+		klog.Errorf("unable to close the probe socket on interface %s: %s", p.iFaceName, err)
+	}
+}
+
+//probeTunnel periodically probes the remote end of the tunnel and saves the results in the status of the tunnelEndpoint
+func (r *TunnelController) probeTunnel(tepName string, p *tunnelProber) {
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-time.After(tunnelProbePeriod):
+		}
+		result, err := liqonetOperator.ProbeTunnel(p.iFaceName, net.ParseIP(p.remoteIP), liqonetOperator.ProbePort, tunnelProbeCount, tunnelProbeTimeout)
+		if err != nil {
+			//we were not even able to send the probes, the tunnel is not usable
+			klog.Errorf("unable to probe the tunnel through interface %s: %s", p.iFaceName, err)
+			result = liqonetOperator.ProbeResult{Sent: tunnelProbeCount}
+		}
+		if result.Received > 0 {
+			now := metav1.Now()
+			p.lastSuccess = &now
+		}
+		if err := r.updateConnection(tepName, result, p.lastSuccess); err != nil {
+			klog.Errorf("unable to update the connection status of resource %s: %s", tepName, err)
+		}
+	}
+}
+
+//updateConnection saves the connection status in the tunnelEndpoint. The status is written when the state of the
+//connection or the packet loss change, otherwise at most every tunnelConnectionRefreshPeriod to limit the updates
+//of the resource
+func (r *TunnelController) updateConnection(tepName string, result liqonetOperator.ProbeResult, lastSuccess *metav1.Time) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		var endpoint netv1alpha1.TunnelEndpoint
+		if err := r.Get(context.Background(), types.NamespacedName{Name: tepName}, &endpoint); err != nil {
+			return err
+		}
+		old := endpoint.Status.Connection
+		connection := getTunnelConnection(old, result, metav1.Now(), lastSuccess)
+		if !connectionToBeWritten(old, connection) {
+			return nil
+		}
+		endpoint.Status.Connection = connection
+		if err := r.Status().Update(context.Background(), &endpoint); err != nil {
+			return err
+		}
+		if old.Status != endpoint.Status.Connection.Status {
+			if endpoint.Status.Connection.Status == netv1alpha1.Connected {
+				r.Recorder.Event(&endpoint, "Normal", "Connection", "the remote cluster is reachable through the tunnel")
+			} else {
+				r.Recorder.Event(&endpoint, "Warning", "Connection", "the remote cluster is not reachable through the tunnel")
+			}
+			r.setTunnelCondition(endpoint.Spec.ClusterID, getTunnelCondition(endpoint.Status.Connection))
+		}
+		return nil
+	})
+}
+
+//setTunnelCondition reports the state of the tunnel in the ForeignCluster of the remote cluster
+func (r *TunnelController) setTunnelCondition(clusterID string, condition discoveryv1alpha1.Condition) {
+	condition.Type = discoveryv1alpha1.TunnelEstablishedCondition
+	if err := discoveryv1alpha1.SetForeignClusterCondition(r.DiscoveryClient, clusterID, condition); err != nil {
+		klog.Errorf("%s -> unable to set the condition %s of the foreign cluster: %s", clusterID, condition.Type, err)
+	}
+}
+
+//getTunnelCondition computes the TunnelEstablished condition from the results of the probes
+func getTunnelCondition(connection netv1alpha1.TunnelConnection) discoveryv1alpha1.Condition {
+	switch connection.Status {
+	case netv1alpha1.Connected:
+		return discoveryv1alpha1.Condition{
+			Status:  corev1.ConditionTrue,
+			Reason:  "ProbeSucceeded",
+			Message: "the remote gateway answers the probes sent through the tunnel",
+		}
+	case netv1alpha1.ConnectionDown:
+		return discoveryv1alpha1.Condition{
+			Status:  corev1.ConditionFalse,
+			Reason:  "ProbeFailed",
+			Message: "the remote gateway does not answer the probes sent through the tunnel",
+		}
+	default:
+		return discoveryv1alpha1.Condition{
+			Status:  corev1.ConditionUnknown,
+			Reason:  "TunnelInstalled",
+			Message: "the tunnel network interface has been installed, waiting for the first probe",
+		}
+	}
+}
+
+//connectionToBeWritten returns true if the state of the connection or the packet loss have changed, or if the written
+//status is older than tunnelConnectionRefreshPeriod
+func connectionToBeWritten(old, connection netv1alpha1.TunnelConnection) bool {
+	if connection.Status != old.Status || connection.PacketLoss != old.PacketLoss {
+		return true
+	}
+	return old.LastProbeTime == nil || connection.LastProbeTime.Sub(old.LastProbeTime.Time) >= tunnelConnectionRefreshPeriod
+}
+
+//getTunnelConnection computes the connection status from the results of the last round of probes
+func getTunnelConnection(old netv1alpha1.TunnelConnection, result liqonetOperator.ProbeResult, now metav1.Time, lastSuccess *metav1.Time) netv1alpha1.TunnelConnection {
+	connection := netv1alpha1.TunnelConnection{
+		Status:          netv1alpha1.ConnectionDown,
+		PacketLoss:      result.Loss(),
+		LastProbeTime:   &now,
+		LastSuccessTime: old.LastSuccessTime,
+	}
+	if lastSuccess != nil {
+		connection.LastSuccessTime = lastSuccess
+	}
+	if result.Received > 0 {
+		connection.Status = netv1alpha1.Connected
+		connection.RTT = metav1.Duration{Duration: result.RTT}
+		connection.LastSuccessTime = &now
+	}
+	return connection
+}
+
+func (r *TunnelController) activateGateway() error {
+	mtu, err := liqonetOperator.GetTunnelMTU()
+	if err != nil {
+		return err
+	}
+	if err := liqonetOperator.SetTunnelMTUAnnotation(r.ClientSet, r.NodeName, mtu); err != nil {
+		return err
+	}
+	return liqonetOperator.SetActiveGateway(r.ClientSet, r.NodeName)
+}
+
 //used to remove all the tunnel interfaces when the controller is closed
 //it does not return an error, but just logs them, cause we can not recover from
 //them at exit time
 func (r *TunnelController) RemoveAllTunnels() {
+	for clusterID := range r.TunnelIFacesPerRemoteCluster {
+		r.stopProber(clusterID)
+	}
 	for clusterID, ifaceIndex := range r.TunnelIFacesPerRemoteCluster {
 		existingIface, err := netlink.LinkByIndex(ifaceIndex)
 		if err == nil {
@@ -186,5 +446,23 @@ func (r *TunnelController) SetupWithManager(mgr ctrl.Manager) error {
 	}
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&netv1alpha1.TunnelEndpoint{}).WithEventFilter(resourceToBeProccesedPredicate).
+		WithEventFilter(ignoreConnectionUpdates).
 		Complete(r)
 }
+
+//ignoreConnectionUpdates filters out the updates of the tunnelEndpoints which change only the connection status written
+//by the probes. The generation cannot be used, since the operators are driven by the status set by the other ones.
+var ignoreConnectionUpdates = predicate.Funcs{
+	UpdateFunc: func(e event.UpdateEvent) bool {
+		oldTep, okOld := e.ObjectOld.(*netv1alpha1.TunnelEndpoint)
+		newTep, okNew := e.ObjectNew.(*netv1alpha1.TunnelEndpoint)
+		if !okOld || !okNew {
+			return true
+		}
+		oldTep, newTep = oldTep.DeepCopy(), newTep.DeepCopy()
+		oldTep.Status.Connection, newTep.Status.Connection = netv1alpha1.TunnelConnection{}, netv1alpha1.TunnelConnection{}
+		oldTep.ResourceVersion, newTep.ResourceVersion = "", ""
+		oldTep.ManagedFields, newTep.ManagedFields = nil, nil
+		return !equality.Semantic.DeepEqual(oldTep, newTep)
+	},
+}
@@ -0,0 +1,61 @@
+package liqonetOperators
+
+import (
+	netv1alpha1 "github.com/liqotech/liqo/apis/net/v1alpha1"
+	"github.com/liqotech/liqo/pkg/liqonet"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"testing"
+	"time"
+)
+
+func TestGetTunnelConnection(t *testing.T) {
+	first := metav1.NewTime(time.Now().Add(-time.Minute))
+	connection := getTunnelConnection(netv1alpha1.TunnelConnection{}, liqonet.ProbeResult{Sent: 3, Received: 2, RTT: 5 * time.Millisecond}, first, &first)
+	assert.Equal(t, netv1alpha1.Connected, connection.Status)
+	assert.Equal(t, 5*time.Millisecond, connection.RTT.Duration)
+	assert.Equal(t, 33, connection.PacketLoss)
+	assert.Equal(t, &first, connection.LastSuccessTime)
+
+	//when all the probes are lost the last success time is preserved
+	now := metav1.Now()
+	connection = getTunnelConnection(connection, liqonet.ProbeResult{Sent: 3}, now, nil)
+	assert.Equal(t, netv1alpha1.ConnectionDown, connection.Status)
+	assert.Equal(t, 100, connection.PacketLoss)
+	assert.Zero(t, connection.RTT.Duration)
+	assert.Equal(t, &now, connection.LastProbeTime)
+	assert.Equal(t, first, *connection.LastSuccessTime)
+
+	//the last success is tracked by the prober, since the status is not written at each round
+	connection = getTunnelConnection(connection, liqonet.ProbeResult{Sent: 3}, now, &now)
+	assert.Equal(t, &now, connection.LastSuccessTime)
+}
+
+func TestConnectionToBeWritten(t *testing.T) {
+	written := metav1.NewTime(time.Now())
+	old := netv1alpha1.TunnelConnection{Status: netv1alpha1.Connected, RTT: metav1.Duration{Duration: time.Millisecond}, LastProbeTime: &written}
+	probed := func(after time.Duration, status netv1alpha1.ConnectionStatus, loss int) netv1alpha1.TunnelConnection {
+		probeTime := metav1.NewTime(written.Add(after))
+		return netv1alpha1.TunnelConnection{Status: status, PacketLoss: loss, RTT: metav1.Duration{Duration: 2 * time.Millisecond}, LastProbeTime: &probeTime}
+	}
+	assert.True(t, connectionToBeWritten(netv1alpha1.TunnelConnection{}, probed(0, netv1alpha1.Connected, 0)))
+	assert.True(t, connectionToBeWritten(old, probed(tunnelProbePeriod, netv1alpha1.ConnectionDown, 100)))
+	assert.True(t, connectionToBeWritten(old, probed(tunnelProbePeriod, netv1alpha1.Connected, 33)))
+	//the RTT of a stable connection is refreshed periodically
+	assert.False(t, connectionToBeWritten(old, probed(tunnelProbePeriod, netv1alpha1.Connected, 0)))
+	assert.True(t, connectionToBeWritten(old, probed(tunnelConnectionRefreshPeriod, netv1alpha1.Connected, 0)))
+}
+
+func TestIgnoreConnectionUpdates(t *testing.T) {
+	tep := &netv1alpha1.TunnelEndpoint{ObjectMeta: metav1.ObjectMeta{Name: "tep", ResourceVersion: "1"}}
+	probed := tep.DeepCopy()
+	probed.ResourceVersion = "2"
+	probed.Status.Connection = netv1alpha1.TunnelConnection{Status: netv1alpha1.Connected}
+	assert.False(t, ignoreConnectionUpdates.Update(event.UpdateEvent{ObjectOld: tep, MetaOld: tep, ObjectNew: probed, MetaNew: probed}))
+
+	//the status set by the other operators is processed
+	configured := probed.DeepCopy()
+	configured.Status.TunnelIFaceName = "gre-1"
+	assert.True(t, ignoreConnectionUpdates.Update(event.UpdateEvent{ObjectOld: probed, MetaOld: probed, ObjectNew: configured, MetaNew: configured}))
+}
@@ -38,12 +38,26 @@ func (r *TunnelEndpointCreator) GetConfiguration(config *configv1alpha1.ClusterC
 	if !correctlyParsed {
 		return nil, fmt.Errorf("the reserved subnets list is not in the correct format")
 	}
+	//when only the services are reachable from the peering clusters the local service CIDR is reserved,
+	//this way the service CIDRs of the remote clusters that overlap with it are remapped
+	if liqonetConfig.NetworkMode == configv1alpha1.ServiceCIDRNetworkMode {
+		_, sn, err := net.ParseCIDR(liqonetConfig.ServiceCIDR)
+		if err != nil {
+			klog.Errorf("an error occurred while parsing the service CIDR %s: %s", liqonetConfig.ServiceCIDR, err)
+			return nil, err
+		}
+		reservedSubnets[sn.String()] = sn
+	}
 	return reservedSubnets, nil
 }
 
 func (r *TunnelEndpointCreator) SetNetParameters(config *configv1alpha1.ClusterConfig) {
 	podCIDR := config.Spec.LiqonetConfig.PodCIDR
 	serviceCIDR := config.Spec.LiqonetConfig.ServiceCIDR
+	networkMode := config.Spec.LiqonetConfig.NetworkMode
+	if networkMode == "" {
+		networkMode = configv1alpha1.PodCIDRNetworkMode
+	}
 	if r.PodCIDR != podCIDR {
 		klog.Infof("setting podCIDR to %s", podCIDR)
 		r.PodCIDR = podCIDR
@@ -52,6 +66,10 @@ func (r *TunnelEndpointCreator) SetNetParameters(config *configv1alpha1.ClusterC
 		klog.Infof("setting serviceCIDR to %s", serviceCIDR)
 		r.ServiceCIDR = serviceCIDR
 	}
+	if r.NetworkMode != networkMode {
+		klog.Infof("setting network mode to %s", networkMode)
+		r.NetworkMode = networkMode
+	}
 }
 
 //it returns the subnets used by the foreign clusters
@@ -100,6 +118,15 @@ func (r *TunnelEndpointCreator) GetClustersSubnets() (map[string]*net.IPNet, err
 			subnets[sn.String()] = sn
 			klog.Infof("subnet %s already reserved for cluster %s", tunEnd.Spec.PodCIDR, tunEnd.Spec.ClusterID)
 		}
+		if tunEnd.Status.RemoteRemappedServiceCIDR != "" && tunEnd.Status.RemoteRemappedServiceCIDR != defaultPodCIDRValue {
+			_, sn, err := net.ParseCIDR(tunEnd.Status.RemoteRemappedServiceCIDR)
+			if err != nil {
+				klog.Errorf("an error occurred while parsing the following cidr %s: %s", tunEnd.Status.RemoteRemappedServiceCIDR, err)
+				return nil, err
+			}
+			subnets[sn.String()] = sn
+			klog.Infof("subnet %s already reserved for the services of cluster %s", tunEnd.Status.RemoteRemappedServiceCIDR, tunEnd.Spec.ClusterID)
+		}
 	}
 	return subnets, nil
 }
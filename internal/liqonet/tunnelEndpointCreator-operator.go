@@ -19,10 +19,12 @@ import (
 	"context"
 	"fmt"
 	"github.com/go-logr/logr"
+	configv1alpha1 "github.com/liqotech/liqo/apis/config/v1alpha1"
 	discoveryv1alpha1 "github.com/liqotech/liqo/apis/discovery/v1alpha1"
 	"github.com/liqotech/liqo/internal/crdReplicator"
 	liqonetOperator "github.com/liqotech/liqo/pkg/liqonet"
 	"github.com/liqotech/liqo/pkg/owner"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
@@ -36,6 +38,7 @@ import (
 	"net"
 	"os"
 	"os/signal"
+	"reflect"
 	"strings"
 	"sync"
 	"time"
@@ -51,6 +54,8 @@ const (
 	TunEndpointNamePrefix = "tun-endpoint-"
 	NetConfigNamePrefix   = "net-config-"
 	defaultPodCIDRValue   = "None"
+	//suffix appended to the clusterID to reserve in the IPAM the subnet used to remap the services of a cluster
+	serviceSubnetSuffix = "-services"
 )
 
 var (
@@ -61,6 +66,11 @@ var (
 		Version:  discoveryv1alpha1.GroupVersion.Version,
 		Resource: "foreignclusters",
 	}
+	NodeGVR = schema.GroupVersionResource{
+		Group:    "",
+		Version:  "v1",
+		Resource: "nodes",
+	}
 	result = ctrl.Result{
 		Requeue:      false,
 		RequeueAfter: 5 * time.Second,
@@ -74,6 +84,14 @@ type networkParam struct {
 	remoteNatPodCIDR string
 	localGatewayIP   string
 	localNatPodCIDR  string
+	//set only if both clusters route the service traffic instead of the pods' traffic
+	remoteServiceCIDR    string
+	remoteNatServiceCIDR string
+	localNatServiceCIDR  string
+	//the MTU agreed by the clusters for the tunnel
+	mtu int
+	//the traffic allowed from the remote cluster, set in its ForeignCluster
+	ingressPolicy *netv1alpha1.IngressPolicy
 }
 
 type TunnelEndpointCreator struct {
@@ -83,8 +101,10 @@ type TunnelEndpointCreator struct {
 	DynClient                  dynamic.Interface
 	DynFactory                 dynamicinformer.DynamicSharedInformerFactory
 	GatewayIP                  string
+	GatewayMTU                 int
 	PodCIDR                    string
 	ServiceCIDR                string
+	NetworkMode                configv1alpha1.NetworkMode
 	netParamPerCluster         map[string]networkParam
 	ReservedSubnets            map[string]*net.IPNet
 	IPManager                  liqonetOperator.IpManager
@@ -158,6 +178,7 @@ func (r *TunnelEndpointCreator) Reconcile(req ctrl.Request) (ctrl.Result, error)
 		}
 		//remove the reserved ip for the cluster
 		r.IPManager.RemoveReservedSubnet(netConfig.Spec.ClusterID)
+		r.IPManager.RemoveReservedSubnet(netConfig.Spec.ClusterID + serviceSubnetSuffix)
 		return result, nil
 	}
 
@@ -227,16 +248,21 @@ func (r *TunnelEndpointCreator) createNetConfig(fc *discoveryv1alpha1.ForeignClu
 			ClusterID:      clusterID,
 			PodCIDR:        r.PodCIDR,
 			TunnelPublicIP: r.GatewayIP,
+			MTU:            r.getTunnelMTU(fc),
 		},
 		Status: netv1alpha1.NetworkConfigStatus{},
 	}
+	if r.NetworkMode == configv1alpha1.ServiceCIDRNetworkMode {
+		netConfig.Spec.ServiceCIDR = r.ServiceCIDR
+	}
 	//check if the resource for the remote cluster already exists
-	_, exists, err := r.GetNetworkConfig(clusterID)
+	existing, exists, err := r.GetNetworkConfig(clusterID)
 	if err != nil {
 		return err
 	}
 	if exists {
-		return nil
+		//the MTU of the ForeignCluster may have been changed
+		return r.updateNetConfigMTU(existing.Name, netConfig.Spec.MTU)
 	}
 	err = r.Create(context.TODO(), &netConfig)
 	if err != nil {
@@ -248,6 +274,44 @@ func (r *TunnelEndpointCreator) createNetConfig(fc *discoveryv1alpha1.ForeignClu
 
 }
 
+//getTunnelMTU returns the MTU advertised to the remote cluster: the one set in the ForeignCluster if any,
+//otherwise the one published by the active gateway
+func (r *TunnelEndpointCreator) getTunnelMTU(fc *discoveryv1alpha1.ForeignCluster) int {
+	if fc != nil && fc.Spec.MTU > 0 {
+		return fc.Spec.MTU
+	}
+	r.Mutex.Lock()
+	defer r.Mutex.Unlock()
+	return r.GatewayMTU
+}
+
+func (r *TunnelEndpointCreator) updateNetConfigMTU(name string, mtu int) error {
+	netConfig := &netv1alpha1.NetworkConfig{}
+	retryError := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		if err := r.Get(context.Background(), client.ObjectKey{Name: name}, netConfig); err != nil {
+			return err
+		}
+		if netConfig.Spec.MTU == mtu {
+			return nil
+		}
+		netConfig.Spec.MTU = mtu
+		return r.Update(context.Background(), netConfig)
+	})
+	if retryError != nil {
+		klog.Errorf("an error occurred while updating the MTU of resource %s: %s", name, retryError)
+		return retryError
+	}
+	return nil
+}
+
+//agreedMTU returns the MTU used by both ends of the tunnel, 0 if none of them advertised it
+func agreedMTU(localMTU, remoteMTU int) int {
+	if localMTU == 0 || (remoteMTU != 0 && remoteMTU < localMTU) {
+		return remoteMTU
+	}
+	return localMTU
+}
+
 func (r *TunnelEndpointCreator) deleteNetConfig(fc *discoveryv1alpha1.ForeignCluster) error {
 	clusterID := fc.Spec.ClusterIdentity.ClusterID
 	netConfigList := &netv1alpha1.NetworkConfigList{}
@@ -321,13 +385,18 @@ func (r *TunnelEndpointCreator) processRemoteNetConfig(netConfig *netv1alpha1.Ne
 		klog.Errorf("an error occurred while getting a new subnet for resource %s: %s", netConfig.Name, err)
 		return err
 	}
+	serviceCIDRNAT, err := r.getServiceCIDRNAT(netConfig)
+	if err != nil {
+		return err
+	}
 
 	//if they are different, the NAT is needed and a new subnet have been reserved for the peering cluster
 	if newSubnet.String() != clusterSubnet.String() {
-		if newSubnet.String() != netConfig.Status.PodCIDRNAT {
+		if newSubnet.String() != netConfig.Status.PodCIDRNAT || serviceCIDRNAT != netConfig.Status.ServiceCIDRNAT {
 			//update netConfig status
 			netConfig.Status.PodCIDRNAT = newSubnet.String()
 			netConfig.Status.NATEnabled = "true"
+			netConfig.Status.ServiceCIDRNAT = serviceCIDRNAT
 			err := r.Status().Update(context.Background(), netConfig)
 			if err != nil {
 				klog.Errorf("an error occurred while updating the status of resource %s: %s", netConfig.Name, err)
@@ -352,10 +421,11 @@ func (r *TunnelEndpointCreator) processRemoteNetConfig(netConfig *netv1alpha1.Ne
 			}
 		}
 	}
-	if netConfig.Status.PodCIDRNAT != defaultPodCIDRValue {
+	if netConfig.Status.PodCIDRNAT != defaultPodCIDRValue || serviceCIDRNAT != netConfig.Status.ServiceCIDRNAT {
 		//update netConfig status
 		netConfig.Status.PodCIDRNAT = defaultPodCIDRValue
 		netConfig.Status.NATEnabled = "false"
+		netConfig.Status.ServiceCIDRNAT = serviceCIDRNAT
 		err := r.Status().Update(context.Background(), netConfig)
 		if err != nil {
 			klog.Errorf("an error occurred while updating the status of resource %s: %s", netConfig.Name, err)
@@ -366,6 +436,37 @@ func (r *TunnelEndpointCreator) processRemoteNetConfig(netConfig *netv1alpha1.Ne
 	return nil
 }
 
+//in ServiceCIDR network mode the service CIDR of the remote cluster is remapped if it overlaps with
+//the local subnets, the local service CIDR included. It returns an empty string if one of the two clusters
+//routes the pods' subnet or the service CIDR is wider than the new subnet, "None" if the remapping is not needed
+//or the new subnet.
+func (r *TunnelEndpointCreator) getServiceCIDRNAT(netConfig *netv1alpha1.NetworkConfig) (string, error) {
+	if r.NetworkMode != configv1alpha1.ServiceCIDRNetworkMode || netConfig.Spec.ServiceCIDR == "" {
+		return "", nil
+	}
+	_, serviceSubnet, err := net.ParseCIDR(netConfig.Spec.ServiceCIDR)
+	if err != nil {
+		klog.Errorf("an error occurred while parsing the ServiceCIDR of resource %s: %s", netConfig.Name, err)
+		return "", err
+	}
+	clusterID := netConfig.Labels[crdReplicator.RemoteLabelSelector] + serviceSubnetSuffix
+	newSubnet, err := r.IPManager.GetNewSubnetPerCluster(serviceSubnet, clusterID)
+	if err != nil {
+		klog.Errorf("an error occurred while getting a new service subnet for resource %s: %s", netConfig.Name, err)
+		return "", err
+	}
+	if newSubnet.String() == serviceSubnet.String() {
+		return defaultPodCIDRValue, nil
+	}
+	//the ClusterIPs of a wider subnet cannot be remapped one-to-one, the pods' subnet is routed instead
+	if err = checkRemappedSubnet(serviceSubnet.String(), newSubnet.String()); err != nil {
+		klog.Warningf("the ServiceCIDR network mode cannot be used with resource %s: %s", netConfig.Name, err)
+		r.IPManager.RemoveReservedSubnet(clusterID)
+		return "", nil
+	}
+	return newSubnet.String(), nil
+}
+
 func (r *TunnelEndpointCreator) processLocalNetConfig(netConfig *netv1alpha1.NetworkConfig) error {
 	//check if the resource has been processed by the remote cluster
 	if netConfig.Status.PodCIDRNAT == "" {
@@ -401,6 +502,20 @@ func (r *TunnelEndpointCreator) processLocalNetConfig(netConfig *netv1alpha1.Net
 		remoteNatPodCIDR: remoteNetConf.Status.PodCIDRNAT,
 		localNatPodCIDR:  netConfig.Status.PodCIDRNAT,
 		localGatewayIP:   netConfig.Spec.TunnelPublicIP,
+		mtu:              agreedMTU(netConfig.Spec.MTU, remoteNetConf.Spec.MTU),
+	}
+	fc, err := r.getForeignCluster(netConfig.Spec.ClusterID)
+	if err != nil {
+		return err
+	}
+	if fc != nil {
+		netParam.ingressPolicy = fc.Spec.IngressPolicy
+	}
+	//the service traffic is routed only if both the clusters remapped the services' subnet of their peer
+	if netConfig.Status.ServiceCIDRNAT != "" && remoteNetConf.Status.ServiceCIDRNAT != "" {
+		netParam.remoteServiceCIDR = remoteNetConf.Spec.ServiceCIDR
+		netParam.remoteNatServiceCIDR = remoteNetConf.Status.ServiceCIDRNAT
+		netParam.localNatServiceCIDR = netConfig.Status.ServiceCIDRNAT
 	}
 	fcOwner := owner.GetOwnerByKind(&netConfig.OwnerReferences, "ForeignCluster")
 	if err := r.ProcessTunnelEndpoint(netParam, fcOwner); err != nil {
@@ -455,6 +570,18 @@ func (r *TunnelEndpointCreator) UpdateSpecTunnelEndpoint(param networkParam) err
 			tep.Spec.PodCIDR = param.remotePodCIDR
 			toBeUpdated = true
 		}
+		if tep.Spec.ServiceCIDR != param.remoteServiceCIDR {
+			tep.Spec.ServiceCIDR = param.remoteServiceCIDR
+			toBeUpdated = true
+		}
+		if tep.Spec.MTU != param.mtu {
+			tep.Spec.MTU = param.mtu
+			toBeUpdated = true
+		}
+		if !reflect.DeepEqual(tep.Spec.IngressPolicy, param.ingressPolicy) {
+			tep.Spec.IngressPolicy = param.ingressPolicy
+			toBeUpdated = true
+		}
 		if toBeUpdated {
 			err = r.Update(context.Background(), tep)
 			return err
@@ -490,6 +617,14 @@ func (r *TunnelEndpointCreator) UpdateStatusTunnelEndpoint(param networkParam) e
 			tep.Status.RemoteRemappedPodCIDR = param.remoteNatPodCIDR
 			toBeUpdated = true
 		}
+		if tep.Status.LocalRemappedServiceCIDR != param.localNatServiceCIDR {
+			tep.Status.LocalRemappedServiceCIDR = param.localNatServiceCIDR
+			toBeUpdated = true
+		}
+		if tep.Status.RemoteRemappedServiceCIDR != param.remoteNatServiceCIDR {
+			tep.Status.RemoteRemappedServiceCIDR = param.remoteNatServiceCIDR
+			toBeUpdated = true
+		}
 		if tep.Status.LocalTunnelPublicIP != param.localGatewayIP {
 			tep.Status.LocalTunnelPublicIP = param.localGatewayIP
 			toBeUpdated = true
@@ -528,13 +663,18 @@ func (r *TunnelEndpointCreator) CreateTunnelEndpoint(param networkParam, owner *
 			ClusterID:      param.remoteClusterID,
 			PodCIDR:        param.remotePodCIDR,
 			TunnelPublicIP: param.remoteGatewayIP,
+			ServiceCIDR:    param.remoteServiceCIDR,
+			MTU:            param.mtu,
+			IngressPolicy:  param.ingressPolicy,
 		},
 		Status: netv1alpha1.TunnelEndpointStatus{
-			Phase:                 "Ready",
-			LocalRemappedPodCIDR:  param.localNatPodCIDR,
-			RemoteRemappedPodCIDR: param.remoteNatPodCIDR,
-			RemoteTunnelPublicIP:  param.remoteGatewayIP,
-			LocalTunnelPublicIP:   param.localGatewayIP,
+			Phase:                     "Ready",
+			LocalRemappedPodCIDR:      param.localNatPodCIDR,
+			RemoteRemappedPodCIDR:     param.remoteNatPodCIDR,
+			LocalRemappedServiceCIDR:  param.localNatServiceCIDR,
+			RemoteRemappedServiceCIDR: param.remoteNatServiceCIDR,
+			RemoteTunnelPublicIP:      param.remoteGatewayIP,
+			LocalTunnelPublicIP:       param.localGatewayIP,
 		},
 	}
 	if owner != nil {
@@ -564,6 +704,7 @@ func (r *TunnelEndpointCreator) ForeignClusterHandlerAdd(obj interface{}) {
 	}
 	if fc.Status.Incoming.Joined || fc.Status.Outgoing.Joined {
 		_ = r.createNetConfig(fc)
+		_ = r.updateIngressPolicy(fc)
 	} else if !fc.Status.Incoming.Joined && !fc.Status.Outgoing.Joined {
 		_ = r.deleteNetConfig(fc)
 	}
@@ -573,6 +714,27 @@ func (r *TunnelEndpointCreator) ForeignClusterHandlerUpdate(oldObj interface{},
 	r.ForeignClusterHandlerAdd(newObj)
 }
 
+//updateIngressPolicy copies the ingress policy of the ForeignCluster in the tunnelEndpoint of the cluster, if it exists
+func (r *TunnelEndpointCreator) updateIngressPolicy(fc *discoveryv1alpha1.ForeignCluster) error {
+	clusterID := fc.Spec.ClusterIdentity.ClusterID
+	retryError := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		tep, found, err := r.GetTunnelEndpoint(clusterID)
+		if err != nil || !found {
+			return err
+		}
+		if reflect.DeepEqual(tep.Spec.IngressPolicy, fc.Spec.IngressPolicy) {
+			return nil
+		}
+		tep.Spec.IngressPolicy = fc.Spec.IngressPolicy
+		return r.Update(context.Background(), tep)
+	})
+	if retryError != nil {
+		klog.Errorf("an error occurred while updating the ingress policy of the tunnelEndpoint for cluster %s: %s", clusterID, retryError)
+		return retryError
+	}
+	return nil
+}
+
 func (r *TunnelEndpointCreator) ForeignClusterHandlerDelete(obj interface{}) {
 	objUnstruct, ok := obj.(*unstructured.Unstructured)
 	if !ok {
@@ -588,6 +750,81 @@ func (r *TunnelEndpointCreator) ForeignClusterHandlerDelete(obj interface{}) {
 	_ = r.deleteNetConfig(fc)
 }
 
+//GatewayNodeHandlers returns the handlers for the events of the gateway nodes
+func (r *TunnelEndpointCreator) GatewayNodeHandlers() cache.ResourceEventHandlerFuncs {
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			r.UpdateGateway()
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			r.UpdateGateway()
+		},
+		DeleteFunc: func(obj interface{}) {
+			r.UpdateGateway()
+		},
+	}
+}
+
+//UpdateGateway checks if the active gateway moved to another node, i.e. after a failover of the tunnel-operator,
+//or if the MTU of its tunnels changed. In this case the IP and the MTU of the gateway are published in the local
+//networkConfigs, so that the peering clusters update their tunnels.
+func (r *TunnelEndpointCreator) UpdateGateway() {
+	nodes := &corev1.NodeList{}
+	if err := r.List(context.Background(), nodes, client.MatchingLabels{liqonetOperator.GatewayLabelKey: "true"}); err != nil {
+		klog.Errorf("an error occurred while listing the gateway nodes: %s", err)
+		return
+	}
+	gateway, err := liqonetOperator.GetActiveGateway(nodes.Items)
+	if err != nil {
+		//the label is moving from a node to another one
+		klog.V(4).Infof("unable to get the active gateway: %s", err)
+		return
+	}
+	gatewayIP, err := liqonetOperator.GetInternalIPOfNode(*gateway)
+	if err != nil {
+		klog.Errorf("unable to get the IP of the gateway node %s: %s", gateway.Name, err)
+		return
+	}
+	gatewayMTU := liqonetOperator.GetTunnelMTUAnnotation(gateway)
+	r.Mutex.Lock()
+	if r.GatewayIP == gatewayIP && r.GatewayMTU == gatewayMTU {
+		r.Mutex.Unlock()
+		return
+	}
+	klog.Infof("the active gateway is running on node %s with IP %s and tunnel MTU %d", gateway.Name, gatewayIP, gatewayMTU)
+	r.GatewayIP = gatewayIP
+	r.GatewayMTU = gatewayMTU
+	r.Mutex.Unlock()
+
+	netConfigList := &netv1alpha1.NetworkConfigList{}
+	if err := r.List(context.Background(), netConfigList, client.MatchingLabels{crdReplicator.LocalLabelSelector: "true"}); err != nil {
+		klog.Errorf("an error occurred while listing resources: %s", err)
+		return
+	}
+	for i := range netConfigList.Items {
+		netConfig := &netConfigList.Items[i]
+		fc, err := r.getForeignCluster(netConfig.Spec.ClusterID)
+		if err != nil {
+			continue
+		}
+		mtu := r.getTunnelMTU(fc)
+		retryError := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+			if err := r.Get(context.Background(), client.ObjectKey{Name: netConfig.Name}, netConfig); err != nil {
+				return err
+			}
+			if netConfig.Spec.TunnelPublicIP == gatewayIP && netConfig.Spec.MTU == mtu {
+				return nil
+			}
+			netConfig.Spec.TunnelPublicIP = gatewayIP
+			netConfig.Spec.MTU = mtu
+			return r.Update(context.Background(), netConfig)
+		})
+		if retryError != nil {
+			klog.Errorf("an error occurred while updating the gateway of resource %s: %s", netConfig.Name, retryError)
+		}
+	}
+}
+
 func (r *TunnelEndpointCreator) GetTunnelEndpoint(destinationClusterID string) (*netv1alpha1.TunnelEndpoint, bool, error) {
 	clusterID := destinationClusterID
 	tunEndpointList := &netv1alpha1.TunnelEndpointList{}
@@ -629,13 +866,28 @@ func (r *TunnelEndpointCreator) deleteTunEndpoint(netConfig *netv1alpha1.Network
 }
 
 func (r *TunnelEndpointCreator) getFCOwner(netConfig *netv1alpha1.NetworkConfig) (*metav1.OwnerReference, error) {
+	fc, err := r.getForeignCluster(netConfig.Spec.ClusterID)
+	if err != nil || fc == nil {
+		return nil, err
+	}
+	return &metav1.OwnerReference{
+		APIVersion: fc.APIVersion,
+		Kind:       fc.Kind,
+		Name:       fc.Name,
+		UID:        fc.UID,
+		Controller: pointer.BoolPtr(true),
+	}, nil
+}
+
+//getForeignCluster returns the ForeignCluster of the given cluster, nil if it does not exist
+func (r *TunnelEndpointCreator) getForeignCluster(clusterID string) (*discoveryv1alpha1.ForeignCluster, error) {
 	dynFC := r.DynClient.Resource(schema.GroupVersionResource{
 		Group:    discoveryv1alpha1.GroupVersion.Group,
 		Version:  discoveryv1alpha1.GroupVersion.Version,
 		Resource: "foreignclusters",
 	})
 	list, err := dynFC.List(context.TODO(), metav1.ListOptions{
-		LabelSelector: strings.Join([]string{"cluster-id", netConfig.Spec.ClusterID}, "="),
+		LabelSelector: strings.Join([]string{"cluster-id", clusterID}, "="),
 	})
 	if err != nil {
 		klog.Error(err)
@@ -644,12 +896,10 @@ func (r *TunnelEndpointCreator) getFCOwner(netConfig *netv1alpha1.NetworkConfig)
 	if len(list.Items) == 0 {
 		return nil, nil
 	}
-	fc := list.Items[0]
-	return &metav1.OwnerReference{
-		APIVersion: fc.GetAPIVersion(),
-		Kind:       fc.GetKind(),
-		Name:       fc.GetName(),
-		UID:        fc.GetUID(),
-		Controller: pointer.BoolPtr(true),
-	}, nil
+	fc := &discoveryv1alpha1.ForeignCluster{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(list.Items[0].Object, fc); err != nil {
+		klog.Errorf("an error occurred while converting resource %s of type %s to typed object: %s", list.Items[0].GetName(), list.Items[0].GetKind(), err)
+		return nil, err
+	}
+	return fc, nil
 }
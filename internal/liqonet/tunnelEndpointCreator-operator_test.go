@@ -0,0 +1,55 @@
+package liqonetOperators
+
+import (
+	configv1alpha1 "github.com/liqotech/liqo/apis/config/v1alpha1"
+	netv1alpha1 "github.com/liqotech/liqo/apis/net/v1alpha1"
+	"github.com/liqotech/liqo/internal/crdReplicator"
+	"github.com/liqotech/liqo/pkg/liqonet"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"net"
+	"testing"
+)
+
+func TestAgreedMTU(t *testing.T) {
+	assert.Equal(t, 0, agreedMTU(0, 0))
+	assert.Equal(t, 1400, agreedMTU(1400, 0))
+	assert.Equal(t, 1400, agreedMTU(0, 1400))
+	assert.Equal(t, 1376, agreedMTU(1376, 1476))
+	assert.Equal(t, 1376, agreedMTU(1476, 1376))
+}
+
+func TestServiceCIDRNAT(t *testing.T) {
+	r := &TunnelEndpointCreator{
+		NetworkMode: configv1alpha1.ServiceCIDRNetworkMode,
+		IPManager: liqonet.IpManager{
+			UsedSubnets:        make(map[string]*net.IPNet),
+			FreeSubnets:        make(map[string]*net.IPNet),
+			ConflictingSubnets: make(map[string]*net.IPNet),
+			SubnetPerCluster:   make(map[string]*net.IPNet),
+		},
+	}
+	assert.Nil(t, r.IPManager.Init())
+	netConfig := func(clusterID, serviceCIDR string) *netv1alpha1.NetworkConfig {
+		return &netv1alpha1.NetworkConfig{
+			ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{crdReplicator.RemoteLabelSelector: clusterID}},
+			Spec:       netv1alpha1.NetworkConfigSpec{ServiceCIDR: serviceCIDR},
+		}
+	}
+
+	//a subnet is remapped if it conflicts with the ones already used
+	serviceCIDRNAT, err := r.getServiceCIDRNAT(netConfig("cluster-1", "10.96.0.0/16"))
+	assert.Nil(t, err)
+	assert.Equal(t, defaultPodCIDRValue, serviceCIDRNAT)
+	serviceCIDRNAT, err = r.getServiceCIDRNAT(netConfig("cluster-2", "10.96.0.0/16"))
+	assert.Nil(t, err)
+	assert.NotEqual(t, defaultPodCIDRValue, serviceCIDRNAT)
+	assert.NotEqual(t, "", serviceCIDRNAT)
+
+	//a wider subnet would remap different ClusterIPs to the same IP, the pods' subnet is routed instead
+	serviceCIDRNAT, err = r.getServiceCIDRNAT(netConfig("cluster-3", "10.96.0.0/12"))
+	assert.Nil(t, err)
+	assert.Equal(t, "", serviceCIDRNAT)
+	_, reserved := r.IPManager.SubnetPerCluster["cluster-3"+serviceSubnetSuffix]
+	assert.False(t, reserved)
+}
@@ -10,6 +10,8 @@ import (
 
 type Config struct {
 	AllowAll bool `json:"allowAll"`
+	// policy applied to the peering requests
+	Policy *PeeringPolicy `json:"-"`
 }
 
 func GetConfig(crdClient *crdClient.CRDClient, namespace string) (*Config, error) {
@@ -30,6 +32,11 @@ func GetConfig(crdClient *crdClient.CRDClient, namespace string) (*Config, error
 	}
 
 	conf.AllowAll = config["allowAll"] == "true"
+	conf.Policy, err = parsePolicy(config)
+	if err != nil {
+		klog.Error(err)
+		return nil, err
+	}
 
 	return conf, nil
 }
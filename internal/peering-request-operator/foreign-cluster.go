@@ -10,6 +10,9 @@ import (
 	"k8s.io/utils/pointer"
 )
 
+// the ForeignCluster of the cluster sending the PeeringRequest does not allow the incoming peering
+var errIncomingNotAllowed = errors.New("the incoming peering is not allowed")
+
 func (r *PeeringRequestReconciler) UpdateForeignCluster(pr *v1alpha1.PeeringRequest) error {
 	tmp, err := r.crdClient.Resource("foreignclusters").List(metav1.ListOptions{
 		LabelSelector: "cluster-id=" + pr.Spec.ClusterIdentity.ClusterID,
@@ -36,6 +39,9 @@ func (r *PeeringRequestReconciler) UpdateForeignCluster(pr *v1alpha1.PeeringRequ
 	} else {
 		// update it
 		fc := &fcList.Items[0]
+		if !fc.IsIncomingAllowed() {
+			return errIncomingNotAllowed
+		}
 		if fc.Status.Incoming.PeeringRequest != nil {
 			// already up to date
 			return nil
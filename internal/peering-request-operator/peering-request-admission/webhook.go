@@ -2,13 +2,16 @@ package peering_request_admission
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	discoveryv1alpha1 "github.com/liqotech/liqo/apis/discovery/v1alpha1"
 	"github.com/liqotech/liqo/internal/peering-request-operator"
+	"github.com/liqotech/liqo/pkg/auth"
 	"github.com/liqotech/liqo/pkg/crdClient"
 	"io/ioutil"
 	"k8s.io/api/admission/v1beta1"
 	admissionregistrationv1beta1 "k8s.io/api/admissionregistration/v1beta1"
+	authenticationv1 "k8s.io/api/authentication/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
@@ -16,7 +19,6 @@ import (
 	"k8s.io/klog"
 	"k8s.io/kubernetes/pkg/apis/core/v1"
 	"net/http"
-	"os"
 )
 
 var (
@@ -54,28 +56,123 @@ func (whsvr *WebhookServer) validate(ar *v1beta1.AdmissionReview) *v1beta1.Admis
 
 	klog.Info("PeeringRequest " + peerReq.Name + " Received")
 
+	// the policy is evaluated on the ClusterID declared in the request, it has to be the one of the requester
+	if err := checkRequester(ar.Request.UserInfo, &peerReq, whsvr.Namespace); err != nil {
+		klog.Warningf("PeeringRequest %s denied: %v", peerReq.Name, err)
+		return deny(err.Error())
+	}
+
+	// the requests are denied if the policy cannot be read, not to allow a peering which would be denied
 	conf, err := peering_request_operator.GetConfig(whsvr.client, whsvr.Namespace)
 	if err != nil {
-		os.Exit(1)
+		klog.Errorf("PeeringRequest %s denied, cannot read the peering policy: %v", peerReq.Name, err)
+		return deny("cannot read the peering policy of the cluster: " + err.Error())
 	}
 
-	if conf.AllowAll {
-		// allow every request
-		klog.Info("PeeringRequest " + peerReq.Name + " Allowed")
-		return &v1beta1.AdmissionResponse{
-			Allowed: true,
-			Result:  nil,
+	candidate, err := whsvr.getCandidate(&peerReq)
+	if err != nil {
+		klog.Errorf("PeeringRequest %s denied, cannot get the foreign cluster: %v", peerReq.Name, err)
+		return deny("cannot evaluate the peering policy: " + err.Error())
+	}
+	incomingPeerings := 0
+	if conf.Policy.MaxIncomingPeerings > 0 {
+		if incomingPeerings, err = whsvr.countIncomingPeerings(peerReq.Name); err != nil {
+			klog.Errorf("PeeringRequest %s denied, cannot count the incoming peerings: %v", peerReq.Name, err)
+			return deny("cannot evaluate the peering policy: " + err.Error())
 		}
-	} else {
-		// TODO: apply policy to accept/reject peering requests
-		klog.Info("PeeringRequest " + peerReq.Name + " Denied")
-		return &v1beta1.AdmissionResponse{
-			Allowed: false,
-			Result: &metav1.Status{
-				Message: "Invalid token",
-			},
+	}
+
+	if err = conf.Policy.Evaluate(candidate, conf.AllowAll, incomingPeerings); err != nil {
+		klog.Infof("PeeringRequest %s Denied: %v", peerReq.Name, err)
+		return deny("peering denied by the policy: " + err.Error())
+	}
+	klog.Info("PeeringRequest " + peerReq.Name + " Allowed")
+	return &v1beta1.AdmissionResponse{
+		Allowed: true,
+		Result:  nil,
+	}
+}
+
+func deny(message string) *v1beta1.AdmissionResponse {
+	return &v1beta1.AdmissionResponse{
+		Allowed: false,
+		Result: &metav1.Status{
+			Message: message,
+		},
+	}
+}
+
+// checkRequester returns an error if the PeeringRequest has not been created by the cluster it declares, with its
+// certificate or its ServiceAccount, or if it is not named after its ClusterID
+func checkRequester(userInfo authenticationv1.UserInfo, pr *discoveryv1alpha1.PeeringRequest, namespace string) error {
+	clusterID := pr.Spec.ClusterIdentity.ClusterID
+	if clusterID == "" {
+		return errors.New("the PeeringRequest does not declare a ClusterID")
+	}
+	if pr.Name != clusterID {
+		return fmt.Errorf("the PeeringRequest %s has to be named after its ClusterID %s", pr.Name, clusterID)
+	}
+	if userInfo.Username != auth.PeerSubject(clusterID).CommonName &&
+		userInfo.Username != fmt.Sprintf("system:serviceaccount:%s:%s", namespace, clusterID) {
+		return fmt.Errorf("the user %s cannot request a peering for the cluster %s", userInfo.Username, clusterID)
+	}
+	return nil
+}
+
+// getCandidate describes the cluster sending the PeeringRequest, as it is known by the local cluster
+func (whsvr *WebhookServer) getCandidate(pr *discoveryv1alpha1.PeeringRequest) (*peering_request_operator.PeeringCandidate, error) {
+	candidate := &peering_request_operator.PeeringCandidate{
+		ClusterID: pr.Spec.ClusterIdentity.ClusterID,
+		// until the cluster is known, only the name it declares is available
+		ClusterName: pr.Spec.ClusterIdentity.ClusterName,
+		// the cluster has not been discovered before its request
+		DiscoveryType:   discoveryv1alpha1.IncomingPeeringDiscovery,
+		TrustMode:       discoveryv1alpha1.TrustModeUnknown,
+		IncomingAllowed: true,
+	}
+
+	tmp, err := whsvr.client.Resource("foreignclusters").List(metav1.ListOptions{
+		LabelSelector: "cluster-id=" + pr.Spec.ClusterIdentity.ClusterID,
+	})
+	if err != nil {
+		return nil, err
+	}
+	fcList, ok := tmp.(*discoveryv1alpha1.ForeignClusterList)
+	if !ok {
+		return nil, errors.New("retrieved object is not a ForeignClusterList")
+	}
+	if len(fcList.Items) > 0 {
+		fc := &fcList.Items[0]
+		candidate.DiscoveryType = fc.Spec.DiscoveryType
+		candidate.IncomingAllowed = fc.IsIncomingAllowed()
+		if fc.Spec.ClusterIdentity.ClusterName != "" {
+			candidate.ClusterName = fc.Spec.ClusterIdentity.ClusterName
+			candidate.ClusterNameKnown = true
+		}
+		if fc.Status.TrustMode != "" {
+			candidate.TrustMode = fc.Status.TrustMode
+		}
+	}
+	return candidate, nil
+}
+
+// countIncomingPeerings returns the number of the PeeringRequests received from the other clusters
+func (whsvr *WebhookServer) countIncomingPeerings(name string) (int, error) {
+	tmp, err := whsvr.client.Resource("peeringrequests").List(metav1.ListOptions{})
+	if err != nil {
+		return 0, err
+	}
+	prList, ok := tmp.(*discoveryv1alpha1.PeeringRequestList)
+	if !ok {
+		return 0, errors.New("retrieved object is not a PeeringRequestList")
+	}
+	count := 0
+	for i := range prList.Items {
+		if prList.Items[i].Name != name {
+			count++
 		}
 	}
+	return count, nil
 }
 
 func (whsvr *WebhookServer) serve(w http.ResponseWriter, r *http.Request) {
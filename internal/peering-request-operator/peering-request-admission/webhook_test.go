@@ -0,0 +1,38 @@
+package peering_request_admission
+
+import (
+	discoveryv1alpha1 "github.com/liqotech/liqo/apis/discovery/v1alpha1"
+	"github.com/liqotech/liqo/pkg/auth"
+	"github.com/stretchr/testify/assert"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"testing"
+)
+
+func TestCheckRequester(t *testing.T) {
+	pr := func(name string, clusterID string) *discoveryv1alpha1.PeeringRequest {
+		return &discoveryv1alpha1.PeeringRequest{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec: discoveryv1alpha1.PeeringRequestSpec{
+				ClusterIdentity: discoveryv1alpha1.ClusterIdentity{ClusterID: clusterID},
+			},
+		}
+	}
+	tests := []struct {
+		name     string
+		username string
+		pr       *discoveryv1alpha1.PeeringRequest
+		allowed  bool
+	}{
+		{"peer certificate", auth.PeerSubject("cluster-x").CommonName, pr("cluster-x", "cluster-x"), true},
+		{"peer ServiceAccount", "system:serviceaccount:liqo:cluster-x", pr("cluster-x", "cluster-x"), true},
+		{"ServiceAccount in another namespace", "system:serviceaccount:default:cluster-x", pr("cluster-x", "cluster-x"), false},
+		{"peer claiming the ClusterID of another one", auth.PeerSubject("cluster-x").CommonName, pr("cluster-y", "cluster-y"), false},
+		{"name different from the ClusterID", auth.PeerSubject("cluster-x").CommonName, pr("cluster-y", "cluster-x"), false},
+		{"no ClusterID", auth.PeerSubject("cluster-x").CommonName, pr("cluster-x", ""), false},
+	}
+	for _, test := range tests {
+		err := checkRequester(authenticationv1.UserInfo{Username: test.username}, test.pr, "liqo")
+		assert.Equal(t, test.allowed, err == nil, test.name)
+	}
+}
@@ -24,6 +24,7 @@ import (
 	"github.com/liqotech/liqo/pkg/crdClient"
 	object_references "github.com/liqotech/liqo/pkg/object-references"
 	appsv1 "k8s.io/api/apps/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/rest"
@@ -50,6 +51,7 @@ type PeeringRequestReconciler struct {
 
 // +kubebuilder:rbac:groups=discovery.liqo.io,resources=peeringrequests,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=discovery.liqo.io,resources=peeringrequests/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;create;patch;delete
 
 func (r *PeeringRequestReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 	_ = context.Background()
@@ -72,6 +74,11 @@ func (r *PeeringRequestReconciler) Reconcile(req ctrl.Request) (ctrl.Result, err
 	}
 
 	err = r.UpdateForeignCluster(pr)
+	if err == errIncomingNotAllowed {
+		// the broadcaster is not deployed, the PeeringRequest is deleted by the foreign-cluster-operator
+		klog.Infof("PeeringRequest %s ignored: %v", pr.Name, err)
+		return ctrl.Result{RequeueAfter: r.retryTimeout}, nil
+	}
 	if err != nil {
 		klog.Error(err, err.Error())
 		return ctrl.Result{RequeueAfter: r.retryTimeout}, err
@@ -105,10 +112,45 @@ func (r *PeeringRequestReconciler) Reconcile(req ctrl.Request) (ctrl.Result, err
 		klog.Error(err, err.Error())
 		return ctrl.Result{RequeueAfter: r.retryTimeout}, err
 	}
+
+	if err = r.deletePreviousKubeconfigs(pr); err != nil {
+		klog.Error(err, err.Error())
+		return ctrl.Result{RequeueAfter: r.retryTimeout}, err
+	}
 	klog.Info("PeeringRequest " + pr.Name + " successfully reconciled")
 	return ctrl.Result{RequeueAfter: r.retryTimeout}, nil
 }
 
+// deletePreviousKubeconfigs deletes the Secrets sent with the PeeringRequest before its current kubeconfig. The
+// foreign cluster replaces the Secret when it rotates its credentials, but it is allowed to create Secrets only.
+func (r *PeeringRequestReconciler) deletePreviousKubeconfigs(pr *discoveryv1alpha1.PeeringRequest) error {
+	secrets, err := r.crdClient.Client().CoreV1().Secrets(pr.Spec.KubeConfigRef.Namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	for i := range secrets.Items {
+		secret := &secrets.Items[i]
+		if secret.Name == pr.Spec.KubeConfigRef.Name || !isOwnedBy(secret.OwnerReferences, pr) {
+			continue
+		}
+		err = r.crdClient.Client().CoreV1().Secrets(secret.Namespace).Delete(context.TODO(), secret.Name, metav1.DeleteOptions{})
+		if err != nil && !kerrors.IsNotFound(err) {
+			return err
+		}
+		klog.Infof("previous kubeconfig %s of PeeringRequest %s deleted", secret.Name, pr.Name)
+	}
+	return nil
+}
+
+func isOwnedBy(references []metav1.OwnerReference, pr *discoveryv1alpha1.PeeringRequest) bool {
+	for _, ref := range references {
+		if ref.Kind == "PeeringRequest" && ref.UID == pr.UID {
+			return true
+		}
+	}
+	return false
+}
+
 func (r *PeeringRequestReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&discoveryv1alpha1.PeeringRequest{}).
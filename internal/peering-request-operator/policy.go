@@ -0,0 +1,167 @@
+package peering_request_operator
+
+import (
+	"fmt"
+	discoveryv1alpha1 "github.com/liqotech/liqo/apis/discovery/v1alpha1"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// PeeringPolicy decides which foreign clusters are allowed to peer with the local one
+type PeeringPolicy struct {
+	// clusters which are always denied
+	DeniedClusterIDs []string
+	// if set, only these clusters are allowed
+	AllowedClusterIDs []string
+	// if set, only the clusters whose name matches it are allowed
+	ClusterNamePattern *regexp.Regexp
+	// if set, only the clusters discovered in one of these ways are allowed
+	AllowedDiscoveryTypes []discoveryv1alpha1.DiscoveryType
+	// if set, only the clusters with one of these trust modes are allowed
+	AllowedTrustModes []discoveryv1alpha1.TrustMode
+	// maximum number of foreign clusters peered with the local one, 0 means unlimited
+	MaxIncomingPeerings int
+}
+
+// PeeringCandidate describes a foreign cluster requesting to peer
+type PeeringCandidate struct {
+	ClusterID   string
+	ClusterName string
+	// true if the name is the one of the ForeignCluster known by the local cluster, false if it is only the one
+	// declared by the cluster in its request
+	ClusterNameKnown bool
+	// how the cluster has been discovered, IncomingPeering if it was not known before its request
+	DiscoveryType discoveryv1alpha1.DiscoveryType
+	TrustMode     discoveryv1alpha1.TrustMode
+	// false if the incoming peering has been disabled on the ForeignCluster of the cluster
+	IncomingAllowed bool
+}
+
+// hasFilters returns true if at least one of the filters selecting the allowed clusters is set
+func (p *PeeringPolicy) hasFilters() bool {
+	return len(p.AllowedClusterIDs) > 0 || p.ClusterNamePattern != nil || len(p.AllowedDiscoveryTypes) > 0 || len(p.AllowedTrustModes) > 0
+}
+
+// Evaluate returns an error explaining why the candidate is denied, nil if it is allowed. When allowAll is set only
+// the denied clusters, the disabled incoming peerings and the maximum number of peerings are enforced, otherwise the
+// candidate has to match all the configured filters, and it is denied if none is configured.
+func (p *PeeringPolicy) Evaluate(candidate *PeeringCandidate, allowAll bool, incomingPeerings int) error {
+	if containsString(p.DeniedClusterIDs, candidate.ClusterID) {
+		return fmt.Errorf("the cluster %s is denied", candidate.ClusterID)
+	}
+	if !candidate.IncomingAllowed {
+		return fmt.Errorf("the incoming peering from the cluster %s is disabled", candidate.ClusterID)
+	}
+
+	if !allowAll {
+		if !p.hasFilters() {
+			return fmt.Errorf("the peering requests are not allowed")
+		}
+		if len(p.AllowedClusterIDs) > 0 && !containsString(p.AllowedClusterIDs, candidate.ClusterID) {
+			return fmt.Errorf("the cluster %s is not in the allowed ones", candidate.ClusterID)
+		}
+		if p.ClusterNamePattern != nil && !candidate.ClusterNameKnown {
+			// the name declared by the cluster itself cannot be trusted to match the pattern
+			return fmt.Errorf("the name of the cluster %s is not known, it cannot be matched with %q", candidate.ClusterID, p.ClusterNamePattern.String())
+		}
+		if p.ClusterNamePattern != nil && !p.ClusterNamePattern.MatchString(candidate.ClusterName) {
+			return fmt.Errorf("the cluster name %q does not match %q", candidate.ClusterName, p.ClusterNamePattern.String())
+		}
+		if len(p.AllowedDiscoveryTypes) > 0 && !containsDiscoveryType(p.AllowedDiscoveryTypes, candidate.DiscoveryType) {
+			return fmt.Errorf("the clusters discovered by %s are not allowed", candidate.DiscoveryType)
+		}
+		if len(p.AllowedTrustModes) > 0 && !containsTrustMode(p.AllowedTrustModes, candidate.TrustMode) {
+			return fmt.Errorf("the clusters with trust mode %s are not allowed", candidate.TrustMode)
+		}
+	}
+
+	if p.MaxIncomingPeerings > 0 && incomingPeerings >= p.MaxIncomingPeerings {
+		return fmt.Errorf("the maximum number of incoming peerings (%d) has been reached", p.MaxIncomingPeerings)
+	}
+	return nil
+}
+
+// parsePolicy reads the policy from the data of the ConfigMap of the peering-request-operator
+func parsePolicy(config map[string]string) (*PeeringPolicy, error) {
+	policy := &PeeringPolicy{
+		DeniedClusterIDs:  splitList(config["deniedClusterIDs"]),
+		AllowedClusterIDs: splitList(config["allowedClusterIDs"]),
+	}
+
+	if pattern := strings.TrimSpace(config["clusterNamePattern"]); pattern != "" {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid clusterNamePattern: %v", err)
+		}
+		policy.ClusterNamePattern = re
+	}
+
+	for _, t := range splitList(config["allowedDiscoveryTypes"]) {
+		discoveryType := discoveryv1alpha1.DiscoveryType(t)
+		switch discoveryType {
+		case discoveryv1alpha1.LanDiscovery, discoveryv1alpha1.WanDiscovery, discoveryv1alpha1.ManualDiscovery, discoveryv1alpha1.IncomingPeeringDiscovery,
+			discoveryv1alpha1.RegistryDiscovery:
+			policy.AllowedDiscoveryTypes = append(policy.AllowedDiscoveryTypes, discoveryType)
+		default:
+			return nil, fmt.Errorf("invalid allowedDiscoveryTypes: unknown discovery type %s", t)
+		}
+	}
+
+	for _, m := range splitList(config["allowedTrustModes"]) {
+		trustMode := discoveryv1alpha1.TrustMode(m)
+		switch trustMode {
+		case discoveryv1alpha1.TrustModeTrusted, discoveryv1alpha1.TrustModeUntrusted, discoveryv1alpha1.TrustModeUnknown:
+			policy.AllowedTrustModes = append(policy.AllowedTrustModes, trustMode)
+		default:
+			return nil, fmt.Errorf("invalid allowedTrustModes: unknown trust mode %s", m)
+		}
+	}
+
+	if max := strings.TrimSpace(config["maxIncomingPeerings"]); max != "" {
+		n, err := strconv.Atoi(max)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid maxIncomingPeerings: %s is not a non negative integer", max)
+		}
+		policy.MaxIncomingPeerings = n
+	}
+	return policy, nil
+}
+
+// splitList splits a comma separated list, ignoring the empty elements
+func splitList(value string) []string {
+	var res []string
+	for _, s := range strings.Split(value, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			res = append(res, s)
+		}
+	}
+	return res
+}
+
+func containsString(list []string, value string) bool {
+	for _, s := range list {
+		if s == value {
+			return true
+		}
+	}
+	return false
+}
+
+func containsDiscoveryType(list []discoveryv1alpha1.DiscoveryType, value discoveryv1alpha1.DiscoveryType) bool {
+	for _, t := range list {
+		if t == value {
+			return true
+		}
+	}
+	return false
+}
+
+func containsTrustMode(list []discoveryv1alpha1.TrustMode, value discoveryv1alpha1.TrustMode) bool {
+	for _, m := range list {
+		if m == value {
+			return true
+		}
+	}
+	return false
+}
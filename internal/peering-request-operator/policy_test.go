@@ -0,0 +1,104 @@
+package peering_request_operator
+
+import (
+	discoveryv1alpha1 "github.com/liqotech/liqo/apis/discovery/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func getCandidate(clusterID, clusterName string, discoveryType discoveryv1alpha1.DiscoveryType, trustMode discoveryv1alpha1.TrustMode) *PeeringCandidate {
+	return &PeeringCandidate{
+		ClusterID:        clusterID,
+		ClusterName:      clusterName,
+		ClusterNameKnown: true,
+		DiscoveryType:    discoveryType,
+		TrustMode:        trustMode,
+		IncomingAllowed:  true,
+	}
+}
+
+func TestParsePolicy(t *testing.T) {
+	policy, err := parsePolicy(map[string]string{
+		"allowAll":              "false",
+		"deniedClusterIDs":      "id-1, id-2,",
+		"allowedClusterIDs":     "id-3",
+		"clusterNamePattern":    "^prod-.*$",
+		"allowedDiscoveryTypes": "LAN,Manual",
+		"allowedTrustModes":     "Trusted",
+		"maxIncomingPeerings":   "3",
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"id-1", "id-2"}, policy.DeniedClusterIDs)
+	assert.Equal(t, []string{"id-3"}, policy.AllowedClusterIDs)
+	assert.Equal(t, "^prod-.*$", policy.ClusterNamePattern.String())
+	assert.Equal(t, []discoveryv1alpha1.DiscoveryType{discoveryv1alpha1.LanDiscovery, discoveryv1alpha1.ManualDiscovery}, policy.AllowedDiscoveryTypes)
+	assert.Equal(t, []discoveryv1alpha1.TrustMode{discoveryv1alpha1.TrustModeTrusted}, policy.AllowedTrustModes)
+	assert.Equal(t, 3, policy.MaxIncomingPeerings)
+
+	policy, err = parsePolicy(map[string]string{"allowAll": "true"})
+	assert.Nil(t, err)
+	assert.False(t, policy.hasFilters())
+	assert.Zero(t, policy.MaxIncomingPeerings)
+
+	invalid := []map[string]string{
+		{"clusterNamePattern": "prod-("},
+		{"allowedDiscoveryTypes": "LAN,Bluetooth"},
+		{"allowedTrustModes": "Maybe"},
+		{"maxIncomingPeerings": "-1"},
+		{"maxIncomingPeerings": "many"},
+	}
+	for _, config := range invalid {
+		_, err = parsePolicy(config)
+		assert.NotNil(t, err, config)
+	}
+}
+
+func TestEvaluatePolicy(t *testing.T) {
+	policy, err := parsePolicy(map[string]string{
+		"deniedClusterIDs":      "denied",
+		"clusterNamePattern":    "^prod-",
+		"allowedDiscoveryTypes": "LAN,IncomingPeering",
+		"allowedTrustModes":     "Trusted,Unknown",
+		"maxIncomingPeerings":   "2",
+	})
+	assert.Nil(t, err)
+	selfDeclared := getCandidate("id", "prod-1", discoveryv1alpha1.IncomingPeeringDiscovery, discoveryv1alpha1.TrustModeUnknown)
+	selfDeclared.ClusterNameKnown = false
+	disabled := getCandidate("id", "prod-1", discoveryv1alpha1.LanDiscovery, discoveryv1alpha1.TrustModeTrusted)
+	disabled.IncomingAllowed = false
+
+	tests := []struct {
+		name             string
+		candidate        *PeeringCandidate
+		allowAll         bool
+		incomingPeerings int
+		allowed          bool
+	}{
+		{"allowed", getCandidate("id", "prod-1", discoveryv1alpha1.LanDiscovery, discoveryv1alpha1.TrustModeTrusted), false, 0, true},
+		{"not discovered before", getCandidate("id", "prod-1", discoveryv1alpha1.IncomingPeeringDiscovery, discoveryv1alpha1.TrustModeUnknown), false, 1, true},
+		{"denied cluster", getCandidate("denied", "prod-1", discoveryv1alpha1.LanDiscovery, discoveryv1alpha1.TrustModeTrusted), false, 0, false},
+		{"denied cluster with allowAll", getCandidate("denied", "prod-1", discoveryv1alpha1.LanDiscovery, discoveryv1alpha1.TrustModeTrusted), true, 0, false},
+		{"name not matching", getCandidate("id", "dev-1", discoveryv1alpha1.LanDiscovery, discoveryv1alpha1.TrustModeTrusted), false, 0, false},
+		{"self-declared name", selfDeclared, false, 0, false},
+		{"self-declared name with allowAll", selfDeclared, true, 0, true},
+		{"name not matching with allowAll", getCandidate("id", "dev-1", discoveryv1alpha1.LanDiscovery, discoveryv1alpha1.TrustModeTrusted), true, 0, true},
+		{"discovery type not allowed", getCandidate("id", "prod-1", discoveryv1alpha1.WanDiscovery, discoveryv1alpha1.TrustModeTrusted), false, 0, false},
+		{"trust mode not allowed", getCandidate("id", "prod-1", discoveryv1alpha1.LanDiscovery, discoveryv1alpha1.TrustModeUntrusted), false, 0, false},
+		{"too many peerings", getCandidate("id", "prod-1", discoveryv1alpha1.LanDiscovery, discoveryv1alpha1.TrustModeTrusted), false, 2, false},
+		{"too many peerings with allowAll", getCandidate("id", "prod-1", discoveryv1alpha1.LanDiscovery, discoveryv1alpha1.TrustModeTrusted), true, 2, false},
+		{"incoming peering disabled", disabled, true, 0, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := policy.Evaluate(test.candidate, test.allowAll, test.incomingPeerings)
+			assert.Equal(t, test.allowed, err == nil, err)
+		})
+	}
+
+	//without filters every cluster is denied, unless allowAll is set
+	empty := &PeeringPolicy{}
+	candidate := getCandidate("id", "prod-1", discoveryv1alpha1.LanDiscovery, discoveryv1alpha1.TrustModeTrusted)
+	assert.NotNil(t, empty.Evaluate(candidate, false, 0))
+	assert.Nil(t, empty.Evaluate(candidate, true, 0))
+}
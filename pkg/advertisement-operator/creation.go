@@ -31,7 +31,7 @@ func CreateVkDeployment(adv *advtypes.Advertisement, vkName, vkNamespace, vkImag
 		"--kubelet-namespace",
 		vkNamespace,
 		"--foreign-kubeconfig",
-		"/app/kubeconfig/remote",
+		"/app/kubeconfig/remote/kubeconfig",
 		"--home-cluster-id",
 		homeClusterId,
 	}
@@ -54,10 +54,10 @@ func CreateVkDeployment(adv *advtypes.Advertisement, vkName, vkNamespace, vkImag
 	}
 
 	volumeMounts := []v1.VolumeMount{
+		// the whole Secret is mounted, as a subPath would not receive the rotated credentials
 		{
 			Name:      "remote-kubeconfig",
 			MountPath: "/app/kubeconfig/remote",
-			SubPath:   "kubeconfig",
 		},
 		{
 			Name:      "virtual-kubelet-crt",
@@ -0,0 +1,98 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"k8s.io/client-go/rest"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// CAFingerprint returns the hex encoded SHA-256 of the DER encoding of the first certificate of a PEM bundle, an empty
+// string if there is none
+func CAFingerprint(caPEM []byte) string {
+	block, _ := pem.Decode(caPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return ""
+	}
+	sum := sha256.Sum256(block.Bytes)
+	return hex.EncodeToString(sum[:])
+}
+
+// ServerCAFingerprint connects to a server trusted by the system CAs and returns the fingerprint of the root CA its
+// certificate has been verified with, in the same format of CAFingerprint
+func ServerCAFingerprint(serverURL string, timeout time.Duration) (string, error) {
+	conn, err := dialTLS(serverURL, nil, timeout)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	return verifiedCAFingerprint(conn.ConnectionState().VerifiedChains)
+}
+
+// CheckServerCA connects to a server and checks that it presents a certificate issued by the given PEM encoded CA
+func CheckServerCA(serverURL string, caPEM []byte, timeout time.Duration) error {
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return errors.New("the CA is not PEM encoded")
+	}
+	conn, err := dialTLS(serverURL, pool, timeout)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// dialTLS connects to the host of the URL, verifying its certificate with the given CAs or, if nil, the system ones
+func dialTLS(serverURL string, rootCAs *x509.CertPool, timeout time.Duration) (*tls.Conn, error) {
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return nil, err
+	}
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), "443")
+	}
+	return tls.DialWithDialer(&net.Dialer{Timeout: timeout}, "tcp", host, &tls.Config{ServerName: u.Hostname(), RootCAs: rootCAs})
+}
+
+// ServerCAFingerprintForConfig is ServerCAFingerprint for a server contacted as stated by a rest.Config, e.g. through
+// a proxy, or verifying it with a CA bundle instead of the system CAs
+func ServerCAFingerprintForConfig(config *rest.Config, timeout time.Duration) (string, error) {
+	rt, err := rest.TransportFor(config)
+	if err != nil {
+		return "", err
+	}
+	client := &http.Client{Transport: rt, Timeout: timeout}
+	resp, err := client.Get(strings.TrimSuffix(config.Host, "/") + "/version")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.TLS == nil {
+		return "", errors.New("the server has not been contacted with TLS")
+	}
+	return verifiedCAFingerprint(resp.TLS.VerifiedChains)
+}
+
+// verifiedCAFingerprint returns the fingerprint of the root CA of the first verified chain
+func verifiedCAFingerprint(chains [][]*x509.Certificate) (string, error) {
+	if len(chains) == 0 || len(chains[0]) == 0 {
+		return "", errors.New("the certificate of the server has not been verified")
+	}
+	return certificateFingerprint(chains[0][len(chains[0])-1]), nil
+}
+
+func certificateFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,123 @@
+package auth
+
+import (
+	"errors"
+	configv1alpha1 "github.com/liqotech/liqo/apis/config/v1alpha1"
+	"io/ioutil"
+	utilnet "k8s.io/apimachinery/pkg/util/net"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/transport"
+	"k8s.io/klog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// RotatingToken holds the bearer token of a peering credential, which can be replaced while the clients
+// authenticating with it keep running
+type RotatingToken struct {
+	mutex sync.RWMutex
+	token string
+}
+
+func NewRotatingToken(token string) *RotatingToken {
+	return &RotatingToken{token: token}
+}
+
+// Get returns the current token
+func (t *RotatingToken) Get() string {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	return t.token
+}
+
+// Set replaces the token, returning true if it has changed
+func (t *RotatingToken) Set(token string) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	changed := t.token != token
+	t.token = token
+	return changed
+}
+
+// SetFromKubeconfig replaces the token with the one of the kubeconfig, returning true if it has changed
+func (t *RotatingToken) SetFromKubeconfig(kubeconfig []byte) (bool, error) {
+	token, err := TokenFromKubeconfig(kubeconfig)
+	if err != nil {
+		return false, err
+	}
+	return t.Set(token), nil
+}
+
+// WrapConfig makes the clients built with the config authenticate with the current token, instead of the static
+// credentials of the config
+func (t *RotatingToken) WrapConfig(config *rest.Config) {
+	config.BearerToken = ""
+	config.BearerTokenFile = ""
+	config.WrapTransport = transport.Wrappers(config.WrapTransport, func(rt http.RoundTripper) http.RoundTripper {
+		return &tokenRoundTripper{token: t, next: rt}
+	})
+}
+
+// ReloadFromFile periodically reads the token from the kubeconfig file, e.g. mounted from a Secret, until stop is closed
+func (t *RotatingToken) ReloadFromFile(kubeconfigPath string, period time.Duration, stop <-chan struct{}) {
+	wait.Until(func() {
+		kubeconfig, err := ioutil.ReadFile(kubeconfigPath)
+		if err != nil {
+			klog.Error(err)
+			return
+		}
+		if changed, err := t.SetFromKubeconfig(kubeconfig); err != nil {
+			klog.Error(err)
+		} else if changed {
+			klog.Infof("credentials reloaded from %s", kubeconfigPath)
+		}
+	}, period, stop)
+}
+
+// TokenFromKubeconfig returns the bearer token of the current context of the kubeconfig
+func TokenFromKubeconfig(kubeconfig []byte) (string, error) {
+	cnf, err := clientcmd.Load(kubeconfig)
+	if err != nil {
+		return "", err
+	}
+	context, ok := cnf.Contexts[cnf.CurrentContext]
+	if !ok {
+		return "", errors.New("the kubeconfig has no current context")
+	}
+	authInfo, ok := cnf.AuthInfos[context.AuthInfo]
+	if !ok || authInfo.Token == "" {
+		return "", errors.New("the kubeconfig has no token")
+	}
+	return authInfo.Token, nil
+}
+
+type tokenRoundTripper struct {
+	token *RotatingToken
+	next  http.RoundTripper
+}
+
+func (rt *tokenRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	token := rt.token.Get()
+	if token == "" || req.Header.Get("Authorization") != "" {
+		return rt.next.RoundTrip(req)
+	}
+	req = utilnet.CloneRequest(req)
+	req.Header.Set("Authorization", "Bearer "+token)
+	return rt.next.RoundTrip(req)
+}
+
+func (rt *tokenRoundTripper) WrappedRoundTripper() http.RoundTripper {
+	return rt.next
+}
+
+// RotationPeriod returns the period after which the credentials issued to the peers are rotated, zero if the
+// configuration does not set it
+func RotationPeriod(config *configv1alpha1.ClusterConfigSpec) time.Duration {
+	if config == nil {
+		return 0
+	}
+	return config.CredentialsConfig.RotationPeriod.Duration
+}
@@ -0,0 +1,82 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+)
+
+const (
+	// all the peer clusters belong to this group, the name of their user is prefixed by PeerUserPrefix
+	PeerGroup      = "liqo.io:peers"
+	PeerUserPrefix = "liqo.io:peer:"
+)
+
+// PeerSubject returns the subject of the certificates issued to the given cluster
+func PeerSubject(clusterID string) pkix.Name {
+	return pkix.Name{
+		CommonName:   PeerUserPrefix + clusterID,
+		Organization: []string{PeerGroup},
+	}
+}
+
+// GenerateCSR creates a new key and the PEM encoded certificate signing request for the identity of the given cluster,
+// the key is returned PEM encoded too
+func GenerateCSR(clusterID string) (csrPEM []byte, keyPEM []byte, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{Subject: PeerSubject(clusterID)}, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), nil
+}
+
+// ParseCSR decodes a PEM encoded certificate signing request and checks that it has been signed by the requester key
+// and that it is bound to the identity of the given cluster
+func ParseCSR(csrPEM []byte, clusterID string) (*x509.CertificateRequest, error) {
+	block, _ := pem.Decode(csrPEM)
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		return nil, errors.New("the certificate signing request is not PEM encoded")
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	if err = csr.CheckSignature(); err != nil {
+		return nil, fmt.Errorf("invalid signature of the certificate signing request: %v", err)
+	}
+	subject := PeerSubject(clusterID)
+	if csr.Subject.CommonName != subject.CommonName || len(csr.Subject.Organization) != 1 || csr.Subject.Organization[0] != PeerGroup {
+		return nil, fmt.Errorf("the subject of the certificate signing request does not match the identity of cluster %s", clusterID)
+	}
+	if len(csr.DNSNames) > 0 || len(csr.IPAddresses) > 0 || len(csr.EmailAddresses) > 0 || len(csr.URIs) > 0 {
+		return nil, errors.New("the certificate signing request cannot contain subject alternative names")
+	}
+	return csr, nil
+}
+
+// PublicKeyFingerprint returns the hex encoded SHA-256 of the DER encoding of a public key
+func PublicKeyFingerprint(key crypto.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:]), nil
+}
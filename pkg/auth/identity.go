@@ -0,0 +1,172 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	// path of the identity endpoint of the auth-service, it takes the nonce as query parameter
+	IdentityPath = "/ids"
+
+	// the cluster issues identities authenticated with a token
+	FeatureTokenIdentity = "token-identity"
+	// the cluster issues client certificates from certificate signing requests
+	FeatureCertificateIdentity = "certificate-identity"
+	// the cluster rotates the credentials issued to its peers
+	FeatureCredentialsRotation = "credentials-rotation"
+)
+
+// ErrIdentityNotServed is returned by FetchIdentity when the auth-service does not serve the identity of the cluster,
+// e.g. because it runs an older version
+var ErrIdentityNotServed = errors.New("the auth-service does not serve the identity of the cluster")
+
+// SupportedFeatures are the features advertised in the identity of the cluster
+var SupportedFeatures = []string{FeatureTokenIdentity, FeatureCertificateIdentity, FeatureCredentialsRotation}
+
+// Identity describes a cluster before it is trusted
+type Identity struct {
+	ClusterID      string `json:"clusterID"`
+	ClusterName    string `json:"clusterName,omitempty"`
+	AuthServiceURL string `json:"authServiceURL,omitempty"`
+	// hex encoded SHA-256 of the DER encoding of the CA of the API server
+	CAFingerprint string `json:"caFingerprint,omitempty"`
+	// PEM encoded CA of the API server, the peers check that the API server presents a certificate issued by it
+	CAData   []byte   `json:"caData,omitempty"`
+	Features []string `json:"features,omitempty"`
+	// random value chosen by the requester, it prevents the replay of a previous response
+	Nonce string `json:"nonce"`
+}
+
+// SignedIdentity is the response of the identity endpoint: the JSON encoded Identity, signed with the key of the
+// cluster identity, which signs the announcements of the cluster too
+type SignedIdentity struct {
+	Identity  []byte `json:"identity"`
+	Signature []byte `json:"signature"`
+	// DER encoded public key of the cluster identity, in PKIX form
+	PublicKey []byte `json:"publicKey"`
+}
+
+// SignIdentity encodes the identity and signs it with the given key
+func SignIdentity(identity *Identity, key crypto.Signer) (*SignedIdentity, error) {
+	data, err := json.Marshal(identity)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := Sign(data, key)
+	if err != nil {
+		return nil, err
+	}
+	publicKey, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		return nil, err
+	}
+	return &SignedIdentity{Identity: data, Signature: signature, PublicKey: publicKey}, nil
+}
+
+// VerifyIdentity checks the signature of the identity and that it has been issued for the given nonce
+func VerifyIdentity(signed *SignedIdentity, key crypto.PublicKey, nonce string) (*Identity, error) {
+	if err := Verify(signed.Identity, signed.Signature, key); err != nil {
+		return nil, fmt.Errorf("invalid signature of the identity: %w", err)
+	}
+
+	identity := &Identity{}
+	if err := json.Unmarshal(signed.Identity, identity); err != nil {
+		return nil, err
+	}
+	if identity.Nonce != nonce {
+		return nil, errors.New("the identity has not been issued for this request")
+	}
+	return identity, nil
+}
+
+// Sign signs the data with the given key. RSA and ECDSA keys sign its SHA-256 digest, Ed25519 keys the data itself.
+func Sign(data []byte, key crypto.Signer) ([]byte, error) {
+	if _, ok := key.Public().(ed25519.PublicKey); ok {
+		return key.Sign(rand.Reader, data, crypto.Hash(0))
+	}
+	digest := sha256.Sum256(data)
+	return key.Sign(rand.Reader, digest[:], crypto.SHA256)
+}
+
+// Verify checks a signature made by Sign with the private key of the given public key
+func Verify(data []byte, signature []byte, key crypto.PublicKey) error {
+	digest := sha256.Sum256(data)
+	var valid bool
+	switch pub := key.(type) {
+	case *rsa.PublicKey:
+		valid = rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], signature) == nil
+	case *ecdsa.PublicKey:
+		valid = ecdsa.VerifyASN1(pub, digest[:], signature)
+	case ed25519.PublicKey:
+		valid = ed25519.Verify(pub, data, signature)
+	default:
+		return fmt.Errorf("unsupported key type %T", key)
+	}
+	if !valid {
+		return errors.New("the signature does not match")
+	}
+	return nil
+}
+
+// FetchIdentity gets the identity of the cluster from its auth-service and verifies it against the key of the cluster
+// identity sent with it, whose fingerprint is returned too.
+// The signature only proves that the auth-service holds that key: the caller has to check the fingerprint against the
+// one of the announcements of the cluster, or against the one pinned on the first use.
+func FetchIdentity(authServiceURL string, timeout time.Duration) (identity *Identity, keyFingerprint string, err error) {
+	nonceBytes := make([]byte, 16)
+	if _, err = rand.Read(nonceBytes); err != nil {
+		return nil, "", err
+	}
+	nonce := hex.EncodeToString(nonceBytes)
+
+	client := &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true}, // nolint:gosec // the identity is signed with the cluster key
+		},
+	}
+	resp, err := client.Get(authServiceURL + IdentityPath + "?nonce=" + url.QueryEscape(nonce))
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, "", fmt.Errorf("%s: %w", authServiceURL, ErrIdentityNotServed)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("the identity endpoint of %s returned %s", authServiceURL, resp.Status)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	signed := &SignedIdentity{}
+	if err = json.Unmarshal(body, signed); err != nil {
+		return nil, "", err
+	}
+
+	key, err := x509.ParsePKIXPublicKey(signed.PublicKey)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid key of the identity: %w", err)
+	}
+	if identity, err = VerifyIdentity(signed, key, nonce); err != nil {
+		return nil, "", err
+	}
+	keyFingerprint, err = PublicKeyFingerprint(key)
+	return identity, keyFingerprint, err
+}
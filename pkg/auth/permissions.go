@@ -0,0 +1,160 @@
+package auth
+
+import (
+	configv1alpha1 "github.com/liqotech/liqo/apis/config/v1alpha1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"strings"
+)
+
+const (
+	// replaced with the ClusterID of the peer in the resourceNames of the rules of a template
+	ClusterIDPlaceholder = "$(CLUSTER_ID)"
+	// name of the ClusterRole granted to the virtual kubelets of the peers
+	VirtualKubeletClusterRole = "liqo-remote-virtual-kubelet"
+)
+
+var (
+	defaultPeeringRequestPermissions = configv1alpha1.PermissionsTemplate{
+		ClusterRules: []rbacv1.PolicyRule{
+			{
+				APIGroups: []string{"discovery.liqo.io"},
+				Resources: []string{"peeringrequests"},
+				Verbs:     []string{"create"},
+			},
+			{
+				APIGroups:     []string{"discovery.liqo.io"},
+				Resources:     []string{"peeringrequests"},
+				Verbs:         []string{"get", "delete", "update"},
+				ResourceNames: []string{ClusterIDPlaceholder},
+			},
+		},
+		NamespacedRules: []rbacv1.PolicyRule{
+			{
+				APIGroups: []string{""},
+				Resources: []string{"secrets"},
+				Verbs:     []string{"create"},
+			},
+			{
+				APIGroups:     []string{""},
+				Resources:     []string{"secrets"},
+				Verbs:         []string{"get", "delete"},
+				ResourceNames: []string{ClusterIDPlaceholder},
+			},
+			{
+				APIGroups:     []string{""},
+				Resources:     []string{"secrets"},
+				Verbs:         []string{"get"},
+				ResourceNames: []string{"ca-data"},
+			},
+		},
+	}
+
+	defaultAdvertisementBroadcasterPermissions = configv1alpha1.PermissionsTemplate{
+		ClusterRules: []rbacv1.PolicyRule{
+			{
+				APIGroups: []string{"sharing.liqo.io"},
+				Resources: []string{"advertisements", "advertisements/status"},
+				Verbs:     []string{"get", "list", "create", "update", "delete", "watch"},
+			},
+		},
+		NamespacedRules: []rbacv1.PolicyRule{
+			{
+				APIGroups: []string{""},
+				Resources: []string{"secrets"},
+				Verbs:     []string{"get", "list", "create", "update", "delete", "watch"},
+			},
+		},
+	}
+
+	defaultVirtualKubeletPermissions = configv1alpha1.PermissionsTemplate{
+		ClusterRules: []rbacv1.PolicyRule{
+			{
+				APIGroups: []string{""},
+				Resources: []string{"namespaces", "pods", "services", "endpoints", "configmaps", "secrets"},
+				Verbs:     []string{"get", "list", "watch", "create", "update", "patch", "delete"},
+			},
+			{
+				APIGroups: []string{""},
+				Resources: []string{"pods/status", "pods/log", "pods/exec"},
+				Verbs:     []string{"get", "create", "update"},
+			},
+			{
+				APIGroups: []string{"apps"},
+				Resources: []string{"replicasets"},
+				Verbs:     []string{"get", "list", "watch", "create", "update", "patch", "delete"},
+			},
+			{
+				APIGroups: []string{"discovery.k8s.io"},
+				Resources: []string{"endpointslices"},
+				Verbs:     []string{"get", "list", "watch", "create", "update", "patch", "delete"},
+			},
+			{
+				APIGroups: []string{"networking.k8s.io"},
+				Resources: []string{"networkpolicies"},
+				Verbs:     []string{"get", "list", "watch", "create", "update", "patch", "delete"},
+			},
+		},
+	}
+)
+
+// PeeringRequestPermissions returns the permissions granted by the auth-service to the given cluster
+func PeeringRequestPermissions(config *configv1alpha1.ClusterConfigSpec, clusterID string) configv1alpha1.PermissionsTemplate {
+	var template *configv1alpha1.PermissionsTemplate
+	if config != nil {
+		template = config.PeerPermissionsConfig.PeeringRequest
+	}
+	return render(template, defaultPeeringRequestPermissions, clusterID)
+}
+
+// AdvertisementBroadcasterPermissions returns the permissions granted to the broadcaster of the given cluster
+func AdvertisementBroadcasterPermissions(config *configv1alpha1.ClusterConfigSpec, clusterID string) configv1alpha1.PermissionsTemplate {
+	var template *configv1alpha1.PermissionsTemplate
+	if config != nil {
+		template = config.PeerPermissionsConfig.AdvertisementBroadcaster
+	}
+	return render(template, defaultAdvertisementBroadcasterPermissions, clusterID)
+}
+
+// CRDReplicatorPermissions returns the permissions granted to the CRD replicator of the given cluster, by default
+// the ones needed to replicate the resources of the DispatcherConfig
+func CRDReplicatorPermissions(config *configv1alpha1.ClusterConfigSpec, clusterID string) configv1alpha1.PermissionsTemplate {
+	var template *configv1alpha1.PermissionsTemplate
+	defaults := configv1alpha1.PermissionsTemplate{}
+	if config != nil {
+		template = config.PeerPermissionsConfig.CRDReplicator
+		for _, res := range config.DispatcherConfig.ResourcesToReplicate {
+			defaults.ClusterRules = append(defaults.ClusterRules, rbacv1.PolicyRule{
+				Verbs:     []string{"*"},
+				APIGroups: []string{res.Group},
+				Resources: []string{res.Resource, res.Resource + "/status"},
+			})
+		}
+	}
+	return render(template, defaults, clusterID)
+}
+
+// VirtualKubeletPermissions returns the permissions granted to the virtual kubelets of all the peers
+func VirtualKubeletPermissions(config *configv1alpha1.ClusterConfigSpec) configv1alpha1.PermissionsTemplate {
+	var template *configv1alpha1.PermissionsTemplate
+	if config != nil {
+		template = config.PeerPermissionsConfig.VirtualKubelet
+	}
+	return render(template, defaultVirtualKubeletPermissions, "")
+}
+
+// render returns a copy of the template, or of the defaults if it is not set, where the placeholder in the
+// resourceNames of the rules has been replaced with the ClusterID
+func render(template *configv1alpha1.PermissionsTemplate, defaults configv1alpha1.PermissionsTemplate, clusterID string) configv1alpha1.PermissionsTemplate {
+	if template == nil {
+		template = &defaults
+	}
+	rendered := template.DeepCopy()
+	for _, rules := range [][]rbacv1.PolicyRule{rendered.ClusterRules, rendered.NamespacedRules} {
+		for i := range rules {
+			for j := range rules[i].ResourceNames {
+				rules[i].ResourceNames[j] = strings.ReplaceAll(rules[i].ResourceNames[j], ClusterIDPlaceholder, clusterID)
+			}
+		}
+	}
+	return *rendered
+}
@@ -1,6 +1,22 @@
 package auth
 
+import "time"
+
 type RoleRequest struct {
 	ClusterID string `json:"clusterID"`
-	Token     string `json:"token"`
+	Token     string `json:"token,omitempty"`
+	// PEM encoded certificate signing request, whose subject has to be the one returned by PeerSubject for the ClusterID.
+	// When it is set the response is a CertificateResponse instead of a kubeconfig.
+	CertificateSigningRequest []byte `json:"csr,omitempty"`
+}
+
+// CertificateResponse contains the certificate issued to a peer cluster and what it needs to contact the API server
+type CertificateResponse struct {
+	// PEM encoded client certificate, signed by the CA of the cluster
+	Certificate []byte `json:"certificate"`
+	// PEM encoded CA of the API server
+	CAData []byte `json:"caData"`
+	Server string `json:"server"`
+	// time after which the certificate has to be renewed, requesting a new one with the current certificate
+	RenewAfter time.Time `json:"renewAfter"`
 }
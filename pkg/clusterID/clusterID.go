@@ -2,7 +2,8 @@ package clusterID
 
 import (
 	"context"
-	"errors"
+	"crypto"
+	"fmt"
 	"github.com/google/uuid"
 	discoveryv1alpha1 "github.com/liqotech/liqo/apis/discovery/v1alpha1"
 	"github.com/liqotech/liqo/pkg/crdClient"
@@ -11,18 +12,31 @@ import (
 	k8serror "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/klog"
+	"k8s.io/kubernetes/pkg/util/slice"
+	"k8s.io/utils/pointer"
 	"os"
-	"sort"
 	"strings"
 	"sync"
 )
 
+const (
+	// ConfigMapName is the name of the ConfigMap storing the ID of the cluster in the Liqo namespace
+	ConfigMapName = "cluster-id"
+	// ConfigMapKey is the key of the ID in the ConfigMap
+	ConfigMapKey = "cluster-id"
+	// Finalizer protects the ConfigMap from the deletion, the cluster ID is lost only when Liqo is purged
+	Finalizer = "liqo.io/cluster-id"
+)
+
 type ClusterID struct {
 	id string
-	m  sync.RWMutex
+	// private key of the cluster identity
+	key crypto.Signer
+	m   sync.RWMutex
 
 	client kubernetes.Interface
 }
@@ -69,7 +83,7 @@ func NewClusterID(kubeconfigPath string) (*ClusterID, error) {
 		"configmaps",
 		namespace,
 		fields.SelectorFromSet(fields.Set{
-			"metadata.name": "cluster-id",
+			"metadata.name": ConfigMapName,
 		}),
 	)
 	_, controller := cache.NewInformer(
@@ -100,16 +114,62 @@ func NewClusterID(kubeconfigPath string) (*ClusterID, error) {
 	return clusterId, nil
 }
 
-func (cId *ClusterID) SetupClusterID(namespace string) error {
-	id, err := cId.getMasterID()
-	if err != nil {
-		klog.Warning(err, "cannot get UID from master, generating new one")
-		id = uuid.New().String()
+// SetupClusterID loads the ID of the cluster from the cluster-id ConfigMap, creating it at the first start with the
+// requested ID or with a generated one. The ID is never replaced: the peerings of the cluster are bound to it. The
+// ConfigMap is immutable and it is protected from the deletion by a finalizer, removed only when Liqo is purged.
+func (cId *ClusterID) SetupClusterID(namespace string, requestedID string) error {
+	if requestedID != "" {
+		if errs := validation.IsDNS1123Label(requestedID); len(errs) > 0 {
+			return fmt.Errorf("invalid cluster ID %s: %s", requestedID, strings.Join(errs, ", "))
+		}
+	}
+
+	configMaps := cId.client.CoreV1().ConfigMaps(namespace)
+	cm, err := configMaps.Get(context.TODO(), ConfigMapName, metav1.GetOptions{})
+	if k8serror.IsNotFound(err) {
+		id := requestedID
+		if id == "" {
+			id = uuid.New().String()
+		}
+		cm = &v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:       ConfigMapName,
+				Finalizers: []string{Finalizer},
+			},
+			Data: map[string]string{
+				ConfigMapKey: id,
+			},
+			Immutable: pointer.BoolPtr(true),
+		}
+		if cm, err = configMaps.Create(context.TODO(), cm, metav1.CreateOptions{}); k8serror.IsAlreadyExists(err) {
+			// created by another instance
+			cm, err = configMaps.Get(context.TODO(), ConfigMapName, metav1.GetOptions{})
+		}
 	}
-	err = cId.save(id, namespace)
 	if err != nil {
 		return err
 	}
+
+	id := cm.Data[ConfigMapKey]
+	if id == "" {
+		return fmt.Errorf("the ConfigMap %s does not contain the cluster ID", ConfigMapName)
+	}
+	if requestedID != "" && requestedID != id {
+		return fmt.Errorf("the cluster ID is %s, it cannot be replaced with %s without losing the peerings", id, requestedID)
+	}
+	// the ConfigMaps created by the previous versions are protected too
+	if !slice.ContainsString(cm.Finalizers, Finalizer, nil) {
+		cm.Finalizers = append(cm.Finalizers, Finalizer)
+		cm.Immutable = pointer.BoolPtr(true)
+		if _, err = configMaps.Update(context.TODO(), cm, metav1.UpdateOptions{}); err != nil {
+			return err
+		}
+	}
+
+	cId.m.Lock()
+	cId.id = id
+	cId.m.Unlock()
+	klog.Infof("ClusterID: %s", id)
 	return nil
 }
 
@@ -120,56 +180,13 @@ func (cId *ClusterID) GetClusterID() string {
 	return res
 }
 
-func (cId *ClusterID) getMasterID() (string, error) {
-	nodes, err := cId.client.CoreV1().Nodes().List(context.TODO(), metav1.ListOptions{
-		LabelSelector: "node-role.kubernetes.io/master",
-	})
-	if err != nil {
-		return "", err
-	}
-	if len(nodes.Items) == 0 {
-		return "", errors.New("no master node found")
-	}
-	// get first master (ordered alphabetically by name)
-	sort.Slice(nodes.Items, func(i, j int) bool {
-		return nodes.Items[i].Name < nodes.Items[j].Name
-	})
-	return string(nodes.Items[0].UID), nil
-}
-
-func (cId *ClusterID) save(id string, namespace string) error {
-	cm, err := cId.client.CoreV1().ConfigMaps(namespace).Get(context.TODO(), "cluster-id", metav1.GetOptions{})
-	if err != nil {
-		if k8serror.IsNotFound(err) {
-			// does not exist
-			cm = &v1.ConfigMap{
-				ObjectMeta: metav1.ObjectMeta{
-					Name: "cluster-id",
-				},
-				Data: map[string]string{
-					"cluster-id": id,
-				},
-			}
-			cId.id = id
-			_, err := cId.client.CoreV1().ConfigMaps(namespace).Create(context.TODO(), cm, metav1.CreateOptions{})
-			return err
-		}
-		// other errors
-		return err
-	}
-	// already exists, update it if needed
-	if cm.Data["cluster-id"] != id {
-		cm.Data["cluster-id"] = id
-		_, err := cId.client.CoreV1().ConfigMaps(namespace).Update(context.TODO(), cm, metav1.UpdateOptions{})
-		return err
-	}
-	return nil
-}
-
 func (cId *ClusterID) clusterIdUpdated(obj interface{}) {
-	tmp := obj.(*v1.ConfigMap).Data["cluster-id"]
+	tmp := obj.(*v1.ConfigMap).Data[ConfigMapKey]
 	cId.m.RLock()
 	curr := cId.id
+	if curr != "" && curr != tmp {
+		klog.Warningf("ClusterID changed from %s to %s, the existing peerings are lost", curr, tmp)
+	}
 	if curr != tmp {
 		cId.m.RLocker().Lock()
 		cId.id = tmp
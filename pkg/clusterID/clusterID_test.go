@@ -0,0 +1,83 @@
+package clusterID
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"testing"
+)
+
+func TestSetupClusterID(t *testing.T) {
+	client := fake.NewSimpleClientset()
+
+	// the requested ID is set at the first start
+	assert.NotNil(t, GetNewClusterID("", client).SetupClusterID("liqo", "Invalid_ID"))
+	clusterID := GetNewClusterID("", client)
+	assert.Nil(t, clusterID.SetupClusterID("liqo", "cluster-1"))
+	assert.Equal(t, "cluster-1", clusterID.GetClusterID())
+	cm, err := client.CoreV1().ConfigMaps("liqo").Get(context.TODO(), ConfigMapName, metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.Contains(t, cm.Finalizers, Finalizer)
+	assert.True(t, *cm.Immutable)
+
+	// the ID is kept across the restarts, and it cannot be replaced
+	clusterID = GetNewClusterID("", client)
+	assert.Nil(t, clusterID.SetupClusterID("liqo", ""))
+	assert.Equal(t, "cluster-1", clusterID.GetClusterID())
+	assert.NotNil(t, GetNewClusterID("", client).SetupClusterID("liqo", "cluster-2"))
+
+	// a generated ID is used if none is requested
+	clusterID = GetNewClusterID("", client)
+	assert.Nil(t, clusterID.SetupClusterID("other", ""))
+	assert.NotEmpty(t, clusterID.GetClusterID())
+	assert.NotEqual(t, "cluster-1", clusterID.GetClusterID())
+}
+
+func TestSetupClusterIDUpgrade(t *testing.T) {
+	// the ConfigMaps created by the previous versions are kept and protected
+	client := fake.NewSimpleClientset(&v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: ConfigMapName, Namespace: "liqo"},
+		Data:       map[string]string{ConfigMapKey: "master-uid"},
+	})
+	clusterID := GetNewClusterID("", client)
+	assert.Nil(t, clusterID.SetupClusterID("liqo", ""))
+	assert.Equal(t, "master-uid", clusterID.GetClusterID())
+	cm, err := client.CoreV1().ConfigMaps("liqo").Get(context.TODO(), ConfigMapName, metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.Contains(t, cm.Finalizers, Finalizer)
+}
+
+func TestLoadKey(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	assert.NotNil(t, GetNewClusterID("", client).LoadKey("liqo"), "the key is bound to the cluster ID")
+
+	clusterID := GetNewClusterID("cluster-1", client)
+	assert.Nil(t, clusterID.LoadKey("liqo"))
+	assert.NotNil(t, clusterID.GetKey())
+
+	// the key is kept across the restarts
+	clusterID2 := GetNewClusterID("cluster-1", client)
+	assert.Nil(t, clusterID2.LoadKey("liqo"))
+	assert.Equal(t, clusterID.GetKey(), clusterID2.GetKey())
+
+	// the key of another cluster ID is refused
+	assert.NotNil(t, GetNewClusterID("cluster-2", client).LoadKey("liqo"))
+}
+
+func TestLoadLegacyKey(t *testing.T) {
+	// the key signing the announcements is adopted, the peers which pinned it keep trusting the cluster
+	keyPEM, err := GenerateKey()
+	assert.Nil(t, err)
+	legacyKey, err := ParseKey(keyPEM)
+	assert.Nil(t, err)
+	client := fake.NewSimpleClientset(&v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: legacyKeySecretName, Namespace: "liqo"},
+		Data:       map[string][]byte{keyField: keyPEM},
+	})
+
+	clusterID := GetNewClusterID("cluster-1", client)
+	assert.Nil(t, clusterID.LoadKey("liqo"))
+	assert.Equal(t, legacyKey, clusterID.GetKey())
+}
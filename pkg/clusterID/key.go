@@ -0,0 +1,126 @@
+package clusterID
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	k8serror "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog"
+)
+
+const (
+	// KeySecretName is the name of the Secret storing the private key of the cluster identity in the Liqo namespace
+	KeySecretName = "liqo-cluster-key"
+	keyField      = "key.pem"
+	// the key is bound to the cluster ID stated by this annotation
+	keyClusterIDAnnotation = "liqo.io/cluster-id"
+
+	// Secret storing the key which signed the announcements before the cluster identity had a key, it is adopted so
+	// that the peers which pinned it keep trusting the cluster
+	legacyKeySecretName = "liqo-announcement-key"
+)
+
+// LoadKey loads the private key of the cluster identity, creating it if it does not exist. The key is bound to the
+// cluster ID, set up before: it signs on behalf of the cluster in the discovery and the authentication.
+func (cId *ClusterID) LoadKey(namespace string) error {
+	id := cId.GetClusterID()
+	if id == "" {
+		return errors.New("the cluster ID is not set yet")
+	}
+
+	secrets := cId.client.CoreV1().Secrets(namespace)
+	secret, err := secrets.Get(context.TODO(), KeySecretName, metav1.GetOptions{})
+	if k8serror.IsNotFound(err) {
+		var keyPEM []byte
+		if keyPEM, err = cId.getInitialKey(namespace); err != nil {
+			return err
+		}
+		secret = &v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        KeySecretName,
+				Annotations: map[string]string{keyClusterIDAnnotation: id},
+			},
+			Data: map[string][]byte{
+				keyField: keyPEM,
+			},
+		}
+		if secret, err = secrets.Create(context.TODO(), secret, metav1.CreateOptions{}); k8serror.IsAlreadyExists(err) {
+			// created by another component
+			secret, err = secrets.Get(context.TODO(), KeySecretName, metav1.GetOptions{})
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	if owner := secret.Annotations[keyClusterIDAnnotation]; owner != id {
+		return fmt.Errorf("the key in the secret %s belongs to the cluster %s, not to %s", KeySecretName, owner, id)
+	}
+	key, err := ParseKey(secret.Data[keyField])
+	if err != nil {
+		return fmt.Errorf("invalid key in the secret %s: %v", KeySecretName, err)
+	}
+	cId.m.Lock()
+	cId.key = key
+	cId.m.Unlock()
+	return nil
+}
+
+// GetKey returns the private key of the cluster identity, nil if it has not been loaded
+func (cId *ClusterID) GetKey() crypto.Signer {
+	cId.m.RLock()
+	defer cId.m.RUnlock()
+	return cId.key
+}
+
+// getInitialKey returns the key signing the announcements if it exists, otherwise it generates a new one
+func (cId *ClusterID) getInitialKey(namespace string) ([]byte, error) {
+	legacy, err := cId.client.CoreV1().Secrets(namespace).Get(context.TODO(), legacyKeySecretName, metav1.GetOptions{})
+	if err == nil {
+		if _, err = ParseKey(legacy.Data[keyField]); err == nil {
+			klog.Infof("the key of the secret %s is adopted by the cluster identity", legacyKeySecretName)
+			return legacy.Data[keyField], nil
+		}
+		klog.Warningf("invalid key in the secret %s, a new one is generated: %v", legacyKeySecretName, err)
+	} else if !k8serror.IsNotFound(err) {
+		return nil, err
+	}
+	return GenerateKey()
+}
+
+// GenerateKey generates an Ed25519 private key, PEM encoded in PKCS #8 form
+func GenerateKey() ([]byte, error) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// ParseKey parses a PEM encoded PKCS #8 private key
+func ParseKey(keyPEM []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(keyPEM)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, errors.New("no PEM encoded private key found")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("the key cannot sign")
+	}
+	return signer, nil
+}
@@ -0,0 +1,127 @@
+package liqonet
+
+import (
+	"context"
+	"fmt"
+	"github.com/liqotech/liqo/internal/utils/errdefs"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog"
+	"strconv"
+	"strings"
+)
+
+const (
+	//GatewayLabelKey marks the nodes which can host the gateway
+	GatewayLabelKey = "net.liqo.io/gateway"
+	//ActiveGatewayLabelKey marks the gateway node where the tunnel-operator holding the leadership is running
+	ActiveGatewayLabelKey = "net.liqo.io/gateway-active"
+	//TunnelMTUAnnotationKey publishes the MTU of the tunnels computed from the interface of the active gateway
+	TunnelMTUAnnotationKey = "net.liqo.io/tunnel-mtu"
+)
+
+//ListGatewayNodes returns the nodes labeled as gateway, the active one and the standby ones
+func ListGatewayNodes(clientset kubernetes.Interface) ([]corev1.Node, error) {
+	nodesList, err := clientset.CoreV1().Nodes().List(context.TODO(), metav1.ListOptions{LabelSelector: GatewayLabelKey + "=true"})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list nodes with label '%s=true': %v", GatewayLabelKey, err)
+	}
+	return nodesList.Items, nil
+}
+
+//GetActiveGateway returns, among the given gateway nodes, the one hosting the active gateway.
+//If there is a single gateway node it is the active one even if it has not been labeled yet,
+//this way the clusters without standby gateways keep working as before.
+func GetActiveGateway(nodes []corev1.Node) (*corev1.Node, error) {
+	var active []corev1.Node
+	for i := range nodes {
+		if nodes[i].Labels[ActiveGatewayLabelKey] == "true" {
+			active = append(active, nodes[i])
+		}
+	}
+	switch {
+	case len(active) == 1:
+		return &active[0], nil
+	case len(active) == 0 && len(nodes) == 1:
+		return &nodes[0], nil
+	default:
+		klog.V(4).Infof("number of gateway nodes found: %d, active ones: %d", len(nodes), len(active))
+		return nil, errdefs.NotFound("no active gateway node has been found")
+	}
+}
+
+//SetActiveGateway labels the given node as the active gateway and removes the label from the other gateway nodes.
+//It is called by the tunnel-operator when it acquires the leadership.
+func SetActiveGateway(clientset kubernetes.Interface, nodeName string) error {
+	nodes, err := ListGatewayNodes(clientset)
+	if err != nil {
+		return err
+	}
+	found := false
+	//the label is set on the new gateway before removing it from the old one: while the two nodes are both
+	//labeled no gateway is returned by GetActiveGateway and the other components keep the current configuration
+	for _, node := range nodes {
+		if node.Name == nodeName {
+			found = true
+			if err := patchActiveGatewayLabel(clientset, node.Name, `"true"`); err != nil {
+				return err
+			}
+		}
+	}
+	if !found {
+		return fmt.Errorf("node %s is not labeled as '%s=true'", nodeName, GatewayLabelKey)
+	}
+	for _, node := range nodes {
+		if node.Name != nodeName && node.Labels[ActiveGatewayLabelKey] != "" {
+			if err := patchActiveGatewayLabel(clientset, node.Name, "null"); err != nil {
+				return err
+			}
+		}
+	}
+	klog.Infof("node %s is the active gateway", nodeName)
+	return nil
+}
+
+func patchActiveGatewayLabel(clientset kubernetes.Interface, nodeName, value string) error {
+	patch := fmt.Sprintf(`{"metadata":{"labels":{"%s":%s}}}`, ActiveGatewayLabelKey, value)
+	_, err := clientset.CoreV1().Nodes().Patch(context.TODO(), nodeName, types.MergePatchType, []byte(patch), metav1.PatchOptions{})
+	if err != nil {
+		return fmt.Errorf("unable to patch label '%s' of node %s: %v", ActiveGatewayLabelKey, nodeName, err)
+	}
+	return nil
+}
+
+//SetTunnelMTUAnnotation publishes on the gateway node the MTU supported by its tunnels
+func SetTunnelMTUAnnotation(clientset kubernetes.Interface, nodeName string, mtu int) error {
+	patch := fmt.Sprintf(`{"metadata":{"annotations":{"%s":"%d"}}}`, TunnelMTUAnnotationKey, mtu)
+	_, err := clientset.CoreV1().Nodes().Patch(context.TODO(), nodeName, types.MergePatchType, []byte(patch), metav1.PatchOptions{})
+	if err != nil {
+		return fmt.Errorf("unable to patch annotation '%s' of node %s: %v", TunnelMTUAnnotationKey, nodeName, err)
+	}
+	return nil
+}
+
+//GetTunnelMTUAnnotation returns the MTU published on the gateway node, 0 if it has not been set
+func GetTunnelMTUAnnotation(node *corev1.Node) int {
+	value, ok := node.Annotations[TunnelMTUAnnotationKey]
+	if !ok {
+		return 0
+	}
+	mtu, err := strconv.Atoi(value)
+	if err != nil {
+		klog.Errorf("invalid value '%s' for annotation '%s' of node %s", value, TunnelMTUAnnotationKey, node.Name)
+		return 0
+	}
+	return mtu
+}
+
+//GetVxlanIP derives the IP address of the vxlan device of a node from its internal IP:
+//the last octet of the internal IP is appended to the first three octets of the vxlan network
+func GetVxlanIP(internalIP, vxlanNetwork string) string {
+	//TODO: use & and | operators with masks
+	temp := strings.Split(internalIP, ".")
+	temp1 := strings.Split(strings.Split(vxlanNetwork, "/")[0], ".")
+	return temp1[0] + "." + temp1[1] + "." + temp1[2] + "." + temp[3]
+}
@@ -0,0 +1,61 @@
+package liqonet
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"testing"
+)
+
+func getGatewayNode(name, ip string, active bool) *corev1.Node {
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: map[string]string{GatewayLabelKey: "true"},
+		},
+		Status: corev1.NodeStatus{
+			Addresses: []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: ip}},
+		},
+	}
+	if active {
+		node.Labels[ActiveGatewayLabelKey] = "true"
+	}
+	return node
+}
+
+func TestGetActiveGateway(t *testing.T) {
+	//a single gateway node is the active one even if it is not labeled
+	node, err := GetActiveGateway([]corev1.Node{*getGatewayNode("node1", "10.0.0.1", false)})
+	assert.Nil(t, err)
+	assert.Equal(t, "node1", node.Name)
+	//with standby gateways only the labeled one is active
+	_, err = GetActiveGateway([]corev1.Node{*getGatewayNode("node1", "10.0.0.1", false), *getGatewayNode("node2", "10.0.0.2", false)})
+	assert.NotNil(t, err)
+	node, err = GetActiveGateway([]corev1.Node{*getGatewayNode("node1", "10.0.0.1", false), *getGatewayNode("node2", "10.0.0.2", true)})
+	assert.Nil(t, err)
+	assert.Equal(t, "node2", node.Name)
+	_, err = GetActiveGateway(nil)
+	assert.NotNil(t, err)
+}
+
+func TestSetActiveGateway(t *testing.T) {
+	clientset := fake.NewSimpleClientset(getGatewayNode("node1", "10.0.0.1", true), getGatewayNode("node2", "10.0.0.2", false))
+	//failover to node2
+	assert.Nil(t, SetActiveGateway(clientset, "node2"))
+	nodes, err := ListGatewayNodes(clientset)
+	assert.Nil(t, err)
+	node, err := GetActiveGateway(nodes)
+	assert.Nil(t, err)
+	assert.Equal(t, "node2", node.Name)
+	old, err := clientset.CoreV1().Nodes().Get(context.TODO(), "node1", metav1.GetOptions{})
+	assert.Nil(t, err)
+	_, ok := old.Labels[ActiveGatewayLabelKey]
+	assert.False(t, ok)
+	vxlanIP, err := GetGatewayVxlanIP(clientset, VxlanNetConfig{Network: "192.168.200.0/24"})
+	assert.Nil(t, err)
+	assert.Equal(t, "192.168.200.2", vxlanIP)
+	//a node which is not labeled as gateway can not become the active one
+	assert.NotNil(t, SetActiveGateway(clientset, "node3"))
+}
@@ -14,6 +14,7 @@ type gretunAttributes struct {
 	local  net.IP
 	remote net.IP
 	ttl    uint8
+	mtu    int
 }
 
 type gretunIface struct {
@@ -64,6 +65,9 @@ func compareGretun(existing, new *netlink.Gretun) bool {
 	if existing.Ttl != new.Ttl {
 		return false
 	}
+	if new.MTU != 0 && existing.MTU != new.MTU {
+		return false
+	}
 	return true
 }
 
@@ -72,6 +76,7 @@ func newGretunInterface(attributes *gretunAttributes) (*gretunIface, error) {
 	iface := &netlink.Gretun{
 		LinkAttrs: netlink.LinkAttrs{
 			Name: attributes.name,
+			MTU:  attributes.mtu,
 		},
 		Local:  attributes.local,
 		Remote: attributes.remote,
@@ -94,20 +99,38 @@ func (iface *gretunIface) deleteGretunIface() error {
 	return nil
 }
 
-func (iface *gretunIface) configureIPAddress(ipAddress net.IP, mask net.IPMask) error {
-	ipConfig := &netlink.Addr{
-		IPNet: &net.IPNet{
-			IP:   ipAddress,
-			Mask: mask,
-		},
+//configurePeerAddress assigns to the gretun interface the local address of the point to point link with the remote
+//end of the tunnel, removing the stale ones
+func (iface *gretunIface) configurePeerAddress(local, peer net.IP) error {
+	//the interface is looked up again, the index is not known if it already existed
+	link, err := netlink.LinkByName(iface.link.Name)
+	if err != nil {
+		return fmt.Errorf("unable to get the gretun interface (%s): %v", iface.link.Name, err)
 	}
-	//add the ip address to the gretun interface
-	err := netlink.AddrAdd(iface.link, ipConfig)
-	if err == syscall.EEXIST {
-		klog.V(6).Infof("gretun interface (%s) has already IP (%s)", iface.link.Name, ipAddress)
+	addr := &netlink.Addr{
+		IPNet: &net.IPNet{IP: local, Mask: net.CIDRMask(32, 32)},
+		Peer:  &net.IPNet{IP: peer, Mask: net.CIDRMask(32, 32)},
+	}
+	existing, err := netlink.AddrList(link, netlink.FAMILY_V4)
+	if err != nil {
+		return fmt.Errorf("unable to list the IP addresses of gretun interface (%s): %v", iface.link.Name, err)
+	}
+	configured := false
+	for i := range existing {
+		if existing[i].IPNet.IP.Equal(local) && existing[i].Peer != nil && existing[i].Peer.IP.Equal(peer) {
+			configured = true
+			continue
+		}
+		if err := netlink.AddrDel(link, &existing[i]); err != nil {
+			return fmt.Errorf("unable to remove IP address (%s) from gretun interface (%s): %v", existing[i].IPNet, iface.link.Name, err)
+		}
+	}
+	if configured {
+		klog.V(6).Infof("gretun interface (%s) has already IP (%s) peer (%s)", iface.link.Name, local, peer)
 		return nil
-	} else if err != nil {
-		return fmt.Errorf("unable to configure IP address (%s) on gretun interface (%s). %v", ipAddress, iface.link.Name, err)
+	}
+	if err := netlink.AddrAdd(link, addr); err != nil {
+		return fmt.Errorf("unable to configure IP address (%s) peer (%s) on gretun interface (%s): %v", local, peer, iface.link.Name, err)
 	}
 	return nil
 }
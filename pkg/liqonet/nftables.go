@@ -0,0 +1,595 @@
+package liqonet
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"github.com/coreos/go-iptables/iptables"
+	"k8s.io/klog"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+const (
+	IPTablesBackend = "iptables"
+	NFTablesBackend = "nftables"
+	AutoBackend     = "auto"
+
+	nftFamily         = "ip"
+	nftTablePrefix    = "liqo-"
+	nftCommentKeyword = "comment \""
+	nftHandleKeyword  = "# handle "
+)
+
+// the hooks of the base chains we support, keyed by the iptables table and chain name. They live in the tables of
+// liqo, hence their accept policy and the accept verdicts of their rules end the evaluation only within these tables:
+// a packet accepted by liqo is still dropped by a base chain of another table on the same hook with a drop policy,
+// e.g. the FORWARD chain of the "ip filter" table set up by docker. See DropPolicyChains.
+var nftBaseChains = map[string]map[string]string{
+	"nat": {
+		"PREROUTING":  "type nat hook prerouting priority -100; policy accept;",
+		"POSTROUTING": "type nat hook postrouting priority 100; policy accept;",
+		"OUTPUT":      "type nat hook output priority -100; policy accept;",
+	},
+	"filter": {
+		"INPUT":   "type filter hook input priority 0; policy accept;",
+		"FORWARD": "type filter hook forward priority 0; policy accept;",
+		"OUTPUT":  "type filter hook output priority 0; policy accept;",
+	},
+	"mangle": {
+		"PREROUTING":  "type filter hook prerouting priority -150; policy accept;",
+		"FORWARD":     "type filter hook forward priority -150; policy accept;",
+		"POSTROUTING": "type filter hook postrouting priority -150; policy accept;",
+	},
+}
+
+// RuleSetUpdater is implemented by the backends able to replace the rules of several chains in a single
+// atomic operation. The route operator uses it, when available, to update the chains of a peering cluster
+// instead of inserting and deleting the rules one by one.
+type RuleSetUpdater interface {
+	UpdateRuleSet(ruleSet map[IPTableChain][]string) error
+}
+
+// NftExecutor runs the nft commands on behalf of NFTables, it is an interface so that tests can replace the nft binary
+type NftExecutor interface {
+	//Apply feeds the script to "nft -f -": all the commands in the script are applied in a single transaction
+	Apply(script string) error
+	//ListTable returns the output of "nft -a list table", an empty string if the table does not exist
+	ListTable(family, table string) (string, error)
+	//ListRuleset returns the output of "nft list ruleset" for the given family
+	ListRuleset(family string) (string, error)
+}
+
+// NftError is returned by NFTables when the target of an operation does not exist
+type NftError struct {
+	msg      string
+	notExist bool
+}
+
+func (e *NftError) Error() string {
+	return e.msg
+}
+
+// IsNotExist returns true if the error is due to the chain or the rule not existing,
+// it mirrors the method exposed by the errors of go-iptables
+func (e *NftError) IsNotExist() bool {
+	return e.notExist
+}
+
+// NFTables implements the IPTables interface on top of nftables. The iptables tables used by liqo are mapped
+// on dedicated nftables tables (e.g. "nat" becomes "ip liqo-nat") and the rulespecs are translated in nftables
+// expressions. Each rule carries its original rulespec as comment, in order to be listed and deleted as
+// go-iptables does. Unlike with iptables, the rules cannot override the drop policies of the other tables.
+type NFTables struct {
+	Exec NftExecutor
+}
+
+// NewNFTables returns an NFTables using the nft binary found in PATH
+func NewNFTables() (*NFTables, error) {
+	path, err := exec.LookPath("nft")
+	if err != nil {
+		return nil, fmt.Errorf("unable to find the nft binary: %v", err)
+	}
+	return &NFTables{Exec: &nftBinary{path: path}}, nil
+}
+
+// NewIPTablesBackend returns the implementation of IPTables for the given backend. With AutoBackend the nftables
+// backend is chosen when iptables runs on top of nftables, unless a chain of the host drops the packets by default:
+// in that case iptables is used, since it inserts the rules in the chains of the host. The nftables backend is
+// chosen also when the iptables binaries are missing.
+func NewIPTablesBackend(backend string) (IPTables, error) {
+	switch backend {
+	case IPTablesBackend:
+		return newIPTables()
+	case NFTablesBackend:
+		nft, err := NewNFTables()
+		if err != nil {
+			return nil, err
+		}
+		if chains, err := nft.DropPolicyChains(); err == nil && len(chains) > 0 {
+			klog.Warningf("the chains %s drop the packets by default: the traffic of the remote clusters has to be accepted "+
+				"there too, the rules of liqo cannot override them with the %s backend", strings.Join(chains, ", "), NFTablesBackend)
+		}
+		return nft, nil
+	case AutoBackend:
+		nft, nftErr := NewNFTables()
+		ipt, err := newIPTables()
+		if err != nil {
+			if nftErr == nil {
+				return nft, nil
+			}
+			return nil, err
+		}
+		if nftErr != nil || !iptablesUsesNFTables() {
+			return ipt, nil
+		}
+		chains, err := nft.DropPolicyChains()
+		if err != nil {
+			klog.Warningf("unable to check the policies of the nftables chains, using the %s backend: %s", IPTablesBackend, err)
+			return ipt, nil
+		}
+		if len(chains) > 0 {
+			klog.Infof("the chains %s drop the packets by default, using the %s backend", strings.Join(chains, ", "), IPTablesBackend)
+			return ipt, nil
+		}
+		return nft, nil
+	default:
+		return nil, fmt.Errorf("unknown iptables backend %s, accepted values are: %s, %s, %s", backend, AutoBackend, IPTablesBackend, NFTablesBackend)
+	}
+}
+
+func newIPTables() (IPTables, error) {
+	ipt, err := iptables.New()
+	if err != nil {
+		return nil, fmt.Errorf("unable to initialize iptables, check if the binaries are present in the system: %v", err)
+	}
+	return ipt, nil
+}
+
+// iptablesUsesNFTables returns true if the iptables binary is iptables-nft, i.e. the host programs the packet filter
+// through nftables and the rules inserted through iptables-legacy would be evaluated separately
+func iptablesUsesNFTables() bool {
+	out, err := exec.Command("iptables", "-V").Output()
+	if err != nil {
+		return false
+	}
+	return isNFTablesVersion(string(out))
+}
+
+// isNFTablesVersion parses the output of "iptables -V", e.g. "iptables v1.8.4 (nf_tables)". The versions preceding
+// iptables-nft do not report the variant, they are legacy
+func isNFTablesVersion(version string) bool {
+	return strings.Contains(version, "(nf_tables)")
+}
+
+// DropPolicyChains returns the base chains of the tables not managed by liqo with a drop policy, as "table/chain".
+// The packets dropped there are not delivered even if the rules of liqo accept them.
+func (n *NFTables) DropPolicyChains() ([]string, error) {
+	out, err := n.Exec.ListRuleset(nftFamily)
+	if err != nil {
+		return nil, err
+	}
+	return parseDropPolicyChains(out), nil
+}
+
+// parseDropPolicyChains parses the output of "nft list ruleset", looking for the "policy drop" statements
+func parseDropPolicyChains(out string) []string {
+	var chains []string
+	var table, chain string
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		switch {
+		case len(fields) >= 3 && fields[0] == "table":
+			table, chain = fields[2], ""
+		case len(fields) >= 2 && fields[0] == "chain":
+			chain = fields[1]
+		case strings.Contains(strings.Join(fields, " "), "policy drop;") && chain != "" && !strings.HasPrefix(table, nftTablePrefix):
+			chains = append(chains, table+"/"+chain)
+		}
+	}
+	return chains
+}
+
+func (n *NFTables) Insert(table string, chain string, pos int, rulespec ...string) error {
+	expr, err := translateRuleSpec(rulespec)
+	if err != nil {
+		return err
+	}
+	position := ""
+	if pos > 1 {
+		rules, err := n.listRules(table, chain)
+		if err != nil {
+			return err
+		}
+		if len(rules) < pos-1 {
+			//as iptables -I does, a position past the end of the chain is refused
+			return fmt.Errorf("index of insertion %d too big for chain %s of table %s with %d rules", pos, chain, table, len(rules))
+		}
+		//nft adds the rule after the one identified by the handle
+		position = "position " + strconv.Itoa(rules[pos-2].handle) + " "
+	}
+	verb := "insert"
+	if position != "" {
+		verb = "add"
+	}
+	script := n.ensureChainCmd(table, chain) +
+		fmt.Sprintf("%s rule %s %s %s%s %s\n", verb, nftFamily, nftTableName(table), chain, position, withComment(expr, rulespec))
+	return n.Exec.Apply(script)
+}
+
+func (n *NFTables) Delete(table string, chain string, rulespec ...string) error {
+	rules, err := n.listRules(table, chain)
+	if err != nil {
+		return err
+	}
+	spec := strings.Join(rulespec, " ")
+	for _, rule := range rules {
+		if rule.spec == spec {
+			return n.Exec.Apply(fmt.Sprintf("delete rule %s %s %s handle %d\n", nftFamily, nftTableName(table), chain, rule.handle))
+		}
+	}
+	return &NftError{msg: fmt.Sprintf("rule '%s' does not exist in chain %s of table %s", spec, chain, table), notExist: true}
+}
+
+func (n *NFTables) Exists(table string, chain string, rulespec ...string) (bool, error) {
+	rules, err := n.listRules(table, chain)
+	if err != nil {
+		return false, err
+	}
+	spec := strings.Join(rulespec, " ")
+	for _, rule := range rules {
+		if rule.spec == spec {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (n *NFTables) ListChains(table string) ([]string, error) {
+	chains, err := n.listTable(table)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(chains))
+	for _, chain := range chains {
+		names = append(names, chain.name)
+	}
+	return names, nil
+}
+
+func (n *NFTables) NewChain(table string, chain string) error {
+	return n.Exec.Apply(n.ensureChainCmd(table, chain))
+}
+
+// List returns the rules of the chain in the same format of "iptables -S"
+func (n *NFTables) List(table, chain string) ([]string, error) {
+	rules, err := n.listRules(table, chain)
+	if err != nil {
+		return nil, err
+	}
+	var list []string
+	if _, ok := nftBaseChains[table][chain]; ok {
+		list = append(list, strings.Join([]string{"-P", chain, "ACCEPT"}, " "))
+	} else {
+		list = append(list, strings.Join([]string{"-N", chain}, " "))
+	}
+	for _, rule := range rules {
+		list = append(list, strings.Join([]string{"-A", chain, rule.spec}, " "))
+	}
+	return list, nil
+}
+
+func (n *NFTables) AppendUnique(table string, chain string, rulespec ...string) error {
+	exists, err := n.Exists(table, chain, rulespec...)
+	if err != nil || exists {
+		return err
+	}
+	expr, err := translateRuleSpec(rulespec)
+	if err != nil {
+		return err
+	}
+	return n.Exec.Apply(n.ensureChainCmd(table, chain) +
+		fmt.Sprintf("add rule %s %s %s %s\n", nftFamily, nftTableName(table), chain, withComment(expr, rulespec)))
+}
+
+// ClearChain flushes the chain, creating it if it does not exist as go-iptables does
+func (n *NFTables) ClearChain(table, chain string) error {
+	return n.Exec.Apply(n.ensureChainCmd(table, chain) +
+		fmt.Sprintf("flush chain %s %s %s\n", nftFamily, nftTableName(table), chain))
+}
+
+func (n *NFTables) DeleteChain(table, chain string) error {
+	chains, err := n.listTable(table)
+	if err != nil {
+		return err
+	}
+	for _, c := range chains {
+		if c.name == chain {
+			return n.Exec.Apply(fmt.Sprintf("delete chain %s %s %s\n", nftFamily, nftTableName(table), chain))
+		}
+	}
+	return &NftError{msg: fmt.Sprintf("chain %s does not exist in table %s", chain, table), notExist: true}
+}
+
+// UpdateRuleSet flushes the given chains and fills them with the new rules in a single nft transaction
+func (n *NFTables) UpdateRuleSet(ruleSet map[IPTableChain][]string) error {
+	var script strings.Builder
+	for chain, rules := range ruleSet {
+		script.WriteString(n.ensureChainCmd(chain.Table, chain.Name))
+		script.WriteString(fmt.Sprintf("flush chain %s %s %s\n", nftFamily, nftTableName(chain.Table), chain.Name))
+		for _, rule := range rules {
+			rulespec := strings.Split(rule, " ")
+			expr, err := translateRuleSpec(rulespec)
+			if err != nil {
+				return err
+			}
+			script.WriteString(fmt.Sprintf("add rule %s %s %s %s\n", nftFamily, nftTableName(chain.Table), chain.Name, withComment(expr, rulespec)))
+		}
+	}
+	return n.Exec.Apply(script.String())
+}
+
+// ensureChainCmd returns the commands which create the table and the chain, "add" is a no-op for existing objects
+func (n *NFTables) ensureChainCmd(table, chain string) string {
+	cmd := fmt.Sprintf("add table %s %s\n", nftFamily, nftTableName(table))
+	if hook, ok := nftBaseChains[table][chain]; ok {
+		return cmd + fmt.Sprintf("add chain %s %s %s { %s }\n", nftFamily, nftTableName(table), chain, hook)
+	}
+	return cmd + fmt.Sprintf("add chain %s %s %s\n", nftFamily, nftTableName(table), chain)
+}
+
+type nftRule struct {
+	spec   string
+	handle int
+}
+
+type nftChain struct {
+	name  string
+	rules []nftRule
+}
+
+func (n *NFTables) listTable(table string) ([]nftChain, error) {
+	out, err := n.Exec.ListTable(nftFamily, nftTableName(table))
+	if err != nil {
+		return nil, err
+	}
+	return parseNftTable(out), nil
+}
+
+func (n *NFTables) listRules(table, chain string) ([]nftRule, error) {
+	chains, err := n.listTable(table)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range chains {
+		if c.name == chain {
+			return c.rules, nil
+		}
+	}
+	//the base chains are created on demand, until then they are empty as the built-in chains of iptables
+	if _, ok := nftBaseChains[table][chain]; ok {
+		return nil, nil
+	}
+	return nil, &NftError{msg: fmt.Sprintf("chain %s does not exist in table %s", chain, table), notExist: true}
+}
+
+// parseNftTable parses the output of "nft -a list table", only the rules carrying a comment are returned
+func parseNftTable(out string) []nftChain {
+	var chains []nftChain
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "chain ") {
+			chains = append(chains, nftChain{name: strings.Fields(line)[1]})
+			continue
+		}
+		if len(chains) == 0 {
+			continue
+		}
+		start := strings.Index(line, nftCommentKeyword)
+		handleIndex := strings.LastIndex(line, nftHandleKeyword)
+		if start == -1 || handleIndex == -1 {
+			continue
+		}
+		comment := line[start+len(nftCommentKeyword):]
+		end := strings.Index(comment, "\"")
+		if end == -1 {
+			continue
+		}
+		handle, err := strconv.Atoi(strings.TrimSpace(line[handleIndex+len(nftHandleKeyword):]))
+		if err != nil {
+			continue
+		}
+		current := &chains[len(chains)-1]
+		current.rules = append(current.rules, nftRule{spec: comment[:end], handle: handle})
+	}
+	return chains
+}
+
+func nftTableName(table string) string {
+	return nftTablePrefix + table
+}
+
+func withComment(expr string, rulespec []string) string {
+	return fmt.Sprintf("%s comment \"%s\"", expr, strings.Join(rulespec, " "))
+}
+
+// translateRuleSpec converts the iptables rulespecs used by liqo in nftables expressions. The NETMAP target is
+// translated as a prefix source NAT when the rule matches on the source address, as a prefix destination NAT otherwise
+func translateRuleSpec(rulespec []string) (string, error) {
+	var matches, verdict []string
+	var protocol, source, destination string
+	negate := false
+	op := func() string {
+		if negate {
+			negate = false
+			return "!= "
+		}
+		return ""
+	}
+	next := func(i int) (string, error) {
+		if i+1 >= len(rulespec) {
+			return "", fmt.Errorf("missing value for option %s in rulespec '%s'", rulespec[i], strings.Join(rulespec, " "))
+		}
+		return rulespec[i+1], nil
+	}
+	for i := 0; i < len(rulespec); i++ {
+		token := rulespec[i]
+		if token == "" {
+			continue
+		}
+		if token == "!" {
+			negate = true
+			continue
+		}
+		value, err := next(i)
+		if err != nil {
+			return "", err
+		}
+		i++
+		switch token {
+		case "-s":
+			if !negate {
+				source = value
+			}
+			matches = append(matches, "ip saddr "+op()+value)
+		case "-d":
+			if !negate {
+				destination = value
+			}
+			matches = append(matches, "ip daddr "+op()+value)
+		case "-i":
+			matches = append(matches, "iifname "+op()+strconv.Quote(value))
+		case "-o":
+			matches = append(matches, "oifname "+op()+strconv.Quote(value))
+		case "-p":
+			protocol = value
+			matches = append(matches, "meta l4proto "+op()+value)
+		case "-m":
+			//the match modules are implied by the nftables expressions
+		case "--dport", "--sport":
+			if protocol == "" {
+				return "", fmt.Errorf("option %s requires a protocol in rulespec '%s'", token, strings.Join(rulespec, " "))
+			}
+			matches = append(matches, protocol+" "+strings.TrimPrefix(token, "--")+" "+op()+value)
+		case "--ctstate":
+			matches = append(matches, "ct state "+op()+strings.ToLower(value))
+		case "--tcp-flags":
+			if protocol != "tcp" || i+1 >= len(rulespec) {
+				return "", fmt.Errorf("option %s requires the tcp protocol, a mask and the flags to be set in rulespec '%s'", token, strings.Join(rulespec, " "))
+			}
+			i++
+			matches = append(matches, fmt.Sprintf("tcp flags & (%s) %s%s", translateTCPFlags(value), eqOrOp(op()), translateTCPFlags(rulespec[i])))
+		case "-j":
+			switch value {
+			case "ACCEPT", "DROP", "RETURN":
+				verdict = append(verdict, strings.ToLower(value))
+			case "SNAT", "DNAT":
+				if i+2 >= len(rulespec) {
+					return "", fmt.Errorf("missing address for target %s in rulespec '%s'", value, strings.Join(rulespec, " "))
+				}
+				verdict = append(verdict, strings.ToLower(value)+" to "+rulespec[i+2])
+				i += 2
+			case "TCPMSS":
+				if i+1 >= len(rulespec) {
+					return "", fmt.Errorf("missing option for target TCPMSS in rulespec '%s'", strings.Join(rulespec, " "))
+				}
+				switch rulespec[i+1] {
+				case "--clamp-mss-to-pmtu":
+					verdict = append(verdict, "tcp option maxseg size set rt mtu")
+					i++
+				case "--set-mss":
+					if i+2 >= len(rulespec) {
+						return "", fmt.Errorf("missing value for option --set-mss in rulespec '%s'", strings.Join(rulespec, " "))
+					}
+					verdict = append(verdict, "tcp option maxseg size set "+rulespec[i+2])
+					i += 2
+				default:
+					return "", fmt.Errorf("option %s of target TCPMSS is not supported by the nftables backend", rulespec[i+1])
+				}
+			case "NETMAP":
+				if i+2 >= len(rulespec) || rulespec[i+1] != "--to" {
+					return "", fmt.Errorf("missing --to option for target NETMAP in rulespec '%s'", strings.Join(rulespec, " "))
+				}
+				to := rulespec[i+2]
+				i += 2
+				if source != "" {
+					verdict = append(verdict, fmt.Sprintf("snat ip prefix to ip saddr map { %s : %s }", source, to))
+				} else if destination != "" {
+					verdict = append(verdict, fmt.Sprintf("dnat ip prefix to ip daddr map { %s : %s }", destination, to))
+				} else {
+					return "", fmt.Errorf("target NETMAP requires a source or a destination in rulespec '%s'", strings.Join(rulespec, " "))
+				}
+			default:
+				//any other target is a user defined chain
+				verdict = append(verdict, "jump "+value)
+			}
+		default:
+			return "", fmt.Errorf("option %s is not supported by the nftables backend", token)
+		}
+	}
+	return strings.Join(append(matches, verdict...), " "), nil
+}
+
+// translateTCPFlags converts a comma separated list of iptables tcp flags in a nftables expression
+func translateTCPFlags(flags string) string {
+	switch flags {
+	case "NONE":
+		return "0x0"
+	case "ALL":
+		return "fin|syn|rst|psh|ack|urg"
+	}
+	return strings.ToLower(strings.ReplaceAll(flags, ",", "|"))
+}
+
+// eqOrOp returns the operator of a comparison, since a bitwise expression requires an explicit equality operator
+func eqOrOp(op string) string {
+	if op == "" {
+		return "== "
+	}
+	return op
+}
+
+// nftBinary is the NftExecutor which runs the nft binary
+type nftBinary struct {
+	path string
+}
+
+func (b *nftBinary) Apply(script string) error {
+	cmd := exec.Command(b.path, "-f", "-")
+	cmd.Stdin = strings.NewReader(script)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("unable to apply nft script '%s': %v: %s", script, err, stderr.String())
+	}
+	return nil
+}
+
+func (b *nftBinary) ListRuleset(family string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(b.path, "list", "ruleset", family)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("unable to list the nft ruleset of family %s: %v: %s", family, err, stderr.String())
+	}
+	return stdout.String(), nil
+}
+
+func (b *nftBinary) ListTable(family, table string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(b.path, "-a", "list", "table", family, table)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		//nft fails with "No such file or directory" when the table has not been created yet
+		if strings.Contains(stderr.String(), "No such file or directory") {
+			return "", nil
+		}
+		return "", fmt.Errorf("unable to list nft table %s %s: %v: %s", family, table, err, stderr.String())
+	}
+	return stdout.String(), nil
+}
@@ -0,0 +1,200 @@
+package liqonet
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type mockNftRule struct {
+	handle int
+	expr   string
+}
+
+type mockNftChain struct {
+	name string
+	//the type, hook, priority and policy of the base chains
+	hook  string
+	rules []mockNftRule
+}
+
+type mockNftTable struct {
+	chains []*mockNftChain
+}
+
+// MockNftExecutor emulates the nft binary: it interprets the scripts generated by NFTables and keeps the ruleset in memory.
+// As nft does, a script is applied atomically: if one of its commands fails the ruleset is left untouched.
+type MockNftExecutor struct {
+	//Scripts contains the scripts applied so far, one per transaction
+	Scripts    []string
+	tables     map[string]*mockNftTable
+	lastHandle int
+}
+
+func (m *MockNftExecutor) Apply(script string) error {
+	tables := m.copyTables()
+	lastHandle := m.lastHandle
+	for _, line := range strings.Split(strings.TrimSpace(script), "\n") {
+		if err := m.applyCommand(tables, strings.TrimSpace(line)); err != nil {
+			m.lastHandle = lastHandle
+			return err
+		}
+	}
+	m.tables = tables
+	m.Scripts = append(m.Scripts, script)
+	return nil
+}
+
+func (m *MockNftExecutor) ListTable(family, table string) (string, error) {
+	t, ok := m.tables[family+" "+table]
+	if !ok {
+		return "", nil
+	}
+	return t.list(family, table, true), nil
+}
+
+func (m *MockNftExecutor) ListRuleset(family string) (string, error) {
+	names := make([]string, 0, len(m.tables))
+	for name := range m.tables {
+		if strings.HasPrefix(name, family+" ") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for _, name := range names {
+		b.WriteString(m.tables[name].list(family, strings.TrimPrefix(name, family+" "), false))
+	}
+	return b.String(), nil
+}
+
+func (t *mockNftTable) list(family, table string, handles bool) string {
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("table %s %s {\n", family, table))
+	for _, chain := range t.chains {
+		b.WriteString(fmt.Sprintf("\tchain %s {\n", chain.name))
+		if chain.hook != "" {
+			b.WriteString(fmt.Sprintf("\t\t%s\n", chain.hook))
+		}
+		for _, rule := range chain.rules {
+			if handles {
+				b.WriteString(fmt.Sprintf("\t\t%s # handle %d\n", rule.expr, rule.handle))
+			} else {
+				b.WriteString(fmt.Sprintf("\t\t%s\n", rule.expr))
+			}
+		}
+		b.WriteString("\t}\n")
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+func (m *MockNftExecutor) copyTables() map[string]*mockNftTable {
+	tables := make(map[string]*mockNftTable, len(m.tables))
+	for name, table := range m.tables {
+		t := &mockNftTable{}
+		for _, chain := range table.chains {
+			c := *chain
+			c.rules = append([]mockNftRule(nil), chain.rules...)
+			t.chains = append(t.chains, &c)
+		}
+		tables[name] = t
+	}
+	return tables
+}
+
+func (m *MockNftExecutor) applyCommand(tables map[string]*mockNftTable, line string) error {
+	fields := strings.Fields(line)
+	if len(fields) < 4 {
+		return fmt.Errorf("invalid nft command '%s'", line)
+	}
+	cmd, object, tableName := fields[0]+" "+fields[1], fields[1], fields[2]+" "+fields[3]
+	if object == "table" {
+		if cmd != "add table" {
+			return fmt.Errorf("unsupported nft command '%s'", line)
+		}
+		if _, ok := tables[tableName]; !ok {
+			tables[tableName] = &mockNftTable{}
+		}
+		return nil
+	}
+	table, ok := tables[tableName]
+	if !ok {
+		return fmt.Errorf("table %s does not exist", tableName)
+	}
+	if len(fields) < 5 {
+		return fmt.Errorf("invalid nft command '%s'", line)
+	}
+	chainName := fields[4]
+	index := -1
+	for i, c := range table.chains {
+		if c.name == chainName {
+			index = i
+		}
+	}
+	if cmd == "add chain" {
+		if index == -1 {
+			chain := &mockNftChain{name: chainName}
+			if start, end := strings.Index(line, "{"), strings.LastIndex(line, "}"); start != -1 && end > start {
+				chain.hook = strings.TrimSpace(line[start+1 : end])
+			}
+			table.chains = append(table.chains, chain)
+		}
+		return nil
+	}
+	if index == -1 {
+		return fmt.Errorf("chain %s does not exist in table %s", chainName, tableName)
+	}
+	chain := table.chains[index]
+	switch cmd {
+	case "flush chain":
+		chain.rules = nil
+	case "delete chain":
+		if len(chain.rules) != 0 {
+			return fmt.Errorf("chain %s is not empty", chainName)
+		}
+		table.chains = append(table.chains[:index], table.chains[index+1:]...)
+	case "add rule", "insert rule":
+		expr := strings.Join(fields[5:], " ")
+		m.lastHandle++
+		rule := mockNftRule{handle: m.lastHandle, expr: expr}
+		if len(fields) > 6 && fields[5] == "position" {
+			handle, err := strconv.Atoi(fields[6])
+			if err != nil {
+				return err
+			}
+			rule.expr = strings.Join(fields[7:], " ")
+			for i, r := range chain.rules {
+				if r.handle == handle {
+					chain.rules = append(chain.rules[:i+1], append([]mockNftRule{rule}, chain.rules[i+1:]...)...)
+					return nil
+				}
+			}
+			return fmt.Errorf("rule with handle %d does not exist in chain %s", handle, chainName)
+		}
+		if cmd == "insert rule" {
+			chain.rules = append([]mockNftRule{rule}, chain.rules...)
+		} else {
+			chain.rules = append(chain.rules, rule)
+		}
+	case "delete rule":
+		if len(fields) != 7 || fields[5] != "handle" {
+			return fmt.Errorf("invalid nft command '%s'", line)
+		}
+		handle, err := strconv.Atoi(fields[6])
+		if err != nil {
+			return err
+		}
+		for i, r := range chain.rules {
+			if r.handle == handle {
+				chain.rules = append(chain.rules[:i], chain.rules[i+1:]...)
+				return nil
+			}
+		}
+		return fmt.Errorf("rule with handle %d does not exist in chain %s", handle, chainName)
+	default:
+		return fmt.Errorf("unsupported nft command '%s'", line)
+	}
+	return nil
+}
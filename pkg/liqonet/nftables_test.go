@@ -0,0 +1,173 @@
+package liqonet
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestTranslateRuleSpec(t *testing.T) {
+	tests := []struct {
+		rulespec string
+		expected string
+	}{
+		{
+			"-s 10.200.0.0/16 -d 10.2.0.0/16 -j ACCEPT",
+			"ip saddr 10.200.0.0/16 ip daddr 10.2.0.0/16 accept",
+		},
+		{
+			"! -s 10.200.0.0/16 -d 10.2.0.0/16 -j SNAT --to-source 10.200.0.0",
+			"ip saddr != 10.200.0.0/16 ip daddr 10.2.0.0/16 snat to 10.200.0.0",
+		},
+		{
+			"-s 10.200.0.0/16 -d 10.2.0.0/16 -j NETMAP --to 10.1.0.0/16",
+			"ip saddr 10.200.0.0/16 ip daddr 10.2.0.0/16 snat ip prefix to ip saddr map { 10.200.0.0/16 : 10.1.0.0/16 }",
+		},
+		{
+			"-d 10.1.0.0/16 -i gre-1 -j NETMAP --to 10.200.0.0/16",
+			"ip daddr 10.1.0.0/16 iifname \"gre-1\" dnat ip prefix to ip daddr map { 10.1.0.0/16 : 10.200.0.0/16 }",
+		},
+		{
+			"-p udp -m udp --dport 4789 -j ACCEPT",
+			"meta l4proto udp udp dport 4789 accept",
+		},
+		{
+			"-d 10.2.0.0/16 -p tcp -m tcp --tcp-flags SYN,RST SYN -j TCPMSS --clamp-mss-to-pmtu",
+			"ip daddr 10.2.0.0/16 meta l4proto tcp tcp flags & (syn|rst) == syn tcp option maxseg size set rt mtu",
+		},
+		{
+			"-p tcp -m tcp --tcp-flags SYN,RST SYN -j TCPMSS --set-mss 1360",
+			"meta l4proto tcp tcp flags & (syn|rst) == syn tcp option maxseg size set 1360",
+		},
+		{
+			"-s 10.200.0.0/16 -m conntrack --ctstate RELATED,ESTABLISHED -j ACCEPT",
+			"ip saddr 10.200.0.0/16 ct state related,established accept",
+		},
+		{
+			"-s 10.200.0.0/16 -d 10.1.0.5/32 -p sctp -m sctp --dport 9000 -j ACCEPT",
+			"ip saddr 10.200.0.0/16 ip daddr 10.1.0.5/32 meta l4proto sctp sctp dport 9000 accept",
+		},
+		{
+			"-d 10.2.0.0/16 -j LIQO-PSTRT-CLS-9ed4d9bd",
+			"ip daddr 10.2.0.0/16 jump LIQO-PSTRT-CLS-9ed4d9bd",
+		},
+	}
+	for _, test := range tests {
+		expr, err := translateRuleSpec(strings.Split(test.rulespec, " "))
+		assert.Nil(t, err)
+		assert.Equal(t, test.expected, expr)
+	}
+	_, err := translateRuleSpec([]string{"--dport", "4789", "-j", "ACCEPT"})
+	assert.NotNil(t, err, "a port without a protocol should not be accepted")
+	_, err = translateRuleSpec([]string{"-m", "statistic", "--probability", "0.5", "-j", "ACCEPT"})
+	assert.NotNil(t, err, "unsupported options should not be accepted")
+}
+
+func TestNFTables_Rules(t *testing.T) {
+	nft := &NFTables{Exec: &MockNftExecutor{}}
+	rule1 := []string{"-d", "10.2.0.0/16", "-j", "ACCEPT"}
+	rule2 := []string{"-s", "10.200.0.0/16", "-j", "ACCEPT"}
+	assert.Nil(t, nft.NewChain("filter", "LIQO-FORWARD"))
+	chains, err := nft.ListChains("filter")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"LIQO-FORWARD"}, chains)
+	//AppendUnique has to be idempotent
+	for i := 0; i < 2; i++ {
+		assert.Nil(t, nft.AppendUnique("filter", "LIQO-FORWARD", rule1...))
+	}
+	assert.Nil(t, nft.Insert("filter", "LIQO-FORWARD", 1, rule2...))
+	//as with iptables, a position past the end of the chain is refused
+	assert.NotNil(t, nft.Insert("filter", "LIQO-FORWARD", 4, rule2...))
+	rules, err := nft.List("filter", "LIQO-FORWARD")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"-N LIQO-FORWARD", "-A LIQO-FORWARD " + strings.Join(rule2, " "), "-A LIQO-FORWARD " + strings.Join(rule1, " ")}, rules)
+	exists, err := nft.Exists("filter", "LIQO-FORWARD", rule1...)
+	assert.Nil(t, err)
+	assert.True(t, exists)
+	assert.Nil(t, nft.Delete("filter", "LIQO-FORWARD", rule1...))
+	exists, err = nft.Exists("filter", "LIQO-FORWARD", rule1...)
+	assert.Nil(t, err)
+	assert.False(t, exists)
+	//deleting a missing rule returns an error recognized as not existing
+	err = nft.Delete("filter", "LIQO-FORWARD", rule1...)
+	e, ok := err.(*NftError)
+	assert.True(t, ok)
+	assert.True(t, e.IsNotExist())
+	//the base chains are listed as empty until something is inserted
+	rules, err = nft.List("filter", "FORWARD")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"-P FORWARD ACCEPT"}, rules)
+	assert.Nil(t, nft.ClearChain("filter", "LIQO-FORWARD"))
+	assert.Nil(t, nft.DeleteChain("filter", "LIQO-FORWARD"))
+	chains, err = nft.ListChains("filter")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(chains))
+}
+
+func TestNFTables_UpdateRuleSet(t *testing.T) {
+	exec := &MockNftExecutor{}
+	nft := &NFTables{Exec: exec}
+	postrouting := IPTableChain{Table: "nat", Name: "LIQO-PSTRT-CLS-test"}
+	forward := IPTableChain{Table: "filter", Name: "LIQO-FRWD-CLS-test"}
+	assert.Nil(t, nft.NewChain(postrouting.Table, postrouting.Name))
+	assert.Nil(t, nft.AppendUnique(postrouting.Table, postrouting.Name, "-s", "10.200.0.0/16", "-d", "10.3.0.0/16", "-j", "ACCEPT"))
+	ruleSet := map[IPTableChain][]string{
+		postrouting: {"-s 10.200.0.0/16 -d 10.2.0.0/16 -j ACCEPT"},
+		forward:     {"-d 10.2.0.0/16 -j ACCEPT"},
+	}
+	scripts := len(exec.Scripts)
+	assert.Nil(t, nft.UpdateRuleSet(ruleSet))
+	assert.Equal(t, scripts+1, len(exec.Scripts), "the rule set should be applied in a single transaction")
+	for chain, expected := range ruleSet {
+		rules, err := nft.List(chain.Table, chain.Name)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"-N " + chain.Name, "-A " + chain.Name + " " + expected[0]}, rules)
+	}
+	//an invalid rule leaves the existing rules untouched
+	ruleSet[forward] = []string{"-m statistic --probability 0.5 -j ACCEPT"}
+	assert.NotNil(t, nft.UpdateRuleSet(ruleSet))
+	rules, err := nft.List(forward.Table, forward.Name)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"-N " + forward.Name, "-A " + forward.Name + " -d 10.2.0.0/16 -j ACCEPT"}, rules)
+}
+
+func TestNFTables_DropPolicyChains(t *testing.T) {
+	exec := &MockNftExecutor{}
+	nft := &NFTables{Exec: exec}
+	//the base chains of liqo accept the packets by default
+	assert.Nil(t, nft.AppendUnique("filter", "FORWARD", "-d", "10.2.0.0/16", "-j", "ACCEPT"))
+	chains, err := nft.DropPolicyChains()
+	assert.Nil(t, err)
+	assert.Empty(t, chains)
+	//the accept verdicts of liqo do not prevent the host chains from dropping the packets
+	assert.Nil(t, exec.Apply("add table ip filter\n"+
+		"add chain ip filter INPUT { type filter hook input priority 0; policy accept; }\n"+
+		"add chain ip filter FORWARD { type filter hook forward priority 0; policy drop; }\n"+
+		"add chain ip filter DOCKER-USER\n"))
+	chains, err = nft.DropPolicyChains()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"filter/FORWARD"}, chains)
+
+	ruleset := `table ip filter {
+	chain INPUT {
+		type filter hook input priority filter; policy drop;
+		ct state established,related accept
+	}
+	chain KUBE-FIREWALL {
+		meta mark & 0x00008000 == 0x00008000 counter packets 0 bytes 0 drop
+	}
+}
+table ip liqo-filter {
+	chain FORWARD {
+		type filter hook forward priority filter; policy accept;
+	}
+}
+`
+	assert.Equal(t, []string{"filter/INPUT"}, parseDropPolicyChains(ruleset))
+}
+
+func TestIsNFTablesVersion(t *testing.T) {
+	assert.True(t, isNFTablesVersion("iptables v1.8.4 (nf_tables)\n"))
+	assert.False(t, isNFTablesVersion("iptables v1.8.4 (legacy)\n"))
+	assert.False(t, isNFTablesVersion("iptables v1.6.1\n"))
+}
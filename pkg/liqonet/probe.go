@@ -0,0 +1,132 @@
+package liqonet
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+const (
+	//UDP port where the gateways answer to the probes sent through the tunnels
+	ProbePort = 5872
+	//each probe carries the magic number, the sequence number and the time it has been sent
+	probeMagic = 0x6c69716f
+	probeSize  = 16
+)
+
+//ProbeResult collects the outcome of a round of probes sent through a tunnel
+type ProbeResult struct {
+	Sent     int
+	Received int
+	//average round trip time of the answered probes
+	RTT time.Duration
+}
+
+//Loss returns the percentage of the probes which have not been answered
+func (r ProbeResult) Loss() int {
+	if r.Sent == 0 {
+		return 0
+	}
+	return (r.Sent - r.Received) * 100 / r.Sent
+}
+
+//bindToDevice returns a ListenConfig whose sockets send and receive only through the given interface:
+//this way the probes and their answers are forced to cross the tunnel and not the default route.
+//An empty name leaves the socket unbound.
+func bindToDevice(ifaceName string) *net.ListenConfig {
+	return &net.ListenConfig{
+		Control: func(network, address string, c syscall.RawConn) error {
+			if ifaceName == "" {
+				return nil
+			}
+			var bindErr error
+			if err := c.Control(func(fd uintptr) {
+				bindErr = syscall.BindToDevice(int(fd), ifaceName)
+			}); err != nil {
+				return err
+			}
+			return bindErr
+		},
+	}
+}
+
+//ListenProbes opens the socket used to answer the probes coming from the remote gateway through the given tunnel
+func ListenProbes(ifaceName string, port int) (net.PacketConn, error) {
+	conn, err := bindToDevice(ifaceName).ListenPacket(context.Background(), "udp4", net.JoinHostPort("", strconv.Itoa(port)))
+	if err != nil {
+		return nil, fmt.Errorf("unable to listen for probes on interface %s: %v", ifaceName, err)
+	}
+	return conn, nil
+}
+
+//ServeProbes echoes back the probes received on the connection, until it is closed
+func ServeProbes(conn net.PacketConn) {
+	buf := make([]byte, probeSize)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		if n != probeSize || binary.BigEndian.Uint32(buf[0:4]) != probeMagic {
+			continue
+		}
+		_, _ = conn.WriteTo(buf[:n], addr)
+	}
+}
+
+//ProbeTunnel sends count probes to the remote gateway through the given tunnel, waiting up to timeout for each answer
+func ProbeTunnel(ifaceName string, remote net.IP, port, count int, timeout time.Duration) (ProbeResult, error) {
+	result := ProbeResult{}
+	conn, err := bindToDevice(ifaceName).ListenPacket(context.Background(), "udp4", ":0")
+	if err != nil {
+		return result, fmt.Errorf("unable to open the probe socket on interface %s: %v", ifaceName, err)
+	}
+	defer conn.Close()
+	dst := &net.UDPAddr{IP: remote, Port: port}
+	req := make([]byte, probeSize)
+	resp := make([]byte, probeSize)
+	var total time.Duration
+	for seq := uint32(0); seq < uint32(count); seq++ {
+		sent := time.Now()
+		binary.BigEndian.PutUint32(req[0:4], probeMagic)
+		binary.BigEndian.PutUint32(req[4:8], seq)
+		binary.BigEndian.PutUint64(req[8:16], uint64(sent.UnixNano()))
+		if _, err := conn.WriteTo(req, dst); err != nil {
+			return result, fmt.Errorf("unable to send probe to %s through interface %s: %v", dst, ifaceName, err)
+		}
+		result.Sent++
+		if err := conn.SetReadDeadline(sent.Add(timeout)); err != nil {
+			return result, err
+		}
+		//wait for the answer to the current probe, discarding the late answers to the previous ones
+		for {
+			n, _, err := conn.ReadFrom(resp)
+			if err != nil {
+				break
+			}
+			if n == probeSize && binary.BigEndian.Uint32(resp[0:4]) == probeMagic && binary.BigEndian.Uint32(resp[4:8]) == seq {
+				result.Received++
+				total += time.Since(sent)
+				break
+			}
+		}
+	}
+	if result.Received > 0 {
+		result.RTT = total / time.Duration(result.Received)
+	}
+	return result, nil
+}
+
+//the gre interfaces have no addresses: the probes answered through them come from the public IP of the
+//remote gateway, which is reachable through the default route, hence the strict reverse path filter would drop them
+func setLooseRPFilter(ifaceName string) error {
+	if err := ioutil.WriteFile("/proc/sys/net/ipv4/conf/"+ifaceName+"/rp_filter", []byte("2"), 0600); err != nil {
+		return fmt.Errorf("unable to update rp_filter proc entry for interface %s, err: %s", ifaceName, err)
+	}
+	return nil
+}
@@ -0,0 +1,30 @@
+package liqonet
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestProbeTunnel(t *testing.T) {
+	conn, err := ListenProbes("", 0)
+	assert.Nil(t, err)
+	go ServeProbes(conn)
+	port := conn.LocalAddr().(*net.UDPAddr).Port
+
+	result, err := ProbeTunnel("", net.ParseIP("127.0.0.1"), port, 3, time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, result.Sent)
+	assert.Equal(t, 3, result.Received)
+	assert.Equal(t, 0, result.Loss())
+	assert.NotZero(t, result.RTT)
+
+	//once the responder is gone all the probes are lost
+	assert.Nil(t, conn.Close())
+	result, err = ProbeTunnel("", net.ParseIP("127.0.0.1"), port, 2, 100*time.Millisecond)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, result.Sent)
+	assert.Equal(t, 0, result.Received)
+	assert.Equal(t, 100, result.Loss())
+}
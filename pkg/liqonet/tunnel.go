@@ -1,7 +1,9 @@
 package liqonet
 
 import (
+	"bytes"
 	"errors"
+	"fmt"
 	netv1alpha1 "github.com/liqotech/liqo/apis/net/v1alpha1"
 	"github.com/liqotech/liqo/internal/utils/errdefs"
 	"github.com/prometheus/common/log"
@@ -14,8 +16,13 @@ import (
 const (
 	tunnelNamePrefix = "liqo-"
 	tunnelTtl        = 255
+	//outer IPv4 header plus the GRE header
+	greOverhead int = 24
 )
 
+//link-local addresses of the two ends of the tunnels, every tunnel is a point to point link with its own interface
+var tunnelAddresses = [2]net.IP{net.IPv4(169, 254, 100, 1), net.IPv4(169, 254, 100, 2)}
+
 //Get the LocalTunnelPublicIP which is exported to the pod through an environment
 //variable called LocalTunnelPublicIP. The pod is run with hostNetwork=true so it gets the same IP
 //of the host where it is scheduled. The IP is the same used by the kubelet to register
@@ -31,6 +38,34 @@ func GetLocalTunnelPublicIP() (net.IP, error) {
 	return net.ParseIP(ipAddress), nil
 }
 
+//GetTunnelAddresses returns the addresses of the local and the remote end of the tunnel, used to probe it. The end
+//with the lower gateway IP takes the first address: the gateway IPs are exchanged by the peering clusters, hence both
+//of them assign the addresses in the same way.
+func GetTunnelAddresses(endpoint *netv1alpha1.TunnelEndpoint) (net.IP, net.IP, error) {
+	local := net.ParseIP(endpoint.Status.LocalTunnelPublicIP)
+	remote := net.ParseIP(endpoint.Status.RemoteTunnelPublicIP)
+	if local == nil || remote == nil {
+		return nil, nil, fmt.Errorf("the gateway IPs of the tunnel towards cluster %s are not set", endpoint.Spec.ClusterID)
+	}
+	switch bytes.Compare(local.To16(), remote.To16()) {
+	case -1:
+		return tunnelAddresses[0], tunnelAddresses[1], nil
+	case 1:
+		return tunnelAddresses[1], tunnelAddresses[0], nil
+	default:
+		return nil, nil, fmt.Errorf("the local and the remote gateway of the tunnel towards cluster %s have the same IP %s", endpoint.Spec.ClusterID, local)
+	}
+}
+
+//GetTunnelMTU returns the MTU of the tunnels computed from the interface of the default route
+func GetTunnelMTU() (int, error) {
+	mtu, err := getDefaultIfaceMTU()
+	if err != nil {
+		return 0, err
+	}
+	return mtu - greOverhead, nil
+}
+
 func InstallGreTunnel(endpoint *netv1alpha1.TunnelEndpoint) (int, string, error) {
 	tokens := strings.Split(endpoint.Name, "-")
 	name := strings.Join([]string{tunnelNamePrefix, tokens[2]}, "")
@@ -41,11 +76,19 @@ func InstallGreTunnel(endpoint *netv1alpha1.TunnelEndpoint) (int, string, error)
 	}
 	remote := net.ParseIP(endpoint.Spec.TunnelPublicIP)
 	ttl := tunnelTtl
+	//the MTU agreed with the remote cluster takes precedence over the one of the local interface
+	mtu := endpoint.Spec.MTU
+	if mtu == 0 {
+		if mtu, err = GetTunnelMTU(); err != nil {
+			return 0, "", err
+		}
+	}
 	attr := gretunAttributes{
 		name:   name,
 		local:  local,
 		remote: remote,
 		ttl:    uint8(ttl),
+		mtu:    mtu,
 	}
 	gretunnel, err := newGretunInterface(&attr)
 	if err != nil {
@@ -57,6 +100,16 @@ func InstallGreTunnel(endpoint *netv1alpha1.TunnelEndpoint) (int, string, error)
 	if err = gretunnel.setUp(); err != nil {
 		return 0, "", err
 	}
+	localAddress, remoteAddress, err := GetTunnelAddresses(endpoint)
+	if err != nil {
+		return 0, "", err
+	}
+	if err = gretunnel.configurePeerAddress(localAddress, remoteAddress); err != nil {
+		return 0, "", err
+	}
+	if err = setLooseRPFilter(gretunnel.link.Name); err != nil {
+		return 0, "", err
+	}
 	return gretunnel.link.Index, gretunnel.link.Name, nil
 }
 
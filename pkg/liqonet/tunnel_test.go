@@ -0,0 +1,30 @@
+package liqonet
+
+import (
+	netv1alpha1 "github.com/liqotech/liqo/apis/net/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestGetTunnelAddresses(t *testing.T) {
+	endpoint := func(localGateway, remoteGateway string) *netv1alpha1.TunnelEndpoint {
+		return &netv1alpha1.TunnelEndpoint{
+			Spec:   netv1alpha1.TunnelEndpointSpec{ClusterID: "cluster-test"},
+			Status: netv1alpha1.TunnelEndpointStatus{LocalTunnelPublicIP: localGateway, RemoteTunnelPublicIP: remoteGateway},
+		}
+	}
+	//the two ends of the tunnel take opposite addresses
+	local1, remote1, err := GetTunnelAddresses(endpoint("192.168.5.1", "192.168.10.1"))
+	assert.Nil(t, err)
+	local2, remote2, err := GetTunnelAddresses(endpoint("192.168.10.1", "192.168.5.1"))
+	assert.Nil(t, err)
+	assert.Equal(t, "169.254.100.1", local1.String())
+	assert.Equal(t, "169.254.100.2", remote1.String())
+	assert.Equal(t, local1, remote2)
+	assert.Equal(t, remote1, local2)
+
+	_, _, err = GetTunnelAddresses(endpoint("192.168.5.1", "192.168.5.1"))
+	assert.NotNil(t, err)
+	_, _, err = GetTunnelAddresses(endpoint("192.168.5.1", ""))
+	assert.NotNil(t, err)
+}
@@ -13,7 +13,6 @@ import (
 	"k8s.io/klog"
 	"net"
 	"os"
-	"strings"
 )
 
 const (
@@ -48,7 +47,7 @@ func GetClusterPodCIDR() (string, error) {
 	return podCIDR, nil
 }
 
-func getInternalIPOfNode(node corev1.Node) (string, error) {
+func GetInternalIPOfNode(node corev1.Node) (string, error) {
 	var internalIp string
 	for _, address := range node.Status.Addresses {
 		if address.Type == "InternalIP" {
@@ -63,24 +62,24 @@ func getInternalIPOfNode(node corev1.Node) (string, error) {
 	return internalIp, nil
 }
 
-func IsGatewayNode(clientset *kubernetes.Clientset) (bool, error) {
+func IsGatewayNode(clientset kubernetes.Interface) (bool, error) {
 	isGatewayNode := false
-	//retrieve the node which is labeled as the gateway
-	nodesList, err := clientset.CoreV1().Nodes().List(context.TODO(), metav1.ListOptions{LabelSelector: "net.liqo.io/gateway == true"})
+	//retrieve the node which is labeled as the active gateway
+	nodes, err := ListGatewayNodes(clientset)
 	if err != nil {
-		logger.Error(err, "Unable to list nodes with labbel 'net.liqo.io/gateway=true'")
-		return isGatewayNode, fmt.Errorf(" Unable to list nodes with label 'net.liqo.io/gateway=true': %v", err)
+		logger.Error(err, "Unable to list nodes with label 'net.liqo.io/gateway=true'")
+		return isGatewayNode, err
 	}
-	if len(nodesList.Items) != 1 {
-		klog.V(4).Infof("number of gateway nodes found: %d", len(nodesList.Items))
-		return isGatewayNode, errdefs.NotFound("no gateway node has been found")
+	gateway, err := GetActiveGateway(nodes)
+	if err != nil {
+		return isGatewayNode, err
 	}
 	//check if my ip node is the same as the internal ip of the gateway node
 	podIP, err := getPodIP()
 	if err != nil {
 		return isGatewayNode, err
 	}
-	internalIP, err := getInternalIPOfNode(nodesList.Items[0])
+	internalIP, err := GetInternalIPOfNode(*gateway)
 	if err != nil {
 		return isGatewayNode, fmt.Errorf("unable to get internal ip of the gateway node: %v", err)
 	}
@@ -91,32 +90,27 @@ func IsGatewayNode(clientset *kubernetes.Clientset) (bool, error) {
 		return isGatewayNode, nil
 	}
 }
-func GetGatewayVxlanIP(clientset *kubernetes.Clientset, vxlanConfig VxlanNetConfig) (string, error) {
+func GetGatewayVxlanIP(clientset kubernetes.Interface, vxlanConfig VxlanNetConfig) (string, error) {
 	var gatewayVxlanIP string
-	//retrieve the node which is labeled as the gateway
-	nodesList, err := clientset.CoreV1().Nodes().List(context.TODO(), metav1.ListOptions{LabelSelector: "net.liqo.io/gateway == true"})
+	//retrieve the node which is labeled as the active gateway
+	nodes, err := ListGatewayNodes(clientset)
 	if err != nil {
 		logger.Error(err, "Unable to list nodes with label 'net.liqo.io/gateway=true'")
-		return gatewayVxlanIP, fmt.Errorf(" Unable to list nodes with label 'net.liqo.io/gateway=true': %v", err)
+		return gatewayVxlanIP, err
 	}
-	if len(nodesList.Items) != 1 {
-		klog.V(4).Infof("number of gateway nodes found: %d", len(nodesList.Items))
-		return gatewayVxlanIP, errdefs.NotFound("no gateway node has been found")
+	gateway, err := GetActiveGateway(nodes)
+	if err != nil {
+		return gatewayVxlanIP, err
 	}
-	internalIP, err := getInternalIPOfNode(nodesList.Items[0])
+	internalIP, err := GetInternalIPOfNode(*gateway)
 	if err != nil {
 		return gatewayVxlanIP, fmt.Errorf("unable to get internal ip of the gateway node: %v", err)
 	}
-	token := strings.Split(vxlanConfig.Network, "/")
-	vxlanNet := token[0]
 	//derive IP for the vxlan device
-	//take the last octet of the podIP
-	//TODO: use & and | operators with masks
-	temp := strings.Split(internalIP, ".")
-	temp1 := strings.Split(vxlanNet, ".")
-	gatewayVxlanIP = temp1[0] + "." + temp1[1] + "." + temp1[2] + "." + temp[3]
+	gatewayVxlanIP = GetVxlanIP(internalIP, vxlanConfig.Network)
 	return gatewayVxlanIP, nil
 }
+
 func getRemoteVTEPS(clientset *kubernetes.Clientset) ([]string, error) {
 	var remoteVTEP []string
 	nodesList, err := clientset.CoreV1().Nodes().List(context.TODO(), metav1.ListOptions{LabelSelector: "type != virtual-node"})
@@ -131,7 +125,7 @@ func getRemoteVTEPS(clientset *kubernetes.Clientset) ([]string, error) {
 	}
 	//populate the VTEPs
 	for _, node := range nodesList.Items {
-		internalIP, err := getInternalIPOfNode(node)
+		internalIP, err := GetInternalIPOfNode(node)
 		if err != nil {
 			//Log the error but don't exit
 			logger.Error(err, "unable to get internal ip of the node named -> %s", node.Name)
@@ -7,17 +7,19 @@ const (
 	ReplicaSets
 	Services
 	Secrets
+	NetworkPolicies
 )
 
 type ApiType int
 
 var ApiNames = map[ApiType]string{
-	Configmaps:     "configmaps",
-	EndpointSlices: "endpointslices",
-	Pods:           "pods",
-	ReplicaSets:    "replicasets",
-	Services:       "services",
-	Secrets:        "secrets",
+	Configmaps:      "configmaps",
+	EndpointSlices:  "endpointslices",
+	Pods:            "pods",
+	ReplicaSets:     "replicasets",
+	Services:        "services",
+	Secrets:         "secrets",
+	NetworkPolicies: "networkpolicies",
 }
 
 type ApiEvent struct {
@@ -8,10 +8,11 @@ import (
 )
 
 var ReflectorBuilders = map[apimgmt.ApiType]func(reflector ri.APIReflector, opts map[options.OptionKey]options.Option) ri.OutgoingAPIReflector{
-	apimgmt.Configmaps:     configmapsReflectorBuilder,
-	apimgmt.EndpointSlices: endpointslicesReflectorBuilder,
-	apimgmt.Secrets:        secretsReflectorBuilder,
-	apimgmt.Services:       servicesReflectorBuilder,
+	apimgmt.Configmaps:      configmapsReflectorBuilder,
+	apimgmt.EndpointSlices:  endpointslicesReflectorBuilder,
+	apimgmt.Secrets:         secretsReflectorBuilder,
+	apimgmt.Services:        servicesReflectorBuilder,
+	apimgmt.NetworkPolicies: networkPoliciesReflectorBuilder,
 }
 
 func configmapsReflectorBuilder(reflector ri.APIReflector, _ map[options.OptionKey]options.Option) ri.OutgoingAPIReflector {
@@ -20,9 +21,10 @@ func configmapsReflectorBuilder(reflector ri.APIReflector, _ map[options.OptionK
 
 func endpointslicesReflectorBuilder(reflector ri.APIReflector, opts map[options.OptionKey]options.Option) ri.OutgoingAPIReflector {
 	return &EndpointSlicesReflector{
-		APIReflector:         reflector,
-		LocalRemappedPodCIDR: opts[types.LocalRemappedPodCIDR],
-		VirtualNodeName:      opts[types.VirtualNodeName],
+		APIReflector:             reflector,
+		LocalRemappedPodCIDR:     opts[types.LocalRemappedPodCIDR],
+		LocalRemappedServiceCIDR: opts[types.LocalRemappedServiceCIDR],
+		VirtualNodeName:          opts[types.VirtualNodeName],
 	}
 }
 
@@ -30,6 +32,13 @@ func secretsReflectorBuilder(reflector ri.APIReflector, _ map[options.OptionKey]
 	return &SecretsReflector{APIReflector: reflector}
 }
 
-func servicesReflectorBuilder(reflector ri.APIReflector, _ map[options.OptionKey]options.Option) ri.OutgoingAPIReflector {
-	return &ServicesReflector{APIReflector: reflector}
+func servicesReflectorBuilder(reflector ri.APIReflector, opts map[options.OptionKey]options.Option) ri.OutgoingAPIReflector {
+	return &ServicesReflector{
+		APIReflector:             reflector,
+		LocalRemappedServiceCIDR: opts[types.LocalRemappedServiceCIDR],
+	}
+}
+
+func networkPoliciesReflectorBuilder(reflector ri.APIReflector, _ map[options.OptionKey]options.Option) ri.OutgoingAPIReflector {
+	return &NetworkPoliciesReflector{APIReflector: reflector}
 }
@@ -23,8 +23,9 @@ var endpointsliceLabels = map[string]string{
 type EndpointSlicesReflector struct {
 	ri.APIReflector
 
-	LocalRemappedPodCIDR options.ReadOnlyOption
-	VirtualNodeName      options.ReadOnlyOption
+	LocalRemappedPodCIDR     options.ReadOnlyOption
+	LocalRemappedServiceCIDR options.ReadOnlyOption
+	VirtualNodeName          options.ReadOnlyOption
 }
 
 func (r *EndpointSlicesReflector) SetSpecializedPreProcessingHandlers() {
@@ -122,6 +123,9 @@ func (r *EndpointSlicesReflector) PreAdd(obj interface{}) interface{} {
 		Endpoints:   filterEndpoints(epLocal, string(r.LocalRemappedPodCIDR.Value()), string(r.VirtualNodeName.Value())),
 		Ports:       epLocal.Ports,
 	}
+	if serviceCidr := remappedServiceCIDR(r.LocalRemappedServiceCIDR); serviceCidr != "" {
+		epsRemote.Endpoints, epsRemote.Ports = r.serviceEndpoints(epLocal.Namespace, svcName, serviceCidr)
+	}
 
 	return epsRemote
 }
@@ -149,6 +153,9 @@ func (r *EndpointSlicesReflector) PreUpdate(newObj, _ interface{}) interface{} {
 
 	RemoteEpSlice.Endpoints = filterEndpoints(endpointSliceHome, string(r.LocalRemappedPodCIDR.Value()), string(r.VirtualNodeName.Value()))
 	RemoteEpSlice.Ports = endpointSliceHome.Ports
+	if serviceCidr := remappedServiceCIDR(r.LocalRemappedServiceCIDR); serviceCidr != "" && len(endpointSliceHome.OwnerReferences) > 0 {
+		RemoteEpSlice.Endpoints, RemoteEpSlice.Ports = r.serviceEndpoints(endpointSliceHome.Namespace, endpointSliceHome.OwnerReferences[0].Name, serviceCidr)
+	}
 
 	return RemoteEpSlice
 }
@@ -184,6 +191,40 @@ func filterEndpoints(slice *discoveryv1beta1.EndpointSlice, podCidr string, node
 	return epList
 }
 
+// serviceEndpoints is used when only the service traffic crosses the tunnel: the local pods are not reachable
+// from the foreign cluster, hence the only endpoint is the remapped ClusterIP of the local service, which is
+// translated back to the original one by the route operator on the local gateway.
+func (r *EndpointSlicesReflector) serviceEndpoints(namespace, svcName, serviceCidr string) ([]discoveryv1beta1.Endpoint, []discoveryv1beta1.EndpointPort) {
+	obj, err := r.GetCacheManager().GetHomeNamespacedObject(apimgmt.Services, namespace, svcName)
+	if err != nil {
+		klog.Errorf("error while retrieving home service %v in endpointslices reflector - ERR: %v", r.Keyer(namespace, svcName), err)
+		return nil, nil
+	}
+	svc := obj.(*corev1.Service)
+	if svc.Spec.ClusterIP == "" || svc.Spec.ClusterIP == corev1.ClusterIPNone {
+		klog.V(3).Infof("headless service %v cannot be reached through its ClusterIP", r.Keyer(namespace, svcName))
+		return nil, nil
+	}
+
+	ready := true
+	endpoints := []discoveryv1beta1.Endpoint{
+		{
+			Addresses:  []string{forge.ChangeServiceIp(serviceCidr, svc.Spec.ClusterIP)},
+			Conditions: discoveryv1beta1.EndpointConditions{Ready: &ready},
+		},
+	}
+	var ports []discoveryv1beta1.EndpointPort
+	for i := range svc.Spec.Ports {
+		p := svc.Spec.Ports[i]
+		ports = append(ports, discoveryv1beta1.EndpointPort{
+			Name:     &p.Name,
+			Protocol: &p.Protocol,
+			Port:     &p.Port,
+		})
+	}
+	return endpoints, ports
+}
+
 func (r *EndpointSlicesReflector) CleanupNamespace(localNamespace string) {
 	foreignNamespace, err := r.NattingTable().NatNamespace(localNamespace, false)
 	if err != nil {
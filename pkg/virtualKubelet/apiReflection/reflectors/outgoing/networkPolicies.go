@@ -0,0 +1,227 @@
+package outgoing
+
+import (
+	"context"
+	apimgmt "github.com/liqotech/liqo/pkg/virtualKubelet/apiReflection"
+	ri "github.com/liqotech/liqo/pkg/virtualKubelet/apiReflection/reflectors/reflectorsInterfaces"
+	"github.com/liqotech/liqo/pkg/virtualKubelet/forge"
+	"github.com/pkg/errors"
+	networkingv1 "k8s.io/api/networking/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog"
+)
+
+// NetworkPoliciesReflector reflects the NetworkPolicies of the home namespaces in the foreign cluster, so that
+// they are enforced also on the offloaded pods, which keep the labels of the home ones.
+type NetworkPoliciesReflector struct {
+	ri.APIReflector
+}
+
+func (r *NetworkPoliciesReflector) SetSpecializedPreProcessingHandlers() {
+	r.SetPreProcessingHandlers(ri.PreProcessingHandlers{
+		AddFunc:    r.PreAdd,
+		UpdateFunc: r.PreUpdate,
+		DeleteFunc: r.PreDelete})
+}
+
+func (r *NetworkPoliciesReflector) HandleEvent(e interface{}) {
+	var err error
+
+	event := e.(watch.Event)
+	policy, ok := event.Object.(*networkingv1.NetworkPolicy)
+	if !ok {
+		klog.Error("OUTGOING REFLECTION: cannot cast object to networkPolicy")
+		return
+	}
+	klog.V(3).Infof("OUTGOING REFLECTION: received %v for networkpolicy %v/%v", event.Type, policy.Namespace, policy.Name)
+
+	switch event.Type {
+	case watch.Added:
+		_, err := r.GetForeignClient().NetworkingV1().NetworkPolicies(policy.Namespace).Create(context.TODO(), policy, metav1.CreateOptions{})
+		if kerrors.IsAlreadyExists(err) {
+			klog.V(3).Infof("OUTGOING REFLECTION: The remote networkpolicy %v/%v has not been created: %v", policy.Namespace, policy.Name, err)
+			break
+		}
+
+		if err != nil {
+			klog.Errorf("OUTGOING REFLECTION: Error while creating the remote networkpolicy %v/%v - ERR: %v", policy.Namespace, policy.Name, err)
+		} else {
+			klog.V(3).Infof("OUTGOING REFLECTION: remote networkPolicy %v/%v correctly created", policy.Namespace, policy.Name)
+		}
+
+	case watch.Modified:
+		if _, err = r.GetForeignClient().NetworkingV1().NetworkPolicies(policy.Namespace).Update(context.TODO(), policy, metav1.UpdateOptions{}); err != nil {
+			klog.Errorf("OUTGOING REFLECTION: Error while updating the remote networkpolicy %v/%v - ERR: %v", policy.Namespace, policy.Name, err)
+		} else {
+			klog.V(3).Infof("OUTGOING REFLECTION: remote networkPolicy %v/%v correctly updated", policy.Namespace, policy.Name)
+		}
+
+	case watch.Deleted:
+		if err := r.GetForeignClient().NetworkingV1().NetworkPolicies(policy.Namespace).Delete(context.TODO(), policy.Name, metav1.DeleteOptions{}); err != nil {
+			klog.Errorf("OUTGOING REFLECTION: Error while deleting the remote networkpolicy %v/%v - ERR: %v", policy.Namespace, policy.Name, err)
+		} else {
+			klog.V(3).Infof("OUTGOING REFLECTION: remote networkPolicy %v/%v correctly deleted", policy.Namespace, policy.Name)
+		}
+	}
+}
+
+func (r *NetworkPoliciesReflector) PreAdd(obj interface{}) interface{} {
+	policyLocal := obj.(*networkingv1.NetworkPolicy)
+	klog.V(3).Infof("PreAdd routine started for networkpolicy %v/%v", policyLocal.Namespace, policyLocal.Name)
+
+	nattedNs, err := r.NattingTable().NatNamespace(policyLocal.Namespace, false)
+	if err != nil {
+		klog.Error(err)
+		return nil
+	}
+
+	policyRemote := &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        policyLocal.Name,
+			Namespace:   nattedNs,
+			Labels:      make(map[string]string),
+			Annotations: make(map[string]string),
+		},
+		Spec: foreignNetworkPolicySpec(policyLocal.Spec),
+	}
+	for k, v := range policyLocal.Labels {
+		policyRemote.Labels[k] = v
+	}
+	policyRemote.Labels[forge.LiqoReflectionKey] = forge.LiqoOutgoing
+
+	klog.V(3).Infof("PreAdd routine completed for networkpolicy %v/%v", policyLocal.Namespace, policyLocal.Name)
+	return policyRemote
+}
+
+func (r *NetworkPoliciesReflector) PreUpdate(newObj, _ interface{}) interface{} {
+	newHomePolicy := newObj.(*networkingv1.NetworkPolicy).DeepCopy()
+
+	klog.V(3).Infof("PreUpdate routine started for networkpolicy %v/%v", newHomePolicy.Namespace, newHomePolicy.Name)
+
+	nattedNs, err := r.NattingTable().NatNamespace(newHomePolicy.Namespace, false)
+	if err != nil {
+		err = errors.Wrapf(err, "networkpolicy %v/%v", nattedNs, newHomePolicy.Name)
+		klog.Error(err)
+		return nil
+	}
+
+	oldForeignObj, err := r.GetCacheManager().GetForeignNamespacedObject(apimgmt.NetworkPolicies, nattedNs, newHomePolicy.Name)
+	if err != nil {
+		err = errors.Wrapf(err, "networkpolicy %v/%v", nattedNs, newHomePolicy.Name)
+		klog.Error(err)
+		return nil
+	}
+
+	oldRemotePolicy := oldForeignObj.(*networkingv1.NetworkPolicy)
+
+	newHomePolicy.SetNamespace(nattedNs)
+	newHomePolicy.SetResourceVersion(oldRemotePolicy.ResourceVersion)
+	newHomePolicy.SetUID(oldRemotePolicy.UID)
+	if newHomePolicy.Labels == nil {
+		newHomePolicy.Labels = make(map[string]string)
+	}
+	for k, v := range oldRemotePolicy.Labels {
+		newHomePolicy.Labels[k] = v
+	}
+	newHomePolicy.Labels[forge.LiqoReflectionKey] = forge.LiqoOutgoing
+
+	if newHomePolicy.Annotations == nil {
+		newHomePolicy.Annotations = make(map[string]string)
+	}
+	for k, v := range oldRemotePolicy.Annotations {
+		newHomePolicy.Annotations[k] = v
+	}
+	newHomePolicy.Spec = foreignNetworkPolicySpec(newHomePolicy.Spec)
+
+	klog.V(3).Infof("PreUpdate routine completed for networkpolicy %v/%v", newHomePolicy.Namespace, newHomePolicy.Name)
+	return newHomePolicy
+}
+
+func (r *NetworkPoliciesReflector) PreDelete(obj interface{}) interface{} {
+	policyLocal := obj.(*networkingv1.NetworkPolicy).DeepCopy()
+	klog.V(3).Infof("PreDelete routine started for networkpolicy %v/%v", policyLocal.Namespace, policyLocal.Name)
+
+	nattedNs, err := r.NattingTable().NatNamespace(policyLocal.Namespace, false)
+	if err != nil {
+		klog.Error(err)
+		return nil
+	}
+	policyLocal.Namespace = nattedNs
+
+	klog.V(3).Infof("PreDelete routine completed for networkpolicy %v/%v", policyLocal.Namespace, policyLocal.Name)
+	return policyLocal
+}
+
+func (r *NetworkPoliciesReflector) CleanupNamespace(localNamespace string) {
+	foreignNamespace, err := r.NattingTable().NatNamespace(localNamespace, false)
+	if err != nil {
+		klog.Error(err)
+		return
+	}
+
+	objects, err := r.GetCacheManager().ResyncListForeignNamespacedObject(apimgmt.NetworkPolicies, foreignNamespace)
+	if err != nil {
+		klog.Error(err)
+		return
+	}
+
+	retriable := func(err error) bool {
+		switch kerrors.ReasonForError(err) {
+		case metav1.StatusReasonNotFound:
+			return false
+		default:
+			klog.Warningf("retrying while deleting networkpolicy because of- ERR; %v", err)
+			return true
+		}
+	}
+	for _, obj := range objects {
+		policy := obj.(*networkingv1.NetworkPolicy)
+		if err := retry.OnError(retry.DefaultBackoff, retriable, func() error {
+			return r.GetForeignClient().NetworkingV1().NetworkPolicies(foreignNamespace).Delete(context.TODO(), policy.Name, metav1.DeleteOptions{})
+		}); err != nil {
+			klog.Errorf("Error while deleting remote networkpolicy %v/%v", policy.Namespace, policy.Name)
+		}
+	}
+}
+
+// foreignNetworkPolicySpec translates the spec of a home NetworkPolicy for the foreign cluster. Only the peers
+// selecting pods of the same namespace keep their meaning there: the ones referring to other namespaces or to
+// home addresses are removed, and so are the rules left without peers, since they would allow any peer.
+func foreignNetworkPolicySpec(spec networkingv1.NetworkPolicySpec) networkingv1.NetworkPolicySpec {
+	foreignSpec := networkingv1.NetworkPolicySpec{
+		PodSelector: *spec.PodSelector.DeepCopy(),
+		PolicyTypes: spec.PolicyTypes,
+	}
+	for _, rule := range spec.Ingress {
+		peers, ok := foreignPeers(rule.From)
+		if !ok {
+			continue
+		}
+		foreignSpec.Ingress = append(foreignSpec.Ingress, networkingv1.NetworkPolicyIngressRule{Ports: rule.Ports, From: peers})
+	}
+	for _, rule := range spec.Egress {
+		peers, ok := foreignPeers(rule.To)
+		if !ok {
+			continue
+		}
+		foreignSpec.Egress = append(foreignSpec.Egress, networkingv1.NetworkPolicyEgressRule{Ports: rule.Ports, To: peers})
+	}
+	return foreignSpec
+}
+
+// foreignPeers returns the peers which can be reflected and false if the rule restricted the peers but none is left
+func foreignPeers(peers []networkingv1.NetworkPolicyPeer) ([]networkingv1.NetworkPolicyPeer, bool) {
+	if len(peers) == 0 {
+		return nil, true
+	}
+	var foreign []networkingv1.NetworkPolicyPeer
+	for i := range peers {
+		if peers[i].PodSelector != nil && peers[i].NamespaceSelector == nil && peers[i].IPBlock == nil {
+			foreign = append(foreign, *peers[i].DeepCopy())
+		}
+	}
+	return foreign, len(foreign) > 0
+}
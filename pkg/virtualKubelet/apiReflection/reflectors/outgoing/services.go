@@ -5,6 +5,7 @@ import (
 	apimgmt "github.com/liqotech/liqo/pkg/virtualKubelet/apiReflection"
 	ri "github.com/liqotech/liqo/pkg/virtualKubelet/apiReflection/reflectors/reflectorsInterfaces"
 	"github.com/liqotech/liqo/pkg/virtualKubelet/forge"
+	"github.com/liqotech/liqo/pkg/virtualKubelet/options"
 	"github.com/pkg/errors"
 	corev1 "k8s.io/api/core/v1"
 	kerrors "k8s.io/apimachinery/pkg/api/errors"
@@ -16,6 +17,8 @@ import (
 
 type ServicesReflector struct {
 	ri.APIReflector
+
+	LocalRemappedServiceCIDR options.ReadOnlyOption
 }
 
 func (r *ServicesReflector) SetSpecializedPreProcessingHandlers() {
@@ -196,3 +199,12 @@ func (r *ServicesReflector) isAllowed(obj interface{}) bool {
 	}
 	return !ok
 }
+
+// remappedServiceCIDR returns the value of the option, which is not provided when the reflectors are built
+// without the service remapping support.
+func remappedServiceCIDR(opt options.ReadOnlyOption) string {
+	if opt == nil {
+		return ""
+	}
+	return string(opt.Value())
+}
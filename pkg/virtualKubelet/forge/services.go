@@ -1,7 +1,30 @@
 package forge
 
-import corev1 "k8s.io/api/core/v1"
+import (
+	corev1 "k8s.io/api/core/v1"
+	"net"
+)
 
 func (f *apiForger) serviceHomeToForeign(homeService, foreignService *corev1.Service) (*corev1.Service, error) {
 	panic("to implement")
 }
+
+// ChangeServiceIp returns the IP used by the foreign cluster to reach a local ClusterIP: the host bits of the ClusterIP
+// are kept in the remapped subnet, as the NETMAP target does. The mapping is one-to-one only if the remapped subnet is
+// not narrower than the local services' one, which is refused by the network operators.
+// The newServiceCidr is "None" when the foreign cluster does not remap the local service subnet.
+func ChangeServiceIp(newServiceCidr string, oldServiceIp string) (newServiceIp string) {
+	if newServiceCidr == "None" || newServiceCidr == "" {
+		return oldServiceIp
+	}
+	_, subnet, err := net.ParseCIDR(newServiceCidr)
+	ip := net.ParseIP(oldServiceIp).To4()
+	if err != nil || ip == nil || len(subnet.Mask) != net.IPv4len {
+		return oldServiceIp
+	}
+	newIp := make(net.IP, net.IPv4len)
+	for i := range newIp {
+		newIp[i] = subnet.IP[i] | ip[i]&^subnet.Mask[i]
+	}
+	return newIp.String()
+}
@@ -12,6 +12,8 @@ const (
 	LocalRemappedPodCIDR  = "localRemappedPodCIDR"
 	RemoteRemappedPodCIDR = "remoteRemappedPodCIDR"
 	VirtualNodeName       = "virtualNodeName"
+	//LocalRemappedServiceCIDR is set only when the service traffic is routed instead of the pods' one
+	LocalRemappedServiceCIDR = "localRemappedServiceCIDR"
 )
 
 func NewNetworkingOption(key NetworkingKey, value NetworkingValue) *NetworkingOption {
@@ -1,10 +1,12 @@
 package provider
 
 import (
+	discoveryv1alpha1 "github.com/liqotech/liqo/apis/discovery/v1alpha1"
 	nettypes "github.com/liqotech/liqo/apis/net/v1alpha1"
 	advtypes "github.com/liqotech/liqo/apis/sharing/v1alpha1"
 	nattingv1 "github.com/liqotech/liqo/apis/virtualKubelet/v1alpha1"
 	"github.com/liqotech/liqo/internal/virtualKubelet/node"
+	"github.com/liqotech/liqo/pkg/auth"
 	"github.com/liqotech/liqo/pkg/crdClient"
 	"github.com/liqotech/liqo/pkg/virtualKubelet/apiReflection/controller"
 	"github.com/liqotech/liqo/pkg/virtualKubelet/forge"
@@ -12,6 +14,7 @@ import (
 	"github.com/liqotech/liqo/pkg/virtualKubelet/options"
 	optTypes "github.com/liqotech/liqo/pkg/virtualKubelet/options/types"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/rest"
@@ -28,6 +31,8 @@ type LiqoProvider struct { // nolint:golint]
 	tunEndClient  *crdClient.CRDClient
 	homeClient    *crdClient.CRDClient
 	foreignClient kubernetes.Interface
+	//used to report the readiness of the virtual node in the ForeignCluster
+	discoveryClient *crdClient.CRDClient
 
 	operatingSystem    string
 	internalIP         string
@@ -43,6 +48,10 @@ type LiqoProvider struct { // nolint:golint]
 	nodeName              options.Option
 	RemoteRemappedPodCidr options.Option
 	LocalRemappedPodCidr  options.Option
+	//the subnet used by the foreign cluster to reach the local services in ServiceCIDR network mode
+	LocalRemappedServiceCidr options.Option
+	//true when the probes sent through the tunnel towards the foreign cluster are not answered
+	tunnelDown bool
 
 	foreignPodWatcherStop chan struct{}
 	nodeUpdateStop        chan struct{}
@@ -72,10 +81,26 @@ func NewLiqoProvider(nodeName, foreignClusterId, homeClusterId string, internalI
 		return nil, err
 	}
 
+	discoveryConfig, err := crdClient.NewKubeconfig(kubeconfig, &discoveryv1alpha1.GroupVersion)
+	if err != nil {
+		return nil, err
+	}
+	discoveryClient, err := crdClient.NewFromConfig(discoveryConfig)
+	if err != nil {
+		return nil, err
+	}
+
 	restConfig, err := crdClient.NewKubeconfig(remoteKubeConfig, &schema.GroupVersion{})
 	if err != nil {
 		return nil, err
 	}
+	// the credentials are reloaded when they are rotated by the foreign cluster
+	token := auth.NewRotatingToken(restConfig.BearerToken)
+	token.WrapConfig(restConfig)
+	go token.ReloadFromFile(remoteKubeConfig, time.Minute, wait.NeverStop)
+	if err = discoveryv1alpha1.ApplyForeignClusterConnection(discoveryClient, foreignClusterId, restConfig); err != nil {
+		return nil, err
+	}
 
 	foreignClient, err := kubernetes.NewForConfig(restConfig)
 	if err != nil {
@@ -91,13 +116,15 @@ func NewLiqoProvider(nodeName, foreignClusterId, homeClusterId string, internalI
 	remoteRemappedPodCIDROpt := optTypes.NewNetworkingOption(optTypes.RemoteRemappedPodCIDR, "")
 	localRemappedPodCIDROpt := optTypes.NewNetworkingOption(optTypes.LocalRemappedPodCIDR, "")
 	virtualNodeNameOpt := optTypes.NewNetworkingOption(optTypes.VirtualNodeName, optTypes.NetworkingValue(nodeName))
+	localRemappedServiceCIDROpt := optTypes.NewNetworkingOption(optTypes.LocalRemappedServiceCIDR, "")
 
 	forge.InitForger(mapper, remoteRemappedPodCIDROpt, localRemappedPodCIDROpt, virtualNodeNameOpt)
 
 	opts := forgeOptionsMap(
 		remoteRemappedPodCIDROpt,
 		localRemappedPodCIDROpt,
-		virtualNodeNameOpt)
+		virtualNodeNameOpt,
+		localRemappedServiceCIDROpt)
 
 	provider := LiqoProvider{
 		apiController:         controller.NewApiController(client.Client(), foreignClient, mapper, opts),
@@ -115,9 +142,12 @@ func NewLiqoProvider(nodeName, foreignClusterId, homeClusterId string, internalI
 		foreignClient:         foreignClient,
 		advClient:             advClient,
 		tunEndClient:          tepClient,
+		discoveryClient:       discoveryClient,
 
 		RemoteRemappedPodCidr: remoteRemappedPodCIDROpt,
 		LocalRemappedPodCidr:  localRemappedPodCIDROpt,
+
+		LocalRemappedServiceCidr: localRemappedServiceCIDROpt,
 	}
 
 	return &provider, nil
@@ -3,6 +3,7 @@ package provider
 import (
 	"context"
 	"errors"
+	discoveryv1alpha1 "github.com/liqotech/liqo/apis/discovery/v1alpha1"
 	nettypes "github.com/liqotech/liqo/apis/net/v1alpha1"
 	advtypes "github.com/liqotech/liqo/apis/sharing/v1alpha1"
 	advertisementOperator "github.com/liqotech/liqo/internal/advertisement-operator"
@@ -134,6 +135,8 @@ func (p *LiqoProvider) ReconcileNodeFromTep(event watch.Event) error {
 	if event.Type == watch.Deleted {
 		klog.Infof("tunnelEndpoint %v deleted", tep.Name)
 		p.RemoteRemappedPodCidr.SetValue("")
+		p.LocalRemappedServiceCidr.SetValue("")
+		p.tunnelDown = false
 		no, err := p.homeClient.Client().CoreV1().Nodes().Get(context.TODO(), p.nodeName.Value().ToString(), metav1.GetOptions{})
 		if err != nil {
 			klog.Error(err)
@@ -234,6 +237,18 @@ func (p *LiqoProvider) updateFromTep(tep nettypes.TunnelEndpoint) error {
 		p.LocalRemappedPodCidr.SetValue(options.OptionValue(tep.Status.LocalRemappedPodCIDR))
 	}
 
+	//the service subnets are set only if both the clusters route the service traffic
+	if tep.Spec.ServiceCIDR != "" {
+		p.LocalRemappedServiceCidr.SetValue(options.OptionValue(tep.Status.LocalRemappedServiceCIDR))
+	} else {
+		p.LocalRemappedServiceCidr.SetValue("")
+	}
+
+	if down := tep.Status.Connection.Status == nettypes.ConnectionDown; down != p.tunnelDown {
+		klog.Infof("tunnel towards cluster %s is down: %v", p.foreignClusterId, down)
+		p.tunnelDown = down
+	}
+
 	no, err := p.homeClient.Client().CoreV1().Nodes().Get(context.TODO(), p.nodeName.Value().ToString(), metav1.GetOptions{})
 	if err != nil {
 		return err
@@ -268,8 +283,27 @@ func (p *LiqoProvider) updateFromTep(tep nettypes.TunnelEndpoint) error {
 }
 
 func (p *LiqoProvider) updateNode(node *v1.Node) error {
-	if p.RemoteRemappedPodCidr.Value() != "" && node.Status.Allocatable != nil {
+	fcCondition := discoveryv1alpha1.Condition{
+		Type:   discoveryv1alpha1.VirtualNodeReadyCondition,
+		Status: v1.ConditionFalse,
+	}
+	if p.tunnelDown {
+		// the data plane towards the foreign cluster is not working: the node is not ready
+		fcCondition.Reason = "TunnelDown"
+		fcCondition.Message = "the tunnel towards the foreign cluster is down"
+		for i, condition := range node.Status.Conditions {
+			if condition.Type == v1.NodeReady {
+				node.Status.Conditions[i].Status = v1.ConditionFalse
+			}
+			if condition.Type == v1.NodeNetworkUnavailable {
+				node.Status.Conditions[i].Status = v1.ConditionTrue
+			}
+		}
+	} else if p.RemoteRemappedPodCidr.Value() != "" && node.Status.Allocatable != nil {
 		// both the podCIDR and the resources have been set: the node is ready
+		fcCondition.Status = v1.ConditionTrue
+		fcCondition.Reason = "NodeReady"
+		fcCondition.Message = "the virtual node " + node.Name + " is ready"
 		for i, condition := range node.Status.Conditions {
 			if condition.Type == v1.NodeReady {
 				node.Status.Conditions[i].Status = v1.ConditionTrue
@@ -280,6 +314,8 @@ func (p *LiqoProvider) updateNode(node *v1.Node) error {
 		}
 	} else if p.RemoteRemappedPodCidr.Value() != "" && node.Status.Allocatable == nil {
 		// the resources have not been set yet: set the node status to NotReady
+		fcCondition.Reason = "ResourcesMissing"
+		fcCondition.Message = "waiting for the resources of the Advertisement"
 		for i, condition := range node.Status.Conditions {
 			if condition.Type == v1.NodeReady {
 				node.Status.Conditions[i].Status = v1.ConditionFalse
@@ -287,6 +323,8 @@ func (p *LiqoProvider) updateNode(node *v1.Node) error {
 		}
 	} else if p.RemoteRemappedPodCidr.Value() == "" && node.Status.Allocatable != nil {
 		// the podCIDR has not been set yet: set the node status to NetworkUnavailable
+		fcCondition.Reason = "PodCIDRMissing"
+		fcCondition.Message = "waiting for the podCIDR of the TunnelEndpoint"
 		for i, condition := range node.Status.Conditions {
 			if condition.Type == v1.NodeReady {
 				node.Status.Conditions[i].Status = v1.ConditionFalse
@@ -297,12 +335,17 @@ func (p *LiqoProvider) updateNode(node *v1.Node) error {
 		}
 	} else {
 		// both the podCIDR and resources have not been set
+		fcCondition.Reason = "NodeNotConfigured"
+		fcCondition.Message = "waiting for the resources of the Advertisement and the podCIDR of the TunnelEndpoint"
 		for i, condition := range node.Status.Conditions {
 			if condition.Type == v1.NodeReady {
 				node.Status.Conditions[i].Status = v1.ConditionFalse
 			}
 		}
 	}
+	if err := discoveryv1alpha1.SetForeignClusterCondition(p.discoveryClient, p.foreignClusterId, fcCondition); err != nil {
+		klog.Errorf("unable to set the condition %s of the foreign cluster %s: %v", fcCondition.Type, p.foreignClusterId, err)
+	}
 	return p.nodeController.UpdateNodeFromOutside(false, node)
 }
 
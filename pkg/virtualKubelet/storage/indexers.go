@@ -7,17 +7,19 @@ import (
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	discoveryv1beta1 "k8s.io/api/discovery/v1beta1"
+	networkingv1 "k8s.io/api/networking/v1"
 	"k8s.io/client-go/tools/cache"
 	"strings"
 )
 
 var InformerIndexers = map[apimgmt.ApiType]func() cache.Indexers{
-	apimgmt.Configmaps:     configmapsIndexers,
-	apimgmt.EndpointSlices: endpointSlicesIndexers,
-	apimgmt.Pods:           podsIndexers,
-	apimgmt.ReplicaSets:    replicasetsIndexers,
-	apimgmt.Secrets:        secretsIndexers,
-	apimgmt.Services:       servicesIndexers,
+	apimgmt.Configmaps:      configmapsIndexers,
+	apimgmt.EndpointSlices:  endpointSlicesIndexers,
+	apimgmt.Pods:            podsIndexers,
+	apimgmt.ReplicaSets:     replicasetsIndexers,
+	apimgmt.Secrets:         secretsIndexers,
+	apimgmt.Services:        servicesIndexers,
+	apimgmt.NetworkPolicies: networkPoliciesIndexers,
 }
 
 func configmapsIndexers() cache.Indexers {
@@ -114,3 +116,17 @@ func servicesIndexers() cache.Indexers {
 	}
 	return i
 }
+
+func networkPoliciesIndexers() cache.Indexers {
+	i := cache.Indexers{}
+	i["networkpolicies"] = func(obj interface{}) ([]string, error) {
+		policy, ok := obj.(*networkingv1.NetworkPolicy)
+		if !ok {
+			return []string{}, errors.New("cannot convert obj to networkpolicy")
+		}
+		return []string{
+			strings.Join([]string{policy.Namespace, policy.Name}, "/"),
+		}, nil
+	}
+	return i
+}
@@ -7,12 +7,13 @@ import (
 )
 
 var InformerBuilders = map[apimgmt.ApiType]func(informers.SharedInformerFactory) cache.SharedIndexInformer{
-	apimgmt.Configmaps:     configmapsInformerBuilder,
-	apimgmt.EndpointSlices: endpointSlicesInformerBuilder,
-	apimgmt.Pods:           podsInformerBuilder,
-	apimgmt.ReplicaSets:    replicaSetsInformerBuilder,
-	apimgmt.Services:       servicesInformerBuilder,
-	apimgmt.Secrets:        secretsInformerBuilder,
+	apimgmt.Configmaps:      configmapsInformerBuilder,
+	apimgmt.EndpointSlices:  endpointSlicesInformerBuilder,
+	apimgmt.Pods:            podsInformerBuilder,
+	apimgmt.ReplicaSets:     replicaSetsInformerBuilder,
+	apimgmt.Services:        servicesInformerBuilder,
+	apimgmt.Secrets:         secretsInformerBuilder,
+	apimgmt.NetworkPolicies: networkPoliciesInformerBuilder,
 }
 
 func configmapsInformerBuilder(factory informers.SharedInformerFactory) cache.SharedIndexInformer {
@@ -38,3 +39,7 @@ func servicesInformerBuilder(factory informers.SharedInformerFactory) cache.Shar
 func secretsInformerBuilder(factory informers.SharedInformerFactory) cache.SharedIndexInformer {
 	return factory.Core().V1().Secrets().Informer()
 }
+
+func networkPoliciesInformerBuilder(factory informers.SharedInformerFactory) cache.SharedIndexInformer {
+	return factory.Networking().V1().NetworkPolicies().Informer()
+}
@@ -0,0 +1,195 @@
+package unjoin_e2e
+
+import (
+	"context"
+	"fmt"
+	discoveryv1alpha1 "github.com/liqotech/liqo/apis/discovery/v1alpha1"
+	"github.com/liqotech/liqo/pkg/virtualKubelet"
+	"github.com/liqotech/liqo/test/e2e/util"
+	"gotest.tools/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/utils/pointer"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	drainNamespace  = "test-drain"
+	drainDeployment = "drain-app"
+	// the drain waits for the evicted pods to be deleted in the foreign cluster
+	drainRetries        = 72
+	sleepBetweenRetries = 5 * time.Second
+)
+
+var foreignClusterResource = discoveryv1alpha1.GroupVersion.WithResource("foreignclusters")
+
+// testDrain disables the outgoing peering of the first cluster while a workload is offloaded to the second one, and
+// checks that the virtual node is cordoned and the offloaded pods are evicted and recreated on the local nodes before
+// the peering is torn down. The drain can be observed only if the first cluster has not been uninstalled yet.
+func testDrain(t *testing.T) {
+	tester := util.GetTester()
+	if tester.ClusterID1 == "" || tester.ClusterID2 == "" {
+		t.Skip("liqo is not installed in both the clusters, the peering to be drained has already been torn down")
+	}
+	client := tester.Client1
+	nodeName := virtualKubelet.VirtualNodePrefix + tester.ClusterID2
+
+	_, err := util.CreateNamespace(client, tester.ClusterID1, drainNamespace)
+	assert.NilError(t, err)
+	defer func() {
+		assert.NilError(t, util.DeleteNamespace(client, drainNamespace))
+	}()
+	_, err = client.AppsV1().Deployments(drainNamespace).Create(context.TODO(), getDrainDeployment(), metav1.CreateOptions{})
+	assert.NilError(t, err)
+	assert.Assert(t, waitForPods(t, client, func(pods []v1.Pod) bool {
+		return countPods(pods, func(pod *v1.Pod) bool { return pod.Spec.NodeName == nodeName }) > 0
+	}), "no pod has been offloaded to the virtual node "+nodeName)
+
+	// the virtual node is watched before the drain begins, it is deleted when the peering is torn down
+	watcher, err := client.CoreV1().Nodes().Watch(context.TODO(), metav1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("metadata.name", nodeName).String(),
+	})
+	assert.NilError(t, err)
+	defer watcher.Stop()
+	cordoned := make(chan bool, 1)
+	go watchCordon(watcher, cordoned)
+
+	dynClient, err := dynamic.NewForConfig(tester.Config1)
+	assert.NilError(t, err)
+	setJoin(t, dynClient, tester.ClusterID2, false)
+
+	select {
+	case ok := <-cordoned:
+		assert.Assert(t, ok, "the virtual node "+nodeName+" has been deleted without being cordoned")
+	case <-time.After(drainRetries * sleepBetweenRetries):
+		t.Fatal("the virtual node " + nodeName + " has not been cordoned")
+	}
+	assert.Assert(t, waitForCondition(t, dynClient, tester.ClusterID2, discoveryv1alpha1.WorkloadDrainedCondition, v1.ConditionTrue),
+		"the workload has not been drained from the virtual node "+nodeName)
+	assert.Assert(t, waitForPods(t, client, func(pods []v1.Pod) bool {
+		return len(pods) > 0 && countPods(pods, func(pod *v1.Pod) bool {
+			return isReady(pod) && !strings.HasPrefix(pod.Spec.NodeName, virtualKubelet.VirtualNodePrefix)
+		}) == len(pods)
+	}), "the evicted pods are not running on the local nodes")
+}
+
+// getDrainDeployment returns a Deployment whose pods prefer the virtual nodes, but can run on the local ones
+func getDrainDeployment() *appsv1.Deployment {
+	labels := map[string]string{"app": drainDeployment}
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: drainDeployment},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: pointer.Int32Ptr(2),
+			Selector: &metav1.LabelSelector{MatchLabels: labels},
+			Template: v1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: v1.PodSpec{
+					Containers: []v1.Container{{Name: "nginx", Image: "nginx"}},
+					Affinity: &v1.Affinity{NodeAffinity: &v1.NodeAffinity{
+						PreferredDuringSchedulingIgnoredDuringExecution: []v1.PreferredSchedulingTerm{{
+							Weight: 100,
+							Preference: v1.NodeSelectorTerm{MatchExpressions: []v1.NodeSelectorRequirement{{
+								Key:      "type",
+								Operator: v1.NodeSelectorOpIn,
+								Values:   []string{"virtual-node"},
+							}}},
+						}},
+					}},
+				},
+			},
+		},
+	}
+}
+
+// watchCordon sends true if the node is cordoned, false if it is deleted before
+func watchCordon(watcher watch.Interface, cordoned chan<- bool) {
+	for event := range watcher.ResultChan() {
+		node, ok := event.Object.(*v1.Node)
+		if !ok {
+			continue
+		}
+		if event.Type == watch.Deleted {
+			cordoned <- false
+			return
+		}
+		if node.Spec.Unschedulable {
+			cordoned <- true
+			return
+		}
+	}
+	cordoned <- false
+}
+
+// setJoin enables or disables the outgoing peering with the cluster
+func setJoin(t *testing.T, client dynamic.Interface, clusterID string, join bool) {
+	fc := getForeignCluster(t, client, clusterID)
+	patch := []byte(fmt.Sprintf(`{"spec":{"join":%t}}`, join))
+	_, err := client.Resource(foreignClusterResource).Patch(context.TODO(), fc.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+	assert.NilError(t, err)
+}
+
+func getForeignCluster(t *testing.T, client dynamic.Interface, clusterID string) *discoveryv1alpha1.ForeignCluster {
+	list, err := client.Resource(foreignClusterResource).List(context.TODO(), metav1.ListOptions{
+		LabelSelector: "cluster-id=" + clusterID,
+	})
+	assert.NilError(t, err)
+	assert.Equal(t, len(list.Items), 1, "ForeignCluster not found for cluster id "+clusterID)
+	fc := &discoveryv1alpha1.ForeignCluster{}
+	assert.NilError(t, runtime.DefaultUnstructuredConverter.FromUnstructured(list.Items[0].UnstructuredContent(), fc))
+	return fc
+}
+
+// waitForCondition waits for the condition of the ForeignCluster of the cluster to have the given status
+func waitForCondition(t *testing.T, client dynamic.Interface, clusterID string, conditionType discoveryv1alpha1.ConditionType, status v1.ConditionStatus) bool {
+	for i := 0; i < drainRetries; i++ {
+		condition := getForeignCluster(t, client, clusterID).GetCondition(conditionType)
+		if condition != nil && condition.Status == status {
+			return true
+		}
+		time.Sleep(sleepBetweenRetries)
+	}
+	return false
+}
+
+// waitForPods waits for the pods of the test Deployment to satisfy the check
+func waitForPods(t *testing.T, client kubernetes.Interface, check func([]v1.Pod) bool) bool {
+	for i := 0; i < drainRetries; i++ {
+		pods, err := client.CoreV1().Pods(drainNamespace).List(context.TODO(), metav1.ListOptions{
+			LabelSelector: "app=" + drainDeployment,
+		})
+		assert.NilError(t, err)
+		if check(pods.Items) {
+			return true
+		}
+		time.Sleep(sleepBetweenRetries)
+	}
+	return false
+}
+
+func countPods(pods []v1.Pod, match func(*v1.Pod) bool) int {
+	count := 0
+	for i := range pods {
+		if pods[i].DeletionTimestamp == nil && match(&pods[i]) {
+			count++
+		}
+	}
+	return count
+}
+
+func isReady(pod *v1.Pod) bool {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == v1.PodReady {
+			return condition.Status == v1.ConditionTrue
+		}
+	}
+	return false
+}
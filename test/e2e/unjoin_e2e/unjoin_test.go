@@ -11,6 +11,9 @@ import (
 )
 
 func TestUnjoin(t *testing.T) {
+	// the outgoing peering is disabled first, draining the offloaded workload
+	t.Run("drain", testDrain)
+
 	context := util.GetTester()
 	NoPods(context.Client1, context.Namespace, t, "cluster1")
 
@@ -12,7 +12,7 @@ func TestClusterID(t *testing.T) {
 
 func testSetupClusterID(t *testing.T) {
 	clID := clusterID.GetNewClusterID("", clientCluster.client.Client())
-	err := clID.SetupClusterID("default")
+	err := clID.SetupClusterID("default", "")
 	assert.NilError(t, err)
 	assert.Assert(t, clID.GetClusterID() != "", "cluster id string has not been filled")
 }
@@ -0,0 +1,74 @@
+package reflection
+
+import (
+	apimgmt "github.com/liqotech/liqo/pkg/virtualKubelet/apiReflection"
+	api "github.com/liqotech/liqo/pkg/virtualKubelet/apiReflection/reflectors"
+	"github.com/liqotech/liqo/pkg/virtualKubelet/apiReflection/reflectors/outgoing"
+	"github.com/liqotech/liqo/pkg/virtualKubelet/namespacesMapping/test"
+	storageTest "github.com/liqotech/liqo/pkg/virtualKubelet/storage/test"
+	"gotest.tools/assert"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"testing"
+)
+
+func TestNetworkPolicyAdd(t *testing.T) {
+	foreignClient := fake.NewSimpleClientset()
+	cacheManager := &storageTest.MockManager{
+		HomeCache:    map[string]map[apimgmt.ApiType]map[string]metav1.Object{},
+		ForeignCache: map[string]map[apimgmt.ApiType]map[string]metav1.Object{},
+	}
+	nattingTable := &test.MockNamespaceMapper{Cache: map[string]string{}}
+
+	Greflector := &api.GenericAPIReflector{
+		ForeignClient:    foreignClient,
+		NamespaceNatting: nattingTable,
+		CacheManager:     cacheManager,
+	}
+
+	reflector := &outgoing.NetworkPoliciesReflector{
+		APIReflector: Greflector,
+	}
+	reflector.SetSpecializedPreProcessingHandlers()
+
+	frontend := &metav1.LabelSelector{MatchLabels: map[string]string{"app": "frontend"}}
+	policy := networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "name",
+			Namespace: "homeNamespace",
+		},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{MatchLabels: map[string]string{"app": "backend"}},
+			Ingress: []networkingv1.NetworkPolicyIngressRule{
+				{
+					From: []networkingv1.NetworkPolicyPeer{
+						{PodSelector: frontend},
+						{NamespaceSelector: &metav1.LabelSelector{}},
+					},
+				},
+				{
+					From: []networkingv1.NetworkPolicyPeer{
+						{IPBlock: &networkingv1.IPBlock{CIDR: "10.0.0.0/8"}},
+					},
+				},
+			},
+			Egress: []networkingv1.NetworkPolicyEgressRule{{}},
+			PolicyTypes: []networkingv1.PolicyType{
+				networkingv1.PolicyTypeIngress,
+				networkingv1.PolicyTypeEgress,
+			},
+		},
+	}
+
+	_, _ = nattingTable.NatNamespace("homeNamespace", true)
+	postadd := reflector.PreProcessAdd(&policy).(*networkingv1.NetworkPolicy)
+
+	assert.Equal(t, postadd.Namespace, "homeNamespace-natted")
+	assert.DeepEqual(t, postadd.Spec.PodSelector, policy.Spec.PodSelector)
+	// the peers referring to other namespaces or home addresses cannot be reflected, nor the rules left without peers
+	assert.Equal(t, len(postadd.Spec.Ingress), 1)
+	assert.DeepEqual(t, postadd.Spec.Ingress[0].From, []networkingv1.NetworkPolicyPeer{{PodSelector: frontend}})
+	assert.Equal(t, len(postadd.Spec.Egress), 1)
+	assert.DeepEqual(t, postadd.Spec.PolicyTypes, policy.Spec.PolicyTypes)
+}